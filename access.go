@@ -2,6 +2,8 @@ package wgo
 
 import (
 	"encoding/json"
+	"net/url"
+	"strings"
 	"time"
 
 	"wgo/environ"
@@ -21,21 +23,23 @@ type (
 	}
 	// access log
 	AccessLog struct {
-		Ts      string  `json:"t,omitempty"`       // timestamp
-		Ver     string  `json:"v,omitempty"`       // server version
-		Host    string  `json:"h,omitempty"`       // server host
-		SId     string  `json:"s,omitempty"`       // 服务ID(服务发现管理)
-		SName   string  `json:"n,omitempty"`       // 服务名(服务发现管理)
-		Dura    float64 `json:"d"`                 // 持续时间, 单位毫秒
-		ReqID   string  `json:"rid,omitempty"`     // request-id, 首次访问由服务端生成, 各端传播
-		Env     string  `json:"env,omitempty"`     // 服务环境(testing,production等)
-		Err     int     `json:"err"`               // 错误码(成功为0)
-		Msg     string  `json:"msg,omitempty"`     // 错误信息
-		CIP     string  `json:"cip,omitempty"`     // 客户端IP
-		Proto   string  `json:"proto,omitempty"`   // 协议 `[ "http", "rpc" ]`
-		Call    Call    `json:"call,omitempty"`    // 调用信息
-		App     App     `json:"app,omitempty"`     // 应用程序信息
-		Service Service `json:"service,omitempty"` // 服务信息
+		Ts      string       `json:"t,omitempty"`       // timestamp
+		Ver     string       `json:"v,omitempty"`       // server version
+		Host    string       `json:"h,omitempty"`       // server host
+		SId     string       `json:"s,omitempty"`       // 服务ID(服务发现管理)
+		SName   string       `json:"n,omitempty"`       // 服务名(服务发现管理)
+		Dura    float64      `json:"d"`                 // 持续时间, 单位毫秒
+		ReqID   string       `json:"rid,omitempty"`     // request-id, 首次访问由服务端生成, 各端传播
+		TraceID string       `json:"tid,omitempty"`     // W3C trace id
+		Env     string       `json:"env,omitempty"`     // 服务环境(testing,production等)
+		Err     int          `json:"err"`               // 错误码(成功为0)
+		Msg     string       `json:"msg,omitempty"`     // 错误信息
+		CIP     string       `json:"cip,omitempty"`     // 客户端IP
+		Proto   string       `json:"proto,omitempty"`   // 协议 `[ "http", "rpc" ]`
+		Call    Call         `json:"call,omitempty"`    // 调用信息
+		Quota   *AccessQuota `json:"quota,omitempty"`   // 配额信息
+		App     App          `json:"app,omitempty"`     // 应用程序信息
+		Service Service      `json:"service,omitempty"` // 服务信息
 	}
 
 	App struct {
@@ -69,20 +73,24 @@ type (
 		Endpoint string `json:"ep,omitempty"`
 		Action   string `json:"act,omitempty"`
 		Desc     string `json:"desc,omitempty"`
+		Stage    string `json:"stage,omitempty"` // job所属的流水线阶段
 		RowKey   string `json:"rk,omitempty"`
 		User     User   `json:"user,omitempty"` // 客户信息
 		Old      string `json:"old,omitempty"`
 		New      string `json:"new,omitempty"`
+		Denied   string `json:"denied,omitempty"` // 权限拒绝的原因
 	}
 )
 
-func NewAccessLog() *AccessLog {
+func NewAccessLog() *AccessLog { return Self().NewAccessLog() }
+func (w *WGO) NewAccessLog() *AccessLog {
+	env := w.Env()
 	ac := &AccessLog{
 		Ver:   VERSION,
-		Host:  Env().Hostname,
-		SId:   Env().ServiceId,   // 服务id, 这个应该从配置中心拿到
-		SName: Env().ServiceName, // 服务名, 这个代码应该'自知'
-		Env:   Env().ServiceEnv,  // 服务环境, 这个应该从配置中心拿到
+		Host:  env.Hostname,
+		SId:   env.ServiceId,   // 服务id, 这个应该从配置中心拿到
+		SName: env.ServiceName, // 服务名, 这个代码应该'自知'
+		Env:   env.ServiceEnv,  // 服务环境, 这个应该从配置中心拿到
 		Call:  Call{},
 		App:   App{},
 		Service: Service{
@@ -96,11 +104,13 @@ func NewAccessLog() *AccessLog {
 func (ac *AccessLog) Reset(t time.Time) {
 	ac.Ts = t.UTC().Format("2006-01-02T15:04:05.000Z07:00")
 	ac.ReqID = ""
+	ac.TraceID = ""
 	ac.Dura = 0
 	ac.Err = 0
 	ac.Msg = ""
 	ac.CIP = ""
 	ac.Proto = ""
+	ac.Quota = nil
 	ac.Call.Depth = 0
 	ac.Call.From = ""
 	ac.Call.To = ""
@@ -116,9 +126,11 @@ func (ac *AccessLog) Reset(t time.Time) {
 	ac.Service.Endpoint = ""
 	ac.Service.Action = ""
 	ac.Service.Desc = ""
+	ac.Service.Stage = ""
 	ac.Service.RowKey = ""
 	ac.Service.New = ""
 	ac.Service.Old = ""
+	ac.Service.Denied = ""
 	ac.Service.User.IP = ""
 	ac.Service.User.Id = ""
 	ac.Service.User.ExtId = ""
@@ -127,9 +139,21 @@ func (ac *AccessLog) Reset(t time.Time) {
 
 // clone
 func (ac *AccessLog) Clone() *AccessLog {
-	nac := NewAccessLog()
+	nac := &AccessLog{
+		Ver:   ac.Ver,
+		Host:  ac.Host,
+		SId:   ac.SId,
+		SName: ac.SName,
+		Env:   ac.Env,
+		Call:  Call{},
+		App:   App{},
+		Service: Service{
+			User: User{},
+		},
+	}
 	nac.Ts = time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00")
 	nac.ReqID = ac.ReqID
+	nac.TraceID = ac.TraceID
 	nac.CIP = ac.CIP
 	return nac
 }
@@ -137,9 +161,9 @@ func (ac *AccessLog) Clone() *AccessLog {
 // 记录access日志
 // access应该是最外层的middleware
 func Access() MiddlewareFunc {
-	accessor := Accessor()
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c *Context) (err error) {
+			accessor := c.App().Accessor()
 			if accessor == nil { // 没有定义则跳过
 				return next(c)
 			}
@@ -149,6 +173,7 @@ func Access() MiddlewareFunc {
 			ac.CIP = c.ClientIP()
 			// request id
 			ac.ReqID = c.RequestID()
+			ac.TraceID = c.TraceID()
 
 			if err = next(c); err != nil {
 				c.ERROR(err) // 这里必须处理error, 否则access抓不到
@@ -177,7 +202,7 @@ func Access() MiddlewareFunc {
 			ac.App.Ext = c.Ext()
 			// app
 			ac.App.Query = c.Query()
-			ac.App.Params = c.Params()
+			ac.App.Params = redactQuery(c.Params())
 			ac.App.Host = c.Host()
 			ac.App.Origin = c.Origin()
 			ac.App.Status = c.Status()
@@ -205,11 +230,11 @@ func Access() MiddlewareFunc {
 }
 
 // get access logger(accessor)
-func Accessor() wlog.Logger { return wgo.Accessor() }
+func Accessor() wlog.Logger { return Self().Accessor() }
 func (w *WGO) Accessor() wlog.Logger {
-	if w.accessor == nil {
+	w.accessOnce.Do(func() {
 		ac := &AccessConfig{}
-		if err := wgo.Cfg().UnmarshalKey(environ.CFG_KEY_ACCESS, ac); err == nil { // 有配置
+		if err := w.Cfg().UnmarshalKey(environ.CFG_KEY_ACCESS, ac); err == nil { // 有配置
 			accessor := make(wlog.Logger)
 			if ac.Path != "" { // file
 				accessor.Start(wlog.LogConfig{
@@ -244,6 +269,44 @@ func (w *WGO) Accessor() wlog.Logger {
 				w.accessor = accessor
 			}
 		}
-	}
+	})
 	return w.accessor
 }
+
+// 日志中隐藏的query参数, 比配置的规则(isSecretKey)窄, 避免把keyword/author等也隐藏了
+var secretParams = []string{"token", "pass", "pwd", "secret", "signature", "credential", "session"}
+
+func isSecretParam(name string) bool {
+	k := strings.ToLower(name)
+	if k == "authorization" || strings.HasSuffix(k, "key") {
+		return true
+	}
+	for _, sp := range secretParams {
+		if strings.Contains(k, sp) {
+			return true
+		}
+	}
+	return false
+}
+
+// querystring中的token/password等参数的值替换为******, 其余保持原样
+func redactQuery(qs string) string {
+	if qs == "" {
+		return qs
+	}
+	parts := strings.Split(qs, "&")
+	for i, p := range parts {
+		k := p
+		if j := strings.IndexByte(p, '='); j >= 0 {
+			k = p[:j]
+		}
+		name := k
+		if uk, err := url.QueryUnescape(k); err == nil {
+			name = uk
+		}
+		if isSecretParam(name) {
+			parts[i] = k + "=" + redacted
+		}
+	}
+	return strings.Join(parts, "&")
+}
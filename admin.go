@@ -0,0 +1,425 @@
+//
+// admin.go
+// 管理接口(mode: admin): pprof, 路由, 配置, cron, works, cache, 版本, 运行时修改日志级别
+// 必须配置token, 或者只监听在本机
+//
+
+package wgo
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	rtrace "runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+
+	"wgo/cache"
+	"wgo/cron"
+	"wgo/environ"
+	"wgo/whttp"
+)
+
+// 配置中名称包含这些的项都隐藏
+var (
+	secretKeys  = []string{"pass", "pwd", "secret", "token", "credential", "private", "auth", "dsn", "key"}
+	secretNoKey = []string{"key_file", "keyfile"} // 这些是路径, 不隐藏
+	userinfoRe  = regexp.MustCompile(`([^:/@\s]+):([^@\s]+)@`)
+)
+
+const redacted = "******"
+
+/* {{{ func (w *WGO) serveAdmin()
+ * 没有token且没有只监听本机的admin server直接去掉, 不监听
+ */
+func (w *WGO) serveAdmin() {
+	for _, s := range w.AdminServers() {
+		if s.Token() == "" && !isLoopbackAddr(s.Addr()) {
+			w.Error("[serveAdmin]admin server(%s) must set a token or listen on localhost, addr: %s", s.Name(), s.Addr())
+			w.removeServer(s)
+			continue
+		}
+		ss := Servers{s}
+		ss.Use(Recover())
+		ss.Use(Access())
+		ss.Use(adminGuard(s.Token()))
+
+		ss.GET("/", adminIndex)
+		ss.GET("/routes", adminRoutes)
+		ss.GET("/config", adminConfig)
+		ss.GET("/cron", adminCron)
+		ss.GET("/works", adminWorks)
+		ss.GET("/caches", adminCaches)
+		ss.GET("/version", adminVersion)
+		ss.GET("/quota", adminQuota)
+		if !w.Cfg().Bool(environ.CFG_KEY_METRICS + ".disable") {
+			ss.GET(defaultMetricsPath, metricsHandler)
+		}
+		ss.GET("/loglevel", adminLogLevel)
+		ss.PUT("/loglevel", adminSetLogLevel)
+		if !w.Cfg().Bool(environ.CFG_KEY_HEALTH + ".disable") {
+			probeRoutes(ss, true)
+		}
+
+		// pprof
+		ss.GET("/debug/pprof/", pprofIndex)
+		ss.GET("/debug/pprof/cmdline", pprofCmdline)
+		ss.GET("/debug/pprof/profile", pprofProfile)
+		ss.GET("/debug/pprof/trace", pprofTrace)
+		ss.GET("/debug/pprof/:name", pprofLookup)
+		w.Info("[serveAdmin]admin server(%s) on %s", s.Name(), s.Addr())
+	}
+}
+
+/* }}} */
+
+/* {{{ func adminGuard(token string) MiddlewareFunc
+ * 有token时校验token(只接受Authorization: Bearer, 不从query读取, 避免出现在日志/代理/浏览器历史中), 否则只允许本机访问
+ * admin不经过Prepare, 不会有CORS头
+ */
+func adminGuard(token string) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if token != "" {
+				given := ""
+				if auth := c.RequestHeader().Get(whttp.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+					given = strings.TrimPrefix(auth, "Bearer ")
+				}
+				if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+					return c.NewError(whttp.StatusUnauthorized*1000, "invalid admin token")
+				}
+			} else if ip := net.ParseIP(c.ClientIP()); ip == nil || !ip.IsLoopback() {
+				return c.NewError(whttp.StatusForbidden*1000, "admin is only available from localhost")
+			}
+			c.SetHeader("Cache-Control", "no-cache")
+			return next(c)
+		}
+	}
+}
+
+/* }}} */
+
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func adminIndex(c *Context) error {
+	return c.JSON(whttp.StatusOK, []string{
+		"/routes", "/config", "/cron", "/works", "/caches", "/version", "/quota", "/metrics",
+		"/loglevel", "/healthz", "/readyz", "/livez", "/debug/pprof/",
+	})
+}
+
+/* {{{ func adminRoutes(c *Context) error
+ * 各http server(包括admin)的路由, middleware为实际生效的顺序
+ */
+type adminRoute struct {
+	Method     string                 `json:"method"`
+	Path       string                 `json:"path"`
+	Middleware []string               `json:"middleware"`
+	Options    map[string]interface{} `json:"options,omitempty"`
+}
+
+type adminServer struct {
+	Name   string       `json:"name"`
+	Mode   string       `json:"mode"`
+	Addr   string       `json:"addr"`
+	Routes []adminRoute `json:"routes"`
+}
+
+func adminRoutes(c *Context) error {
+	rst := make([]adminServer, 0)
+	for _, s := range c.App().servers {
+		mux, ok := s.Mux().(*whttp.Mux)
+		if !ok {
+			continue
+		}
+		as := adminServer{Name: s.Name(), Mode: s.Mode(), Addr: s.Addr(), Routes: make([]adminRoute, 0)}
+		for _, r := range mux.Router().Routes() {
+			ar := adminRoute{Method: r.Method, Path: r.Path, Middleware: r.Chain()}
+			if opts := r.Options(); len(opts) > 0 {
+				ar.Options = make(map[string]interface{}, len(opts))
+				for k, v := range opts {
+					ar.Options[k] = jsonable(v)
+				}
+			}
+			as.Routes = append(as.Routes, ar)
+		}
+		rst = append(rst, as)
+	}
+	return c.JSON(whttp.StatusOK, rst)
+}
+
+// 不能序列化的(如func)输出%v
+func jsonable(v interface{}) interface{} {
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return v
+}
+
+/* }}} */
+
+/* {{{ func adminConfig(c *Context) error
+ * 合并后的配置, 敏感项隐藏
+ */
+func adminConfig(c *Context) error {
+	return c.JSON(whttp.StatusOK, redact("", c.App().Cfg().AllSettings()))
+}
+
+func redact(key string, v interface{}) interface{} {
+	if isSecretKey(key) {
+		return redacted
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, sv := range t {
+			m[k] = redact(k, sv)
+		}
+		return m
+	case map[interface{}]interface{}: // yaml
+		m := make(map[string]interface{}, len(t))
+		for k, sv := range t {
+			ks := fmt.Sprint(k)
+			m[ks] = redact(ks, sv)
+		}
+		return m
+	case map[string]string:
+		m := make(map[string]interface{}, len(t))
+		for k, sv := range t {
+			m[k] = redact(k, sv)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, sv := range t {
+			l[i] = redact("", sv)
+		}
+		return l
+	case string: // dsn/url中的密码
+		return userinfoRe.ReplaceAllString(t, "$1:"+redacted+"@")
+	default:
+		return jsonable(v)
+	}
+}
+
+func isSecretKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	k := strings.ToLower(key)
+	for _, nk := range secretNoKey {
+		if k == nk {
+			return false
+		}
+	}
+	for _, sk := range secretKeys {
+		if strings.Contains(k, sk) {
+			return true
+		}
+	}
+	return false
+}
+
+/* }}} */
+
+/* {{{ func adminCron(c *Context) error
+ *
+ */
+type adminCronEntry struct {
+	Spec string     `json:"spec"`
+	Job  string     `json:"job"`
+	Next *time.Time `json:"next,omitempty"`
+	Prev *time.Time `json:"prev,omitempty"`
+}
+
+func adminCron(c *Context) error {
+	rst := make([]adminCronEntry, 0)
+	if cr := c.App().Cron(); cr != nil {
+		for _, e := range cr.Entries() {
+			ae := adminCronEntry{Spec: e.Spec, Job: jobName(e.Job)}
+			if !e.Next.IsZero() {
+				next := e.Next
+				ae.Next = &next
+			}
+			if !e.Prev.IsZero() {
+				prev := e.Prev
+				ae.Prev = &prev
+			}
+			rst = append(rst, ae)
+		}
+	}
+	return c.JSON(whttp.StatusOK, rst)
+}
+
+func jobName(j cron.Job) string {
+	if fj, ok := j.(cron.FuncJob); ok {
+		if f := runtime.FuncForPC(reflect.ValueOf(fj).Pointer()); f != nil {
+			return f.Name()
+		}
+	}
+	return reflect.TypeOf(j).String()
+}
+
+/* }}} */
+
+func adminWorks(c *Context) error {
+	rst := make([]WorkStats, 0)
+	for _, wp := range c.App().Works() {
+		rst = append(rst, wp.Stats())
+	}
+	return c.JSON(whttp.StatusOK, rst)
+}
+
+func adminCaches(c *Context) error {
+	return c.JSON(whttp.StatusOK, cache.Stats())
+}
+
+func adminVersion(c *Context) error {
+	host, _ := os.Hostname()
+	return c.JSON(whttp.StatusOK, map[string]interface{}{
+		"version":     Version(),
+		"wgo":         WGO_VERSION,
+		"prerelease":  VersionPrerelease,
+		"app_version": AppVersion,
+		"git_commit":  GitCommit,
+		"build_time":  BuildTime,
+		"package":     Package,
+		"level":       Level(),
+		"go":          runtime.Version(),
+		"proc_name":   c.App().Env().ProcName,
+		"hostname":    host,
+		"pid":         os.Getpid(),
+	})
+}
+
+// ?app=xxx 查询app的配额用量
+func adminQuota(c *Context) error {
+	app := c.QueryParam("app")
+	if app == "" {
+		return c.NewError(whttp.StatusBadRequest*1000, "app is required")
+	}
+	return quotaResponse(c, app)
+}
+
+/* {{{ log level
+ * GET返回各filter的level, PUT ?level=info[&tag=xxx] 修改, level可以是"DEBUG|INFO"组合
+ */
+func adminLogLevel(c *Context) error {
+	return c.JSON(whttp.StatusOK, c.App().Env().Logger().Levels())
+}
+
+func adminSetLogLevel(c *Context) error {
+	lvl := c.QueryParam("level")
+	if lvl == "" {
+		return c.NewError(whttp.StatusBadRequest*1000, "level is required")
+	}
+	var tags []string
+	if tag := c.QueryParam("tag"); tag != "" {
+		tags = strings.Split(tag, ",")
+	}
+	if err := c.App().Env().Logger().SetLevel(lvl, tags...); err != nil {
+		return c.NewError(whttp.StatusBadRequest*1000, err.Error())
+	}
+	c.Warn("[admin]log level changed to %s, tags: %v", lvl, tags)
+	return c.JSON(whttp.StatusOK, c.App().Env().Logger().Levels())
+}
+
+/* }}} */
+
+/* {{{ pprof
+ * 直接使用runtime/pprof, 不引入net/http/pprof(会注册到http.DefaultServeMux)
+ * curl -H "Authorization: Bearer xxx" -o heap.pprof http://127.0.0.1:9100/debug/pprof/heap && go tool pprof heap.pprof
+ */
+func pprofIndex(c *Context) error {
+	ps := make(map[string]int)
+	for _, p := range pprof.Profiles() {
+		ps[p.Name()] = p.Count()
+	}
+	return c.JSON(whttp.StatusOK, map[string]interface{}{
+		"profiles": ps,
+		"extra":    []string{"profile?seconds=30", "trace?seconds=1", "cmdline"},
+	})
+}
+
+func pprofCmdline(c *Context) error {
+	return c.String(whttp.StatusOK, strings.Join(os.Args, "\x00"))
+}
+
+// ?debug=1输出文本, ?gc=1先gc(heap)
+func pprofLookup(c *Context) error {
+	p := pprof.Lookup(c.Param("name"))
+	if p == nil {
+		return c.NewError(whttp.StatusNotFound*1000, "unknown profile: "+c.Param("name"))
+	}
+	if c.QueryParam("gc") != "" && p.Name() == "heap" {
+		runtime.GC()
+	}
+	debug, _ := strconv.Atoi(c.QueryParam("debug"))
+	buf := new(bytes.Buffer)
+	if err := p.WriteTo(buf, debug); err != nil {
+		return err
+	}
+	if debug > 0 {
+		return c.Blob(whttp.StatusOK, whttp.MIMETextPlainCharsetUTF8, buf.Bytes())
+	}
+	c.SetHeader(whttp.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, p.Name()))
+	return c.Blob(whttp.StatusOK, whttp.MIMEOctetStream, buf.Bytes())
+}
+
+// cpu profile
+func pprofProfile(c *Context) error {
+	buf := new(bytes.Buffer)
+	if err := pprof.StartCPUProfile(buf); err != nil {
+		return c.NewError(whttp.StatusInternalServerError*1000, "could not enable CPU profiling: "+err.Error())
+	}
+	pprofSleep(c, 30)
+	pprof.StopCPUProfile()
+	c.SetHeader(whttp.HeaderContentDisposition, `attachment; filename="profile"`)
+	return c.Blob(whttp.StatusOK, whttp.MIMEOctetStream, buf.Bytes())
+}
+
+// execution trace
+func pprofTrace(c *Context) error {
+	buf := new(bytes.Buffer)
+	if err := rtrace.Start(buf); err != nil {
+		return c.NewError(whttp.StatusInternalServerError*1000, "could not enable tracing: "+err.Error())
+	}
+	pprofSleep(c, 1)
+	rtrace.Stop()
+	c.SetHeader(whttp.HeaderContentDisposition, `attachment; filename="trace"`)
+	return c.Blob(whttp.StatusOK, whttp.MIMEOctetStream, buf.Bytes())
+}
+
+// ?seconds=n, 请求结束时提前返回
+func pprofSleep(c *Context, def int) {
+	sec, err := strconv.ParseFloat(c.QueryParam("seconds"), 64)
+	if err != nil || sec <= 0 {
+		sec = float64(def)
+	}
+	t := time.NewTimer(time.Duration(sec * float64(time.Second)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-c.Context().Done():
+	}
+}
+
+/* }}} */
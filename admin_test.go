@@ -0,0 +1,50 @@
+package wgo_test
+
+import (
+	"testing"
+
+	"wgo"
+	"wgo/wgotest"
+)
+
+func TestAdminGuard(t *testing.T) {
+	app := wgotest.New("proc_name: admintest")
+	defer app.Close()
+	wgo.GET("/admin", func(c *wgo.Context) error { return c.String(200, "ok") }, wgo.AdminGuard("t0ken"))
+
+	cases := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		code    int
+	}{
+		{"bearer", "/admin", map[string]string{"Authorization": "Bearer t0ken"}, 200},
+		{"wrong bearer", "/admin", map[string]string{"Authorization": "Bearer other"}, 401},
+		{"no token", "/admin", nil, 401},
+		{"query token", "/admin?token=t0ken", nil, 401},
+		{"basic", "/admin", map[string]string{"Authorization": "Basic t0ken"}, 401},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			app.GET(c.path, c.headers).Expect(t, c.code)
+		})
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	cases := []struct{ in, out string }{
+		{"", ""},
+		{"a=1&b=2", "a=1&b=2"},
+		{"token=abc&page=2", "token=******&page=2"},
+		{"access_token=abc&password=x&api_key=k&sign=1", "access_token=******&password=******&api_key=******&sign=1"},
+		{"X-Signature=s&Authorization=Bearer+x", "X-Signature=******&Authorization=******"},
+		{"%74oken=abc", "%74oken=******"},
+		{"token", "token=******"},
+		{"keyword=go&author=me", "keyword=go&author=me"},
+	}
+	for _, c := range cases {
+		if got := wgo.RedactQuery(c.in); got != c.out {
+			t.Errorf("%q: %q, want %q", c.in, got, c.out)
+		}
+	}
+}
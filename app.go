@@ -0,0 +1,76 @@
+//
+// app.go
+// NewApp的参数
+//
+
+package wgo
+
+import (
+	"path/filepath"
+	"strings"
+
+	"wgo/environ"
+	"wgo/server"
+	"wgo/storage"
+)
+
+type (
+	appOptions struct {
+		cfg     *environ.Config
+		level   string
+		storage *storage.Storage
+		servers []server.Config
+	}
+
+	appConfigFile    string
+	appConfigContent struct {
+		content string
+		typ     string
+	}
+	appLevel string
+)
+
+// 配置文件, 类型由后缀决定(json/yaml/toml...), 默认json
+func ConfigFile(path string) interface{} {
+	return appConfigFile(path)
+}
+
+// 配置内容, typ为json/yaml等
+func ConfigContent(content, typ string) interface{} {
+	return appConfigContent{content: content, typ: typ}
+}
+
+// 环境级别, dev/testing/production, 默认为AppLevel
+func RunLevel(level string) interface{} {
+	return appLevel(level)
+}
+
+func newAppOptions(opts ...interface{}) *appOptions {
+	ao := &appOptions{level: AppLevel}
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case *environ.Config:
+			ao.cfg = o
+		case appConfigFile:
+			ct := strings.TrimPrefix(filepath.Ext(string(o)), ".")
+			if ct == "" {
+				ct = "json"
+			}
+			ao.cfg = environ.NewConfig().ReadInConfig(string(o), ct)
+		case appConfigContent:
+			ao.cfg = environ.NewConfig().ReadConfig(o.content, o.typ)
+		case appLevel:
+			ao.level = string(o)
+		case *storage.Storage:
+			ao.storage = o
+		case server.Config:
+			ao.servers = append(ao.servers, o)
+		case []server.Config:
+			ao.servers = append(ao.servers, o...)
+		}
+	}
+	if ao.cfg == nil {
+		ao.cfg = environ.NewConfig()
+	}
+	return ao
+}
@@ -10,8 +10,7 @@ import (
 
 // wgo online cache
 func Cache() MiddlewareFunc {
-	cache := wcache.NewCache()
-	wcache.SetLogger(wgo)
+	cache := wcache.NewCache("response")
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c *Context) (err error) {
 			switch c.ServerMode() {
@@ -23,11 +23,15 @@ func hashFunc(data []byte) uint64 {
 // If the size is set relatively large, you should call
 // `debug.SetGCPercent()`, set it to a much smaller value
 // to limit the memory consumption and GC pause time.
-func NewCache() (cache *Cache) {
+// 传入name时输出指标(wgo_cache_*{cache="name"})
+func NewCache(name ...string) (cache *Cache) {
 	cache = new(Cache)
 	for i := 0; i < 256; i++ {
 		cache.buckets[i] = newBucket(i)
 	}
+	if len(name) > 0 && name[0] != "" {
+		register(name[0], cache)
+	}
 	return
 }
 
@@ -0,0 +1,121 @@
+//
+// metrics.go
+// 有名称的cache输出指标, 同名的多个cache(如每个server一个)合并
+//
+
+package cache
+
+import (
+	"sort"
+	"sync"
+
+	"wgo/metrics"
+)
+
+var (
+	nlock sync.Mutex
+	named = make(map[string][]*Cache)
+)
+
+func init() {
+	metrics.MustRegister(
+		metrics.NewGaugeFunc("wgo_cache_entries", "Number of entries in cache.",
+			collect(func(c *Cache) float64 { return float64(c.EntryCount()) })),
+		metrics.NewCounterFunc("wgo_cache_hits_total", "Cache lookups that found an entry.",
+			collect(func(c *Cache) float64 { return float64(c.HitCount()) })),
+		metrics.NewCounterFunc("wgo_cache_lookups_total", "Total cache lookups.",
+			collect(func(c *Cache) float64 { return float64(c.LookupCount()) })),
+		metrics.NewCounterFunc("wgo_cache_evictions_total", "Entries evicted to make room for new ones.",
+			collect(func(c *Cache) float64 { return float64(c.EvacuateCount()) })),
+		metrics.NewCounterFunc("wgo_cache_expired_total", "Entries removed after expiration.",
+			collect(func(c *Cache) float64 { return float64(c.ExpiredCount()) })),
+		metrics.NewGaugeFunc("wgo_cache_hit_ratio", "Cache hit ratio since start.", hitRatio),
+	)
+}
+
+// 记录cache, 输出指标时使用
+func register(name string, c *Cache) {
+	nlock.Lock()
+	named[name] = append(named[name], c)
+	nlock.Unlock()
+}
+
+func names() []string {
+	ns := make([]string, 0, len(named))
+	for n := range named {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// 统计, 同名cache合并
+type Stat struct {
+	Name      string  `json:"name"`
+	Instances int     `json:"instances"`
+	Entries   int64   `json:"entries"`
+	Hits      int64   `json:"hits"`
+	Lookups   int64   `json:"lookups"`
+	HitRatio  float64 `json:"hit_ratio"`
+	Evictions int64   `json:"evictions"`
+	Expired   int64   `json:"expired"`
+	Overwrite int64   `json:"overwrite"`
+}
+
+// 所有有名称cache的统计
+func Stats() []Stat {
+	nlock.Lock()
+	defer nlock.Unlock()
+	st := make([]Stat, 0, len(named))
+	for _, n := range names() {
+		s := Stat{Name: n, Instances: len(named[n])}
+		for _, c := range named[n] {
+			s.Entries += c.EntryCount()
+			s.Hits += c.HitCount()
+			s.Lookups += c.LookupCount()
+			s.Evictions += c.EvacuateCount()
+			s.Expired += c.ExpiredCount()
+			s.Overwrite += c.OverwriteCount()
+		}
+		if s.Lookups > 0 {
+			s.HitRatio = float64(s.Hits) / float64(s.Lookups)
+		}
+		st = append(st, s)
+	}
+	return st
+}
+
+func collect(fn func(*Cache) float64) func() []metrics.Sample {
+	return func() []metrics.Sample {
+		nlock.Lock()
+		defer nlock.Unlock()
+		ss := make([]metrics.Sample, 0, len(named))
+		for _, n := range names() {
+			var v float64
+			for _, c := range named[n] {
+				v += fn(c)
+			}
+			ss = append(ss, metrics.Sample{Labels: metrics.Labels("cache", n), Value: v})
+		}
+		return ss
+	}
+}
+
+func hitRatio() []metrics.Sample {
+	nlock.Lock()
+	defer nlock.Unlock()
+	ss := make([]metrics.Sample, 0, len(named))
+	for _, n := range names() {
+		var hits, lookups int64
+		for _, c := range named[n] {
+			hits += c.HitCount()
+			lookups += c.LookupCount()
+		}
+		ratio := 0.0
+		if lookups > 0 {
+			ratio = float64(hits) / float64(lookups)
+		}
+		ss = append(ss, metrics.Sample{Labels: metrics.Labels("cache", n), Value: ratio})
+	}
+	return ss
+}
@@ -8,11 +8,42 @@ import (
 
 	// project
 	"wgo/daemon"
+	"wgo/environ"
 )
 
-func interceptCmd(tag string) {
-	if running, pid := daemon.CheckStatus(wgo.Daemon.PidFile); running {
-		fmt.Printf("%s is running at %d\n", wgo.Daemon.ProcName, pid)
+// 自定义命令, app <name> [flags] [args], 在Run时执行后退出
+type CommandFunc func(w *WGO, args []string) error
+
+var commands = make(map[string]CommandFunc)
+
+/* {{{ func AddCommand(name string, fn CommandFunc)
+ * 需要在init()中调用(解析命令行之前)
+ */
+func AddCommand(name string, fn CommandFunc) {
+	commands[name] = fn
+	environ.AddCommand(name)
+}
+
+/* }}} */
+
+// 执行自定义命令, 不是自定义命令返回false
+func (w *WGO) runCmd(tag string) bool {
+	fn, ok := commands[tag]
+	if !ok {
+		return false
+	}
+	w.Logger().DenyConsole()
+	if err := fn(w, environ.CommandArgs()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", tag, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+	return true
+}
+
+func (w *WGO) interceptCmd(tag string) {
+	if running, pid := daemon.CheckStatus(w.Daemon.PidFile); running {
+		fmt.Printf("%s is running at %d\n", w.Daemon.ProcName, pid)
 		switch tag {
 		case "status":
 			// do nothing
@@ -23,9 +54,9 @@ func interceptCmd(tag string) {
 				} else if err := proc.Signal(syscall.SIGINT); err != nil {
 					fmt.Printf("send sig(%s) to %d error: %s", syscall.SIGINT, pid, err)
 				}
-				fmt.Printf("%s is stopping \n", wgo.Daemon.ProcName)
+				fmt.Printf("%s is stopping \n", w.Daemon.ProcName)
 			} else {
-				fmt.Printf("%s's pid lost\n", wgo.Daemon.ProcName)
+				fmt.Printf("%s's pid lost\n", w.Daemon.ProcName)
 			}
 		case "reload":
 			if pid > 0 {
@@ -34,9 +65,9 @@ func interceptCmd(tag string) {
 				} else if err := proc.Signal(syscall.SIGHUP); err != nil {
 					fmt.Printf("send sig(%s) to %d error: %s", syscall.SIGHUP, pid, err)
 				}
-				fmt.Printf("%s is reloading\n", wgo.Daemon.ProcName)
+				fmt.Printf("%s is reloading\n", w.Daemon.ProcName)
 			} else {
-				fmt.Printf("%s's pid lost\n", wgo.Daemon.ProcName)
+				fmt.Printf("%s's pid lost\n", w.Daemon.ProcName)
 			}
 		case "help", "h":
 			fmt.Println("working")
@@ -44,7 +75,7 @@ func interceptCmd(tag string) {
 			fmt.Println("invalid command")
 		}
 	} else {
-		fmt.Printf("%s not running\n", wgo.Daemon.ProcName)
+		fmt.Printf("%s not running\n", w.Daemon.ProcName)
 	}
 	time.Sleep(20 * time.Millisecond)
 	os.Exit(0)
@@ -2,9 +2,12 @@ package wgo
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"wgo/environ"
@@ -15,6 +18,8 @@ import (
 
 type (
 	Context struct {
+		app      *WGO
+		ctxMu    sync.RWMutex // context可能被下游(如net/http)的goroutine读取, 同时被middleware替换
 		context  context.Context
 		mux      server.Mux
 		request  interface{} // 具体的request在各自包内定义
@@ -33,64 +38,142 @@ type (
 		mode     string
 		access   *AccessLog
 		noCache  bool
+
+		// detached, 没有request时使用
+		cancel   context.CancelFunc
+		userID   string
+		clientIP string
+		depth    uint64
+	}
+
+	// 只保留values, 不继承deadline和cancel
+	detachedContext struct {
+		context.Context
 	}
 )
 
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
 // Generator
-func NewContext() interface{} {
+func NewContext() interface{} { return Self().NewContext() }
+func (w *WGO) NewContext() interface{} {
 	c := &Context{
-		access: NewAccessLog(),
+		app:    w,
+		access: w.NewAccessLog(),
 	}
-	c.SetLogger(Logger())
+	c.SetLogger(w.Logger())
 	return c
 }
 
-// clone
+// 所属的app
+func (c *Context) App() *WGO {
+	if c.app != nil {
+		return c.app
+	}
+	return Self()
+}
+
+// clone, 同Detach
 func (c *Context) Clone() *Context {
-	nc := &Context{}
-	nc.context = context.Background()
+	return c.Detach()
+}
+
+/* {{{ func (c *Context) Detach() *Context
+ * 独立于请求的context, 请求结束后Context会回到sync.Pool被复用, 之后还要运行的逻辑应该使用Detach
+ * 保留request id, user id, depth, logger, access以及Set的值, 不包含request/response
+ * 不继承请求的deadline和cancel, 有自己的cancel(见Cancel)
+ */
+func (c *Context) Detach() *Context {
+	parent := c.Context()
+	if parent == nil {
+		parent = context.Background()
+	}
+	nc := &Context{app: c.app}
+	nc.context, nc.cancel = context.WithCancel(detachedContext{parent})
 	nc.mode = "job"
 	nc.start = time.Now()
 	nc.access = c.Access().Clone()
 	nc.reqID = c.RequestID()
+	nc.userID = c.UserID()
+	nc.clientIP = c.ClientIP()
+	nc.depth = c.Depth()
 	nc.logger = c.logger
 	return nc
 }
 
+/* }}} */
+
+/* {{{ func (c *Context) Go(fn func(*Context)) *Context
+ * 在新的goroutine中运行fn, fn拿到的是Detach的context, panic会被recover并记录
+ * 返回该context, 可以用Cancel通知fn结束(fn需要检查c.Done())
+ */
+func (c *Context) Go(fn func(*Context)) *Context {
+	nc := c.Detach()
+	go func() {
+		defer nc.Cancel()
+		defer func() {
+			if r := recover(); r != nil {
+				stack := make([]byte, 4<<10)
+				length := runtime.Stack(stack, false)
+				nc.Error("[Context.Go]%s %s", fmt.Sprint(r), stack[:length])
+			}
+		}()
+		fn(nc)
+	}()
+	return nc
+}
+
+/* }}} */
+
+// 取消Detach出的context
+func (c *Context) Cancel() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
 func (c *Context) Access() *AccessLog {
 	return c.access
 }
 
 func (c *Context) Context() context.Context {
+	c.ctxMu.RLock()
+	defer c.ctxMu.RUnlock()
 	return c.context
 }
 
 func (c *Context) SetContext(ctx context.Context) {
+	c.ctxMu.Lock()
 	c.context = ctx
+	c.ctxMu.Unlock()
 }
 
 func (c *Context) Deadline() (time.Time, bool) {
-	return c.context.Deadline()
+	return c.Context().Deadline()
 }
 
 func (c *Context) Done() <-chan struct{} {
-	return c.context.Done()
+	return c.Context().Done()
 }
 
 func (c *Context) Err() error {
-	return c.context.Err()
+	return c.Context().Err()
 }
 
 func (c *Context) Set(key string, val interface{}) {
+	c.ctxMu.Lock()
 	c.context = context.WithValue(c.context, key, val)
+	c.ctxMu.Unlock()
 }
 
 func (c *Context) Get(key string) interface{} {
-	return c.context.Value(key)
+	return c.Context().Value(key)
 }
 
 func (c *Context) Value(key interface{}) interface{} {
-	return c.context.Value(key)
+	return c.Context().Value(key)
 }
 
 func (c *Context) Job() *Job {
@@ -225,7 +308,7 @@ func (c *Context) ClientIP() string {
 		}
 	default:
 	}
-	return ""
+	return c.clientIP
 }
 
 // method
@@ -430,18 +513,31 @@ func (c *Context) UserIP() string {
 		return c.ClientIP()
 	default:
 	}
-	return ""
+	return c.clientIP
 }
 
-// userid
+// userid, 认证(如JWT)设置的优先于header
 func (c *Context) UserID() string {
+	if c.userID != "" {
+		return c.userID
+	}
 	switch c.ServerMode() {
 	case "http", "https", "whttp":
 		return c.Request().(whttp.Request).Header().Get(whttp.HeaderXUserId)
 	case "rpc", "wrpc", "grpc":
 	default:
 	}
-	return ""
+	return c.userID
+}
+
+// 认证之后设置userid
+func (c *Context) SetUserID(uid string) {
+	c.userID = uid
+}
+
+// 认证(如JWT)或者SetUserID设置的userid, 不使用客户端传入的header
+func (c *Context) AuthUserID() string {
+	return c.userID
 }
 
 // depth
@@ -453,8 +549,9 @@ func (c *Context) Depth() uint64 {
 				return depth + 1
 			}
 		}
+		return 0
 	}
-	return 0
+	return c.depth
 }
 
 // from
@@ -466,6 +563,18 @@ func (c *Context) From() string {
 	return ""
 }
 
+// 客户端应用id(X-App-Id), 没有则为rest.Client发送的X-WGO-AppId, 用于限流/配额
+func (c *Context) AppID() string {
+	switch c.ServerMode() {
+	case "http", "https", "whttp":
+		if app := c.Request().(whttp.Request).Header().Get(whttp.HeaderXClientAppId); app != "" {
+			return app
+		}
+		return c.From()
+	}
+	return ""
+}
+
 // get pre request id
 func (c *Context) PreRequestId() string {
 	switch c.ServerMode() {
@@ -477,7 +586,7 @@ func (c *Context) PreRequestId() string {
 
 // cfg
 func (c *Context) Cfg() *environ.Config {
-	return Cfg()
+	return c.App().Cfg()
 }
 
 // router
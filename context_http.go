@@ -152,15 +152,17 @@ func ContentTypeByExtension(name string) (t string) {
 }
 
 func (c *Context) HTTPReset(req whttp.Request, res whttp.Response) {
-	c.context = context.Background()
+	c.SetContext(context.Background())
 	c.request = req
 	c.response = res
 	c.mode = "http"
 	c.start = time.Now()
 	c.access.Reset(c.start)
 	c.auth = false
+	c.userID = ""
 	c.encoding = ""
 	c.node = nil
+	c.path = ""
 	c.reqID = ""
 	c.noCache = false
 	// c.ext = nil
@@ -179,7 +181,7 @@ func (c *Context) String(code int, s string) (err error) {
 }
 
 func (c *Context) JSON(code int, i interface{}, opts ...interface{}) (err error) {
-	if debug || utils.NewParams(opts).BoolByIndex(0) {
+	if c.App().Env().DebugMode || utils.NewParams(opts).BoolByIndex(0) {
 		return c.JSONPretty(code, i, "  ")
 	}
 	b, err := json.Marshal(i)
@@ -223,7 +225,7 @@ func (c *Context) JSONPBlob(code int, callback string, b []byte) (err error) {
 }
 
 func (c *Context) XML(code int, i interface{}) (err error) {
-	if debug {
+	if c.App().Env().DebugMode {
 		return c.XMLPretty(code, i, "  ")
 	}
 	b, err := xml.Marshal(i)
@@ -8,13 +8,14 @@ import (
 
 // reset rpc context
 func (c *Context) RPCReset(req *wrpc.Request, res *wrpc.Response) {
-	c.context = req.Context()
+	c.SetContext(req.Context())
 	c.request = req
 	c.response = res
 	c.mode = "rpc"
 	c.start = time.Now()
 	c.access.Reset(c.start)
 	c.auth = false
+	c.userID = ""
 	c.encoding = ""
 	c.node = nil
 	c.reqID = ""
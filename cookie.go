@@ -12,6 +12,7 @@ type (
 		expires  time.Time
 		secure   bool
 		httpOnly bool
+		sameSite string
 		err      error
 	}
 )
@@ -103,3 +104,14 @@ func (c *cookie) SetHTTPOnly(httpOnly bool) *cookie {
 	c.httpOnly = httpOnly
 	return c
 }
+
+// SameSite returns the cookie SameSite attribute.
+func (c *cookie) SameSite() string {
+	return c.sameSite
+}
+
+// SetSameSite sets the cookie SameSite attribute(Lax, Strict, None).
+func (c *cookie) SetSameSite(sameSite string) *cookie {
+	c.sameSite = sameSite
+	return c
+}
@@ -0,0 +1,322 @@
+//
+// cors.go
+// CORS middleware, 规则来自路由option(CORSKey), 配置(cors, 可以按server/route)或SetCORS
+// 没有任何规则时不返回CORS头, 旧的行为(反射Origin并允许credentials)需要配置cors.legacy
+//
+
+package wgo
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"wgo/environ"
+	"wgo/server"
+	"wgo/whttp"
+)
+
+// 路由option的key, 值为*CORSPolicy, false代表该路由不返回CORS头
+const CORSKey = "cors"
+
+var (
+	compiledCORS       sync.Map // *CORSPolicy -> *corsRules
+	defaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"}
+	defaultCORSHeaders = []string{"Origin", "Accept", "Content-Type", "Authorization", "X-Requested-With",
+		whttp.HeaderXRequestId, whttp.HeaderXClientAppId, whttp.HeaderXCSRFToken}
+
+	// cors.legacy, 允许所有origin及header并带credentials
+	legacyCORSPolicy = &CORSPolicy{
+		AllowOrigins:     []string{"*"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"*"},
+		MaxAge:           86400,
+		AllowCredentials: true,
+	}
+)
+
+type (
+	// CORSPolicy 一组CORS规则
+	// AllowOrigins: "*"为所有, 带*的为通配(如https://*.example.com), "re:"开头的为正则, 其他为完全匹配(不区分大小写)
+	// AllowHeaders: "*"为允许请求的所有header
+	CORSPolicy struct {
+		Route            string   `mapstructure:"route"`  // 配置中使用, "GET /users/:id"或"/users/:id"(所有method)
+		Server           string   `mapstructure:"server"` // 配置中使用, server的name, 为空时所有server
+		Disable          bool     `mapstructure:"disable"`
+		AllowOrigins     []string `mapstructure:"allow_origins"`
+		AllowMethods     []string `mapstructure:"allow_methods"` // 默认GET,POST,PUT,PATCH,DELETE,HEAD
+		AllowHeaders     []string `mapstructure:"allow_headers"` // 默认Origin,Accept,Content-Type,Authorization等常用header
+		ExposeHeaders    []string `mapstructure:"expose_headers"`
+		MaxAge           int      `mapstructure:"max_age"` // preflight缓存秒数, 0不返回
+		AllowCredentials bool     `mapstructure:"allow_credentials"`
+	}
+
+	// 预处理之后的规则
+	corsRules struct {
+		*CORSPolicy
+		any      bool
+		origins  map[string]bool
+		patterns []*regexp.Regexp
+		methods  string
+		headers  map[string]bool
+	}
+
+	// CORSConfig 配置中的cors部分, 本身为默认规则
+	CORSConfig struct {
+		CORSPolicy `mapstructure:",squash"`
+		Legacy     bool          `mapstructure:"legacy"` // 默认规则没有origin时使用旧的行为, 不安全, 只用于兼容
+		Servers    []*CORSPolicy `mapstructure:"servers"`
+		Routes     []*CORSPolicy `mapstructure:"routes"`
+	}
+)
+
+/* {{{ func CORS() MiddlewareFunc
+ * preflight(带Access-Control-Request-Method的OPTIONS)直接返回204, 规则按请求的method查找
+ * origin不允许时不返回CORS头(由浏览器拦截), 默认已加载
+ */
+func CORS() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			switch c.ServerMode() {
+			case "rpc", "wrpc", "grpc":
+				return next(c)
+			}
+			origin := c.RequestHeader().Get(whttp.HeaderOrigin)
+			preflight := c.isPreflight()
+			p := c.corsPolicy(preflight)
+			header := c.response.(whttp.Response).Header()
+			if origin != "" {
+				header.Add(whttp.HeaderVary, whttp.HeaderOrigin)
+			}
+			if preflight {
+				header.Add(whttp.HeaderVary, whttp.HeaderAccessControlRequestMethod)
+				header.Add(whttp.HeaderVary, whttp.HeaderAccessControlRequestHeaders)
+				if p != nil && origin != "" && p.allowOrigin(origin) {
+					if hs, ok := p.allowHeaders(c.RequestHeader().Get(whttp.HeaderAccessControlRequestHeaders)); ok {
+						p.setOrigin(header, origin)
+						header.Set(whttp.HeaderAccessControlAllowMethods, p.methods)
+						if hs != "" {
+							header.Set(whttp.HeaderAccessControlAllowHeaders, hs)
+						}
+						if p.MaxAge > 0 {
+							header.Set(whttp.HeaderAccessControlMaxAge, strconv.Itoa(p.MaxAge))
+						}
+					}
+				} else {
+					c.Debug("[CORS]preflight rejected, origin: %s", origin)
+				}
+				c.response.(whttp.Response).WriteHeader(whttp.StatusNoContent)
+				return nil
+			}
+			if p != nil && origin != "" && p.allowOrigin(origin) {
+				p.setOrigin(header, origin)
+				if len(p.ExposeHeaders) > 0 {
+					header.Set(whttp.HeaderAccessControlExposeHeaders, strings.Join(p.ExposeHeaders, ", "))
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func SetCORS(p *CORSPolicy)
+ * 代码中设置规则, p.Server为空时为默认规则, 否则为该server的规则, 会覆盖配置
+ */
+func SetCORS(p *CORSPolicy) { Self().SetCORS(p) }
+func (w *WGO) SetCORS(p *CORSPolicy) {
+	cfg := w.corsConfig()
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if cfg == nil {
+		cfg = &CORSConfig{}
+	}
+	ncfg := &CORSConfig{Legacy: cfg.Legacy, Servers: cfg.Servers, Routes: cfg.Routes}
+	if p.Server == "" {
+		ncfg.CORSPolicy = *p
+	} else {
+		ncfg.CORSPolicy = cfg.CORSPolicy
+		ncfg.Servers = append([]*CORSPolicy{p}, cfg.Servers...)
+	}
+	w.cors = ncfg
+}
+
+/* }}} */
+
+// 读取配置, 没有配置时为nil
+func (w *WGO) corsConfig() *CORSConfig {
+	w.corsOnce.Do(func() {
+		if w.Cfg().IsSet(environ.CFG_KEY_CORS) {
+			cfg := &CORSConfig{}
+			if err := w.Cfg().UnmarshalKey(environ.CFG_KEY_CORS, cfg); err != nil {
+				w.Logger().Error("[cors]invalid config: %s", err)
+			}
+			w.lock.Lock()
+			w.cors = cfg
+			w.lock.Unlock()
+		}
+	})
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.cors
+}
+
+/* {{{ func (c *Context) corsPolicy(preflight bool) *corsRules
+ * 路由option > 配置中的路由 > server > 默认, 为nil时不返回CORS头
+ * preflight时按Access-Control-Request-Method查找路由
+ */
+func (c *Context) corsPolicy(preflight bool) *corsRules {
+	method, path := c.Method(), c.Path()
+	opt := c.Options(CORSKey)
+	if preflight {
+		method = strings.ToUpper(c.RequestHeader().Get(whttp.HeaderAccessControlRequestMethod))
+		path, opt = "", nil
+		if mux, ok := c.Mux().(*whttp.Mux); ok {
+			if rp, opts, ok := mux.Router().Lookup(method, c.Request().(whttp.Request).URL().Path()); ok {
+				path, opt = rp, opts[CORSKey]
+			}
+		}
+	}
+	switch o := opt.(type) {
+	case *CORSPolicy:
+		return o.valid()
+	case bool:
+		if !o {
+			return nil
+		}
+	}
+	cfg := c.App().corsConfig()
+	if cfg == nil {
+		return nil
+	}
+	sn := c.serverName()
+	if path != "" {
+		for _, r := range cfg.Routes {
+			if (r.Route == method+" "+path || r.Route == path) && (r.Server == "" || r.Server == sn) {
+				return r.valid()
+			}
+		}
+	}
+	for _, s := range cfg.Servers {
+		if s.Server == sn {
+			return s.valid()
+		}
+	}
+	if cfg.Legacy && len(cfg.AllowOrigins) == 0 && !cfg.Disable {
+		return legacyCORSPolicy.valid()
+	}
+	return cfg.CORSPolicy.valid()
+}
+
+/* }}} */
+
+// 禁用或没有origin的规则返回nil
+func (cp *CORSPolicy) valid() *corsRules {
+	if cp == nil || cp.Disable || len(cp.AllowOrigins) == 0 {
+		return nil
+	}
+	if p, ok := compiledCORS.Load(cp); ok {
+		return p.(*corsRules)
+	}
+	p, _ := compiledCORS.LoadOrStore(cp, cp.compile())
+	return p.(*corsRules)
+}
+
+// 预处理origin及header
+func (cp *CORSPolicy) compile() *corsRules {
+	p := &corsRules{CORSPolicy: cp}
+	p.origins = make(map[string]bool)
+	for _, o := range p.AllowOrigins {
+		switch {
+		case o == "*":
+			p.any = true
+		case strings.HasPrefix(o, "re:"):
+			// 整个origin匹配, re:example\.com不能匹配example.com.evil.net
+			if re, err := regexp.Compile("^(?:" + strings.TrimPrefix(o, "re:") + ")$"); err == nil {
+				p.patterns = append(p.patterns, re)
+			} else {
+				Error("[cors]invalid origin pattern %s: %s", o, err)
+			}
+		case strings.Contains(o, "*"):
+			ps := strings.Split(regexp.QuoteMeta(strings.ToLower(o)), `\*`)
+			p.patterns = append(p.patterns, regexp.MustCompile("^"+strings.Join(ps, "[a-z0-9.-]+")+"$"))
+		default:
+			p.origins[strings.ToLower(o)] = true
+		}
+	}
+	ms := p.AllowMethods
+	if len(ms) == 0 {
+		ms = defaultCORSMethods
+	}
+	p.methods = strings.ToUpper(strings.Join(ms, ", "))
+	hs := p.AllowHeaders
+	if len(hs) == 0 {
+		hs = defaultCORSHeaders
+	}
+	p.headers = make(map[string]bool)
+	for _, h := range hs {
+		p.headers[strings.ToLower(strings.TrimSpace(h))] = true
+	}
+	return p
+}
+
+func (p *corsRules) allowOrigin(origin string) bool {
+	if p.any {
+		return true
+	}
+	o := strings.ToLower(origin)
+	if p.origins[o] {
+		return true
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// preflight请求的header都允许时返回要回复的Allow-Headers
+func (p *corsRules) allowHeaders(requested string) (string, bool) {
+	if requested == "" {
+		return "", true
+	}
+	if p.headers["*"] {
+		return requested, true
+	}
+	for _, h := range strings.Split(requested, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" && !p.headers[h] {
+			return "", false
+		}
+	}
+	return requested, true
+}
+
+// 允许所有origin且不带credentials时返回*, 否则返回请求的origin
+func (p *corsRules) setOrigin(header server.Header, origin string) {
+	if p.any && !p.AllowCredentials {
+		header.Set(whttp.HeaderAccessControlAllowOrigin, "*")
+	} else {
+		header.Set(whttp.HeaderAccessControlAllowOrigin, origin)
+	}
+	if p.AllowCredentials {
+		header.Set(whttp.HeaderAccessControlAllowCredentials, "true")
+	}
+}
+
+// 带Access-Control-Request-Method的OPTIONS请求
+func (c *Context) isPreflight() bool {
+	return c.Method() == "OPTIONS" && c.RequestHeader().Get(whttp.HeaderAccessControlRequestMethod) != ""
+}
+
+// 当前请求所属server的name
+func (c *Context) serverName() string {
+	for _, s := range c.App().AllServers() {
+		if s.Mux() == c.Mux() {
+			return s.Name()
+		}
+	}
+	return ""
+}
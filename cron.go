@@ -10,21 +10,24 @@ package wgo
 import "wgo/cron"
 
 // init
-func initCron() {
-	c := cron.NewWithLocation(Env().Location).Register()
-	SetCron(c)
+func (w *WGO) initCron() {
+	c := cron.NewWithLocation(w.Env().Location)
+	if w == current() {
+		c.Register()
+	}
+	w.SetCron(c)
 	c.Start()
 }
 
 // get cron
-func Cron() *cron.Cron { return wgo.Cron() }
+func Cron() *cron.Cron { return Self().Cron() }
 func (w *WGO) Cron() *cron.Cron {
 	return w.cron
 }
 
 // set cron
 func SetCron(c *cron.Cron) {
-	wgo.SetCron(c)
+	Self().SetCron(c)
 }
 func (w *WGO) SetCron(c *cron.Cron) {
 	if w != nil {
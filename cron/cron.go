@@ -1,8 +1,10 @@
 package cron
 
 import (
+	"context"
 	"runtime"
 	"sort"
+	"sync"
 	"time"
 )
 
@@ -16,6 +18,7 @@ type Cron struct {
 	snapshot chan []*Entry
 	running  bool
 	location *time.Location
+	jobs     sync.WaitGroup // 正在运行的job
 }
 
 var cron *Cron
@@ -39,6 +42,9 @@ type Schedule interface {
 
 // Entry consists of a schedule and the func to execute on that schedule.
 type Entry struct {
+	// The spec string given to AddJob/AddFunc, empty when added by Schedule.
+	Spec string
+
 	// The schedule on which this job should be run.
 	Schedule Schedule
 
@@ -106,13 +112,18 @@ func (c *Cron) AddJob(spec string, cmd Job) error {
 	if err != nil {
 		return err
 	}
-	c.Schedule(schedule, cmd)
+	c.schedule(spec, schedule, cmd)
 	return nil
 }
 
 // Schedule adds a Job to the Cron to be run on the given schedule.
 func (c *Cron) Schedule(schedule Schedule, cmd Job) {
+	c.schedule("", schedule, cmd)
+}
+
+func (c *Cron) schedule(spec string, schedule Schedule, cmd Job) {
 	entry := &Entry{
+		Spec:     spec,
 		Schedule: schedule,
 		Job:      cmd,
 	}
@@ -158,6 +169,7 @@ func (c *Cron) Run() {
 }
 
 func (c *Cron) runWithRecovery(j Job) {
+	defer c.jobs.Done()
 	defer func() {
 		if r := recover(); r != nil {
 			const size = 64 << 10
@@ -201,6 +213,7 @@ func (c *Cron) run() {
 						break
 					}
 					// Debug("next: %s, now: %s", e.Next, now)
+					c.jobs.Add(1)
 					go c.runWithRecovery(e.Job)
 					e.Prev = e.Next
 					e.Next = e.Schedule.Next(now)
@@ -235,11 +248,29 @@ func (c *Cron) Stop() {
 	c.running = false
 }
 
+// Shutdown stops the scheduler and waits for running jobs to finish,
+// or until ctx is done.
+func (c *Cron) Shutdown(ctx context.Context) error {
+	c.Stop()
+	done := make(chan struct{})
+	go func() {
+		c.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // entrySnapshot returns a copy of the current cron entry list.
 func (c *Cron) entrySnapshot() []*Entry {
 	entries := []*Entry{}
 	for _, e := range c.entries {
 		entries = append(entries, &Entry{
+			Spec:     e.Spec,
 			Schedule: e.Schedule,
 			Next:     e.Next,
 			Prev:     e.Prev,
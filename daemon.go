@@ -1,38 +1,143 @@
 package wgo
 
 import (
-	"os"
+	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	s "wgo/server"
 )
 
+type (
+	// StartHook 在servers启动之前按注册顺序执行, 返回error则Run中止
+	StartHook func() error
+	// StopHook 在请求/job/cron都结束后按注册的逆序执行(先注册的后执行, 同defer)
+	StopHook func(context.Context) error
+)
+
+/* {{{ func OnStart(h StartHook)
+ *
+ */
+func OnStart(h StartHook) { Self().OnStart(h) }
+func (w *WGO) OnStart(h StartHook) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.startHooks = append(w.startHooks, h)
+}
+
+/* }}} */
+
+/* {{{ func OnStop(h StopHook)
+ * storage, db等资源也通过OnStop关闭, 由于逆序执行, 业务注册的hook总是先于它们执行
+ */
+func OnStop(h StopHook) { Self().OnStop(h) }
+func (w *WGO) OnStop(h StopHook) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.stopHooks = append(w.stopHooks, h)
+}
+
+/* }}} */
+
+// run start hooks
+func (w *WGO) start() error {
+	for _, h := range w.startHooks {
+		if err := h(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* {{{ func Shutdown(ctx context.Context) error
+ * 优雅关闭, 只会执行一次
+ * 顺序: servers(停止接收并等待进行中请求) -> works(处理完已接收的job) -> cron -> stop hooks
+ */
+func Shutdown(ctx context.Context) error { return Self().Shutdown(ctx) }
+func (w *WGO) Shutdown(ctx context.Context) error {
+	w.stopOnce.Do(func() {
+		w.stopErr = w.doShutdown(ctx)
+		w.done <- w.stopErr
+	})
+	return w.stopErr
+}
+
+/* }}} */
+
+// daemon收到退出信号时调用
 func (w *WGO) shutdown() {
-	wg := new(sync.WaitGroup)
-	// stop work
-	for _, work := range w.works {
-		w.Info("bye work(%s)", work.Name())
-		work.End()
+	ctx, cancel := context.WithTimeout(context.Background(), w.Env().ShutdownTimeout)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != nil {
+		w.Error("shutdown error: %s", err)
 	}
+}
+
+func (w *WGO) doShutdown(ctx context.Context) error {
+	w.lock.Lock()
+	w.stopping = true
+	w.lock.Unlock()
+
+	var errs []string
 	// shutdown servers
+	wg := new(sync.WaitGroup)
+	var el sync.Mutex
 	for _, server := range w.servers {
 		wg.Add(1)
-		//w.Info("Closing Server(%s,%s)", server.Name(), server.Addr())
 		go func(server *s.Server) {
 			defer wg.Done()
-			if err := server.Close(); err != nil {
-				w.Error("Close server(%s) error: %s", server.Addr(), err)
-			}
-			//w.Info("Shutting down, waiting server(%s, %s) to idle...", server.Name(), server.Addr())
-			if server.IsIdle() {
-				w.Info("bye server(%s, %s)", server.Name(), server.Addr())
+			if err := server.Shutdown(ctx); err != nil {
+				w.Error("Shutdown server(%s) error: %s", server.Addr(), err)
+				el.Lock()
+				errs = append(errs, fmt.Sprintf("server(%s): %s", server.Name(), err))
+				el.Unlock()
+				return
 			}
+			w.Info("bye server(%s, %s)", server.Name(), server.Addr())
 		}(server)
 	}
 	wg.Wait()
+
+	// stop works
+	for _, work := range w.works {
+		if err := work.Shutdown(ctx); err != nil {
+			w.Error("Shutdown work(%s) error: %s", work.Name(), err)
+			errs = append(errs, fmt.Sprintf("work(%s): %s", work.Name(), err))
+			continue
+		}
+		w.Info("bye work(%s)", work.Name())
+	}
+
+	// stop cron
+	if w.cron != nil {
+		if err := w.cron.Shutdown(ctx); err != nil {
+			w.Error("Shutdown cron error: %s", err)
+			errs = append(errs, fmt.Sprintf("cron: %s", err))
+		}
+	}
+
+	// stop hooks, 逆序
+	for i := len(w.stopHooks) - 1; i >= 0; i-- {
+		if err := w.stopHooks[i](ctx); err != nil {
+			w.Error("stop hook error: %s", err)
+			errs = append(errs, err.Error())
+		}
+	}
+
+	// 给日志一点时间
 	time.Sleep(20 * time.Millisecond)
-	os.Exit(0)
+	if len(errs) > 0 {
+		return fmt.Errorf("shutdown: %v", errs)
+	}
+	return nil
+}
+
+// 是否正在关闭
+func (w *WGO) ShuttingDown() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.stopping
 }
 
 /* {{{ func (w *WGO) daemonize()
@@ -40,7 +145,7 @@ func (w *WGO) shutdown() {
  */
 func (w *WGO) daemonize() {
 	if err := w.Daemon.MakeDaemon(); err != nil {
-		Fatal("%s daemonize failed: %s", w.Daemon.ProcName, err)
+		w.Fatal("%s daemonize failed: %s", w.Daemon.ProcName, err)
 	} else if w.Daemon.Daemonize {
 		// deny console logging, and capture stdout
 		w.Logger().DenyConsole()
@@ -47,6 +47,7 @@ type (
 		PidFile     string      // pid file path
 		Files       []**os.File // 文件
 		SignalHooks map[int]map[os.Signal][]func()
+		HammerTime  time.Duration // 收到退出信号后, 超过这个时间强制退出
 
 		logger   logger // Logger for this package
 		state    uint8
@@ -37,17 +37,19 @@ func (d *Daemon) handleSignals() {
 				d.Log("Reload err: %s", err)
 			}
 			// 防止多个进程
-			go d.hammerTime(DefaultHammerTime * time.Second)
+			go d.hammerTime(d.hammerTimeout())
 		case syscall.SIGINT:
 			d.Log(pid, "Received SIGINT.")
 			if d.state == StateRunning {
-				go d.hammerTime(DefaultHammerTime * time.Second)
+				d.state = StateShuttingDown
+				go d.hammerTime(d.hammerTimeout())
 				d.shutdown()
 			}
 		case syscall.SIGTERM:
 			d.Log(pid, "Received SIGTERM.")
 			if d.state == StateRunning {
-				go d.hammerTime(DefaultHammerTime * time.Second)
+				d.state = StateShuttingDown
+				go d.hammerTime(d.hammerTimeout())
 				d.shutdown()
 			}
 		default:
@@ -69,6 +71,14 @@ func (d *Daemon) signalHooks(flag int, sig os.Signal) {
 	return
 }
 
+// hammer timeout, 默认DefaultHammerTime秒
+func (d *Daemon) hammerTimeout() time.Duration {
+	if d.HammerTime > 0 {
+		return d.HammerTime
+	}
+	return DefaultHammerTime * time.Second
+}
+
 /* {{{ func hammerTime()
  *
  */
@@ -38,6 +38,17 @@ const (
 	CFG_KEY_PORT        = "port"
 	CFG_KEY_HOSTS       = "hosts"
 	CFG_KEY_ACCESS      = "access"
+	CFG_KEY_SHUTDOWN    = "shutdown_timeout"
+	CFG_KEY_WORKS       = "works"
+	CFG_KEY_JOBTTL      = "job_result_ttl"
+	CFG_KEY_HEALTH      = "health"
+	CFG_KEY_METRICS     = "metrics"
+	CFG_KEY_TRACE       = "trace"
+	CFG_KEY_RATELIMIT   = "ratelimit"
+	CFG_KEY_QUOTA       = "quota"
+	CFG_KEY_TIMEOUT     = "timeout"
+	CFG_KEY_CORS        = "cors"
+	CFG_KEY_JWT         = "jwt"
 )
 
 type (
@@ -147,6 +158,15 @@ func getConfigInfoFromSysEnv() (string, string) {
 
 /* }}} */
 
+/* {{{ func (cfg *Config) Set(key string, value interface{})
+ * 覆盖配置项
+ */
+func (cfg *Config) Set(key string, value interface{}) {
+	cfg.v.Set(key, value)
+}
+
+/* }}} */
+
 /* {{{ func (cfg *Config) Get(key string) interface{}
  * 封装viper方法
  */
@@ -269,6 +289,15 @@ func (cfg *Config) IsSet(key string) bool {
 
 /* }}} */
 
+/* {{{ func (cfg *Config) AllSettings() map[string]interface{}
+ * 合并后(默认值, 配置文件, 环境变量等)的全部配置
+ */
+func (cfg *Config) AllSettings() map[string]interface{} {
+	return cfg.v.AllSettings()
+}
+
+/* }}} */
+
 /* {{{ func (cfg *Config) Sub(key string) *Config
  *
  */
@@ -21,6 +21,7 @@ const (
 	defaultDaemonize   = false
 	defaultEnableCache = true
 	defaultDockerize   = false
+	defaultShutdown    = 10 * time.Second
 )
 
 var (
@@ -47,6 +48,7 @@ type (
 		ServiceEnv  string // service env
 
 		// app info
+		Level       string // app level, dev/testing/production
 		Hostname    string // hostname
 		ExecPath    string // execute path
 		ProcName    string // proc name
@@ -59,7 +61,8 @@ type (
 		PidFile     string // pidfile abs path
 
 		// options
-		Location *time.Location // location
+		Location        *time.Location // location
+		ShutdownTimeout time.Duration  // 优雅关闭的最长等待时间
 
 		// origin config
 		cfg *Config
@@ -94,12 +97,12 @@ func init() {
  *
  */
 func New(opts ...interface{}) *Environ {
+	w := new(Environ).WithDefaults()
 	if len(opts) > 0 {
 		if lvl, ok := opts[0].(string); ok && lvl != "" {
-			level = lvl
+			w.Level = lvl
 		}
 	}
-	w := new(Environ).WithDefaults()
 	return w
 }
 
@@ -109,6 +112,7 @@ func New(opts ...interface{}) *Environ {
  * wgo default env
  */
 func (env *Environ) WithDefaults() *Environ {
+	env.Level = level
 	env.ExecPath = executePath
 	env.Hostname = hostname
 	env.ProcName = executeName
@@ -119,6 +123,7 @@ func (env *Environ) WithDefaults() *Environ {
 	env.AppDir = executeDir
 	env.PidFile = defaultPidFile
 	env.Location = defaultLocation
+	env.ShutdownTimeout = defaultShutdown
 
 	return env
 }
@@ -140,8 +145,18 @@ func (env *Environ) Register() *Environ {
  */
 func WithConfig() *Environ { return environ.WithConfig() }
 func (env *Environ) WithConfig() *Environ {
-	env.Register()
+	if dbg := BoolFlag(FLAG_KEY_DEBUG); dbg == true {
+		env.DebugMode = true
+	}
+	return env.Register().Configure()
+}
+
+/* }}} */
 
+/* {{{ func (env *Environ) Configure() *Environ
+ * 根据配置设置env, 不注册为全局的environ(同一进程多个app时使用)
+ */
+func (env *Environ) Configure() *Environ {
 	cfg := env.Cfg()
 
 	if pn := cfg.String(CFG_KEY_PROCNAME); pn != "" {
@@ -156,13 +171,10 @@ func (env *Environ) WithConfig() *Environ {
 	if ec := cfg.Bool(CFG_KEY_ENABLECACHE); ec == true {
 		env.EnableCache = ec
 	}
-	if dbg := BoolFlag(FLAG_KEY_DEBUG); dbg == true {
-		env.DebugMode = true
-	} else if dbg := cfg.Bool(CFG_KEY_DEBUG); dbg == true && level != LVL_PRODUCTION {
+	// 命令行指定的debug(见WithConfig)优先
+	if dbg := cfg.Bool(CFG_KEY_DEBUG); dbg == true && !env.DebugMode && env.Level != LVL_PRODUCTION {
 		//  production环境永远不输入debug级别的日志
 		env.DebugMode = true
-	} else {
-		env.DebugMode = false
 	}
 	if ad := cfg.String(CFG_KEY_APPDIR); ad != "" {
 		env.AppDir = cfg.String(CFG_KEY_APPDIR)
@@ -192,8 +204,11 @@ func (env *Environ) WithConfig() *Environ {
 	if env.Location == nil {
 		env.Location, _ = time.LoadLocation(defaultLoc)
 	}
+	if st := cfg.Duration(CFG_KEY_SHUTDOWN); st > 0 {
+		env.ShutdownTimeout = st
+	}
 	// init logger, 在environ初始化logger目的是为了尽可能早的初始化logger
-	env.Logger().Init(cfg)
+	env.Logger().init(env, cfg)
 
 	env.cfg = cfg
 
@@ -202,6 +217,16 @@ func (env *Environ) WithConfig() *Environ {
 
 /* }}} */
 
+/* {{{ func (env *Environ) SetCfg(cfg *Config) *Environ
+ * 使用给定的配置(如内存中的配置), 之后调用WithConfig不会再读取配置文件
+ */
+func (env *Environ) SetCfg(cfg *Config) *Environ {
+	env.cfg = cfg
+	return env
+}
+
+/* }}} */
+
 /* {{{ func (env *Environ) Cfg() *Config
  *
  */
@@ -4,6 +4,7 @@ import (
 	goflags "flag"
 	"fmt"
 	"os"
+	"sync"
 )
 
 type (
@@ -28,8 +29,9 @@ const (
 )
 
 var (
-	flagset *goflags.FlagSet
-	flags   = Flags{ // 这里定义flag
+	flagset   *goflags.FlagSet
+	flagsOnce sync.Once
+	flags     = Flags{ // 这里定义flag
 		FLAG_KEY_CONFIGFILE: &flag{
 			ns: []string{FLAG_KEY_CONFIGFILE, "config", "c"},
 			u:  "configuration file",
@@ -49,27 +51,24 @@ var (
 		},
 	}
 	Test string = "hi"
-)
 
-func init() {
-	// 解析参数
-	parseFlags()
-}
+	commands = []string{FLAG_CMD_STATUS, FLAG_CMD_RELOAD, FLAG_CMD_STOP} // 第一个参数为这些时作为command tag
+)
 
 /* {{{ func parseFlags()
- *
+ * 第一次读取flag时才解析命令行, import时不做任何事
  */
 func parseFlags() {
 	flagset = goflags.NewFlagSet("_ENV_", goflags.ContinueOnError)
-	// command tag
-	if len(os.Args) > 1 {
-		switch os.Args[1] {
-		case FLAG_CMD_STATUS:
-			flags[FLAG_KEY_CMDTAG].v = FLAG_CMD_STATUS
-		case FLAG_CMD_RELOAD:
-			flags[FLAG_KEY_CMDTAG].v = FLAG_CMD_RELOAD
-		case FLAG_CMD_STOP:
-			flags[FLAG_KEY_CMDTAG].v = FLAG_CMD_STOP
+	// command tag, 之后的参数照常解析(app status -c conf.json)
+	args := os.Args[1:]
+	if len(args) > 0 {
+		for _, cmd := range commands {
+			if args[0] == cmd {
+				flags[FLAG_KEY_CMDTAG].v = cmd
+				args = args[1:]
+				break
+			}
 		}
 	}
 	if len(flags) > 0 {
@@ -91,7 +90,7 @@ func parseFlags() {
 			}
 		}
 	}
-	if err := flagset.Parse(os.Args[1:]); err != nil {
+	if err := flagset.Parse(args); err != nil {
 		fmt.Println("[Error] ", err)
 	}
 }
@@ -102,6 +101,7 @@ func parseFlags() {
  *
  */
 func (fs Flags) get(name string) *flag {
+	flagsOnce.Do(parseFlags)
 	if f, ok := fs[name]; ok {
 		return f
 	}
@@ -136,6 +136,30 @@ func CommandTag() string { return flags.String(FLAG_KEY_CMDTAG) }
 
 /* }}} */
 
+/* {{{ func AddCommand(name string)
+ * 增加command tag, 需要在解析命令行(第一次读取flag)之前
+ */
+func AddCommand(name string) {
+	for _, cmd := range commands {
+		if cmd == name {
+			return
+		}
+	}
+	commands = append(commands, name)
+}
+
+/* }}} */
+
+/* {{{ func CommandArgs() []string
+ * flag之后剩余的参数
+ */
+func CommandArgs() []string {
+	flagsOnce.Do(parseFlags)
+	return flagset.Args()
+}
+
+/* }}} */
+
 /* {{{ func BoolFlag(name string) bool
  *
  */
@@ -52,14 +52,15 @@ func (env *Environ) Logger() *logger {
 /* {{{ func (env *Environ) Init(cfg *Config)
  * 通过日志配置初始化日志引擎
  */
-func (l *logger) Init(cfg *Config) {
+func (l *logger) Init(cfg *Config) { l.init(environ, cfg) }
+func (l *logger) init(env *Environ, cfg *Config) {
 	if l.logs = BuildLogs(cfg); len(l.logs) > 0 {
 		for _, log := range l.logs {
 			if log.Format == "" {
 				log.Format = defaultFormat // default format
 			}
-			if environ != nil {
-				log.Location = environ.Location
+			if env != nil {
+				log.Location = env.Location
 			}
 			l.Start(wlog.LogConfig(log))
 		}
@@ -67,12 +68,12 @@ func (l *logger) Init(cfg *Config) {
 		panic("[PANIC] start logger failed!")
 	}
 	// forbid debug
-	if environ.DebugMode {
+	if env.DebugMode {
 		l.Add(wlog.DEBUG)
 	} else {
 		l.Remove(wlog.DEBUG)
 		// 不是debug mode的情况下, 生产环境只放出error以上的日志
-		if level == LVL_PRODUCTION {
+		if env.Level == LVL_PRODUCTION {
 			l.Limit(wlog.ERROR)
 		}
 	}
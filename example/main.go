@@ -12,5 +12,7 @@ import (
 )
 
 func main() {
-	wgo.Run()
+	if err := wgo.Run(); err != nil {
+		wgo.Error("run error: %s", err)
+	}
 }
@@ -0,0 +1,18 @@
+package wgo
+
+import "time"
+
+var (
+	AdminGuard  = adminGuard
+	RedactQuery = redactQuery
+)
+
+// 测试中替换队列的时钟
+func SetQueueNow(q JobQueue, now func() time.Time) {
+	switch t := q.(type) {
+	case *memoryJobQueue:
+		t.now = now
+	case *storageJobQueue:
+		t.now = now
+	}
+}
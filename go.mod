@@ -17,6 +17,7 @@ require (
 	github.com/spaolacci/murmur3 v1.1.0
 	github.com/spf13/viper v1.3.2
 	github.com/valyala/fasthttp v1.18.0
+	github.com/yuin/gopher-lua v1.1.1
 	golang.org/x/crypto v0.0.0-20200709230013-948cd5f35899
 	golang.org/x/net v0.0.0-20201016165138-7b1cca2348c0
 	google.golang.org/grpc v1.20.1
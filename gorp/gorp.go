@@ -13,12 +13,14 @@ package gorp
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -162,6 +164,8 @@ type DbMap struct {
 	tables    map[string]*TableMap
 	logger    GorpLogger
 	logPrefix string
+	alias     string          // Using时的alias, 用于指标
+	ctx       context.Context // 见WithContext
 }
 
 // TableMap represents a mapping between a Go struct and a database table
@@ -1058,6 +1062,11 @@ func (m *DbMap) Update(list ...interface{}) (int64, error) {
 	return update(m, m, list...)
 }
 
+// UpdateWhere 与Update相同, WHERE中增加额外的条件(字段 => 值), 如行级权限, 不满足时affected为0
+func (m *DbMap) UpdateWhere(ptr interface{}, where map[string]interface{}) (int64, error) {
+	return updateWhere(m, m, ptr, where)
+}
+
 // Delete runs a SQL DELETE statement for each element in list.  List
 // items must be pointers.
 //
@@ -1116,9 +1125,10 @@ func (m *DbMap) Select(i interface{}, query string, args ...interface{}) ([]inte
 
 // Exec runs an arbitrary SQL statement.  args represent the bind parameters.
 // This is equivalent to running:  Exec() using database/sql
-func (m *DbMap) Exec(query string, args ...interface{}) (sql.Result, error) {
+func (m *DbMap) Exec(query string, args ...interface{}) (r sql.Result, err error) {
 	m.trace(query, args...)
-	return m.Db.Exec(query, args...)
+	defer m.observe(opExec, query)(&err)
+	return m.Db.ExecContext(m.context(), query, args...)
 }
 
 // SelectInt is a convenience wrapper around the gorp.SelectInt function
@@ -1159,7 +1169,7 @@ func (m *DbMap) SelectOne(holder interface{}, query string, args ...interface{})
 // Begin starts a gorp Transaction
 func (m *DbMap) Begin() (*Transaction, error) {
 	m.trace("begin;")
-	tx, err := m.Db.Begin()
+	tx, err := m.Db.BeginTx(m.context(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1189,7 +1199,7 @@ func (m *DbMap) TableFor(t reflect.Type, checkPK bool) (*TableMap, error) {
 // This is equivalent to running:  Prepare() using database/sql
 func (m *DbMap) Prepare(query string) (*sql.Stmt, error) {
 	m.trace(query, nil)
-	return m.Db.Prepare(query)
+	return m.Db.PrepareContext(m.context(), query)
 }
 
 func tableOrNil(m *DbMap, t reflect.Type) *TableMap {
@@ -1224,12 +1234,14 @@ func (m *DbMap) tableForPointer(ptr interface{}, checkPK bool) (*TableMap, refle
 
 func (m *DbMap) queryRow(query string, args ...interface{}) *sql.Row {
 	m.trace(query, args...)
-	return m.Db.QueryRow(query, args...)
+	defer m.observe(opQuery, query)(nil)
+	return m.Db.QueryRowContext(m.context(), query, args...)
 }
 
-func (m *DbMap) query(query string, args ...interface{}) (*sql.Rows, error) {
+func (m *DbMap) query(query string, args ...interface{}) (rows *sql.Rows, err error) {
 	m.trace(query, args...)
-	return m.Db.Query(query, args...)
+	defer m.observe(opQuery, query)(&err)
+	return m.Db.QueryContext(m.context(), query, args...)
 }
 
 func (m *DbMap) trace(query string, args ...interface{}) {
@@ -1301,9 +1313,10 @@ func (t *Transaction) Select(i interface{}, query string, args ...interface{}) (
 }
 
 // Exec has the same behavior as DbMap.Exec(), but runs in a transaction.
-func (t *Transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+func (t *Transaction) Exec(query string, args ...interface{}) (r sql.Result, err error) {
 	t.dbmap.trace(query, args...)
-	return t.tx.Exec(query, args...)
+	defer t.dbmap.observe(opExec, query)(&err)
+	return t.tx.ExecContext(t.dbmap.context(), query, args...)
 }
 
 // SelectInt is a convenience wrapper around the gorp.SelectInt function.
@@ -1369,7 +1382,7 @@ func (t *Transaction) Rollback() error {
 func (t *Transaction) Savepoint(name string) error {
 	query := "savepoint " + t.dbmap.Dialect.QuoteField(name)
 	t.dbmap.trace(query, nil)
-	_, err := t.tx.Exec(query)
+	_, err := t.tx.ExecContext(t.dbmap.context(), query)
 	return err
 }
 
@@ -1379,7 +1392,7 @@ func (t *Transaction) Savepoint(name string) error {
 func (t *Transaction) RollbackToSavepoint(savepoint string) error {
 	query := "rollback to savepoint " + t.dbmap.Dialect.QuoteField(savepoint)
 	t.dbmap.trace(query, nil)
-	_, err := t.tx.Exec(query)
+	_, err := t.tx.ExecContext(t.dbmap.context(), query)
 	return err
 }
 
@@ -1389,24 +1402,26 @@ func (t *Transaction) RollbackToSavepoint(savepoint string) error {
 func (t *Transaction) ReleaseSavepoint(savepoint string) error {
 	query := "release savepoint " + t.dbmap.Dialect.QuoteField(savepoint)
 	t.dbmap.trace(query, nil)
-	_, err := t.tx.Exec(query)
+	_, err := t.tx.ExecContext(t.dbmap.context(), query)
 	return err
 }
 
 // Prepare has the same behavior as DbMap.Prepare(), but runs in a transaction.
 func (t *Transaction) Prepare(query string) (*sql.Stmt, error) {
 	t.dbmap.trace(query, nil)
-	return t.tx.Prepare(query)
+	return t.tx.PrepareContext(t.dbmap.context(), query)
 }
 
 func (t *Transaction) queryRow(query string, args ...interface{}) *sql.Row {
 	t.dbmap.trace(query, args...)
-	return t.tx.QueryRow(query, args...)
+	defer t.dbmap.observe(opQuery, query)(nil)
+	return t.tx.QueryRowContext(t.dbmap.context(), query, args...)
 }
 
-func (t *Transaction) query(query string, args ...interface{}) (*sql.Rows, error) {
+func (t *Transaction) query(query string, args ...interface{}) (rows *sql.Rows, err error) {
 	t.dbmap.trace(query, args...)
-	return t.tx.Query(query, args...)
+	defer t.dbmap.observe(opQuery, query)(&err)
+	return t.tx.QueryContext(t.dbmap.context(), query, args...)
 }
 
 ///////////////
@@ -2004,53 +2019,79 @@ func delete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 func update(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 	count := int64(0)
 	for _, ptr := range list {
-		table, elem, err := m.tableForPointer(ptr, true)
+		rows, err := updateWhere(m, exec, ptr, nil)
 		if err != nil {
-			return -1, err
+			return rows, err
 		}
+		count += rows
+	}
+	return count, nil
+}
 
-		eval := elem.Addr().Interface()
-		if v, ok := eval.(HasPreUpdate); ok {
-			err = v.PreUpdate(exec)
-			if err != nil {
-				return -1, err
-			}
-		}
+func updateWhere(m *DbMap, exec SqlExecutor, ptr interface{}, where map[string]interface{}) (int64, error) {
+	table, elem, err := m.tableForPointer(ptr, true)
+	if err != nil {
+		return -1, err
+	}
 
-		bi, err := table.bindUpdate(elem)
+	eval := elem.Addr().Interface()
+	if v, ok := eval.(HasPreUpdate); ok {
+		err = v.PreUpdate(exec)
 		if err != nil {
 			return -1, err
 		}
+	}
 
-		res, err := exec.Exec(bi.query, bi.args...)
-		if err != nil {
-			return -1, err
+	bi, err := table.bindUpdate(elem)
+	if err != nil {
+		return -1, err
+	}
+	if len(where) > 0 {
+		// 按字段名排序, 保证sql一致
+		fields := make([]string, 0, len(where))
+		for f := range where {
+			fields = append(fields, f)
 		}
-
-		rows, err := res.RowsAffected()
-		if err != nil {
-			return -1, err
+		sort.Strings(fields)
+		s := bytes.Buffer{}
+		s.WriteString(strings.TrimSuffix(bi.query, m.Dialect.QuerySuffix()))
+		for _, f := range fields {
+			s.WriteString(" AND ")
+			s.WriteString(m.Dialect.QuoteField(f))
+			s.WriteString("=")
+			s.WriteString(m.Dialect.BindVar(len(bi.args)))
+			bi.args = append(bi.args, where[f])
 		}
+		s.WriteString(m.Dialect.QuerySuffix())
+		bi.query = s.String()
+	}
 
-		if rows == 0 && bi.existingVersion > 0 {
-			return lockError(m, exec, table.TableName,
-				bi.existingVersion, elem, bi.keys...)
-		}
+	res, err := exec.Exec(bi.query, bi.args...)
+	if err != nil {
+		return -1, err
+	}
 
-		if bi.versField != "" {
-			elem.FieldByName(bi.versField).SetInt(bi.existingVersion + 1)
-		}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
 
-		count += rows
+	if rows == 0 && bi.existingVersion > 0 {
+		return lockError(m, exec, table.TableName,
+			bi.existingVersion, elem, bi.keys...)
+	}
 
-		if v, ok := eval.(HasPostUpdate); ok {
-			err = v.PostUpdate(exec)
-			if err != nil {
-				return -1, err
-			}
+	if bi.versField != "" {
+		elem.FieldByName(bi.versField).SetInt(bi.existingVersion + 1)
+	}
+
+	if v, ok := eval.(HasPostUpdate); ok {
+		err = v.PostUpdate(exec)
+		if err != nil {
+			return -1, err
 		}
 	}
-	return count, nil
+	return rows, nil
 }
 
 func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
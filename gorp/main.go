@@ -2,9 +2,11 @@ package gorp
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 	"regexp"
+	"sort"
 	//"strings"
 	"sync"
 )
@@ -42,6 +44,51 @@ func Open(alias, driver, datasource string, param ...int) error {
 	return nil
 }
 
+// close db by alias
+func Close(alias string) error {
+	m.Lock()
+	defer m.Unlock()
+	db, ok := dbcache[alias]
+	if !ok {
+		return fmt.Errorf("cannot find DbMap with alias `%s`", alias)
+	}
+	// gorp内的delete被覆盖了, 重建cache
+	nc := make(map[string]*sql.DB, len(dbcache))
+	for a, d := range dbcache {
+		if a != alias {
+			nc[a] = d
+		}
+	}
+	dbcache = nc
+	if dbMap.Db == db {
+		dbMap.Db = nil
+	}
+	return db.Close()
+}
+
+// 所有已打开的alias
+func Aliases() []string {
+	m.Lock()
+	defer m.Unlock()
+	as := make([]string, 0, len(dbcache))
+	for a := range dbcache {
+		as = append(as, a)
+	}
+	sort.Strings(as)
+	return as
+}
+
+// ping db by alias
+func Ping(ctx context.Context, alias string) error {
+	m.Lock()
+	db, ok := dbcache[alias]
+	m.Unlock()
+	if !ok {
+		return fmt.Errorf("cannot find DbMap with alias `%s`", alias)
+	}
+	return db.PingContext(ctx)
+}
+
 func Using(alias string) *DbMap {
 	m.Lock()
 	defer m.Unlock()
@@ -50,9 +97,10 @@ func Using(alias string) *DbMap {
 		panic(fmt.Errorf("cannot find DbMap with alias `%s`", alias))
 	}
 	if dbMap.Db != db {
-		return &DbMap{Db: db, Dialect: dbMap.Dialect, tables: dbMap.tables, logger: dbMap.logger, TypeConverter: dbMap.TypeConverter, logPrefix: dbMap.logPrefix}
+		return &DbMap{Db: db, Dialect: dbMap.Dialect, tables: dbMap.tables, logger: dbMap.logger, TypeConverter: dbMap.TypeConverter, logPrefix: dbMap.logPrefix, alias: alias}
 	}
 	dbMap.Db = db
+	dbMap.alias = alias
 	return dbMap
 }
 
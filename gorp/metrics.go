@@ -0,0 +1,73 @@
+package gorp
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"wgo/metrics"
+	"wgo/trace"
+)
+
+const (
+	opExec  = "exec"
+	opQuery = "query"
+
+	maxStatement = 1024 // span中记录的sql长度
+)
+
+var (
+	queryDuration = metrics.GetHistogram("wgo_gorp_query_duration_seconds",
+		"SQL statement latency in seconds.", nil, "db", "op")
+	queryErrors = metrics.GetCounter("wgo_gorp_query_errors_total",
+		"SQL statements that returned an error.", "db", "op")
+)
+
+// WithContext 使用ctx的DbMap(浅拷贝), ctx中有span时语句记录为其子span, 有deadline时语句超时取消
+func (m *DbMap) WithContext(ctx context.Context) *DbMap {
+	nm := *m
+	nm.ctx = ctx
+	return &nm
+}
+
+// 执行语句使用的ctx
+func (m *DbMap) context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
+
+// 开始记录语句, 返回的函数在结束时调用, err为nil时(如QueryRow)不判断错误
+func (m *DbMap) observe(op string, query string) func(*error) {
+	db := m.alias
+	if db == "" {
+		db = "default"
+	}
+	start := time.Now()
+	var span *trace.Span
+	if m.ctx != nil {
+		if len(query) > maxStatement {
+			query = query[:maxStatement]
+		}
+		_, span = trace.StartChild(m.ctx, db+" "+sqlVerb(query), trace.SpanKindClient,
+			trace.Attr("db.name", db), trace.Attr("db.operation", op), trace.Attr("db.statement", query))
+	}
+	return func(err *error) {
+		queryDuration.Observe(time.Since(start).Seconds(), db, op)
+		if err != nil && *err != nil {
+			queryErrors.Inc(db, op)
+			span.SetError(*err)
+		}
+		span.End()
+	}
+}
+
+// sql的第一个词, 如SELECT
+func sqlVerb(query string) string {
+	fs := strings.Fields(query)
+	if len(fs) == 0 {
+		return "SQL"
+	}
+	return strings.ToUpper(fs[0])
+}
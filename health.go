@@ -0,0 +1,382 @@
+//
+// health.go
+// 健康检查: http server上的/healthz, /readyz, /livez, wrpc server上的grpc health服务
+//
+
+package wgo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"wgo/environ"
+	"wgo/gorp"
+	"wgo/utils"
+	"wgo/whttp"
+	"wgo/wrpc"
+)
+
+const (
+	// 检查的类型, AddHealthCheck的opts
+	HealthReady HealthKind = 1 << iota // 参与readiness(默认)
+	HealthLive                         // 参与liveness, 失败时应重启进程, 慎用
+
+	HealthOK           = "ok"
+	HealthFail         = "fail"
+	HealthShuttingDown = "shutting_down"
+
+	defaultHealthTimeout    = 3 * time.Second
+	defaultHealthCacheTTL   = time.Second // 探针频繁时不必每次都执行检查
+	defaultHealthSaturation = 0.9         // 队列深度/容量超过该值则work不健康
+	healthWatchInterval     = 5 * time.Second
+	grpcHealthService       = "grpc.health.v1.Health"
+)
+
+type (
+	HealthKind int
+
+	// HealthCheck 返回error表示不健康, 超时由调用方控制
+	HealthCheck func(ctx context.Context) error
+
+	healthCheck struct {
+		name    string
+		check   HealthCheck
+		timeout time.Duration // 0为使用health.timeout
+		kind    HealthKind
+	}
+
+	// HealthResult 单个检查的结果
+	HealthResult struct {
+		Name    string  `json:"name"`
+		Status  string  `json:"status"`
+		Error   string  `json:"error,omitempty"`
+		Elapsed float64 `json:"elapsed"` // ms
+	}
+
+	// HealthReport 检查结果汇总, Status为ok/fail/shutting_down
+	HealthReport struct {
+		Status string         `json:"status"`
+		Checks []HealthResult `json:"checks"`
+	}
+
+	healthCached struct {
+		report  *HealthReport
+		expires time.Time
+	}
+)
+
+/* {{{ func AddHealthCheck(name string, check HealthCheck, opts ...interface{})
+ * 注册检查, 同名的替换, opts: time.Duration(超时), HealthKind(默认HealthReady)
+ * gorp的每个alias(gorp.xxx), storage的每个节点(redis.0...), 每个work(work.xxx)自动检查, 不需要注册
+ */
+func AddHealthCheck(name string, check HealthCheck, opts ...interface{}) {
+	Self().AddHealthCheck(name, check, opts...)
+}
+func (w *WGO) AddHealthCheck(name string, check HealthCheck, opts ...interface{}) {
+	hc := &healthCheck{name: name, check: check, kind: HealthReady}
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case time.Duration:
+			hc.timeout = o
+		case HealthKind:
+			hc.kind = o
+		}
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for i, c := range w.healthChecks {
+		if c.name == name {
+			w.healthChecks[i] = hc
+			return
+		}
+	}
+	w.healthChecks = append(w.healthChecks, hc)
+}
+
+/* }}} */
+
+// 注册的检查及gorp/storage/works的检查
+func (w *WGO) allHealthChecks() []*healthCheck {
+	w.lock.Lock()
+	hcs := append([]*healthCheck(nil), w.healthChecks...)
+	w.lock.Unlock()
+	exists := make(map[string]bool, len(hcs))
+	for _, hc := range hcs {
+		exists[hc.name] = true
+	}
+	add := func(name string, check HealthCheck) {
+		if !exists[name] {
+			hcs = append(hcs, &healthCheck{name: name, check: check, kind: HealthReady})
+		}
+	}
+
+	for _, alias := range gorp.Aliases() {
+		alias := alias
+		add("gorp."+alias, func(ctx context.Context) error {
+			return gorp.Ping(ctx, alias)
+		})
+	}
+	if s := w.Storage(); s != nil {
+		for i := 0; i < s.Len(); i++ {
+			idx := i
+			add(fmt.Sprintf("%s.%d", s.Name(), idx), func(ctx context.Context) error {
+				return s.Ping(idx)
+			})
+		}
+	}
+	saturation := w.Cfg().Float64(environ.CFG_KEY_HEALTH + ".work_saturation")
+	if saturation <= 0 {
+		saturation = defaultHealthSaturation
+	}
+	for _, work := range w.Works() {
+		work := work
+		add("work."+work.Name(), func(ctx context.Context) error {
+			st := work.Stats()
+			if st.Capacity > 0 && float64(st.Depth) >= float64(st.Capacity)*saturation {
+				return fmt.Errorf("queue saturated: %d/%d", st.Depth, st.Capacity)
+			}
+			return nil
+		})
+	}
+	return hcs
+}
+
+/* {{{ func (w *WGO) CheckHealth(ctx context.Context, kind HealthKind, names ...string) *HealthReport
+ * 并发执行kind类型的检查(names不为空时只执行这些), 每个检查有自己的超时
+ * 关闭中readiness直接返回shutting_down, 不执行检查
+ */
+func CheckHealth(ctx context.Context, kind HealthKind, names ...string) *HealthReport {
+	return Self().CheckHealth(ctx, kind, names...)
+}
+func (w *WGO) CheckHealth(ctx context.Context, kind HealthKind, names ...string) *HealthReport {
+	report := &HealthReport{Status: HealthOK, Checks: make([]HealthResult, 0)}
+	if kind&HealthReady != 0 && w.ShuttingDown() {
+		report.Status = HealthShuttingDown
+		return report
+	}
+
+	timeout := w.Cfg().Duration(environ.CFG_KEY_HEALTH + ".timeout")
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	hcs := make([]*healthCheck, 0)
+	for _, hc := range w.allHealthChecks() {
+		if hc.kind&kind != 0 && (len(names) == 0 || utils.InSlice(hc.name, names)) {
+			hcs = append(hcs, hc)
+		}
+	}
+
+	report.Checks = make([]HealthResult, len(hcs))
+	wg := new(sync.WaitGroup)
+	for i, hc := range hcs {
+		wg.Add(1)
+		go func(i int, hc *healthCheck) {
+			defer wg.Done()
+			to := hc.timeout
+			if to <= 0 {
+				to = timeout
+			}
+			report.Checks[i] = runHealthCheck(ctx, hc, to)
+		}(i, hc)
+	}
+	wg.Wait()
+
+	sort.Slice(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+	for _, r := range report.Checks {
+		if r.Status != HealthOK {
+			report.Status = HealthFail
+			break
+		}
+	}
+	return report
+}
+
+/* }}} */
+
+// 超时后不再等待check返回
+func runHealthCheck(ctx context.Context, hc *healthCheck, timeout time.Duration) HealthResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- hc.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timeout after %s", timeout)
+	}
+	r := HealthResult{
+		Name:    hc.name,
+		Status:  HealthOK,
+		Elapsed: utils.Round(float64(time.Since(start))/float64(time.Millisecond), 3),
+	}
+	if err != nil {
+		r.Status = HealthFail
+		r.Error = err.Error()
+	}
+	return r
+}
+
+/* {{{ func (w *WGO) cachedHealth(ctx context.Context, kind HealthKind, names ...string) *HealthReport
+ * 探针(http/grpc)使用, 相同的检查在health.cache_ttl(默认1s, 负数不缓存)内直接返回上次的结果
+ * 关闭中的readiness不缓存
+ */
+func (w *WGO) cachedHealth(ctx context.Context, kind HealthKind, names ...string) *HealthReport {
+	ttl := defaultHealthCacheTTL
+	if w.Cfg().IsSet(environ.CFG_KEY_HEALTH + ".cache_ttl") {
+		ttl = w.Cfg().Duration(environ.CFG_KEY_HEALTH + ".cache_ttl")
+	}
+	if ttl <= 0 || (kind&HealthReady != 0 && w.ShuttingDown()) {
+		return w.CheckHealth(ctx, kind, names...)
+	}
+	key := fmt.Sprintf("%d:%s", kind, strings.Join(names, ","))
+	now := time.Now()
+	w.healthMu.Lock()
+	if hc, ok := w.healthCache[key]; ok && now.Before(hc.expires) {
+		w.healthMu.Unlock()
+		return hc.report
+	}
+	w.healthMu.Unlock()
+
+	report := w.CheckHealth(ctx, kind, names...)
+	w.healthMu.Lock()
+	if w.healthCache == nil {
+		w.healthCache = make(map[string]*healthCached)
+	}
+	w.healthCache[key] = &healthCached{report: report, expires: now.Add(ttl)}
+	w.healthMu.Unlock()
+	return report
+}
+
+/* }}} */
+
+/* {{{ func (w *WGO) serveHealth()
+ * 在http server上注册/healthz, /readyz, /livez, wrpc server上注册grpc health, 配置health.disable关闭
+ * 探针不经过JWT/RateLimit/Quota/Timeout, 公开的server上不返回检查的错误信息, admin server上的(见serveAdmin)返回
+ */
+func (w *WGO) serveHealth() {
+	if w.Cfg().Bool(environ.CFG_KEY_HEALTH + ".disable") {
+		return
+	}
+	if ss := w.HTTPServers(); len(ss) > 0 {
+		probeRoutes(ss, false)
+	}
+	for _, s := range w.RPCServers() {
+		if e, ok := s.Engine().(*wrpc.Engine); ok {
+			e.RegisterNative(grpcHealthService, func(gs *grpc.Server) {
+				healthpb.RegisterHealthServer(gs, &grpcHealth{app: w})
+			})
+		}
+	}
+}
+
+/* }}} */
+
+func probeRoutes(ss Servers, detail bool) {
+	for path, kind := range map[string]HealthKind{
+		"/healthz": HealthReady | HealthLive,
+		"/readyz":  HealthReady,
+		"/livez":   HealthLive,
+	} {
+		ss.GET(path, healthHandler(kind, detail)).
+			SetOptions(JWTKey, false).
+			SetOptions(RateLimitKey, false).
+			SetOptions(QuotaWeightKey, 0).
+			SetOptions(TimeoutKey, false)
+	}
+}
+
+// ?check=a&check=b只执行指定的检查, detail为false时去掉错误信息
+func healthHandler(kind HealthKind, detail bool) HandlerFunc {
+	return func(c *Context) error {
+		report := c.App().cachedHealth(c.Context(), kind, c.QueryParams()["check"]...)
+		if !detail {
+			report = report.brief()
+		}
+		c.SetHeader("Cache-Control", "no-cache")
+		if report.Status != HealthOK {
+			return c.JSON(whttp.StatusServiceUnavailable, report)
+		}
+		return c.JSON(whttp.StatusOK, report)
+	}
+}
+
+// 去掉错误信息的副本(错误中可能有地址/账号等)
+func (r *HealthReport) brief() *HealthReport {
+	br := &HealthReport{Status: r.Status, Checks: make([]HealthResult, len(r.Checks))}
+	for i, hr := range r.Checks {
+		hr.Error = ""
+		br.Checks[i] = hr
+	}
+	return br
+}
+
+/* {{{ grpc health
+ * service为空检查readiness, 否则为检查的名称
+ */
+type grpcHealth struct {
+	app *WGO
+}
+
+func (gh *grpcHealth) status(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	var names []string
+	if service != "" {
+		names = []string{service}
+	}
+	report := gh.app.cachedHealth(ctx, HealthReady|HealthLive, names...)
+	if service != "" && len(report.Checks) == 0 && report.Status != HealthShuttingDown {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, status.Errorf(codes.NotFound, "unknown service: %s", service)
+	}
+	if report.Status != HealthOK {
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_SERVING, nil
+}
+
+func (gh *grpcHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, err := gh.status(ctx, req.GetService())
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// 先返回当前状态, 之后状态变化时再返回
+func (gh *grpcHealth) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	for {
+		st, _ := gh.status(stream.Context(), req.GetService())
+		if st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.Error(codes.Canceled, "watch canceled")
+		case <-ticker.C:
+		}
+	}
+}
+
+/* }}} */
@@ -246,7 +246,7 @@ func (gs HTTPGroups) File(path, file string) HTTPGroups {
  * 默认all
  */
 func Group(prefix string, ms ...interface{}) (gs HTTPGroups) {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.Group(prefix, ms...)
 	}
 	return
@@ -265,7 +265,7 @@ func (ss Servers) Group(prefix string, ms ...interface{}) (gs HTTPGroups) {
  * 默认all
  */
 func Abandon(ms ...interface{}) (ss Servers) {
-	if ss = wgo.HTTPServers(); len(ss) > 0 {
+	if ss = Self().HTTPServers(); len(ss) > 0 {
 		ss.Abandon(ms...)
 	}
 	return
@@ -286,7 +286,7 @@ func (ss Servers) Abandon(ms ...interface{}) Servers {
  * 默认all
  */
 func CONNECT(path string, h HandlerFunc, ms ...interface{}) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.CONNECT(path, h, ms...)
 	}
 	return nil
@@ -308,7 +308,7 @@ func (ss Servers) CONNECT(path string, h HandlerFunc, ms ...interface{}) whttp.R
  * 默认all
  */
 func DELETE(path string, h HandlerFunc, ms ...interface{}) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.DELETE(path, h, ms...)
 	}
 	return nil
@@ -330,7 +330,7 @@ func (ss Servers) DELETE(path string, h HandlerFunc, ms ...interface{}) whttp.Ro
  * 默认all
  */
 func GET(path string, h HandlerFunc, ms ...interface{}) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.GET(path, h, ms...)
 	}
 	return nil
@@ -352,7 +352,7 @@ func (ss Servers) GET(path string, h HandlerFunc, ms ...interface{}) whttp.Route
  *
  */
 func HEAD(path string, h HandlerFunc, ms ...interface{}) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.HEAD(path, h, ms...)
 	}
 	return nil
@@ -374,7 +374,7 @@ func (ss Servers) HEAD(path string, h HandlerFunc, ms ...interface{}) whttp.Rout
  *
  */
 func OPTIONS(path string, h HandlerFunc, ms ...interface{}) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.OPTIONS(path, h, ms...)
 	}
 	return nil
@@ -397,7 +397,7 @@ func (ss Servers) OPTIONS(path string, h HandlerFunc, ms ...interface{}) whttp.R
  *
  */
 func PATCH(path string, h HandlerFunc, ms ...interface{}) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.PATCH(path, h, ms...)
 	}
 	return nil
@@ -419,7 +419,7 @@ func (ss Servers) PATCH(path string, h HandlerFunc, ms ...interface{}) whttp.Rou
  *
  */
 func POST(path string, h HandlerFunc, ms ...interface{}) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.POST(path, h, ms...)
 	}
 	return nil
@@ -441,7 +441,7 @@ func (ss Servers) POST(path string, h HandlerFunc, ms ...interface{}) whttp.Rout
  *
  */
 func PUT(path string, h HandlerFunc, ms ...interface{}) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.PUT(path, h, ms...)
 	}
 	return nil
@@ -463,7 +463,7 @@ func (ss Servers) PUT(path string, h HandlerFunc, ms ...interface{}) whttp.Route
  *
  */
 func TRACE(path string, h HandlerFunc, ms ...interface{}) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.TRACE(path, h, ms...)
 	}
 	return nil
@@ -485,7 +485,7 @@ func (ss Servers) TRACE(path string, h HandlerFunc, ms ...interface{}) whttp.Rou
  *
  */
 func Any(path string, h HandlerFunc, ms ...interface{}) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.Any(path, h, ms...)
 	}
 	return nil
@@ -509,7 +509,7 @@ func (ss Servers) Any(path string, h HandlerFunc, ms ...interface{}) whttp.Route
  *
  */
 func Match(methods []string, path string, h HandlerFunc, ms ...interface{}) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.Match(methods, path, h, ms...)
 	}
 	return nil
@@ -533,7 +533,7 @@ func (ss Servers) Match(methods []string, path string, h HandlerFunc, ms ...inte
  *
  */
 func File(path, file string) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.File(path, file)
 	}
 	return nil
@@ -557,7 +557,7 @@ func (ss Servers) File(path, file string) whttp.Routes {
  *
  */
 func Static(prefix, root string) whttp.Routes {
-	if ss := wgo.HTTPServers(); len(ss) > 0 {
+	if ss := Self().HTTPServers(); len(ss) > 0 {
 		return ss.Static(prefix, root)
 	}
 	return nil
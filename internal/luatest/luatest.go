@@ -0,0 +1,219 @@
+//
+// luatest.go
+// wgotest.MemoryCache的EVAL实现, 用gopher-lua执行脚本, 只用于wgo自身的测试, 使用wgotest不会依赖lua引擎
+// 支持KEYS/ARGV, redis.call/redis.pcall, cjson.decode, 类型转换规则与redis一致
+//
+
+package luatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"wgo/storage"
+	"wgo/wgotest"
+)
+
+// 支持EVAL的内存storage
+func NewStorage() *storage.Storage {
+	return storage.NewWithCaches("memory", NewMemoryCache())
+}
+
+func NewMemoryCache() *wgotest.MemoryCache {
+	return wgotest.NewMemoryCache().SetEvaluator(Eval)
+}
+
+// 与redigo的参数编码一致
+func encode(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(t)
+	case string:
+		return t
+	case bool:
+		if t {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(t, 'g', -1, 64)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+/* {{{ func Eval(do func(string, ...interface{}) (interface{}, error), args ...interface{}) (interface{}, error)
+ * EVAL script numkeys key [key ...] arg [arg ...], 实现wgotest.Evaluator, MemoryCache持有锁, 与redis一样是原子的
+ */
+func Eval(do func(string, ...interface{}) (interface{}, error), args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("wrong number of arguments for 'eval'")
+	}
+	nk, err := strconv.Atoi(encode(args[1]))
+	if err != nil || nk < 0 || nk > len(args)-2 {
+		return nil, fmt.Errorf("number of keys can't be greater than number of args")
+	}
+	L := lua.NewState()
+	defer L.Close()
+	strs := func(vs []interface{}) *lua.LTable {
+		t := L.NewTable()
+		for _, v := range vs {
+			t.Append(lua.LString(encode(v)))
+		}
+		return t
+	}
+	L.SetGlobal("KEYS", strs(args[2:2+nk]))
+	L.SetGlobal("ARGV", strs(args[2+nk:]))
+	rt := L.NewTable()
+	rt.RawSetString("call", L.NewFunction(luaCall(do, false)))
+	rt.RawSetString("pcall", L.NewFunction(luaCall(do, true)))
+	L.SetGlobal("redis", rt)
+	ct := L.NewTable()
+	ct.RawSetString("decode", L.NewFunction(luaJSONDecode))
+	L.SetGlobal("cjson", ct)
+
+	fn, err := L.LoadString(encode(args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling script: %s", err)
+	}
+	L.Push(fn)
+	if err := L.PCall(0, 1, nil); err != nil {
+		return nil, fmt.Errorf("ERR Error running script: %s", err)
+	}
+	return fromLua(L.Get(-1))
+}
+
+/* }}} */
+
+// redis.call出错时中止脚本, redis.pcall返回{err=...}
+func luaCall(do func(string, ...interface{}) (interface{}, error), protected bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		n := L.GetTop()
+		if n == 0 {
+			L.RaiseError("Please specify at least one argument for redis.call()")
+		}
+		args := make([]interface{}, 0, n)
+		for i := 1; i <= n; i++ {
+			switch v := L.Get(i).(type) {
+			case lua.LString:
+				args = append(args, string(v))
+			case lua.LNumber:
+				args = append(args, strconv.FormatFloat(float64(v), 'f', -1, 64))
+			default:
+				L.RaiseError("Lua redis() command arguments must be strings or integers")
+			}
+		}
+		cmd := fmt.Sprint(args[0])
+		reply, err := do(strings.ToUpper(cmd), args[1:]...)
+		if err != nil {
+			if !protected {
+				L.RaiseError("%s", err)
+			}
+			t := L.NewTable()
+			t.RawSetString("err", lua.LString(err.Error()))
+			L.Push(t)
+			return 1
+		}
+		L.Push(toLua(L, reply))
+		return 1
+	}
+}
+
+// redis的返回值转为lua: 整数->number, bulk->string, nil->false, 状态->{ok=...}
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch t := v.(type) {
+	case nil:
+		return lua.LFalse
+	case int64:
+		return lua.LNumber(t)
+	case []byte:
+		return lua.LString(t)
+	case string:
+		st := L.NewTable()
+		st.RawSetString("ok", lua.LString(t))
+		return st
+	case []interface{}:
+		at := L.NewTable()
+		for _, e := range t {
+			at.Append(toLua(L, e))
+		}
+		return at
+	}
+	return lua.LString(fmt.Sprint(v))
+}
+
+// 脚本的返回值转为redis的: number截断为整数, table按数组处理(遇到nil截止)
+func fromLua(v lua.LValue) (interface{}, error) {
+	switch t := v.(type) {
+	case lua.LNumber:
+		return int64(t), nil
+	case lua.LString:
+		return []byte(t), nil
+	case lua.LBool:
+		if t {
+			return int64(1), nil
+		}
+		return nil, nil
+	case *lua.LTable:
+		if e, ok := t.RawGetString("err").(lua.LString); ok {
+			return nil, fmt.Errorf("%s", e)
+		}
+		if s, ok := t.RawGetString("ok").(lua.LString); ok {
+			return string(s), nil
+		}
+		vs := make([]interface{}, 0, t.Len())
+		for i := 1; ; i++ {
+			e := t.RawGetInt(i)
+			if e == lua.LNil {
+				break
+			}
+			r, err := fromLua(e)
+			if err != nil {
+				return nil, err
+			}
+			vs = append(vs, r)
+		}
+		return vs, nil
+	}
+	return nil, nil
+}
+
+// cjson.decode, json null解析为nil
+func luaJSONDecode(L *lua.LState) int {
+	var v interface{}
+	if err := json.Unmarshal([]byte(L.CheckString(1)), &v); err != nil {
+		L.RaiseError("%s", err)
+	}
+	L.Push(jsonToLua(L, v))
+	return 1
+}
+
+func jsonToLua(L *lua.LState, v interface{}) lua.LValue {
+	switch t := v.(type) {
+	case bool:
+		return lua.LBool(t)
+	case float64:
+		return lua.LNumber(t)
+	case string:
+		return lua.LString(t)
+	case []interface{}:
+		at := L.NewTable()
+		for i, e := range t {
+			at.RawSetInt(i+1, jsonToLua(L, e))
+		}
+		return at
+	case map[string]interface{}:
+		mt := L.NewTable()
+		for k, e := range t {
+			mt.RawSetString(k, jsonToLua(L, e))
+		}
+		return mt
+	}
+	return lua.LNil
+}
@@ -0,0 +1,309 @@
+//
+// jwt.go
+// JWT bearer认证middleware, key来自配置(jwt.keys)或本地JWKS文件(定期重新加载), 通过kid轮换
+// 校验通过后claims放在Context(c.Claims()), 设置UserID并Authorize
+//
+
+package wgo
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"wgo/environ"
+	"wgo/jwt"
+	"wgo/utils"
+	"wgo/whttp"
+)
+
+// 路由option的key, true代表必须带token, false代表该路由不校验
+const JWTKey = "jwt"
+
+const (
+	jwtClaimsKey      = "_wgo_jwt_claims_"
+	defaultJWTTTL     = 2 * time.Hour
+	defaultJWKSReload = 5 * time.Minute
+)
+
+type (
+	// JWTKeyConfig 配置中的单个key
+	JWTKeyConfig struct {
+		ID     string `mapstructure:"kid"`
+		Alg    string `mapstructure:"alg"`    // HS256, RS256, ES256, 为空时按key推断(有secret为HS256)
+		Secret string `mapstructure:"secret"` // HS256
+		Key    string `mapstructure:"key"`    // RS256/ES256, PEM内容或文件路径, 私钥可以用于签发
+	}
+
+	// JWTConfig 配置中的jwt部分
+	JWTConfig struct {
+		Disable    bool            `mapstructure:"disable"`
+		Required   bool            `mapstructure:"required"`    // 没有token时返回401, 否则放行(由后续的认证处理)
+		Issuer     string          `mapstructure:"issuer"`      // 校验iss, 签发时使用
+		Audience   []string        `mapstructure:"audience"`    // 校验aud(包含其一即可), 签发时使用
+		Leeway     time.Duration   `mapstructure:"leeway"`      // exp/nbf允许的时钟误差
+		TTL        time.Duration   `mapstructure:"ttl"`         // 签发的默认有效期, 默认2h
+		UserClaim  string          `mapstructure:"user_claim"`  // 作为UserID的claim, 默认sub
+		SignKey    string          `mapstructure:"sign_kid"`    // 签发用的kid, 默认第一个可以签名的key
+		Keys       []*JWTKeyConfig `mapstructure:"keys"`        //
+		JWKSFile   string          `mapstructure:"jwks_file"`   // 本地的JWKS文件, 与keys同时有效
+		JWKSReload time.Duration   `mapstructure:"jwks_reload"` // 检查JWKS文件变化的间隔, 默认5m
+	}
+
+	jwtAuth struct {
+		cfg    *JWTConfig
+		keys   *jwt.KeySet
+		mu     sync.Mutex
+		static []*jwt.Key // 配置及代码中添加的key
+		jwks   *jwt.JWKSFile
+	}
+)
+
+/* {{{ func JWT() MiddlewareFunc
+ * 读取Authorization: Bearer <token>, 没有配置时不做任何处理, 默认已加载
+ * token无效返回401, 没有token时只有required(配置或路由option)才返回401
+ */
+func JWT() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			switch c.ServerMode() {
+			case "rpc", "wrpc", "grpc":
+				return next(c)
+			}
+			ja := c.App().jwtAuth()
+			if ja == nil {
+				return next(c)
+			}
+			required := ja.cfg.Required
+			if opt, ok := c.Options(JWTKey).(bool); ok {
+				if !opt {
+					return next(c)
+				}
+				required = true
+			}
+			token := bearerToken(c.RequestHeader().Get(whttp.HeaderAuthorization))
+			if token == "" {
+				if required {
+					c.response.(whttp.Response).Header().Set(whttp.HeaderWWWAuthenticate, `Bearer`)
+					return c.NewError(whttp.StatusUnauthorized*1000, "missing token")
+				}
+				return next(c)
+			}
+			claims, err := ja.parse(token)
+			if err != nil {
+				c.Info("[JWT]%s %s, %s", c.Method(), c.Path(), err)
+				c.response.(whttp.Response).Header().Set(whttp.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return c.NewError(whttp.StatusUnauthorized*1000+1, "invalid token")
+			}
+			c.Set(jwtClaimsKey, claims)
+			if uid := claims.String(ja.cfg.UserClaim); uid != "" {
+				c.SetUserID(uid)
+			}
+			c.Authorize()
+			return next(c)
+		}
+	}
+}
+
+/* }}} */
+
+// 校验通过的claims, 没有时为nil
+func (c *Context) Claims() jwt.Claims {
+	if claims, ok := c.Get(jwtClaimsKey).(jwt.Claims); ok {
+		return claims
+	}
+	return nil
+}
+
+/* {{{ func IssueToken(claims jwt.Claims, ttl time.Duration) (string, error)
+ * 签发token(如登录接口), 自动补充iat/exp/jti, 以及配置中的iss/aud, ttl为0时使用配置的ttl
+ * 例: wgo.IssueToken(jwt.Claims{"sub": uid}, 0)
+ */
+func IssueToken(claims jwt.Claims, ttl time.Duration) (string, error) {
+	return Self().IssueToken(claims, ttl)
+}
+func (w *WGO) IssueToken(claims jwt.Claims, ttl time.Duration) (string, error) {
+	ja := w.jwtAuth()
+	if ja == nil {
+		return "", jwt.ErrNoKey
+	}
+	cl := jwt.Claims{}
+	for k, v := range claims {
+		cl[k] = v
+	}
+	if ttl <= 0 {
+		ttl = ja.cfg.TTL
+	}
+	now := time.Now()
+	if _, ok := cl["iat"]; !ok {
+		cl["iat"] = now.Unix()
+	}
+	if _, ok := cl["exp"]; !ok {
+		cl["exp"] = now.Add(ttl).Unix()
+	}
+	if _, ok := cl["jti"]; !ok {
+		cl["jti"] = utils.FastRequestId(16)
+	}
+	if _, ok := cl["iss"]; !ok && ja.cfg.Issuer != "" {
+		cl["iss"] = ja.cfg.Issuer
+	}
+	if _, ok := cl["aud"]; !ok {
+		switch len(ja.cfg.Audience) {
+		case 0:
+		case 1:
+			cl["aud"] = ja.cfg.Audience[0]
+		default:
+			cl["aud"] = ja.cfg.Audience
+		}
+	}
+	return jwt.Sign(cl, ja.keys.Signer(ja.cfg.SignKey))
+}
+
+/* }}} */
+
+/* {{{ func AddJWTKey(k *jwt.Key)
+ * 代码中添加key(相同kid替换), 没有配置时也会启用JWT, 配置了jwt.disable时忽略
+ */
+func AddJWTKey(k *jwt.Key) { Self().AddJWTKey(k) }
+func (w *WGO) AddJWTKey(k *jwt.Key) {
+	w.jwtAuth()
+	if w.Cfg().Bool(environ.CFG_KEY_JWT + ".disable") {
+		w.Logger().Warn("[jwt]disabled, ignore key: %s", k.ID)
+		return
+	}
+	w.lock.Lock()
+	if w.jwt == nil {
+		w.jwt = newJWTAuth(&JWTConfig{})
+	}
+	ja := w.jwt
+	w.lock.Unlock()
+	ja.mu.Lock()
+	defer ja.mu.Unlock()
+	for i, o := range ja.static {
+		if o.ID == k.ID && k.ID != "" {
+			ja.static[i] = k
+			ja.apply()
+			return
+		}
+	}
+	ja.static = append(ja.static, k)
+	ja.apply()
+}
+
+/* }}} */
+
+// 读取配置, 没有配置时为nil
+func (w *WGO) jwtAuth() *jwtAuth {
+	w.jwtOnce.Do(func() {
+		if !w.Cfg().IsSet(environ.CFG_KEY_JWT) {
+			return
+		}
+		cfg := &JWTConfig{}
+		if err := w.Cfg().UnmarshalKey(environ.CFG_KEY_JWT, cfg); err != nil {
+			w.Logger().Error("[jwt]invalid config: %s", err)
+		}
+		if cfg.Disable {
+			return
+		}
+		ja := newJWTAuth(cfg)
+		for _, kc := range cfg.Keys {
+			if k, err := kc.key(); err != nil {
+				w.Logger().Error("[jwt]invalid key %s: %s", kc.ID, err)
+			} else {
+				ja.static = append(ja.static, k)
+			}
+		}
+		if cfg.JWKSFile != "" {
+			ja.jwks = jwt.NewJWKSFile(cfg.JWKSFile)
+			if err := ja.reload(); err != nil {
+				w.Logger().Error("[jwt]load jwks failed: %s", err)
+			}
+			if w.Cron() != nil {
+				w.Cron().AddFunc("@every "+cfg.JWKSReload.String(), func() {
+					if err := ja.reload(); err != nil {
+						w.Logger().Warn("[jwt]reload jwks failed: %s", err)
+					}
+				})
+			}
+		}
+		ja.mu.Lock()
+		ja.apply()
+		ja.mu.Unlock()
+		w.lock.Lock()
+		w.jwt = ja
+		w.lock.Unlock()
+	})
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.jwt
+}
+
+func newJWTAuth(cfg *JWTConfig) *jwtAuth {
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultJWTTTL
+	}
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = "sub"
+	}
+	if cfg.JWKSReload <= 0 {
+		cfg.JWKSReload = defaultJWKSReload
+	}
+	return &jwtAuth{cfg: cfg, keys: jwt.NewKeySet()}
+}
+
+func (ja *jwtAuth) parse(token string) (jwt.Claims, error) {
+	return jwt.Parse(token, ja.keys, &jwt.Options{
+		Issuer:     ja.cfg.Issuer,
+		Audience:   ja.cfg.Audience,
+		Leeway:     ja.cfg.Leeway,
+		RequireExp: true,
+	})
+}
+
+// JWKS文件有变化时重新加载, 出错时保留之前的key
+func (ja *jwtAuth) reload() error {
+	changed, err := ja.jwks.Reload()
+	if err != nil || !changed {
+		return err
+	}
+	ja.mu.Lock()
+	defer ja.mu.Unlock()
+	ja.apply()
+	return nil
+}
+
+// 合并配置及JWKS的key, 需要持有ja.mu
+func (ja *jwtAuth) apply() {
+	keys := append([]*jwt.Key(nil), ja.static...)
+	if ja.jwks != nil {
+		keys = append(keys, ja.jwks.Keys()...)
+	}
+	ja.keys.Set(keys)
+}
+
+// 配置转为key
+func (kc *JWTKeyConfig) key() (*jwt.Key, error) {
+	if kc.Secret != "" {
+		if kc.Alg != "" && kc.Alg != jwt.HS256 {
+			return nil, jwt.ErrUnsupportedAlg
+		}
+		return jwt.NewHMACKey(kc.ID, []byte(kc.Secret)), nil
+	}
+	b := []byte(kc.Key)
+	if !strings.Contains(kc.Key, "-----BEGIN") {
+		var err error
+		if b, err = ioutil.ReadFile(kc.Key); err != nil {
+			return nil, err
+		}
+	}
+	return jwt.ParsePEM(kc.ID, kc.Alg, b)
+}
+
+// Authorization: Bearer <token>
+func bearerToken(auth string) string {
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
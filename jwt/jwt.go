@@ -0,0 +1,297 @@
+//
+// jwt.go
+// JWT(JWS compact)的签发与校验, 支持HS256, RS256, ES256
+//
+
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	ErrMalformed      = errors.New("jwt: malformed token")
+	ErrUnsupportedAlg = errors.New("jwt: unsupported algorithm")
+	ErrNoKey          = errors.New("jwt: no matching key")
+	ErrSignature      = errors.New("jwt: invalid signature")
+	ErrExpired        = errors.New("jwt: token expired")
+	ErrNoExpiration   = errors.New("jwt: missing exp")
+	ErrNotValidYet    = errors.New("jwt: token not valid yet")
+	ErrIssuer         = errors.New("jwt: invalid issuer")
+	ErrAudience       = errors.New("jwt: invalid audience")
+
+	b64 = base64.RawURLEncoding
+)
+
+type (
+	// Header JOSE header
+	Header struct {
+		Alg string `json:"alg"`
+		Typ string `json:"typ,omitempty"`
+		Kid string `json:"kid,omitempty"`
+	}
+
+	// Claims payload, 数字解析为json.Number
+	Claims map[string]interface{}
+
+	// Options 校验选项
+	Options struct {
+		Issuer     string        // 不为空时iss必须一致
+		Audience   []string      // 不为空时aud必须包含其中之一
+		Leeway     time.Duration // exp/nbf允许的时钟误差
+		RequireExp bool          // 必须有exp
+		Now        func() time.Time
+	}
+)
+
+/* {{{ func Sign(claims Claims, key *Key) (string, error)
+ * 用key签名, header中带key的kid
+ */
+func Sign(claims Claims, key *Key) (string, error) {
+	if key == nil || !key.CanSign() {
+		return "", ErrNoKey
+	}
+	hb, err := json.Marshal(&Header{Alg: key.Alg, Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := b64.EncodeToString(hb) + "." + b64.EncodeToString(cb)
+	sig, err := key.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + b64.EncodeToString(sig), nil
+}
+
+/* }}} */
+
+/* {{{ func Parse(token string, ks *KeySet, opts *Options) (Claims, error)
+ * 校验签名(按kid及alg查找key, 没有kid时逐个尝试)以及exp/nbf/iss/aud
+ */
+func Parse(token string, ks *KeySet, opts *Options) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	hb, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	h := &Header{}
+	if err := json.Unmarshal(hb, h); err != nil {
+		return nil, ErrMalformed
+	}
+	switch h.Alg {
+	case HS256, RS256, ES256:
+	default:
+		return nil, ErrUnsupportedAlg
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	keys := ks.Find(h.Kid, h.Alg)
+	if len(keys) == 0 {
+		return nil, ErrNoKey
+	}
+	input := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range keys {
+		if k.verify(input, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	cb, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	claims := Claims{}
+	dec := json.NewDecoder(bytes.NewReader(cb))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, ErrMalformed
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := claims.Validate(opts); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+/* }}} */
+
+/* {{{ func (cl Claims) Validate(opts *Options) error
+ *
+ */
+func (cl Claims) Validate(opts *Options) error {
+	now := time.Now()
+	if opts.Now != nil {
+		now = opts.Now()
+	}
+	if exp, ok := cl.Int64("exp"); ok {
+		if now.Add(-opts.Leeway).Unix() >= exp {
+			return ErrExpired
+		}
+	} else if opts.RequireExp {
+		return ErrNoExpiration
+	}
+	if nbf, ok := cl.Int64("nbf"); ok && now.Add(opts.Leeway).Unix() < nbf {
+		return ErrNotValidYet
+	}
+	if opts.Issuer != "" && cl.Issuer() != opts.Issuer {
+		return ErrIssuer
+	}
+	if len(opts.Audience) > 0 {
+		for _, a := range cl.Audience() {
+			for _, want := range opts.Audience {
+				if a == want {
+					return nil
+				}
+			}
+		}
+		return ErrAudience
+	}
+	return nil
+}
+
+/* }}} */
+
+func (cl Claims) Subject() string { return cl.String("sub") }
+func (cl Claims) Issuer() string  { return cl.String("iss") }
+
+// aud可以是字符串或数组
+func (cl Claims) Audience() []string {
+	switch v := cl["aud"].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		as := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				as = append(as, s)
+			}
+		}
+		return as
+	}
+	return nil
+}
+
+// 字符串类型的claim, 数字也转为字符串(如数字的sub)
+func (cl Claims) String(key string) string {
+	switch v := cl[key].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+// 数字类型的claim(exp/nbf/iat等)
+func (cl Claims) Int64(key string) (int64, bool) {
+	switch v := cl[key].(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, true
+		}
+		if f, err := v.Float64(); err == nil {
+			return int64(f), true
+		}
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+/* {{{ 签名及校验
+ *
+ */
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch k.Alg {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case RS256:
+		h := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(rand.Reader, k.private.(*rsa.PrivateKey), crypto.SHA256, h[:])
+	case ES256:
+		h := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, k.private.(*ecdsa.PrivateKey), h[:])
+		if err != nil {
+			return nil, err
+		}
+		// R||S, 各32字节
+		sig := make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+		return sig, nil
+	}
+	return nil, ErrUnsupportedAlg
+}
+
+func (k *Key) verify(input, sig []byte) bool {
+	switch k.Alg {
+	case HS256:
+		if k.secret == nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case RS256:
+		pub, ok := k.public.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		h := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig) == nil
+	case ES256:
+		pub, ok := k.public.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		h := sha256.Sum256(input)
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, h[:], r, s)
+	}
+	return false
+}
+
+/* }}} */
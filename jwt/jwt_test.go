@@ -0,0 +1,187 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1700000000, 0)
+
+// 按给定的header签名, 用于构造异常的token
+func forge(t *testing.T, h map[string]interface{}, claims Claims, secret []byte) string {
+	hb, _ := json.Marshal(h)
+	cb, _ := json.Marshal(claims)
+	input := b64.EncodeToString(hb) + "." + b64.EncodeToString(cb)
+	if secret == nil {
+		return input + "."
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return input + "." + b64.EncodeToString(mac.Sum(nil))
+}
+
+func sign(t *testing.T, claims Claims, k *Key) string {
+	tk, err := Sign(claims, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tk
+}
+
+func TestParseKeys(t *testing.T) {
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pubDER, _ := x509.MarshalPKIXPublicKey(&rk.PublicKey)
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	rsaPub, err := ParsePEM("r1", "", pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPriv, _ := newKey("r1", RS256, rk)
+	ek, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecKey, _ := newKey("e1", "", ek)
+	hk := NewHMACKey("h1", []byte("s3cret"))
+	hk2 := NewHMACKey("h2", []byte("s3cret"))
+
+	exp := Claims{"sub": "u1", "exp": now.Add(time.Hour).Unix()}
+	cases := []struct {
+		name  string
+		token string
+		keys  *KeySet
+		err   error
+	}{
+		{"rs256", sign(t, exp, rsaPriv), NewKeySet(rsaPub), nil},
+		{"es256", sign(t, exp, ecKey), NewKeySet(ecKey), nil},
+		{"hs256", sign(t, exp, hk), NewKeySet(hk), nil},
+		// alg-confusion: 用RSA公钥作为HMAC secret
+		{"hs256 with rsa public key", forge(t, map[string]interface{}{"alg": HS256, "kid": "r1"}, exp, pubPEM), NewKeySet(rsaPub), ErrNoKey},
+		{"hs256 with rsa public key no kid", forge(t, map[string]interface{}{"alg": HS256}, exp, pubPEM), NewKeySet(rsaPub), ErrNoKey},
+		{"alg none", forge(t, map[string]interface{}{"alg": "none"}, exp, nil), NewKeySet(hk), ErrUnsupportedAlg},
+		{"alg None", forge(t, map[string]interface{}{"alg": "None", "kid": "h1"}, exp, nil), NewKeySet(hk), ErrUnsupportedAlg},
+		{"alg missing", forge(t, map[string]interface{}{"kid": "h1"}, exp, []byte("s3cret")), NewKeySet(hk), ErrUnsupportedAlg},
+		{"kid mismatch", sign(t, exp, hk2), NewKeySet(hk), ErrNoKey},
+		{"kid of other alg", forge(t, map[string]interface{}{"alg": HS256, "kid": "e1"}, exp, []byte("s3cret")), NewKeySet(hk, ecKey), ErrNoKey},
+		{"wrong secret", forge(t, map[string]interface{}{"alg": HS256, "kid": "h1"}, exp, []byte("other")), NewKeySet(hk), ErrSignature},
+		{"malformed", "a.b", NewKeySet(hk), ErrMalformed},
+		{"no exp", sign(t, Claims{"sub": "u1"}, hk), NewKeySet(hk), ErrNoExpiration},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Parse(c.token, c.keys, &Options{RequireExp: true, Now: func() time.Time { return now }})
+			if err != c.err {
+				t.Errorf("err: %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ts := func(d time.Duration) int64 { return now.Add(d).Unix() }
+	cases := []struct {
+		name   string
+		claims Claims
+		opts   Options
+		err    error
+	}{
+		{"valid", Claims{"exp": ts(time.Minute)}, Options{}, nil},
+		{"expired", Claims{"exp": ts(-time.Second)}, Options{}, ErrExpired},
+		{"expired now", Claims{"exp": ts(0)}, Options{}, ErrExpired},
+		{"expired within leeway", Claims{"exp": ts(-20 * time.Second)}, Options{Leeway: 30 * time.Second}, nil},
+		{"expired beyond leeway", Claims{"exp": ts(-time.Minute)}, Options{Leeway: 30 * time.Second}, ErrExpired},
+		{"nbf future", Claims{"nbf": ts(time.Minute)}, Options{}, ErrNotValidYet},
+		{"nbf within leeway", Claims{"nbf": ts(20 * time.Second)}, Options{Leeway: 30 * time.Second}, nil},
+		{"nbf beyond leeway", Claims{"nbf": ts(time.Minute)}, Options{Leeway: 30 * time.Second}, ErrNotValidYet},
+		{"exp as json number", Claims{"exp": json.Number("1700000060")}, Options{}, nil},
+		{"require exp", Claims{}, Options{RequireExp: true}, ErrNoExpiration},
+		{"issuer", Claims{"iss": "a"}, Options{Issuer: "a"}, nil},
+		{"issuer mismatch", Claims{"iss": "b"}, Options{Issuer: "a"}, ErrIssuer},
+		{"aud string", Claims{"aud": "api"}, Options{Audience: []string{"web", "api"}}, nil},
+		{"aud list", Claims{"aud": []interface{}{"x", "api"}}, Options{Audience: []string{"api"}}, nil},
+		{"aud list mismatch", Claims{"aud": []interface{}{"x", "y"}}, Options{Audience: []string{"api"}}, ErrAudience},
+		{"aud missing", Claims{}, Options{Audience: []string{"api"}}, ErrAudience},
+		{"aud not required", Claims{"aud": "x"}, Options{}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := c.opts
+			opts.Now = func() time.Time { return now }
+			if err := c.claims.Validate(&opts); err != c.err {
+				t.Errorf("err: %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestJWKSFileReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	mtime := now
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime = mtime.Add(time.Second) // 保证mtime变化
+		os.Chtimes(path, mtime, mtime)
+	}
+	kids := func(keys []*Key) (ids []string) {
+		for _, k := range keys {
+			ids = append(ids, k.ID)
+		}
+		return
+	}
+
+	f := NewJWKSFile(path)
+	if _, err := f.Reload(); err == nil {
+		t.Errorf("missing file should fail")
+	}
+	write(`{"keys":[{"kty":"oct","kid":"k1","k":"czNjcmV0"},{"kty":"oct","kid":"k2","k":"czNjcmV0","use":"enc"}]}`)
+	if changed, err := f.Reload(); !changed || err != nil {
+		t.Fatalf("reload: %v %v", changed, err)
+	}
+	if ids := kids(f.Keys()); len(ids) != 1 || ids[0] != "k1" {
+		t.Fatalf("keys: %v", ids)
+	}
+	if changed, err := f.Reload(); changed || err != nil {
+		t.Errorf("unchanged file: %v %v", changed, err)
+	}
+
+	for _, bad := range []string{
+		`{"keys":[`,
+		`{"keys":[{"kty":"oct","kid":"k3","k":""}]}`,
+		`{"keys":[{"kty":"EC","kid":"k4","crv":"P-384","x":"AA","y":"AA"}]}`,
+		`{"keys":[{"kty":"oct","kid":"k5","k":"czNjcmV0","alg":"RS256"}]}`,
+	} {
+		write(bad)
+		if changed, err := f.Reload(); changed || err == nil {
+			t.Errorf("bad jwks %s: %v %v", bad, changed, err)
+		}
+		if ids := kids(f.Keys()); len(ids) != 1 || ids[0] != "k1" {
+			t.Errorf("old keys lost after %s: %v", bad, ids)
+		}
+	}
+
+	write(`{"keys":[{"kty":"oct","kid":"k1","k":"czNjcmV0"},{"kty":"oct","kid":"k6","k":"bmV3"}]}`)
+	if changed, err := f.Reload(); !changed || err != nil {
+		t.Fatalf("reload: %v %v", changed, err)
+	}
+	ks := NewKeySet(f.Keys()...)
+	tk := sign(t, Claims{"exp": now.Add(time.Hour).Unix()}, NewHMACKey("k6", []byte("new")))
+	if _, err := Parse(tk, ks, &Options{Now: func() time.Time { return now }}); err != nil {
+		t.Errorf("rotated key: %v", err)
+	}
+}
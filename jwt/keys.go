@@ -0,0 +1,298 @@
+//
+// keys.go
+// key及keyset, key来自secret, PEM或JWKS, 通过kid轮换(新旧key同时存在)
+//
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// Key 一个签名/校验用的key, HS256为secret, RS256/ES256为公钥(有私钥时可以签名)
+	Key struct {
+		ID      string
+		Alg     string
+		secret  []byte
+		public  crypto.PublicKey
+		private crypto.Signer
+	}
+
+	// KeySet 一组key, 可以整体替换(如JWKS重新加载)
+	KeySet struct {
+		mu   sync.RWMutex
+		keys []*Key
+	}
+
+	// JWKSFile 文件中的JWKS, 文件有变化时重新加载, 出错时保留之前的key
+	JWKSFile struct {
+		Path  string
+		mu    sync.Mutex
+		mtime time.Time
+		keys  []*Key
+	}
+
+	// JWKS中的单个key
+	jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Alg string `json:"alg"`
+		Use string `json:"use"`
+		K   string `json:"k"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+)
+
+// HMAC(HS256)的key
+func NewHMACKey(kid string, secret []byte) *Key {
+	return &Key{ID: kid, Alg: HS256, secret: secret}
+}
+
+/* {{{ func ParsePEM(kid, alg string, b []byte) (*Key, error)
+ * PEM格式的公钥(PKIX/PKCS1/证书)或私钥(PKCS1/PKCS8/EC), alg为空时按key的类型
+ */
+func ParsePEM(kid, alg string, b []byte) (*Key, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("jwt: invalid pem")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("jwt: unsupported pem type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newKey(kid, alg, key)
+}
+
+/* }}} */
+
+// 按key的类型确定alg, 并检查与给定的alg是否一致
+func newKey(kid, alg string, key interface{}) (*Key, error) {
+	k := &Key{ID: kid}
+	switch v := key.(type) {
+	case *rsa.PublicKey:
+		k.Alg, k.public = RS256, v
+	case *rsa.PrivateKey:
+		k.Alg, k.public, k.private = RS256, &v.PublicKey, v
+	case *ecdsa.PublicKey:
+		k.Alg, k.public = ES256, v
+	case *ecdsa.PrivateKey:
+		k.Alg, k.public, k.private = ES256, &v.PublicKey, v
+	default:
+		return nil, fmt.Errorf("jwt: unsupported key type: %T", key)
+	}
+	if k.Alg == ES256 && k.public.(*ecdsa.PublicKey).Curve != elliptic.P256() {
+		return nil, errors.New("jwt: ES256 requires P-256 key")
+	}
+	if alg != "" && alg != k.Alg {
+		return nil, fmt.Errorf("jwt: key type mismatch alg %s", alg)
+	}
+	return k, nil
+}
+
+// 是否可以用于签发
+func (k *Key) CanSign() bool {
+	if k.Alg == HS256 {
+		return len(k.secret) > 0
+	}
+	return k.private != nil
+}
+
+/* {{{ func ParseJWKS(b []byte) ([]*Key, error)
+ * JWKS({"keys":[...]}), 支持oct, RSA, EC(P-256), 只用于校验(私钥部分忽略), use不为sig的忽略
+ */
+func ParseJWKS(b []byte) ([]*Key, error) {
+	set := struct {
+		Keys []*jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]*Key, 0, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		k, err := j.key()
+		if err != nil {
+			return nil, fmt.Errorf("jwt: jwk %q: %s", j.Kid, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+/* }}} */
+
+/* {{{ JWKSFile
+ *
+ */
+func NewJWKSFile(path string) *JWKSFile {
+	return &JWKSFile{Path: path}
+}
+
+// 重新加载, 返回key是否有变化, 文件没有变化时不解析
+func (f *JWKSFile) Reload() (bool, error) {
+	fi, err := os.Stat(f.Path)
+	if err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fi.ModTime().Equal(f.mtime) {
+		return false, nil
+	}
+	b, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return false, err
+	}
+	keys, err := ParseJWKS(b)
+	if err != nil {
+		return false, err
+	}
+	f.keys, f.mtime = keys, fi.ModTime()
+	return true, nil
+}
+
+// 最近一次成功加载的key
+func (f *JWKSFile) Keys() []*Key {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.keys
+}
+
+/* }}} */
+
+func (j *jwk) key() (*Key, error) {
+	switch j.Kty {
+	case "oct":
+		secret, err := b64.DecodeString(j.K)
+		if err != nil || len(secret) == 0 {
+			return nil, errors.New("invalid k")
+		}
+		if j.Alg != "" && j.Alg != HS256 {
+			return nil, ErrUnsupportedAlg
+		}
+		return NewHMACKey(j.Kid, secret), nil
+	case "RSA":
+		n, err1 := b64.DecodeString(j.N)
+		e, err2 := b64.DecodeString(j.E)
+		if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 {
+			return nil, errors.New("invalid n/e")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return newKey(j.Kid, j.Alg, pub)
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported crv: %s", j.Crv)
+		}
+		x, err1 := b64.DecodeString(j.X)
+		y, err2 := b64.DecodeString(j.Y)
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("invalid x/y")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point not on curve")
+		}
+		return newKey(j.Kid, j.Alg, pub)
+	}
+	return nil, fmt.Errorf("unsupported kty: %s", j.Kty)
+}
+
+/* {{{ KeySet
+ *
+ */
+func NewKeySet(keys ...*Key) *KeySet {
+	return &KeySet{keys: keys}
+}
+
+// 整体替换
+func (ks *KeySet) Set(keys []*Key) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+}
+
+// 添加, 相同kid的替换
+func (ks *KeySet) Add(k *Key) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for i, o := range ks.keys {
+		if o.ID == k.ID && k.ID != "" {
+			ks.keys[i] = k
+			return
+		}
+	}
+	ks.keys = append(ks.keys, k)
+}
+
+// 校验用的key, 有kid时按kid, 否则所有alg相同的key
+func (ks *KeySet) Find(kid, alg string) []*Key {
+	if ks == nil {
+		return nil
+	}
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	var keys []*Key
+	for _, k := range ks.keys {
+		if k.Alg == alg && (kid == "" || k.ID == kid) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// 签发用的key, kid为空时为第一个可以签名的key
+func (ks *KeySet) Signer(kid string) *Key {
+	if ks == nil {
+		return nil
+	}
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, k := range ks.keys {
+		if k.CanSign() && (kid == "" || k.ID == kid) {
+			return k
+		}
+	}
+	return nil
+}
+
+/* }}} */
@@ -22,7 +22,7 @@ type (
 /* {{{ Logger() logger
  * get logger
  */
-func Logger() logger { return wgo.Logger() }
+func Logger() logger { return Self().Logger() }
 func (w *WGO) Logger() logger {
 	if w.logger == nil { // 这里env就是logger, 只要实现了logger接口的都可set
 		w.SetLogger(w.Env())
@@ -36,7 +36,7 @@ func (w *WGO) Logger() logger {
  *
  */
 func SetLogger(l logger) {
-	wgo.SetLogger(l)
+	Self().SetLogger(l)
 }
 func (w *WGO) SetLogger(l logger) {
 	if w != nil {
@@ -50,7 +50,7 @@ func (w *WGO) SetLogger(l logger) {
 /* {{{ func Debug()
  *
  */
-func Debug(arg0 interface{}, args ...interface{}) { wgo.Debug(arg0, args...) }
+func Debug(arg0 interface{}, args ...interface{}) { Self().Debug(arg0, args...) }
 func (w *WGO) Debug(arg0 interface{}, args ...interface{}) {
 	if w != nil {
 		w.Logger().Debug(arg0, args...)
@@ -71,7 +71,7 @@ func (w *WGO) Printf(format string, args ...interface{}) {
 /* {{{ func Info()
  *
  */
-func Info(arg0 interface{}, args ...interface{}) { wgo.Info(arg0, args...) }
+func Info(arg0 interface{}, args ...interface{}) { Self().Info(arg0, args...) }
 func (w *WGO) Info(arg0 interface{}, args ...interface{}) {
 	if w != nil {
 		w.Logger().Info(arg0, args...)
@@ -85,7 +85,7 @@ func (w *WGO) Info(arg0 interface{}, args ...interface{}) {
 /* {{{ func Warn()
  *
  */
-func Warn(arg0 interface{}, args ...interface{}) { wgo.Warn(arg0, args...) }
+func Warn(arg0 interface{}, args ...interface{}) { Self().Warn(arg0, args...) }
 func (w *WGO) Warn(arg0 interface{}, args ...interface{}) {
 	if w != nil {
 		w.Logger().Warn(arg0, args...)
@@ -99,7 +99,7 @@ func (w *WGO) Warn(arg0 interface{}, args ...interface{}) {
 /* {{{ func Error()
  *
  */
-func Error(arg0 interface{}, args ...interface{}) { wgo.Error(arg0, args...) }
+func Error(arg0 interface{}, args ...interface{}) { Self().Error(arg0, args...) }
 func (w *WGO) Error(arg0 interface{}, args ...interface{}) {
 	if w != nil {
 		w.Logger().Error(arg0, args...)
@@ -126,7 +126,7 @@ func (w *WGO) Log(arg0 interface{}, args ...interface{}) {
 /* {{{ func Fatal()
  *
  */
-func Fatal(arg0 interface{}, args ...interface{}) { wgo.Fatal(arg0, args...) }
+func Fatal(arg0 interface{}, args ...interface{}) { Self().Fatal(arg0, args...) }
 func (w *WGO) Fatal(arg0 interface{}, args ...interface{}) {
 	if w != nil {
 		w.Logger().Fatal(arg0, args...)
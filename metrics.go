@@ -0,0 +1,173 @@
+//
+// metrics.go
+// 内置指标及/metrics接口(prometheus text format)
+//
+
+package wgo
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"wgo/environ"
+	"wgo/metrics"
+	"wgo/server"
+	"wgo/whttp"
+	"wgo/wrpc"
+)
+
+const defaultMetricsPath = "/metrics"
+
+var (
+	httpRequests = metrics.GetCounter("wgo_http_requests_total",
+		"Total number of HTTP requests.", "method", "route", "status")
+	httpDuration = metrics.GetHistogram("wgo_http_request_duration_seconds",
+		"HTTP request latency in seconds.", nil, "method", "route")
+	httpInflight = metrics.GetGauge("wgo_http_requests_in_flight",
+		"Number of HTTP requests being served.")
+
+	rpcRequests = metrics.GetCounter("wgo_rpc_requests_total",
+		"Total number of wrpc calls.", "method", "code")
+	rpcDuration = metrics.GetHistogram("wgo_rpc_request_duration_seconds",
+		"wrpc call latency in seconds.", nil, "method")
+
+	jobTotal = metrics.GetCounter("wgo_jobs_total",
+		"Total number of handled jobs.", "work", "method", "result")
+	jobDuration = metrics.GetHistogram("wgo_job_duration_seconds",
+		"Job handling latency in seconds.", nil, "work", "method")
+	jobWait = metrics.GetHistogram("wgo_job_wait_seconds",
+		"Time jobs spent in queue in seconds.", nil, "work")
+)
+
+/* {{{ func Metrics() MiddlewareFunc
+ * http按路由模板(RouteNode.Path), wrpc按方法记录请求数及耗时, 默认已加载
+ */
+func Metrics() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) (err error) {
+			start := time.Now()
+			switch c.ServerMode() {
+			case "rpc", "wrpc", "grpc":
+				err = next(c)
+				method := c.Request().(*wrpc.Request).Method()
+				code := "0"
+				if err != nil {
+					code = strconv.FormatInt(ErrorCode(err), 10)
+				}
+				rpcRequests.Inc(method, code)
+				rpcDuration.Observe(time.Since(start).Seconds(), method)
+			default:
+				httpInflight.Inc()
+				defer httpInflight.Dec() // handler panic时也要减回去
+				err = next(c)
+				route := c.Path()
+				if route == "" { // 没有匹配的路由
+					route = "unmatched"
+				}
+				method := c.Method()
+				httpRequests.Inc(method, route, strconv.Itoa(responseStatus(c, err)))
+				httpDuration.Observe(time.Since(start).Seconds(), method, route)
+			}
+			return err
+		}
+	}
+}
+
+/* }}} */
+
+// http返回的状态码, err不为nil时还没有输出错误, 以err为准
+func responseStatus(c *Context, err error) int {
+	status := c.Status()
+	if err != nil {
+		if status = server.WrapError(err).HTTPStatusCode(); status == 0 {
+			status = whttp.StatusInternalServerError
+		}
+	}
+	return status
+}
+
+/* {{{ func Registry() *metrics.Registry
+ * app自己的指标(如works的状态), /metrics输出metrics.Default及它
+ * 自定义指标可以用metrics.GetCounter等注册到metrics.Default, 或者注册到这里
+ */
+func Registry() *metrics.Registry { return Self().Registry() }
+func (w *WGO) Registry() *metrics.Registry {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.registry == nil {
+		w.registry = metrics.NewRegistry()
+	}
+	return w.registry
+}
+
+/* }}} */
+
+// works的状态, 采集时读取
+func (w *WGO) registerWorkMetrics() {
+	stats := func(fn func(WorkStats) float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			ss := make([]metrics.Sample, 0)
+			for _, work := range w.Works() {
+				st := work.Stats()
+				ss = append(ss, metrics.Sample{Labels: metrics.Labels("work", st.Name), Value: fn(st)})
+			}
+			return ss
+		}
+	}
+	r := w.Registry()
+	r.MustRegister(
+		metrics.NewGaugeFunc("wgo_work_queue_depth", "Jobs waiting in queue.",
+			stats(func(st WorkStats) float64 { return float64(st.Depth) })),
+		metrics.NewGaugeFunc("wgo_work_queue_capacity", "Queue capacity.",
+			stats(func(st WorkStats) float64 { return float64(st.Capacity) })),
+		metrics.NewGaugeFunc("wgo_work_workers", "Current number of workers.",
+			stats(func(st WorkStats) float64 { return float64(st.Workers) })),
+		metrics.NewGaugeFunc("wgo_work_busy_workers", "Workers handling a job.",
+			stats(func(st WorkStats) float64 { return float64(st.Busy) })),
+		metrics.NewCounterFunc("wgo_work_rejected_total", "Jobs rejected because the queue was full.",
+			stats(func(st WorkStats) float64 { return float64(st.Rejected) })),
+		metrics.NewCounterFunc("wgo_work_dropped_total", "Jobs dropped because the queue was full.",
+			stats(func(st WorkStats) float64 { return float64(st.Dropped) })),
+	)
+}
+
+// job结束时记录
+func observeJob(job *Job, start time.Time) {
+	result := "ok"
+	if job.err != nil {
+		result = "error"
+	}
+	jobTotal.Inc(job.work, job.method, result)
+	jobDuration.Observe(time.Since(start).Seconds(), job.work, job.method)
+}
+
+// 默认只在admin server上提供/metrics, 明确配置了metrics.path才注册到对外的http server, 配置metrics.disable关闭
+func (w *WGO) serveMetrics() {
+	w.registerWorkMetrics()
+	if w.Cfg().Bool(environ.CFG_KEY_METRICS + ".disable") {
+		return
+	}
+	path := w.Cfg().String(environ.CFG_KEY_METRICS + ".path")
+	if path == "" {
+		if len(w.AdminServers()) == 0 {
+			w.Info("[serveMetrics]no admin server, set %s.path to serve metrics on http servers", environ.CFG_KEY_METRICS)
+		}
+		return
+	}
+	if ss := w.HTTPServers(); len(ss) > 0 {
+		ss.GET(path, metricsHandler)
+	}
+}
+
+func metricsHandler(c *Context) error {
+	buf := new(bytes.Buffer)
+	if _, err := metrics.Default.WriteTo(buf); err != nil {
+		return err
+	}
+	if _, err := c.App().Registry().WriteTo(buf); err != nil {
+		return err
+	}
+	c.SetHeader("Cache-Control", "no-cache")
+	return c.Blob(whttp.StatusOK, metrics.ContentType, buf.Bytes())
+}
@@ -0,0 +1,342 @@
+//
+// metric.go
+// counter, gauge, histogram, 可以带label, 格式与prometheus一致
+//
+
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+	TypeUntyped   = "untyped"
+)
+
+// 默认的histogram分桶(秒)
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type (
+	// Metric 可以注册到Registry的指标
+	Metric interface {
+		Name() string
+		Help() string
+		Type() string
+		// Samples 当前的所有样本
+		Samples() []Sample
+	}
+
+	// Sample 一个样本, Name为空时使用Metric的Name(histogram有_bucket/_sum/_count后缀)
+	Sample struct {
+		Name   string
+		Labels []Label
+		Value  float64
+	}
+
+	Label struct {
+		Name  string
+		Value string
+	}
+
+	desc struct {
+		name   string
+		help   string
+		labels []string
+	}
+
+	// 按label值保存子指标
+	vec struct {
+		desc
+		lock     sync.RWMutex
+		children map[string]*child
+	}
+
+	child struct {
+		values []string
+		bits   uint64 // counter/gauge的值(float64)
+
+		lock    sync.Mutex // histogram
+		buckets []uint64
+		sum     float64
+		count   uint64
+	}
+
+	Counter struct {
+		vec
+	}
+
+	Gauge struct {
+		vec
+	}
+
+	Histogram struct {
+		vec
+		bounds []float64
+	}
+
+	// GaugeFunc 采集时调用fn, 用于队列深度等已有的状态
+	GaugeFunc struct {
+		desc
+		typ string
+		fn  func() []Sample
+	}
+)
+
+func (d *desc) Name() string { return d.name }
+func (d *desc) Help() string { return d.help }
+
+// label值的数量必须与定义时的label名一致
+func (d *desc) check(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("[metrics]%s: expect %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+}
+
+func (d *desc) pairs(values []string, extra ...Label) []Label {
+	ls := make([]Label, 0, len(values)+len(extra))
+	for i, v := range values {
+		ls = append(ls, Label{Name: d.labels[i], Value: v})
+	}
+	return append(ls, extra...)
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{desc: desc{name: name, help: help, labels: labels}, children: make(map[string]*child)}
+}
+
+func (v *vec) get(values []string, init func(*child)) *child {
+	v.check(values)
+	key := strings.Join(values, "\xff")
+	v.lock.RLock()
+	c, ok := v.children[key]
+	v.lock.RUnlock()
+	if ok {
+		return c
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if c, ok = v.children[key]; !ok {
+		c = &child{values: append([]string(nil), values...)}
+		if init != nil {
+			init(c)
+		}
+		v.children[key] = c
+	}
+	return c
+}
+
+// 不存在时不创建
+func (v *vec) lookup(values []string) *child {
+	v.check(values)
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.children[strings.Join(values, "\xff")]
+}
+
+// 按label值排序, 输出稳定
+func (v *vec) sorted() []*child {
+	v.lock.RLock()
+	cs := make([]*child, 0, len(v.children))
+	for _, c := range v.children {
+		cs = append(cs, c)
+	}
+	v.lock.RUnlock()
+	sort.Slice(cs, func(i, j int) bool {
+		return strings.Join(cs[i].values, "\xff") < strings.Join(cs[j].values, "\xff")
+	})
+	return cs
+}
+
+// 清除所有label值
+func (v *vec) Reset() {
+	v.lock.Lock()
+	v.children = make(map[string]*child)
+	v.lock.Unlock()
+}
+
+func (c *child) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		nv := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&c.bits, old, nv) {
+			return
+		}
+	}
+}
+
+func (c *child) value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+/* {{{ counter
+ * 只增不减
+ */
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{vec: newVec(name, help, labels)}
+}
+
+func (m *Counter) Type() string { return TypeCounter }
+
+func (m *Counter) Inc(values ...string) {
+	m.get(values, nil).add(1)
+}
+
+// delta小于0时忽略
+func (m *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	m.get(values, nil).add(delta)
+}
+
+func (m *Counter) Value(values ...string) float64 {
+	if c := m.lookup(values); c != nil {
+		return c.value()
+	}
+	return 0
+}
+
+func (m *Counter) Samples() []Sample {
+	ss := make([]Sample, 0)
+	for _, c := range m.sorted() {
+		ss = append(ss, Sample{Labels: m.pairs(c.values), Value: c.value()})
+	}
+	return ss
+}
+
+/* }}} */
+
+/* {{{ gauge
+ *
+ */
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{vec: newVec(name, help, labels)}
+}
+
+func (m *Gauge) Type() string { return TypeGauge }
+
+func (m *Gauge) Set(v float64, values ...string) {
+	atomic.StoreUint64(&m.get(values, nil).bits, math.Float64bits(v))
+}
+
+func (m *Gauge) Add(delta float64, values ...string) {
+	m.get(values, nil).add(delta)
+}
+
+func (m *Gauge) Inc(values ...string) { m.Add(1, values...) }
+
+func (m *Gauge) Dec(values ...string) { m.Add(-1, values...) }
+
+func (m *Gauge) Value(values ...string) float64 {
+	if c := m.lookup(values); c != nil {
+		return c.value()
+	}
+	return 0
+}
+
+func (m *Gauge) Samples() []Sample {
+	ss := make([]Sample, 0)
+	for _, c := range m.sorted() {
+		ss = append(ss, Sample{Labels: m.pairs(c.values), Value: c.value()})
+	}
+	return ss
+}
+
+/* }}} */
+
+/* {{{ histogram
+ * buckets为空时使用DefaultBuckets
+ */
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	return &Histogram{vec: newVec(name, help, labels), bounds: bounds}
+}
+
+func (m *Histogram) Type() string { return TypeHistogram }
+
+func (m *Histogram) Observe(v float64, values ...string) {
+	c := m.get(values, func(c *child) {
+		c.buckets = make([]uint64, len(m.bounds))
+	})
+	idx := sort.SearchFloat64s(m.bounds, v) // 第一个>=v的桶
+	c.lock.Lock()
+	if idx < len(c.buckets) {
+		c.buckets[idx]++
+	}
+	c.sum += v
+	c.count++
+	c.lock.Unlock()
+}
+
+// 样本数及总和
+func (m *Histogram) Count(values ...string) (uint64, float64) {
+	c := m.lookup(values)
+	if c == nil {
+		return 0, 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.count, c.sum
+}
+
+func (m *Histogram) Samples() []Sample {
+	ss := make([]Sample, 0)
+	for _, c := range m.sorted() {
+		c.lock.Lock()
+		var cum uint64
+		for i, b := range m.bounds {
+			cum += c.buckets[i]
+			ss = append(ss, Sample{Name: m.name + "_bucket", Labels: m.pairs(c.values, Label{Name: "le", Value: formatFloat(b)}), Value: float64(cum)})
+		}
+		ss = append(ss,
+			Sample{Name: m.name + "_bucket", Labels: m.pairs(c.values, Label{Name: "le", Value: "+Inf"}), Value: float64(c.count)},
+			Sample{Name: m.name + "_sum", Labels: m.pairs(c.values), Value: c.sum},
+			Sample{Name: m.name + "_count", Labels: m.pairs(c.values), Value: float64(c.count)},
+		)
+		c.lock.Unlock()
+	}
+	return ss
+}
+
+/* }}} */
+
+/* {{{ func NewGaugeFunc(name, help string, fn func() []Sample) *GaugeFunc
+ * 采集时调用fn取得样本, 样本的Name为空
+ */
+func NewGaugeFunc(name, help string, fn func() []Sample) *GaugeFunc {
+	return &GaugeFunc{desc: desc{name: name, help: help}, typ: TypeGauge, fn: fn}
+}
+
+// 同GaugeFunc, 类型为counter(如已有的累计次数)
+func NewCounterFunc(name, help string, fn func() []Sample) *GaugeFunc {
+	return &GaugeFunc{desc: desc{name: name, help: help}, typ: TypeCounter, fn: fn}
+}
+
+func (m *GaugeFunc) Type() string { return m.typ }
+
+func (m *GaugeFunc) Samples() []Sample {
+	return m.fn()
+}
+
+/* }}} */
+
+// 生成样本的label
+func Labels(pairs ...string) []Label {
+	ls := make([]Label, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		ls = append(ls, Label{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return ls
+}
@@ -0,0 +1,211 @@
+//
+// registry.go
+// 指标的注册及输出(prometheus text format 0.0.4)
+//
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type Registry struct {
+	lock    sync.RWMutex
+	metrics map[string]Metric
+}
+
+// 进程级别的registry, wgo内置的指标都注册在这里
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]Metric)}
+}
+
+/* {{{ func (r *Registry) Register(m Metric) error
+ * 同名的已存在返回error
+ */
+func Register(m Metric) error { return Default.Register(m) }
+func (r *Registry) Register(m Metric) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.metrics[m.Name()]; ok {
+		return fmt.Errorf("metric %s already registered", m.Name())
+	}
+	r.metrics[m.Name()] = m
+	return nil
+}
+
+/* }}} */
+
+func MustRegister(ms ...Metric) { Default.MustRegister(ms...) }
+func (r *Registry) MustRegister(ms ...Metric) {
+	for _, m := range ms {
+		if err := r.Register(m); err != nil {
+			panic("[metrics]" + err.Error())
+		}
+	}
+}
+
+func Unregister(name string) { Default.Unregister(name) }
+func (r *Registry) Unregister(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.metrics, name)
+}
+
+func Get(name string) Metric { return Default.Get(name) }
+func (r *Registry) Get(name string) Metric {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.metrics[name]
+}
+
+// 已存在则返回已有的(类型不一致时panic), 否则用gen创建并注册
+func (r *Registry) getOrRegister(name string, gen func() Metric) Metric {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		return m
+	}
+	m := gen()
+	r.metrics[name] = m
+	return m
+}
+
+/* {{{ Counter/Gauge/Histogram
+ * 取得已注册的指标, 没有则创建, 多处使用同一个指标时不需要关心注册顺序
+ */
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	m := r.getOrRegister(name, func() Metric { return NewCounter(name, help, labels...) })
+	if c, ok := m.(*Counter); ok {
+		return c
+	}
+	panic(fmt.Sprintf("[metrics]%s registered as %s", name, m.Type()))
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	m := r.getOrRegister(name, func() Metric { return NewGauge(name, help, labels...) })
+	if g, ok := m.(*Gauge); ok {
+		return g
+	}
+	panic(fmt.Sprintf("[metrics]%s registered as %s", name, m.Type()))
+}
+
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	m := r.getOrRegister(name, func() Metric { return NewHistogram(name, help, buckets, labels...) })
+	if h, ok := m.(*Histogram); ok {
+		return h
+	}
+	panic(fmt.Sprintf("[metrics]%s registered as %s", name, m.Type()))
+}
+
+func GetCounter(name, help string, labels ...string) *Counter {
+	return Default.Counter(name, help, labels...)
+}
+func GetGauge(name, help string, labels ...string) *Gauge {
+	return Default.Gauge(name, help, labels...)
+}
+func GetHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.Histogram(name, help, buckets, labels...)
+}
+
+/* }}} */
+
+/* {{{ func (r *Registry) WriteTo(w io.Writer) (int64, error)
+ * 按名称排序输出所有指标, 没有样本的指标不输出
+ */
+func WriteTo(w io.Writer) (int64, error) { return Default.WriteTo(w) }
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.RLock()
+	ms := make([]Metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		ms = append(ms, m)
+	}
+	r.lock.RUnlock()
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Name() < ms[j].Name() })
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, m := range ms {
+		ss := m.Samples()
+		if len(ss) == 0 {
+			continue
+		}
+		if m.Help() != "" {
+			fmt.Fprintf(cw, "# HELP %s %s\n", m.Name(), escapeHelp(m.Help()))
+		}
+		fmt.Fprintf(cw, "# TYPE %s %s\n", m.Name(), m.Type())
+		for _, s := range ss {
+			name := s.Name
+			if name == "" {
+				name = m.Name()
+			}
+			cw.WriteString(name)
+			if len(s.Labels) > 0 {
+				cw.WriteString("{")
+				for i, l := range s.Labels {
+					if i > 0 {
+						cw.WriteString(",")
+					}
+					fmt.Fprintf(cw, "%s=\"%s\"", l.Name, escapeLabel(l.Value))
+				}
+				cw.WriteString("}")
+			}
+			cw.WriteString(" " + formatFloat(s.Value) + "\n")
+		}
+	}
+	err := cw.w.Flush()
+	if cw.err != nil {
+		err = cw.err
+	}
+	return cw.n, err
+}
+
+/* }}} */
+
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	if err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return n, err
+}
+
+func (cw *countWriter) WriteString(s string) {
+	cw.Write([]byte(s))
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,27 @@
+package wgo_test
+
+import (
+	"testing"
+
+	"wgo"
+	"wgo/wgotest"
+)
+
+func TestMetricsPath(t *testing.T) {
+	app := wgotest.New("proc_name: metricstest")
+	defer app.Close()
+	// 默认只在admin server上
+	if r := app.GET("/metrics"); r.Code == 200 {
+		t.Errorf("metrics served on public server: %s", r)
+	}
+}
+
+func TestMetricsInflight(t *testing.T) {
+	app := wgotest.New("proc_name: metricstest\nmetrics:\n  path: /metrics")
+	defer app.Close()
+	wgo.GET("/panic", func(c *wgo.Context) error { panic("boom") })
+	app.GET("/panic").ExpectContains(t, "boom")
+	app.GET("/panic").ExpectContains(t, "boom")
+	// 只有/metrics本身在处理中
+	app.GET("/metrics").Expect(t, 200).ExpectContains(t, "wgo_http_requests_in_flight 1\n")
+}
@@ -95,22 +95,17 @@ func RecoverWithConfig(config RecoverConfig) MiddlewareFunc {
 // 准备工作
 // 判断特别参数
 // 生成request_id
+// 解析traceparent, 记录请求的span
 func Prepare() MiddlewareFunc {
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c *Context) (err error) {
 			c.Debug("[wgo.Prepare]-->%s<--", c.Query())
-			if c.Request().(whttp.Request).Method() == "OPTIONS" { // 统一处理options请求
-				c.response.(whttp.Response).Header().Set(whttp.HeaderAccessControlMaxAge, "86400")
-				c.response.(whttp.Response).Header().Set(whttp.HeaderAccessControlAllowMethods, "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-				if ch := c.Request().(whttp.Request).Header().Get(whttp.HeaderAccessControlRequestHeaders); ch != "" {
-					c.response.(whttp.Response).Header().Set(whttp.HeaderAccessControlAllowHeaders, ch) // 来者不拒
-				}
-				if origin := c.Request().(whttp.Request).Header().Get(whttp.HeaderOrigin); origin != "" {
-					c.response.(whttp.Response).Header().Set(whttp.HeaderAccessControlAllowOrigin, origin)
-					c.response.(whttp.Response).Header().Set(whttp.HeaderAccessControlAllowCredentials, "true")
-				}
-				c.Response().(whttp.Response).WriteHeader(whttp.StatusOK)
-				return nil
+			if c.ServerMode() == "rpc" {
+				c.SetRequestID(utils.FastRequestId(16))
+				span := c.startServerSpan()
+				err = next(c)
+				c.endServerSpan(span, err)
+				return err
 			}
 			// find request id
 			requestId := ""
@@ -118,20 +113,16 @@ func Prepare() MiddlewareFunc {
 				requestId = prid
 			} else { // generate request id
 				requestId = utils.FastRequestId(16)
-				// not behind msa,
-				// cors header(Access-Control-Allow-*)
-				if origin := c.Request().(whttp.Request).Header().Get(whttp.HeaderOrigin); origin != "" {
-					c.response.(whttp.Response).Header().Set(whttp.HeaderAccessControlAllowOrigin, origin)
-					c.response.(whttp.Response).Header().Set(whttp.HeaderAccessControlAllowCredentials, "true")
-				}
 			}
 			c.SetRequestID(requestId)
 
+			span := c.startServerSpan()
 			err = next(c)
+			c.endServerSpan(span, err)
 
 			if c.ServerMode() == "http" {
 				c.response.(whttp.Response).Header().
-					Set(whttp.HeaderServer, fmt.Sprintf("%s %s", strings.ToUpper(Env().ProcName), Version()))
+					Set(whttp.HeaderServer, fmt.Sprintf("%s %s", strings.ToUpper(c.App().Env().ProcName), Version()))
 			}
 
 			return err
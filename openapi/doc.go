@@ -0,0 +1,116 @@
+//
+// doc.go
+// 路由的文档注释: wgo.GET(...).SetOptions(openapi.OptionKey, &openapi.Doc{...})
+//
+
+package openapi
+
+import (
+	"strconv"
+
+	"wgo/whttp"
+)
+
+// 路由option的key
+const OptionKey = "_openapi_"
+
+// 路由注释, 都是可选的
+type Doc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Params      []*Parameter // 额外的参数, 用Query/Header构建
+	Request     interface{}  // 请求body的例子(struct或指针), 生成schema
+	Response    interface{}  // 成功时返回的例子
+	Status      int          // 成功时的状态码, 默认200
+	Deprecated  bool
+	Hidden      bool // 不出现在文档中
+}
+
+// 给路由加注释
+func Annotate(rs whttp.Routes, doc *Doc) whttp.Routes {
+	return rs.SetOptions(OptionKey, doc)
+}
+
+// 从路由options中取注释
+func DocOf(opts whttp.Options) *Doc {
+	if d, ok := opts[OptionKey].(*Doc); ok {
+		return d
+	}
+	return nil
+}
+
+// query参数, typ为string/integer/number/boolean
+func Query(name, typ, desc string, required ...bool) *Parameter {
+	return param("query", name, typ, desc, required...)
+}
+
+// header参数
+func Header(name, typ, desc string, required ...bool) *Parameter {
+	return param("header", name, typ, desc, required...)
+}
+
+func param(in, name, typ, desc string, required ...bool) *Parameter {
+	if typ == "" {
+		typ = "string"
+	}
+	return &Parameter{
+		Name:        name,
+		In:          in,
+		Description: desc,
+		Required:    len(required) > 0 && required[0],
+		Schema:      &Schema{Type: typ},
+	}
+}
+
+/* {{{ func (d *Document) Apply(op *Operation, doc *Doc)
+ * 把注释合并到operation, 注释中有的覆盖
+ */
+func (d *Document) Apply(op *Operation, doc *Doc) {
+	if doc == nil {
+		return
+	}
+	if doc.Summary != "" {
+		op.Summary = doc.Summary
+	}
+	if doc.Description != "" {
+		op.Description = doc.Description
+	}
+	if len(doc.Tags) > 0 {
+		op.Tags = doc.Tags
+	}
+	for _, p := range doc.Params {
+		if ep := op.Param(p.Name, p.In); ep != nil {
+			*ep = *p
+		} else {
+			op.Parameters = append(op.Parameters, p)
+		}
+	}
+	if doc.Request != nil {
+		op.RequestBody = &RequestBody{Required: true, Content: JSONContent(d.SchemaOf(doc.Request))}
+	}
+	if doc.Response != nil || doc.Status > 0 {
+		if op.Responses == nil {
+			op.Responses = make(map[string]*Response)
+		}
+		status := doc.Status
+		if status == 0 {
+			status = 200
+		}
+		for code := range op.Responses { // 去掉原来的成功返回
+			if code[0] == '2' {
+				delete(op.Responses, code)
+			}
+		}
+		resp := &Response{Description: "OK"}
+		if doc.Response != nil {
+			resp.Content = JSONContent(d.SchemaOf(doc.Response))
+		}
+		op.Responses[strconv.Itoa(status)] = resp
+	}
+	if doc.Deprecated {
+		op.Deprecated = true
+	}
+}
+
+/* }}} */
@@ -0,0 +1,168 @@
+//
+// schema.go
+// 根据go类型生成schema, 有名称的struct放到components
+//
+
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	rawType       = reflect.TypeOf(json.RawMessage{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+/* {{{ func (d *Document) SchemaOf(i interface{}) *Schema
+ * i可以是值, 指针或reflect.Type, nil返回空schema(任意值)
+ */
+func (d *Document) SchemaOf(i interface{}) *Schema {
+	if i == nil {
+		return &Schema{}
+	}
+	t, ok := i.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(i)
+	}
+	return d.schemaOf(t)
+}
+
+func (d *Document) schemaOf(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+	s := TypeSchema(t)
+	if s == nil {
+		switch t.Kind() {
+		case reflect.Slice, reflect.Array:
+			s = &Schema{Type: "array", Items: d.schemaOf(t.Elem())}
+		case reflect.Map:
+			s = &Schema{Type: "object", AdditionalProperties: d.schemaOf(t.Elem())}
+		case reflect.Struct:
+			if t.Name() == "" {
+				s = d.structSchema(t)
+			} else {
+				name := SchemaName(t)
+				if _, ok := d.Components.Schemas[name]; !ok {
+					d.Components.Schemas[name] = &Schema{} // 占位, 防止递归
+					d.Components.Schemas[name] = d.structSchema(t)
+				}
+				return Ref(name) // $ref不能有其他属性
+			}
+		default: // interface, func等
+			s = &Schema{}
+		}
+	}
+	s.Nullable = nullable && s.Type != ""
+	return s
+}
+
+/* }}} */
+
+/* {{{ func TypeSchema(t reflect.Type) *Schema
+ * 基础类型的schema, 不是基础类型返回nil
+ */
+func TypeSchema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawType:
+		return &Schema{}
+	case t.Kind() == reflect.Struct && (t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType)):
+		return &Schema{} // 自定义序列化, 不知道结构
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+	}
+	return nil
+}
+
+/* }}} */
+
+// struct, 按encoding/json的规则
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := JSONName(f)
+		if !ok {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				// 匿名struct展开
+				for k, v := range d.structSchema(ft).Properties {
+					if _, exists := s.Properties[k]; !exists {
+						s.Properties[k] = v
+					}
+				}
+				continue
+			}
+			name = f.Name
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = d.schemaOf(f.Type)
+	}
+	return s
+}
+
+/* {{{ func JSONName(f reflect.StructField) (name string, ok bool)
+ * json tag中的名称, 不导出或者"-"时ok为false, 匿名字段没有tag时name为空
+ */
+func JSONName(f reflect.StructField) (name string, ok bool) {
+	if f.PkgPath != "" && !f.Anonymous { // 不导出
+		return "", false
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if name = strings.Split(tag, ",")[0]; name == "" && !f.Anonymous {
+		name = f.Name
+	}
+	return name, true
+}
+
+/* }}} */
+
+// schema名称, 包名.类型名
+func SchemaName(t reflect.Type) string {
+	name := t.Name()
+	if pp := t.PkgPath(); pp != "" {
+		pps := strings.Split(pp, "/")
+		name = pps[len(pps)-1] + "." + name
+	}
+	return name
+}
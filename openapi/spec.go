@@ -0,0 +1,232 @@
+//
+// spec.go
+// OpenAPI 3文档结构, 只包含wgo用到的部分
+//
+
+package openapi
+
+import (
+	"regexp"
+	"strings"
+)
+
+const Version = "3.0.3"
+
+type (
+	Document struct {
+		OpenAPI    string               `json:"openapi"`
+		Info       Info                 `json:"info"`
+		Servers    []*Server            `json:"servers,omitempty"`
+		Tags       []*Tag               `json:"tags,omitempty"`
+		Paths      map[string]*PathItem `json:"paths"`
+		Components Components           `json:"components"`
+	}
+
+	Info struct {
+		Title       string `json:"title"`
+		Description string `json:"description,omitempty"`
+		Version     string `json:"version"`
+	}
+
+	Server struct {
+		URL         string `json:"url"`
+		Description string `json:"description,omitempty"`
+	}
+
+	Tag struct {
+		Name        string `json:"name"`
+		Description string `json:"description,omitempty"`
+	}
+
+	PathItem struct {
+		Get     *Operation `json:"get,omitempty"`
+		Put     *Operation `json:"put,omitempty"`
+		Post    *Operation `json:"post,omitempty"`
+		Delete  *Operation `json:"delete,omitempty"`
+		Options *Operation `json:"options,omitempty"`
+		Head    *Operation `json:"head,omitempty"`
+		Patch   *Operation `json:"patch,omitempty"`
+		Trace   *Operation `json:"trace,omitempty"`
+	}
+
+	Operation struct {
+		Tags        []string             `json:"tags,omitempty"`
+		Summary     string               `json:"summary,omitempty"`
+		Description string               `json:"description,omitempty"`
+		OperationID string               `json:"operationId,omitempty"`
+		Parameters  []*Parameter         `json:"parameters,omitempty"`
+		RequestBody *RequestBody         `json:"requestBody,omitempty"`
+		Responses   map[string]*Response `json:"responses"`
+		Deprecated  bool                 `json:"deprecated,omitempty"`
+	}
+
+	Parameter struct {
+		Name        string  `json:"name"`
+		In          string  `json:"in"` // query, header, path, cookie
+		Description string  `json:"description,omitempty"`
+		Required    bool    `json:"required,omitempty"`
+		Schema      *Schema `json:"schema,omitempty"`
+	}
+
+	RequestBody struct {
+		Description string                `json:"description,omitempty"`
+		Required    bool                  `json:"required,omitempty"`
+		Content     map[string]*MediaType `json:"content"`
+	}
+
+	Response struct {
+		Description string                `json:"description"`
+		Content     map[string]*MediaType `json:"content,omitempty"`
+	}
+
+	MediaType struct {
+		Schema *Schema `json:"schema,omitempty"`
+	}
+
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas,omitempty"`
+	}
+
+	Schema struct {
+		Ref                  string             `json:"$ref,omitempty"`
+		Type                 string             `json:"type,omitempty"`
+		Format               string             `json:"format,omitempty"`
+		Description          string             `json:"description,omitempty"`
+		Enum                 []interface{}      `json:"enum,omitempty"`
+		Default              interface{}        `json:"default,omitempty"`
+		Minimum              *float64           `json:"minimum,omitempty"`
+		Maximum              *float64           `json:"maximum,omitempty"`
+		Nullable             bool               `json:"nullable,omitempty"`
+		ReadOnly             bool               `json:"readOnly,omitempty"`
+		WriteOnly            bool               `json:"writeOnly,omitempty"`
+		Items                *Schema            `json:"items,omitempty"`
+		Properties           map[string]*Schema `json:"properties,omitempty"`
+		Required             []string           `json:"required,omitempty"`
+		AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	}
+)
+
+var pathParamRe = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// new document
+func NewDocument(title, version string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: title, Version: version},
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			Schemas: make(map[string]*Schema),
+		},
+	}
+}
+
+/* {{{ func (d *Document) AddOperation(method, path string, op *Operation)
+ * path为wgo的路由(/users/:id), 转为/users/{id}, 并补上path参数
+ */
+func (d *Document) AddOperation(method, path string, op *Operation) {
+	for _, m := range pathParamRe.FindAllStringSubmatch(path, -1) {
+		if op.Param(m[1], "path") == nil {
+			op.Parameters = append([]*Parameter{{Name: m[1], In: "path", Required: true, Schema: &Schema{Type: "string"}}}, op.Parameters...)
+		}
+	}
+	if op.Responses == nil {
+		op.Responses = make(map[string]*Response)
+	}
+	if len(op.Responses) == 0 {
+		op.Responses["200"] = &Response{Description: "OK"}
+	}
+	p := Path(path)
+	pi := d.Paths[p]
+	if pi == nil {
+		pi = new(PathItem)
+		d.Paths[p] = pi
+	}
+	switch strings.ToUpper(method) {
+	case "GET":
+		pi.Get = op
+	case "PUT":
+		pi.Put = op
+	case "POST":
+		pi.Post = op
+	case "DELETE":
+		pi.Delete = op
+	case "OPTIONS":
+		pi.Options = op
+	case "HEAD":
+		pi.Head = op
+	case "PATCH":
+		pi.Patch = op
+	case "TRACE":
+		pi.Trace = op
+	}
+}
+
+/* }}} */
+
+// 已经存在的operation
+func (d *Document) Operation(method, path string) *Operation {
+	pi := d.Paths[Path(path)]
+	if pi == nil {
+		return nil
+	}
+	switch strings.ToUpper(method) {
+	case "GET":
+		return pi.Get
+	case "PUT":
+		return pi.Put
+	case "POST":
+		return pi.Post
+	case "DELETE":
+		return pi.Delete
+	case "OPTIONS":
+		return pi.Options
+	case "HEAD":
+		return pi.Head
+	case "PATCH":
+		return pi.Patch
+	case "TRACE":
+		return pi.Trace
+	}
+	return nil
+}
+
+// add tag, 重复的忽略
+func (d *Document) AddTag(name, desc string) {
+	for _, t := range d.Tags {
+		if t.Name == name {
+			return
+		}
+	}
+	d.Tags = append(d.Tags, &Tag{Name: name, Description: desc})
+}
+
+// 注册schema到components, 返回引用
+func (d *Document) Define(name string, s *Schema) *Schema {
+	d.Components.Schemas[name] = s
+	return Ref(name)
+}
+
+// wgo路由转为openapi路径
+func Path(path string) string {
+	return pathParamRe.ReplaceAllString(path, "{$1}")
+}
+
+// 引用components中的schema
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// 按名称查找参数
+func (op *Operation) Param(name, in string) *Parameter {
+	for _, p := range op.Parameters {
+		if p.Name == name && p.In == in {
+			return p
+		}
+	}
+	return nil
+}
+
+// json body
+func JSONContent(s *Schema) map[string]*MediaType {
+	return map[string]*MediaType{"application/json": {Schema: s}}
+}
@@ -0,0 +1,413 @@
+//
+// quota.go
+// 按app(X-App-Id)的配额: 每天/每月的请求数(可按路由加权), 计数放在storage
+//
+
+package wgo
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"wgo/environ"
+	"wgo/metrics"
+	"wgo/storage"
+	"wgo/storage/core"
+	"wgo/whttp"
+)
+
+const (
+	// 路由option的key, 值为int, 该路由每次请求消耗的配额, 0为不消耗
+	QuotaWeightKey = "quota_weight"
+
+	QuotaDaily   = "daily"
+	QuotaMonthly = "monthly"
+
+	quotaPrefix = "quota:"
+)
+
+var quotaRejected = metrics.GetCounter("wgo_quota_rejected_total",
+	"Total number of requests rejected by exhausted quota.", "plan", "period")
+
+type (
+	// QuotaPlan 配额方案
+	QuotaPlan struct {
+		Name    string         `mapstructure:"name" json:"name"`
+		Daily   int64          `mapstructure:"daily" json:"daily,omitempty"`     // 每天(按env的时区)的请求数, 0为不限
+		Monthly int64          `mapstructure:"monthly" json:"monthly,omitempty"` // 每月
+		Weights []*QuotaWeight `mapstructure:"weights" json:"weights,omitempty"` // 路由的权重, 默认为1
+	}
+	QuotaWeight struct {
+		Route  string `mapstructure:"route" json:"route"` // "POST /orders"或"/orders"(所有method)
+		Weight int64  `mapstructure:"weight" json:"weight"`
+	}
+	QuotaApp struct {
+		ID   string `mapstructure:"id"`
+		Plan string `mapstructure:"plan"`
+	}
+
+	// QuotaConfig 配置中的quota部分
+	QuotaConfig struct {
+		Disable     bool         `mapstructure:"disable"`
+		DefaultPlan string       `mapstructure:"default_plan"` // 没有指定plan的app, 为空则不限
+		RequireApp  bool         `mapstructure:"require_app"`  // 没有app id时返回401
+		Path        string       `mapstructure:"path"`         // 查询自己用量的路由, 如/quota, 为空不注册
+		Plans       []*QuotaPlan `mapstructure:"plans"`
+		Apps        []*QuotaApp  `mapstructure:"apps"`
+	}
+
+	// QuotaUsage app当前的用量
+	QuotaUsage struct {
+		App     string        `json:"app"`
+		Plan    string        `json:"plan,omitempty"`
+		Daily   *QuotaCounter `json:"daily,omitempty"`
+		Monthly *QuotaCounter `json:"monthly,omitempty"`
+	}
+	QuotaCounter struct {
+		Used      int64     `json:"used"`
+		Limit     int64     `json:"limit"`
+		Remaining int64     `json:"remaining"`
+		Reset     time.Time `json:"reset"` // 下一个周期开始的时间
+	}
+
+	// AccessQuota access log中的quota字段
+	AccessQuota struct {
+		App      string `json:"app"`
+		Plan     string `json:"plan"`
+		Weight   int64  `json:"weight"`
+		Exceeded string `json:"exceeded,omitempty"` // 用完的周期(daily/monthly)
+	}
+
+	quotas struct {
+		cfg   *QuotaConfig
+		mu    sync.RWMutex
+		plans map[string]*QuotaPlan
+		apps  map[string]string // app id => plan
+	}
+)
+
+/* {{{ func Quota() MiddlewareFunc
+ * 请求前先计数, 超出则减回并返回429(data为用量), 默认已加载
+ */
+func Quota() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			switch c.ServerMode() {
+			case "rpc", "wrpc", "grpc":
+				return next(c)
+			}
+			qs := c.App().quotas()
+			if qs == nil || c.Path() == "" || c.Path() == qs.cfg.Path {
+				return next(c)
+			}
+			if w, ok := routeWeight(c); ok && w <= 0 { // 路由option的权重为0则不计数也不要求app id
+				return next(c)
+			}
+			app := c.AppID()
+			if app == "" {
+				if qs.cfg.RequireApp {
+					return c.NewError(whttp.StatusUnauthorized*1000, "app id is required")
+				}
+				return next(c)
+			}
+			plan := qs.plan(app)
+			if plan == nil {
+				return next(c)
+			}
+			weight := plan.weight(c)
+			if weight <= 0 {
+				return next(c)
+			}
+			st := c.App().Storage()
+			if st == nil {
+				c.Warn("[Quota]no storage, skip")
+				return next(c)
+			}
+			aq := &AccessQuota{App: app, Plan: plan.Name, Weight: weight}
+			c.Access().Quota = aq
+			usage, period, err := c.App().consumeQuota(st, app, plan, weight)
+			if err != nil { // storage出错时放行
+				c.Warn("[Quota]app: %s, error: %s", app, err)
+				return next(c)
+			}
+			if period != "" {
+				aq.Exceeded = period
+				quotaRejected.Inc(plan.Name, period)
+				c.Info("[Quota]app: %s, plan: %s, %s quota exceeded", app, plan.Name, period)
+				if pc := usage.counter(period); pc != nil {
+					c.SetHeader(whttp.HeaderRetryAfter, fmt.Sprint(int64(time.Until(pc.Reset).Seconds())+1))
+				}
+				return c.NewError(whttp.StatusTooManyRequests*1000+1, period+" quota exceeded").WithData(usage)
+			}
+			return next(c)
+		}
+	}
+}
+
+/* }}} */
+
+// 读取配置
+func (w *WGO) quotas() *quotas {
+	w.quotaOnce.Do(func() {
+		cfg := &QuotaConfig{}
+		if w.Cfg().IsSet(environ.CFG_KEY_QUOTA) {
+			if err := w.Cfg().UnmarshalKey(environ.CFG_KEY_QUOTA, cfg); err != nil {
+				w.Logger().Error("[quota]invalid config: %s", err)
+			}
+		}
+		if cfg.Disable {
+			return
+		}
+		qs := &quotas{cfg: cfg, plans: make(map[string]*QuotaPlan), apps: make(map[string]string)}
+		for _, p := range cfg.Plans {
+			qs.plans[p.Name] = p
+		}
+		for _, a := range cfg.Apps {
+			qs.apps[a.ID] = a.Plan
+		}
+		w.quota = qs
+	})
+	return w.quota
+}
+
+/* {{{ func (w *WGO) AddQuotaPlan(p *QuotaPlan) *WGO
+ * 增加或替换plan
+ */
+func AddQuotaPlan(p *QuotaPlan) *WGO { return Self().AddQuotaPlan(p) }
+func (w *WGO) AddQuotaPlan(p *QuotaPlan) *WGO {
+	if qs := w.quotas(); qs != nil {
+		qs.mu.Lock()
+		qs.plans[p.Name] = p
+		qs.mu.Unlock()
+	}
+	return w
+}
+
+/* }}} */
+
+/* {{{ func (w *WGO) SetAppPlan(app, plan string) *WGO
+ * plan为空则使用默认的plan
+ */
+func SetAppPlan(app, plan string) *WGO { return Self().SetAppPlan(app, plan) }
+func (w *WGO) SetAppPlan(app, plan string) *WGO {
+	if qs := w.quotas(); qs != nil {
+		qs.mu.Lock()
+		if plan == "" {
+			delete(qs.apps, app)
+		} else {
+			qs.apps[app] = plan
+		}
+		qs.mu.Unlock()
+	}
+	return w
+}
+
+/* }}} */
+
+// app的plan, 没有则为默认plan
+func (qs *quotas) plan(app string) *QuotaPlan {
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	name, ok := qs.apps[app]
+	if !ok {
+		name = qs.cfg.DefaultPlan
+	}
+	return qs.plans[name]
+}
+
+// 路由option中的权重
+func routeWeight(c *Context) (int64, bool) {
+	switch w := c.Options(QuotaWeightKey).(type) {
+	case int:
+		return int64(w), true
+	case int64:
+		return w, true
+	}
+	return 0, false
+}
+
+// 本次请求的权重: 路由option > plan的weights > 1
+func (p *QuotaPlan) weight(c *Context) int64 {
+	if w, ok := routeWeight(c); ok {
+		return w
+	}
+	route := c.Method() + " " + c.Path()
+	for _, qw := range p.Weights {
+		if qw.Route == route || qw.Route == c.Path() {
+			return qw.Weight
+		}
+	}
+	return 1
+}
+
+/* {{{ func (w *WGO) consumeQuota(st *storage.Storage, app string, plan *QuotaPlan, weight int64) (*QuotaUsage, string, error)
+ * 依次增加每天/每月的计数, 任何一个超出则把已经加上的减回去, 返回超出的周期
+ */
+func (w *WGO) consumeQuota(st *storage.Storage, app string, plan *QuotaPlan, weight int64) (*QuotaUsage, string, error) {
+	usage := &QuotaUsage{App: app, Plan: plan.Name}
+	var done []string
+	rollback := func() {
+		for _, k := range done {
+			st.Do(k, "INCRBY", k, -weight)
+		}
+	}
+	for _, p := range w.quotaPeriods(app, plan) {
+		if p.limit <= 0 {
+			continue
+		}
+		reply, err := st.Do(p.key, "INCRBY", p.key, weight)
+		if err != nil {
+			rollback()
+			return nil, "", err
+		}
+		used := core.GetInt64(reply)
+		if used == weight { // 新的周期
+			st.Do(p.key, "EXPIRE", p.key, int(time.Until(p.reset).Seconds())+86400)
+		}
+		if used > p.limit {
+			st.Do(p.key, "INCRBY", p.key, -weight)
+			rollback()
+			// 用量为减回之后的
+			for _, q := range w.quotaPeriods(app, plan) {
+				if q.limit > 0 {
+					u := used - weight
+					if q.name != p.name {
+						u, _ = quotaUsed(st, q.key)
+					}
+					usage.set(q.name, newQuotaCounter(u, q.limit, q.reset))
+				}
+			}
+			return usage, p.name, nil
+		}
+		done = append(done, p.key)
+		usage.set(p.name, newQuotaCounter(used, p.limit, p.reset))
+	}
+	return usage, "", nil
+}
+
+/* }}} */
+
+/* {{{ func (w *WGO) QuotaUsage(app string) (*QuotaUsage, error)
+ * app当前周期的用量, 没有plan时只有app
+ */
+func GetQuotaUsage(app string) (*QuotaUsage, error) { return Self().QuotaUsage(app) }
+func (w *WGO) QuotaUsage(app string) (*QuotaUsage, error) {
+	usage := &QuotaUsage{App: app}
+	qs := w.quotas()
+	if qs == nil {
+		return usage, nil
+	}
+	plan := qs.plan(app)
+	if plan == nil {
+		return usage, nil
+	}
+	usage.Plan = plan.Name
+	st := w.Storage()
+	if st == nil {
+		return nil, fmt.Errorf("no storage")
+	}
+	for _, p := range w.quotaPeriods(app, plan) {
+		if p.limit <= 0 {
+			continue
+		}
+		used, err := quotaUsed(st, p.key)
+		if err != nil {
+			return nil, err
+		}
+		usage.set(p.name, newQuotaCounter(used, p.limit, p.reset))
+	}
+	return usage, nil
+}
+
+/* }}} */
+
+type quotaPeriod struct {
+	name  string
+	key   string
+	limit int64
+	reset time.Time
+}
+
+// 当前的每天/每月周期
+func (w *WGO) quotaPeriods(app string, plan *QuotaPlan) []quotaPeriod {
+	loc := w.Env().Location
+	if loc == nil {
+		loc = time.Local
+	}
+	now := time.Now().In(loc)
+	y, m, d := now.Date()
+	prefix := quotaPrefix + w.Env().ProcName + ":" + app + ":"
+	return []quotaPeriod{
+		{QuotaDaily, prefix + now.Format("20060102"), plan.Daily, time.Date(y, m, d+1, 0, 0, 0, 0, loc)},
+		{QuotaMonthly, prefix + now.Format("200601"), plan.Monthly, time.Date(y, m+1, 1, 0, 0, 0, 0, loc)},
+	}
+}
+
+func quotaUsed(st *storage.Storage, key string) (int64, error) {
+	reply, err := st.Do(key, "GET", key)
+	if err != nil || reply == nil {
+		return 0, err
+	}
+	return core.GetInt64(reply), nil
+}
+
+func newQuotaCounter(used, limit int64, reset time.Time) *QuotaCounter {
+	if used < 0 {
+		used = 0
+	}
+	qc := &QuotaCounter{Used: used, Limit: limit, Remaining: limit - used, Reset: reset}
+	if qc.Remaining < 0 {
+		qc.Remaining = 0
+	}
+	return qc
+}
+
+func (u *QuotaUsage) set(period string, qc *QuotaCounter) {
+	switch period {
+	case QuotaDaily:
+		u.Daily = qc
+	case QuotaMonthly:
+		u.Monthly = qc
+	}
+}
+
+func (u *QuotaUsage) counter(period string) *QuotaCounter {
+	switch period {
+	case QuotaDaily:
+		return u.Daily
+	case QuotaMonthly:
+		return u.Monthly
+	}
+	return nil
+}
+
+/* {{{ func (w *WGO) serveQuota()
+ * quota.path配置时注册查询自己(X-App-Id)用量的路由, admin server上的/quota?app=xxx可以查询任意app
+ */
+func (w *WGO) serveQuota() {
+	qs := w.quotas()
+	if qs == nil || qs.cfg.Path == "" {
+		return
+	}
+	if ss := w.HTTPServers(); len(ss) > 0 {
+		ss.GET(qs.cfg.Path, func(c *Context) error {
+			app := c.AppID()
+			if app == "" {
+				return c.NewError(whttp.StatusUnauthorized*1000, "app id is required")
+			}
+			return quotaResponse(c, app)
+		})
+	}
+}
+
+/* }}} */
+
+func quotaResponse(c *Context, app string) error {
+	usage, err := c.App().QuotaUsage(strings.TrimSpace(app))
+	if err != nil {
+		return c.NewError(whttp.StatusServiceUnavailable*1000, err.Error())
+	}
+	return c.JSON(whttp.StatusOK, usage)
+}
@@ -0,0 +1,207 @@
+//
+// ratelimit.go
+// 限流middleware, 规则来自路由option(RateLimitKey)或配置(ratelimit)
+//
+
+package wgo
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wgo/environ"
+	"wgo/metrics"
+	"wgo/ratelimit"
+	"wgo/whttp"
+)
+
+// 路由option的key, 值为*RateLimitRule, false代表该路由不限流(包括默认规则)
+const RateLimitKey = "ratelimit"
+
+var rateLimited = metrics.GetCounter("wgo_ratelimit_rejected_total",
+	"Total number of requests rejected by rate limit.", "method", "route")
+
+type (
+	// RateLimitRule 限流规则, 每个路由(默认规则为所有路由)每个key一个桶/窗口
+	RateLimitRule struct {
+		Route       string                `mapstructure:"route"`       // 配置中使用, "GET /users/:id"或"/users/:id"(所有method)
+		Limit       int                   `mapstructure:"limit"`       // per时间内允许的请求数, <=0不限流
+		Per         time.Duration         `mapstructure:"per"`         // 默认1s
+		Burst       int                   `mapstructure:"burst"`       // 令牌桶的容量, 默认为limit
+		Algorithm   string                `mapstructure:"algorithm"`   // token_bucket(默认), sliding_window
+		Key         string                `mapstructure:"key"`         // ip(默认), user, app(X-App-Id), global, header:<name>
+		Distributed bool                  `mapstructure:"distributed"` // 计数放在storage(redis), 多实例共享
+		KeyFunc     func(*Context) string `mapstructure:"-"`           // 自定义key, 返回空时按Key
+	}
+
+	// RateLimitConfig 配置中的ratelimit部分
+	RateLimitConfig struct {
+		Disable bool             `mapstructure:"disable"`
+		Default *RateLimitRule   `mapstructure:"default"` // 没有单独规则的路由共用
+		Routes  []*RateLimitRule `mapstructure:"routes"`
+	}
+
+	rateLimits struct {
+		cfg      *RateLimitConfig
+		mu       sync.Mutex
+		limiters map[string]ratelimit.Limiter
+	}
+)
+
+/* {{{ func RateLimit() MiddlewareFunc
+ * 被限流时返回429, 带Retry-After; 限流的路由都带RateLimit-Limit/Remaining/Reset头, 默认已加载
+ */
+func RateLimit() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			switch c.ServerMode() {
+			case "rpc", "wrpc", "grpc":
+				return next(c)
+			}
+			rls := c.App().rateLimits()
+			if rls == nil {
+				return next(c)
+			}
+			id, rule := rls.rule(c)
+			if rule == nil {
+				return next(c)
+			}
+			key := rule.key(c)
+			res, err := rls.limiter(c.App(), id, rule).Allow(id + ":" + key)
+			if err != nil { // storage出错时放行
+				c.Warn("[RateLimit]%s, key: %s, error: %s", id, key, err)
+				return next(c)
+			}
+			c.SetHeader(whttp.HeaderRateLimitLimit, strconv.Itoa(res.Limit))
+			c.SetHeader(whttp.HeaderRateLimitRemaining, strconv.Itoa(res.Remaining))
+			c.SetHeader(whttp.HeaderRateLimitReset, strconv.Itoa(ratelimit.Seconds(res.Reset)))
+			if !res.Allowed {
+				c.SetHeader(whttp.HeaderRetryAfter, strconv.Itoa(ratelimit.Seconds(res.RetryAfter)))
+				rateLimited.Inc(c.Method(), c.Path())
+				c.Info("[RateLimit]%s, key: %s, rejected", id, key)
+				return c.NewError(whttp.StatusTooManyRequests*1000, "too many requests")
+			}
+			return next(c)
+		}
+	}
+}
+
+/* }}} */
+
+// 读取配置, 没有配置时也需要(路由option)
+func (w *WGO) rateLimits() *rateLimits {
+	w.rateOnce.Do(func() {
+		cfg := &RateLimitConfig{}
+		if w.Cfg().IsSet(environ.CFG_KEY_RATELIMIT) {
+			if err := w.Cfg().UnmarshalKey(environ.CFG_KEY_RATELIMIT, cfg); err != nil {
+				w.Logger().Error("[ratelimit]invalid config: %s", err)
+			}
+		}
+		if !cfg.Disable {
+			w.rates = &rateLimits{cfg: cfg, limiters: make(map[string]ratelimit.Limiter)}
+		}
+	})
+	return w.rates
+}
+
+/* {{{ func (rls *rateLimits) rule(c *Context) (string, *RateLimitRule)
+ * 路由option > 配置中的路由 > 默认规则, 返回规则的id(计数的前缀)
+ */
+func (rls *rateLimits) rule(c *Context) (string, *RateLimitRule) {
+	route := c.Method() + " " + c.Path()
+	id := c.App().Env().ProcName + ":" + c.Mux().Name() + ":" + route
+	switch opt := c.Options(RateLimitKey).(type) {
+	case *RateLimitRule:
+		return id, opt.valid()
+	case RateLimitRule:
+		return id, opt.valid()
+	case bool:
+		if !opt {
+			return "", nil
+		}
+	}
+	if c.Path() != "" {
+		for _, r := range rls.cfg.Routes {
+			if r.Route == route || r.Route == c.Path() {
+				return id, r.valid()
+			}
+		}
+	}
+	return c.App().Env().ProcName + ":*", rls.cfg.Default.valid()
+}
+
+/* }}} */
+
+/* {{{ func (rls *rateLimits) limiter(w *WGO, id string, rule *RateLimitRule) ratelimit.Limiter
+ * 每个规则id一个limiter, 第一次使用时创建
+ */
+func (rls *rateLimits) limiter(w *WGO, id string, rule *RateLimitRule) ratelimit.Limiter {
+	rls.mu.Lock()
+	defer rls.mu.Unlock()
+	if l, ok := rls.limiters[id]; ok {
+		return l
+	}
+	st := w.Storage()
+	if rule.Distributed && st == nil {
+		w.Logger().Warn("[ratelimit]%s: no storage, fall back to local", id)
+	}
+	var l ratelimit.Limiter
+	switch rule.Algorithm {
+	case ratelimit.AlgoSlidingWindow:
+		if rule.Distributed && st != nil {
+			l = ratelimit.NewRedisSlidingWindow(st, rule.Limit, rule.Per)
+		} else {
+			l = ratelimit.NewSlidingWindow(rule.Limit, rule.Per)
+		}
+	default:
+		if rule.Algorithm != "" && rule.Algorithm != ratelimit.AlgoTokenBucket {
+			w.Logger().Error("[ratelimit]%s: unknown algorithm %s, use %s", id, rule.Algorithm, ratelimit.AlgoTokenBucket)
+		}
+		if rule.Distributed && st != nil {
+			l = ratelimit.NewRedisTokenBucket(st, rule.Limit, rule.Per, rule.Burst)
+		} else {
+			l = ratelimit.NewTokenBucket(rule.Limit, rule.Per, rule.Burst)
+		}
+	}
+	rls.limiters[id] = l
+	return l
+}
+
+/* }}} */
+
+// 不限流的规则返回nil
+func (rule *RateLimitRule) valid() *RateLimitRule {
+	if rule == nil || rule.Limit <= 0 {
+		return nil
+	}
+	return rule
+}
+
+// 计数的key, 取不到时按ip
+func (rule *RateLimitRule) key(c *Context) string {
+	if rule.KeyFunc != nil {
+		if k := rule.KeyFunc(c); k != "" {
+			return "f:" + k
+		}
+	}
+	switch {
+	case rule.Key == "global":
+		return "global"
+	case rule.Key == "user":
+		// 只信任认证过的用户(如JWT), 未认证时X-WGO-UserId头可以随意伪造, 按ip计数
+		if uid := c.UserID(); uid != "" && c.Authorized() {
+			return "user:" + uid
+		}
+	case rule.Key == "app":
+		if app := c.AppID(); app != "" {
+			return "app:" + app
+		}
+	case strings.HasPrefix(rule.Key, "header:"):
+		if v := c.RequestHeader().Get(strings.TrimPrefix(rule.Key, "header:")); v != "" {
+			return "h:" + v
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
@@ -0,0 +1,17 @@
+package ratelimit
+
+import "time"
+
+// 测试中替换时钟
+func SetNow(l Limiter, now func() time.Time) {
+	switch t := l.(type) {
+	case *TokenBucket:
+		t.now = now
+	case *SlidingWindow:
+		t.now = now
+	case *RedisTokenBucket:
+		t.now = now
+	case *RedisSlidingWindow:
+		t.now = now
+	}
+}
@@ -0,0 +1,200 @@
+//
+// local.go
+// 进程内的限流, 只对当前实例有效
+//
+
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type (
+	// TokenBucket 令牌桶, 以limit/per的速率补充, 最多burst个
+	TokenBucket struct {
+		rate    float64 // 每纳秒补充的令牌
+		burst   float64
+		mu      sync.Mutex
+		buckets map[string]*bucket
+		swept   time.Time
+		now     func() time.Time
+	}
+	bucket struct {
+		tokens float64
+		ts     time.Time
+	}
+
+	// SlidingWindow 滑动窗口计数, 用上一个窗口的计数按时间加权估算, 每个key只需要两个计数
+	SlidingWindow struct {
+		limit   int
+		window  time.Duration
+		mu      sync.Mutex
+		windows map[string]*window
+		swept   time.Time
+		now     func() time.Time
+	}
+	window struct {
+		start     time.Time
+		prev, cur int
+	}
+)
+
+/* {{{ func NewTokenBucket(limit int, per time.Duration, burst int) *TokenBucket
+ * burst <= 0 时为limit
+ */
+func NewTokenBucket(limit int, per time.Duration, burst int) *TokenBucket {
+	if burst <= 0 {
+		burst = limit
+	}
+	return &TokenBucket{
+		rate:    perNano(limit, per),
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+/* }}} */
+
+/* {{{ func (tb *TokenBucket) Allow(key string) (*Result, error)
+ *
+ */
+func (tb *TokenBucket) Allow(key string) (*Result, error) {
+	now := tb.now()
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.sweep(now)
+
+	b, ok := tb.buckets[key]
+	if !ok {
+		b = &bucket{tokens: tb.burst, ts: now}
+		tb.buckets[key] = b
+	} else if now.After(b.ts) {
+		b.tokens = math.Min(tb.burst, b.tokens+float64(now.Sub(b.ts))*tb.rate)
+		b.ts = now
+	}
+	return tokenResult(b.tokens, tb.burst, tb.rate, func(left float64) { b.tokens = left }), nil
+}
+
+/* }}} */
+
+// 取一个令牌, 结果(本地与redis共用)
+func tokenResult(tokens, burst, rate float64, take func(float64)) *Result {
+	r := &Result{Limit: int(burst)}
+	if tokens >= 1 {
+		tokens--
+		r.Allowed = true
+		if take != nil {
+			take(tokens)
+		}
+	} else {
+		r.RetryAfter = ceilDuration((1 - tokens) / rate)
+	}
+	r.Remaining = int(tokens)
+	r.Reset = ceilDuration((burst - tokens) / rate)
+	return r
+}
+
+// 清理已经补满的桶, 防止key无限增长
+func (tb *TokenBucket) sweep(now time.Time) {
+	full := time.Duration(tb.burst / tb.rate)
+	if full < time.Minute {
+		full = time.Minute
+	}
+	if now.Sub(tb.swept) < full {
+		return
+	}
+	tb.swept = now
+	for k, b := range tb.buckets {
+		if b.tokens+float64(now.Sub(b.ts))*tb.rate >= tb.burst {
+			delete(tb.buckets, k)
+		}
+	}
+}
+
+/* {{{ func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow
+ *
+ */
+func NewSlidingWindow(limit int, w time.Duration) *SlidingWindow {
+	if w <= 0 {
+		w = time.Second
+	}
+	return &SlidingWindow{
+		limit:   limit,
+		window:  w,
+		windows: make(map[string]*window),
+		now:     time.Now,
+	}
+}
+
+/* }}} */
+
+/* {{{ func (sw *SlidingWindow) Allow(key string) (*Result, error)
+ *
+ */
+func (sw *SlidingWindow) Allow(key string) (*Result, error) {
+	now := sw.now()
+	start := now.Truncate(sw.window)
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.sweep(now)
+
+	w, ok := sw.windows[key]
+	if !ok {
+		w = &window{start: start}
+		sw.windows[key] = w
+	} else if !w.start.Equal(start) {
+		if start.Sub(w.start) == sw.window { // 相邻的窗口
+			w.prev = w.cur
+		} else {
+			w.prev = 0
+		}
+		w.cur, w.start = 0, start
+	}
+	r := windowResult(w.prev, w.cur, sw.limit, sw.window, now.Sub(start))
+	if r.Allowed {
+		w.cur++
+	}
+	return r, nil
+}
+
+/* }}} */
+
+// 判断窗口内是否还能再来一个请求(本地与redis共用), cur不包括本次
+func windowResult(prev, cur, limit int, size, elapsed time.Duration) *Result {
+	r := &Result{Limit: limit, Reset: size - elapsed}
+	weight := 1 - float64(elapsed)/float64(size)
+	est := float64(prev)*weight + float64(cur)
+	if est+1 <= float64(limit) {
+		r.Allowed = true
+		r.Remaining = int(float64(limit) - est - 1)
+		return r
+	}
+	// 需要上一个窗口的权重降下来多少, 当前窗口内等不到就等下一个窗口
+	r.RetryAfter = r.Reset
+	if need := est + 1 - float64(limit); prev > 0 && float64(cur)+1 <= float64(limit) {
+		if wait := ceilDuration(need / float64(prev) * float64(size)); wait < r.RetryAfter {
+			r.RetryAfter = wait
+		}
+	}
+	return r
+}
+
+// 清理两个窗口之前的计数
+func (sw *SlidingWindow) sweep(now time.Time) {
+	every := 2 * sw.window
+	if every < time.Minute {
+		every = time.Minute
+	}
+	if now.Sub(sw.swept) < every {
+		return
+	}
+	sw.swept = now
+	for k, w := range sw.windows {
+		if now.Sub(w.start) >= 2*sw.window {
+			delete(sw.windows, k)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+//
+// ratelimit.go
+// 限流: 本地的令牌桶/滑动窗口, 以及基于storage(redis)的分布式版本
+//
+
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	AlgoTokenBucket   = "token_bucket"
+	AlgoSlidingWindow = "sliding_window"
+)
+
+type (
+	// Limiter 按key限流, 出错时(如redis不可用)由调用方决定放行还是拒绝
+	Limiter interface {
+		Allow(key string) (*Result, error)
+	}
+
+	// Result 一次判断的结果, 用于生成RateLimit-*及Retry-After头
+	Result struct {
+		Allowed    bool
+		Limit      int           // 容量(令牌桶为burst, 滑动窗口为窗口内的请求数)
+		Remaining  int           // 剩余可用
+		Reset      time.Duration // 多久之后完全恢复
+		RetryAfter time.Duration // 被拒绝时, 多久之后可以重试
+	}
+)
+
+// 每个时间单位的速率
+func perNano(limit int, per time.Duration) float64 {
+	if per <= 0 {
+		per = time.Second
+	}
+	return float64(limit) / float64(per)
+}
+
+// float的纳秒数转为Duration, 向上取整
+func ceilDuration(ns float64) time.Duration {
+	if ns <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(ns))
+}
+
+// 向上取整到秒, 用于header, 至少1秒
+func Seconds(d time.Duration) int {
+	s := int(math.Ceil(d.Seconds()))
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
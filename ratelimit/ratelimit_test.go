@@ -0,0 +1,136 @@
+package ratelimit_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"wgo/internal/luatest"
+	"wgo/ratelimit"
+	"wgo/storage"
+	"wgo/wgotest"
+)
+
+var t0 = time.Unix(1700000000, 0)
+
+// 记录往返次数
+type countingCache struct {
+	*wgotest.MemoryCache
+	calls int32
+}
+
+func (cc *countingCache) Do(cmd string, args ...interface{}) (interface{}, error) {
+	atomic.AddInt32(&cc.calls, 1)
+	return cc.MemoryCache.Do(cmd, args...)
+}
+
+type step struct {
+	at        time.Duration // 相对t0
+	allowed   bool
+	remaining int
+	retry     time.Duration
+	reset     time.Duration
+}
+
+// 本地和redis版本跑同样的步骤, 时间通过now注入
+func run(t *testing.T, name string, l ratelimit.Limiter, steps []step) {
+	var now time.Time
+	ratelimit.SetNow(l, func() time.Time { return now })
+	// redis版本的令牌数经过lua的tostring, 允许1ms的误差
+	near := func(got, want time.Duration) bool {
+		d := got - want
+		return d > -time.Millisecond && d < time.Millisecond
+	}
+	for i, s := range steps {
+		now = t0.Add(s.at)
+		r, err := l.Allow("k")
+		if err != nil {
+			t.Fatalf("%s step %d: %v", name, i, err)
+		}
+		if r.Allowed != s.allowed || r.Remaining != s.remaining || !near(r.RetryAfter, s.retry) || !near(r.Reset, s.reset) {
+			t.Errorf("%s step %d at %s: %+v, want %+v", name, i, s.at, *r, s)
+		}
+	}
+}
+
+func limiters(local func() ratelimit.Limiter, redis func(*storage.Storage) ratelimit.Limiter) map[string]ratelimit.Limiter {
+	return map[string]ratelimit.Limiter{
+		"local": local(),
+		"redis": redis(luatest.NewStorage()),
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	ms := time.Millisecond
+	// 每100ms补充一个, 容量5
+	steps := []step{
+		{0, true, 4, 0, 100 * ms},
+		{0, true, 3, 0, 200 * ms},
+		{0, true, 2, 0, 300 * ms},
+		{0, true, 1, 0, 400 * ms},
+		{0, true, 0, 0, 500 * ms},
+		{0, false, 0, 100 * ms, 500 * ms}, // burst用完
+		{50 * ms, false, 0, 50 * ms, 450 * ms},
+		{150 * ms, true, 0, 0, 450 * ms}, // 补充了1.5个
+		{150 * ms, false, 0, 50 * ms, 450 * ms},
+		{10 * time.Second, true, 4, 0, 100 * ms}, // 最多补满到burst
+	}
+	for name, l := range limiters(
+		func() ratelimit.Limiter { return ratelimit.NewTokenBucket(10, time.Second, 5) },
+		func(st *storage.Storage) ratelimit.Limiter {
+			return ratelimit.NewRedisTokenBucket(st, 10, time.Second, 5)
+		},
+	) {
+		run(t, name, l, steps)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	ms := time.Millisecond
+	// 每秒4个
+	steps := []step{
+		{0, true, 3, 0, time.Second},
+		{0, true, 2, 0, time.Second},
+		{0, true, 1, 0, time.Second},
+		{0, true, 0, 0, time.Second},
+		{0, false, 0, time.Second, time.Second}, // 上一个窗口为空, 等到下一个窗口
+		{1250 * ms, true, 0, 0, 750 * ms},       // 上一个窗口按0.75加权, 估算为3
+		{1250 * ms, false, 0, 250 * ms, 750 * ms},
+		{1500 * ms, true, 0, 0, 500 * ms},
+		{1500 * ms, false, 0, 250 * ms, 500 * ms},
+		{3100 * ms, true, 3, 0, 900 * ms}, // 不相邻的窗口不计入
+	}
+	for name, l := range limiters(
+		func() ratelimit.Limiter { return ratelimit.NewSlidingWindow(4, time.Second) },
+		func(st *storage.Storage) ratelimit.Limiter {
+			return ratelimit.NewRedisSlidingWindow(st, 4, time.Second)
+		},
+	) {
+		run(t, name, l, steps)
+	}
+}
+
+func TestRedisRoundTrips(t *testing.T) {
+	cc := &countingCache{MemoryCache: luatest.NewMemoryCache()}
+	st := storage.NewWithCaches("memory", cc)
+	for name, l := range map[string]ratelimit.Limiter{
+		"token_bucket":   ratelimit.NewRedisTokenBucket(st, 1, time.Second, 1),
+		"sliding_window": ratelimit.NewRedisSlidingWindow(st, 1, time.Second),
+	} {
+		ratelimit.SetNow(l, func() time.Time { return t0 })
+		for _, allowed := range []bool{true, false} {
+			atomic.StoreInt32(&cc.calls, 0)
+			r, err := l.Allow(name)
+			if err != nil || r.Allowed != allowed {
+				t.Fatalf("%s: %+v %v", name, r, err)
+			}
+			if n := atomic.LoadInt32(&cc.calls); n != 1 {
+				t.Errorf("%s: %d round trips", name, n)
+			}
+		}
+		// 不同的key分别计数
+		if r, _ := l.Allow(name + ":other"); !r.Allowed {
+			t.Errorf("%s: other key limited", name)
+		}
+	}
+}
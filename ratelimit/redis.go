@@ -0,0 +1,158 @@
+//
+// redis.go
+// 基于storage(redis)的限流, 多个实例共享计数
+// 令牌桶和滑动窗口都用lua脚本, 一次往返并保证原子性
+//
+
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"wgo/storage"
+	"wgo/storage/core"
+)
+
+const KeyPrefix = "ratelimit:"
+
+// KEYS[1]: bucket, ARGV: 每毫秒的速率, burst, 当前毫秒, 过期毫秒
+// 返回 {是否允许, 剩余令牌}, 令牌是小数, 用字符串返回避免被截断
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1])
+local ts = tonumber(b[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate)
+	ts = now
+end
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {allowed, tostring(tokens)}
+`
+
+// KEYS: 当前窗口, 上一个窗口; ARGV: limit, 上一个窗口的权重, 过期毫秒
+// 估算值未超过limit时当前窗口计数加1, 返回 {是否允许, 上一个窗口计数, 当前窗口计数(不含本次)}
+const slidingWindowScript = `
+local limit = tonumber(ARGV[1])
+local weight = tonumber(ARGV[2])
+local cur = tonumber(redis.call('GET', KEYS[1]) or '0')
+local prev = tonumber(redis.call('GET', KEYS[2]) or '0')
+if prev * weight + cur + 1 > limit then
+	return {0, prev, cur}
+end
+redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return {1, prev, cur}
+`
+
+type (
+	// RedisTokenBucket 分布式令牌桶
+	RedisTokenBucket struct {
+		st    *storage.Storage
+		rate  float64 // 每纳秒
+		burst float64
+		now   func() time.Time
+	}
+
+	// RedisSlidingWindow 分布式滑动窗口, 每个窗口一个计数key
+	RedisSlidingWindow struct {
+		st     *storage.Storage
+		limit  int
+		window time.Duration
+		now    func() time.Time
+	}
+)
+
+/* {{{ func NewRedisTokenBucket(st *storage.Storage, limit int, per time.Duration, burst int) *RedisTokenBucket
+ *
+ */
+func NewRedisTokenBucket(st *storage.Storage, limit int, per time.Duration, burst int) *RedisTokenBucket {
+	if burst <= 0 {
+		burst = limit
+	}
+	return &RedisTokenBucket{st: st, rate: perNano(limit, per), burst: float64(burst), now: time.Now}
+}
+
+/* }}} */
+
+/* {{{ func (tb *RedisTokenBucket) Allow(key string) (*Result, error)
+ * 时间以调用方为准, 实例之间的时钟误差会体现为令牌的多少
+ */
+func (tb *RedisTokenBucket) Allow(key string) (*Result, error) {
+	k := KeyPrefix + key
+	now := tb.now().UnixNano() / int64(time.Millisecond)
+	ttl := int64(tb.burst/tb.rate)/int64(time.Millisecond) + 1000
+	reply, err := tb.st.Do(k, "EVAL", tokenBucketScript, 1, k,
+		strconv.FormatFloat(tb.rate*float64(time.Millisecond), 'g', -1, 64),
+		strconv.FormatFloat(tb.burst, 'g', -1, 64), now, ttl)
+	if err != nil {
+		return nil, err
+	}
+	vs, ok := reply.([]interface{})
+	if !ok || len(vs) != 2 {
+		return nil, fmt.Errorf("unexpected reply: %v", reply)
+	}
+	tokens, err := strconv.ParseFloat(core.GetString(vs[1]), 64)
+	if err != nil {
+		return nil, err
+	}
+	if core.GetInt(vs[0]) == 1 {
+		tokens++ // 脚本已经扣除
+	}
+	return tokenResult(tokens, tb.burst, tb.rate, nil), nil
+}
+
+/* }}} */
+
+/* {{{ func NewRedisSlidingWindow(st *storage.Storage, limit int, window time.Duration) *RedisSlidingWindow
+ *
+ */
+func NewRedisSlidingWindow(st *storage.Storage, limit int, w time.Duration) *RedisSlidingWindow {
+	if w <= 0 {
+		w = time.Second
+	}
+	return &RedisSlidingWindow{st: st, limit: limit, window: w, now: time.Now}
+}
+
+/* }}} */
+
+/* {{{ func (sw *RedisSlidingWindow) Allow(key string) (*Result, error)
+ * 两个窗口的key按key选择节点, 保证在同一个节点上
+ */
+func (sw *RedisSlidingWindow) Allow(key string) (*Result, error) {
+	now := sw.now()
+	idx := now.UnixNano() / int64(sw.window)
+	elapsed := time.Duration(now.UnixNano() - idx*int64(sw.window))
+	k := KeyPrefix + key
+	cur := fmt.Sprintf("%s:%d", k, idx)
+	prev := fmt.Sprintf("%s:%d", k, idx-1)
+	weight := 1 - float64(elapsed)/float64(sw.window)
+	ttl := int64(2*sw.window/time.Millisecond) + 1000 // 保留到下一个窗口结束
+
+	reply, err := sw.st.Do(k, "EVAL", slidingWindowScript, 2, cur, prev,
+		sw.limit, strconv.FormatFloat(weight, 'g', -1, 64), ttl)
+	if err != nil {
+		return nil, err
+	}
+	vs, ok := reply.([]interface{})
+	if !ok || len(vs) != 3 {
+		return nil, fmt.Errorf("unexpected reply: %v", reply)
+	}
+	return windowResult(core.GetInt(vs[1]), core.GetInt(vs[2]), sw.limit, sw.window, elapsed), nil
+}
+
+/* }}} */
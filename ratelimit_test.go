@@ -0,0 +1,33 @@
+package wgo_test
+
+import (
+	"testing"
+	"time"
+
+	"wgo"
+	"wgo/jwt"
+	"wgo/wgotest"
+)
+
+// key为user时只按认证过的用户计数, 伪造的X-WGO-UserId头按ip
+func TestRateLimitUserKey(t *testing.T) {
+	app := wgotest.New("proc_name: ratelimittest\njwt:\n  keys:\n    - kid: k1\n      secret: s3cret")
+	defer app.Close()
+	wgo.GET("/search", func(c *wgo.Context) error {
+		return c.String(200, "ok")
+	}).SetOptions(wgo.RateLimitKey, &wgo.RateLimitRule{Limit: 1, Per: time.Minute, Key: "user"})
+	bearer := func(uid string) map[string]string {
+		token, err := wgo.IssueToken(jwt.Claims{"sub": uid}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	app.GET("/search", map[string]string{"X-WGO-UserId": "u1"}).Expect(t, 200)
+	app.GET("/search", map[string]string{"X-WGO-UserId": "u2"}).Expect(t, 429)
+
+	app.GET("/search", bearer("u1")).Expect(t, 200)
+	app.GET("/search", bearer("u1")).Expect(t, 429)
+	app.GET("/search", bearer("u2")).Expect(t, 200)
+}
@@ -8,8 +8,10 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"wgo"
@@ -24,6 +26,8 @@ type Client struct {
 	baseUrl string
 	path    string
 	app     string
+	secret  string          // app的secret, 不为空时请求签名
+	ctx     context.Context // 调用方的context, 见WithContext
 
 	req *resty.Request
 }
@@ -38,6 +42,8 @@ type Response struct {
 }
 
 // new client, can pass appid
+// 配置(rest.sign)中有该app的secret时请求签名
+// opts中的context.Context(如*wgo.Context)同WithContext
 func NewClient(service string, opts ...interface{}) *Client {
 	baseUrl := ""
 	if len(service) > 5 && strings.ToLower(service[0:4]) == "http" {
@@ -48,28 +54,53 @@ func NewClient(service string, opts ...interface{}) *Client {
 	}
 	client := &Client{
 		baseUrl: baseUrl,
-		req:     resty.New().R(),
 	}
 	if len(opts) > 0 {
 		if app, ok := opts[0].(string); ok {
 			client.app = app
+			client.secret = signSecret(app)
+		}
+	}
+	client.req = client.newRequest()
+	for _, opt := range opts {
+		if ctx, ok := opt.(context.Context); ok {
+			client.ctx = ctx
 		}
-		// todo, get app info & sign by appid
 	}
 	return client
 }
 
-// new inner client
-func NewInnerClient(service string) (*Client, error) {
-	// todo: not hardcore inner appid
+// new inner client, 使用本服务的app id(rest.sign.app, 默认proc_name)签名
+func NewInnerClient(service string, opts ...interface{}) (*Client, error) {
+	app := selfApp()
+	if signSecret(app) == "" {
+		Debug("[NewInnerClient]no secret for app: %s, request will not be signed", app)
+	}
+	opts = append([]interface{}{app}, opts...)
 	if len(service) > 5 && strings.ToLower(service[0:4]) == "http" {
-		return NewClient(service, "gxfstpp"), nil
+		return NewClient(service, opts...), nil
 	} else if baseUrl := GetService(service); baseUrl != "" {
-		return NewClient(baseUrl, "gxfstpp"), nil
+		return NewClient(baseUrl, opts...), nil
 	}
 	return nil, fmt.Errorf("Unknown service: %s", service)
 }
 
+// 有secret时在请求发出前签名
+func (client *Client) newRequest() *resty.Request {
+	rc := resty.New()
+	if client.secret != "" {
+		rc.SetPreRequestHook(signRequest(client.app, client.secret))
+	}
+	return rc.R()
+}
+
+// 请求带上ctx: 取消时中断请求, 注入traceparent
+// ctx为*wgo.Context时还会传递request id及调用深度
+func (client *Client) WithContext(ctx context.Context) *Client {
+	client.ctx = ctx
+	return client
+}
+
 func (client *Client) SetJson(data interface{}) *Client {
 	jb, _ := json.Marshal(data)
 	client.req.SetBody(jb)
@@ -93,6 +124,15 @@ func (client *Client) sendAndRecv(cat string, opts ...interface{}) (resp *Respon
 	if client.app != "" {
 		client.req.SetHeader(whttp.HeaderXAppId, client.app)
 	}
+	if client.ctx != nil {
+		client.req.SetContext(client.ctx)
+		if c, ok := client.ctx.(*wgo.Context); ok {
+			if rid := c.RequestID(); rid != "" {
+				client.req.SetHeader(whttp.HeaderXRequestId, rid)
+			}
+			client.req.SetHeader(whttp.HeaderXDepth, strconv.FormatUint(c.Depth(), 10))
+		}
+	}
 	if len(opts) > 0 {
 		if path, ok := opts[0].(string); ok {
 			client.path = path
@@ -123,7 +163,7 @@ func (client *Client) sendAndRecv(cat string, opts ...interface{}) (resp *Respon
 	// wgo.Info("[sendAndRecv]code: %d, message: %s", resp.Code(), resp.Message())
 
 	// renew req
-	client.req = resty.New().R()
+	client.req = client.newRequest()
 
 	return resp, nil
 }
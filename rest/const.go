@@ -34,6 +34,9 @@ const (
 	AECK_SESSION_KEY     = "session.key"
 	AECK_SESSION_DOMAIN  = "session.domain"
 	AECK_SESSION_DOMAINS = "session.domains"
+	AECK_OPENAPI         = "rest.openapi"
+	AECK_SIGN            = "rest.sign"
+	AECK_RBAC            = "rest.rbac"
 	// rest config key
 	RCK_ES_ADDR         = "addr"
 	RCK_ES_USER         = "user"
@@ -68,7 +71,9 @@ const (
 	DIMENSION_KEY     = "_dimension_"
 	SIDE_KEY          = "_sidekey_"
 	USERID_KEY        = "_userid_"
+	AUTHUSER_KEY      = "_authuser_"
 	SESSION_KEY       = "_session_"
+	SESSION_STATE_KEY = "_session_state_"
 	APPID_KEY         = "_appid_"
 	STAG_KEY          = "_stag_"
 	PERMISSION_KEY    = "_perm_"
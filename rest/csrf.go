@@ -0,0 +1,97 @@
+//
+// csrf.go
+// CSRF防护(double-submit): token放在js可读的cookie中, 修改类请求需要在header(X-CSRF-Token)或表单(_csrf)中带上相同的值
+//
+
+package rest
+
+import (
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"wgo"
+	"wgo/whttp"
+)
+
+const (
+	csrfTokenKey   = "_csrf_token_" // context中保存本次请求新生成的token
+	csrfFormField  = "_csrf"
+	defaultCSRFKey = "csrf_token"
+)
+
+func (sc *SessionConfig) csrfKey() string {
+	if sc.CSRFKey != "" {
+		return sc.CSRFKey
+	}
+	return defaultCSRFKey
+}
+
+/* {{{ func CSRF() wgo.MiddlewareFunc
+ * GET/HEAD/OPTIONS没有token时下发, 其他method校验, 不一致返回403
+ * 没有session cookie(不依赖cookie认证), 签名的服务间调用以及JWT认证的请求不校验
+ */
+func CSRF() wgo.MiddlewareFunc {
+	return func(next wgo.HandlerFunc) wgo.HandlerFunc {
+		return func(c *wgo.Context) error {
+			switch c.ServerMode() {
+			case "rpc", "wrpc", "grpc":
+				return next(c)
+			}
+			switch c.Method() {
+			case "GET", "HEAD", "OPTIONS", "TRACE":
+				if ck, err := c.Cookie(scfg.csrfKey()); err != nil || ck.Value() == "" {
+					SetCSRFToken(c)
+				}
+				return next(c)
+			}
+			if ck, err := c.Cookie(scfg.Key); err != nil || ck.Value() == "" {
+				return next(c)
+			}
+			if SignedApp(c) != "" || c.Claims() != nil {
+				return next(c)
+			}
+			expected := ""
+			if ck, err := c.Cookie(scfg.csrfKey()); err == nil {
+				expected = ck.Value()
+			}
+			token := c.RequestHeader().Get(whttp.HeaderXCSRFToken)
+			if token == "" {
+				ct := c.RequestHeader().Get(whttp.HeaderContentType)
+				if strings.HasPrefix(ct, whttp.MIMEApplicationForm) || strings.HasPrefix(ct, whttp.MIMEMultipartForm) {
+					token = c.FormValue(csrfFormField)
+				}
+			}
+			if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(token)) != 1 {
+				c.Info("[CSRF]%s %s, token mismatch", c.Method(), c.Path())
+				return c.NewError(whttp.StatusForbidden*1000+1, "invalid csrf token")
+			}
+			return next(c)
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func SetCSRFToken(c *wgo.Context) string
+ * 生成新的token并设置cookie(非HttpOnly, 页面js读取后放在header中), session变化时自动调用
+ */
+func SetCSRFToken(c *wgo.Context) string {
+	token := newSessionID()
+	setCookie(c, scfg.csrfKey(), token, time.Time{}, false)
+	c.Set(csrfTokenKey, token)
+	return token
+}
+
+/* }}} */
+
+// 当前的token(如渲染到表单中), 本次请求生成的优先
+func CSRFToken(c *wgo.Context) string {
+	if token, ok := c.Get(csrfTokenKey).(string); ok {
+		return token
+	}
+	if ck, err := c.Cookie(scfg.csrfKey()); err == nil {
+		return ck.Value()
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package rest_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"testing"
+	"time"
+
+	"wgo"
+	"wgo/rest"
+	"wgo/wgotest"
+)
+
+const csrfCfg = `
+proc_name: csrftest
+session:
+  key: sid
+  store: memory
+rest:
+  sign:
+    apps:
+      - id: caller
+        secret: s3cret
+`
+
+func newCSRFApp(t *testing.T) *wgotest.App {
+	app := wgotest.New(csrfCfg)
+	ok := func(c *wgo.Context) error { return c.String(200, rest.CSRFToken(c)) }
+	wgo.Any("/act", ok, rest.CSRF())
+	wgo.POST("/signed", ok, rest.VerifySign(), rest.CSRF())
+	return app
+}
+
+func TestCSRFIssue(t *testing.T) {
+	app := newCSRFApp(t)
+	defer app.Close()
+
+	// 没有token时下发, 本次请求即可读到
+	r := app.GET("/act").Expect(t, 200)
+	ck := cookies(r)["csrf_token"]
+	if ck == nil || ck.Value == "" || ck.HttpOnly {
+		t.Fatalf("csrf cookie: %v", r.Header["Set-Cookie"])
+	}
+	if r.String() != ck.Value {
+		t.Errorf("CSRFToken: %s, cookie %s", r, ck.Value)
+	}
+
+	// 已有token时不重复下发
+	r = app.GET("/act", map[string]string{"Cookie": "csrf_token=" + ck.Value}).Expect(t, 200)
+	if _, ok := cookies(r)["csrf_token"]; ok || r.String() != ck.Value {
+		t.Errorf("token reissued: %v, %s", r.Header["Set-Cookie"], r)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	app := newCSRFApp(t)
+	defer app.Close()
+	const tok = "t0ken"
+	sess := "sid=s1; csrf_token=" + tok
+	form := "application/x-www-form-urlencoded"
+
+	cases := []struct {
+		name    string
+		method  string
+		body    string
+		headers map[string]string
+		code    int
+	}{
+		{"GET exempt", "GET", "", map[string]string{"Cookie": sess}, 200},
+		{"HEAD exempt", "HEAD", "", map[string]string{"Cookie": sess}, 200},
+		{"OPTIONS exempt", "OPTIONS", "", map[string]string{"Cookie": sess}, 200},
+		{"no session cookie", "POST", "", nil, 200},
+		{"header", "POST", "", map[string]string{"Cookie": sess, "X-CSRF-Token": tok}, 200},
+		{"header on PUT", "PUT", "", map[string]string{"Cookie": sess, "X-CSRF-Token": tok}, 200},
+		{"header on DELETE", "DELETE", "", map[string]string{"Cookie": sess, "X-CSRF-Token": tok}, 200},
+		{"form field", "POST", "_csrf=" + tok, map[string]string{"Cookie": sess, "Content-Type": form}, 200},
+		{"missing", "POST", "", map[string]string{"Cookie": sess}, 403},
+		{"missing on PATCH", "PATCH", "", map[string]string{"Cookie": sess}, 403},
+		{"header mismatch", "POST", "", map[string]string{"Cookie": sess, "X-CSRF-Token": "other"}, 403},
+		{"form mismatch", "POST", "_csrf=other", map[string]string{"Cookie": sess, "Content-Type": form}, 403},
+		{"form field not json", "POST", `{"_csrf":"` + tok + `"}`, map[string]string{"Cookie": sess, "Content-Type": "application/json"}, 403},
+		{"no cookie token", "POST", "", map[string]string{"Cookie": "sid=s1", "X-CSRF-Token": ""}, 403},
+		{"header takes precedence", "POST", "_csrf=" + tok, map[string]string{"Cookie": sess, "Content-Type": form, "X-CSRF-Token": "other"}, 403},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var body interface{}
+			if c.body != "" {
+				body = c.body
+			}
+			r := app.Request(c.method, "/act", body, c.headers).Expect(t, c.code)
+			if c.code == 403 {
+				r.ExpectContains(t, `"code":403001`)
+			}
+		})
+	}
+
+	t.Run("multipart", func(t *testing.T) {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		mw.WriteField("_csrf", tok)
+		mw.Close()
+		app.POST("/act", buf.Bytes(), map[string]string{"Cookie": sess, "Content-Type": mw.FormDataContentType()}).Expect(t, 200)
+	})
+
+	// 签名的服务间调用不依赖cookie, 不校验
+	t.Run("signed", func(t *testing.T) {
+		h := signed("POST", "/signed", "", "s3cret", time.Now(), "c1")
+		h["Cookie"] = sess
+		app.POST("/signed", "", h).Expect(t, 200)
+		app.POST("/signed", "", map[string]string{"Cookie": sess}).Expect(t, 403)
+	})
+}
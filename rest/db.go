@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -38,7 +39,13 @@ func OpenDB(tag, dns string) (err error) {
 	gorp.SetTypeConvert(BaseConverter{})
 	if err = gorp.Open(tag, "mysql", dns); err != nil {
 		//Debug("open error: %s", err)
+		return
 	}
+	// 退出时关闭
+	wgo.OnStop(func(context.Context) error {
+		Info("close db: %s", tag)
+		return gorp.Close(tag)
+	})
 	return
 }
 
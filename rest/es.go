@@ -52,6 +52,21 @@ func OpenElasticSearch() (err error) {
 	return
 }
 
+// es集群状态, red为不健康
+func esHealth(ctx context.Context) error {
+	if ElasticClient == nil {
+		return fmt.Errorf("elasticsearch not opened")
+	}
+	res, err := ElasticClient.ClusterHealth().Do(ctx)
+	if err != nil {
+		return err
+	}
+	if res.Status == "red" {
+		return fmt.Errorf("cluster status: %s", res.Status)
+	}
+	return nil
+}
+
 func SearchService(index string) *elastic.SearchService {
 	return ElasticClient.Search().Index(index)
 }
@@ -156,26 +171,42 @@ func Fields(f string) *elastic.TermsAggregation {
 }
 
 // save to es
+// m的REST在请求结束后会被复用, 因此在请求中读取记录, 只把写入es放到后台
 func saveToES(m Model) {
+	if ElasticClient == nil {
+		return
+	}
 	defer func() {
 		if err := recover(); err != nil {
 			Error("error: %s", err)
 		}
 	}()
-	if _, pk, _ := m.PKey(); pk != "" {
-		idx := fmt.Sprintf("%s%s", esPrefix, m.TableName())
+	_, pk, _ := m.PKey()
+	if pk == "" {
+		Warn("not found primary key")
+		return
+	}
+	idx := fmt.Sprintf("%s%s", esPrefix, m.TableName())
+	nm, err := m.Row()
+	if err != nil {
+		Warn("[saveToES]read %s failed: %s", pk, err)
+		return
+	}
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				Error("error: %s", err)
+			}
+		}()
 		exists, _ := ElasticClient.IndexExists(idx).Do(context.Background())
 		if !exists {
 			// create index
 			ElasticClient.CreateIndex(idx)
 		}
-		nm, _ := m.Row()
 		bulk := ElasticClient.Bulk().Index(idx)
 		bulk.Add(NewBulkIndexRequest().Id(pk).Doc(nm))
 		bulk.Do(context.Background())
-	} else {
-		Warn("not found primary key")
-	}
+	}()
 }
 
 // save all rows to es
@@ -952,14 +952,18 @@ func (r *REST) NewSlice() interface{} {
 /* }}} */
 
 /* {{{ func (r *REST) DBConn(tag string) *gorp.DbMap
-* 默认数据库连接为admin
+* 默认数据库连接为admin, 有请求时带上请求的context(sql记录为请求的子span)
  */
 func (r *REST) DBConn(tag string) *gorp.DbMap {
 	tb := r.TableName()
+	alias := DBTAG
 	if dt, ok := DataAccessor[tb+"::"+tag]; ok && dt != "" {
-		return gorp.Using(dt)
+		alias = dt
 	}
-	return gorp.Using(DBTAG)
+	if c := r.Context(); c != nil && c.Context() != nil {
+		return gorp.Using(alias).WithContext(c.Context())
+	}
+	return gorp.Using(alias)
 }
 
 /* }}} */
@@ -1308,6 +1312,11 @@ func Valid(m Model, fields ...string) (Model, error) {
 	// r.Debug("[Valid]updating: %v, creating: %v", r.Updating(), r.Creating())
 	// keeper := m.Keeper()
 	v := reflect.ValueOf(m)
+	if r.Creating() { // 行级权限的字段(如creator)由服务端赋值
+		if err := r.applyFilter(m); err != nil {
+			return nil, err
+		}
+	}
 	if cols := r.Columns(); cols != nil {
 		ufs := make([]string, 0)
 		for _, col := range cols {
@@ -1332,6 +1341,9 @@ func Valid(m Model, fields ...string) (Model, error) {
 					r.Warn("%s is uneditable: %v", col.Tag, fv)
 					//return nil, fmt.Errorf("%s is uneditable", col.Tag) //尝试编辑不可编辑的字段,直接报错
 					fv.Set(reflect.Zero(fv.Type())) // 不报错, 忽略之
+				} else if !r.writable(col) { // 没有写权限, 忽略
+					r.Warn("%s is not writable: %v", col.Tag, fv)
+					fv.Set(reflect.Zero(fv.Type()))
 				} else if !col.TagOptions.Contains(DBTAG_PK) {
 					// 处理字段, 不包括primary key
 					ufs = append(ufs, col.Tag)
@@ -1367,7 +1379,7 @@ func (r *REST) Protect() (Model, error) {
 		if cols := r.Columns(); cols != nil {
 			v := reflect.ValueOf(m)
 			for _, col := range cols {
-				if col.ExtOptions.Contains(TAG_SECRET) { //保密,不对外
+				if col.ExtOptions.Contains(TAG_SECRET) || !r.readable(col) { //保密或没有读权限,不对外
 					fv := utils.FieldByIndex(v, col.Index)
 					fv.Set(reflect.Zero(fv.Type()))
 				}
@@ -1447,7 +1459,7 @@ func (r *REST) CreateRow() (Model, error) {
 func (r *REST) Save(m Model) Model {
 	r.saved = true
 	nm := r.setModel(m)
-	go saveToES(nm)
+	saveToES(nm)
 	return nm
 }
 func (r *REST) Saved() bool {
@@ -1479,8 +1491,15 @@ func (r *REST) UpdateRow(opts ...interface{}) (affected int64, err error) {
 			Warn("[UpdateRow]union keys empty")
 			return 0, ErrNoRecord
 		}
-		go saveToES(m)
-		return r.DBConn(WRITETAG).Update(m)
+		where, we := r.rowFilter()
+		if we != nil {
+			return 0, we
+		}
+		affected, err = r.DBConn(WRITETAG).UpdateWhere(m, where)
+		if err == nil {
+			saveToES(m)
+		}
+		return
 	}
 	err = ErrNoModel
 	return
@@ -1497,7 +1516,11 @@ func (r *REST) DeleteRow(id string) (affected int64, err error) {
 		if err = utils.ImportValue(m, map[string]string{DBTAG_PK: id, DBTAG_LOGIC: "-1"}); err != nil {
 			return
 		}
-		return db.Update(m)
+		where, we := r.rowFilter()
+		if we != nil {
+			return 0, we
+		}
+		return db.UpdateWhere(m, where)
 	}
 	return 0, ErrNoModel
 }
@@ -2184,6 +2207,8 @@ func GetDbFields(i interface{}, ops ...interface{}) (s []string) {
 				continue
 			} else if readTag && col.ExtOptions.Contains(TAG_SECRET) { //默认忽略tag
 				continue
+			} else if readTag && !i.(Model).GetREST().readable(col) { //没有读权限
+				continue
 			} else if len(fs) > 0 && !col.TagOptions.Contains(DBTAG_PK) && !utils.InSlice(col.Tag, fs) {
 				continue
 			}
@@ -0,0 +1,354 @@
+//
+// openapi.go
+// 根据路由及model的filter tag生成OpenAPI 3文档
+// GET /openapi.json(openapi.path配置), 或者命令 app openapi [file]
+//
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"wgo"
+	"wgo/openapi"
+	"wgo/server"
+	"wgo/utils"
+	"wgo/whttp"
+)
+
+const defaultOpenAPIPath = "/openapi.json"
+
+var opIDRe = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func init() {
+	wgo.AddCommand("openapi", writeOpenAPI)
+}
+
+// 注册文档路由
+func serveOpenAPI(w *wgo.WGO) {
+	if w.Cfg().Bool(AECK_OPENAPI + ".disable") {
+		return
+	}
+	path := w.Cfg().String(AECK_OPENAPI + ".path")
+	if path == "" {
+		path = defaultOpenAPIPath
+	}
+	if ss := w.HTTPServers(); len(ss) > 0 {
+		openapi.Annotate(ss.GET(path, openAPIHandler), &openapi.Doc{Hidden: true})
+	}
+}
+
+func openAPIHandler(c *wgo.Context) error {
+	c.SetHeader("Cache-Control", "no-cache")
+	return c.JSON(whttp.StatusOK, OpenAPI(c.App()))
+}
+
+// app openapi [file], 不指定file则输出到stdout
+func writeOpenAPI(w *wgo.WGO, args []string) error {
+	b, err := json.MarshalIndent(OpenAPI(w), "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if len(args) > 0 && args[0] != "-" {
+		return ioutil.WriteFile(args[0], b, 0644)
+	}
+	_, err = os.Stdout.Write(b)
+	return err
+}
+
+/* {{{ func OpenAPI(w *wgo.WGO) *openapi.Document
+ * rest model的内置路由按GM_*及filter tag生成, 其他路由按openapi.Doc注释生成
+ */
+func OpenAPI(w *wgo.WGO) *openapi.Document {
+	cfg := w.Cfg()
+	title := cfg.String(AECK_OPENAPI + ".title")
+	if title == "" {
+		title = w.Env().ProcName
+	}
+	doc := openapi.NewDocument(title, wgo.Version())
+	doc.Info.Description = cfg.String(AECK_OPENAPI + ".description")
+	for _, u := range cfg.StringSlice(AECK_OPENAPI + ".servers") {
+		doc.Servers = append(doc.Servers, &openapi.Server{URL: u})
+	}
+
+	g := &apiGen{doc: doc, ids: make(map[string]int), models: make(map[string]string)}
+	seen := make(map[string]bool)
+	for _, s := range w.HTTPServers() {
+		mux, ok := s.Mux().(*whttp.Mux)
+		if !ok {
+			continue
+		}
+		// 后注册的覆盖前面的(AddMiddleware/Register会重新注册)
+		rs := mux.Router().Routes()
+		for i := len(rs) - 1; i >= 0; i-- {
+			rt := rs[i]
+			if key := rt.Method + " " + rt.Path; seen[key] {
+				continue
+			} else {
+				seen[key] = true
+			}
+			g.add(rt)
+		}
+	}
+	return doc
+}
+
+/* }}} */
+
+type apiGen struct {
+	doc    *openapi.Document
+	ids    map[string]int    // operationId去重
+	models map[string]string // model全名 => schema名称
+}
+
+func (g *apiGen) add(rt *whttp.Route) {
+	opts := rt.Options()
+	ad := openapi.DocOf(opts)
+	if ad != nil && ad.Hidden {
+		return
+	}
+	var op *openapi.Operation
+	path := rt.Path
+	if pool, ok := opts[optionKey(ModelPoolKey)].(*sync.Pool); ok {
+		r, _ := pool.Get().(*REST)
+		if r == nil {
+			return
+		}
+		defer pool.Put(r)
+		if cur := restPool.Get(r.Name()); cur != pool { // 已经被新的endpoint替代
+			return
+		}
+		if op = g.restOperation(r, rt); op == nil { // 内置路由没有开启
+			return
+		}
+		path = strings.Replace(path, ":"+RowkeyKey, ":id", -1)
+	} else {
+		op = &openapi.Operation{}
+	}
+	if op.OperationID == "" {
+		op.OperationID = strings.Trim(opIDRe.ReplaceAllString(strings.ToLower(rt.Method)+"_"+path, "_"), "_")
+	}
+	if n := g.ids[op.OperationID]; n > 0 {
+		g.ids[op.OperationID]++
+		op.OperationID = fmt.Sprintf("%s_%d", op.OperationID, n+1)
+	} else {
+		g.ids[op.OperationID] = 1
+	}
+	g.doc.Apply(op, ad)
+	if op.Responses == nil {
+		op.Responses = make(map[string]*openapi.Response)
+	}
+	if _, ok := op.Responses["default"]; !ok {
+		op.Responses["default"] = &openapi.Response{Description: "Error", Content: openapi.JSONContent(g.doc.SchemaOf(server.ServerError{}))}
+	}
+	if len(op.Responses) == 1 { // 只有default
+		op.Responses["200"] = &openapi.Response{Description: "OK"}
+	}
+	g.doc.AddOperation(rt.Method, path, op)
+}
+
+/* {{{ func (g *apiGen) restOperation(r *REST, rt *whttp.Route) *openapi.Operation
+ * 内置路由按flag判断是否开启, 其他rest路由(REST.Add)只加上tag
+ */
+func (g *apiGen) restOperation(r *REST, rt *whttp.Route) *openapi.Operation {
+	ep := "/" + r.Endpoint()
+	rk := ep + "/:" + RowkeyKey
+	name := g.schema(r)
+	_, short := fullName(r.Model())
+	g.doc.AddTag(r.Endpoint(), short)
+	op := &openapi.Operation{Tags: []string{r.Endpoint()}, Responses: make(map[string]*openapi.Response)}
+	ok := func(code string, s *openapi.Schema) {
+		resp := &openapi.Response{Description: "OK"}
+		if s != nil {
+			resp.Content = openapi.JSONContent(s)
+		}
+		op.Responses[code] = resp
+	}
+
+	gm, act := GM_NONE, ""
+	switch {
+	case rt.Method == whttp.METHOD_HEAD && rt.Path == ep:
+		gm, act = GM_HEAD, "head"
+		op.Summary = "Check " + r.Endpoint()
+		ok("200", nil)
+	case rt.Method == whttp.METHOD_GET && rt.Path == rk:
+		gm, act = GM_GET, "get"
+		op.Summary = "Get " + short
+		ok("200", openapi.Ref(name))
+	case rt.Method == whttp.METHOD_GET && rt.Path == ep:
+		gm, act = GM_LIST, "list"
+		op.Summary = "List " + r.Endpoint()
+		op.Parameters = g.listParams(r)
+		ok("200", g.doc.Define(name+"List", &openapi.Schema{
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"info": g.doc.SchemaOf(ListInfo{}),
+				"list": {Type: "array", Items: openapi.Ref(name)},
+			},
+		}))
+	case rt.Method == whttp.METHOD_POST && rt.Path == ep:
+		gm, act = GM_POST, "create"
+		op.Summary = "Create " + short
+		op.RequestBody = &openapi.RequestBody{Required: true, Content: openapi.JSONContent(openapi.Ref(name))}
+		ok("201", openapi.Ref(name))
+	case rt.Method == whttp.METHOD_DELETE && rt.Path == rk:
+		gm, act = GM_DELETE, "delete"
+		op.Summary = "Delete " + short
+		op.Responses["204"] = &openapi.Response{Description: "No Content"}
+	case rt.Method == whttp.METHOD_PATCH && rt.Path == rk:
+		gm, act = GM_PATCH, "update"
+		op.Summary = "Update " + short
+		op.RequestBody = &openapi.RequestBody{Required: true, Content: openapi.JSONContent(openapi.Ref(name + "Patch"))}
+		ok("200", openapi.Ref(name))
+	case rt.Method == whttp.METHOD_PUT && rt.Path == rk:
+		gm, act = GM_PUT, "reset"
+		op.Summary = "Reset " + short
+		op.RequestBody = &openapi.RequestBody{Required: true, Content: openapi.JSONContent(openapi.Ref(name))}
+		ok("201", openapi.Ref(name))
+	}
+	if gm != GM_NONE {
+		if r.flag&gm == 0 {
+			return nil
+		}
+		op.OperationID = r.Endpoint() + "." + act
+		return op
+	}
+
+	// 其他路由
+	op.Responses = nil
+	if d, ok := rt.Options()[optionKey(DescKey)].(string); ok {
+		op.Summary = d
+	}
+	return op
+}
+
+/* }}} */
+
+/* {{{ func (g *apiGen) schema(r *REST) string
+ * model的schema: H不出现, S只写, G/ro只读, R必填; 另有<name>Patch用于PATCH(没有必填, D只读)
+ */
+func (g *apiGen) schema(r *REST) string {
+	full, _ := fullName(r.Model())
+	if name, ok := g.models[full]; ok {
+		return name
+	}
+	name := openapi.SchemaName(utils.ToType(r.Model()))
+	g.models[full] = name
+
+	t := utils.ToType(r.Model())
+	s := &openapi.Schema{Type: "object", Properties: make(map[string]*openapi.Schema)}
+	ps := &openapi.Schema{Type: "object", Properties: make(map[string]*openapi.Schema)}
+	for _, col := range r.Columns() {
+		if col.Tag == "-" || col.ExtOptions.Contains(TAG_HIDDEN) {
+			continue
+		}
+		f, ok := t.FieldByName(col.Name)
+		if !ok {
+			continue
+		}
+		jn, ok := openapi.JSONName(f)
+		if !ok || jn == "" {
+			continue
+		}
+		prop := g.doc.SchemaOf(f.Type)
+		if prop.Ref != "" { // $ref不能有其他属性, 只读/只写只能忽略
+			s.Properties[jn] = prop
+			ps.Properties[jn] = prop
+			continue
+		}
+		switch {
+		case col.ExtOptions.Contains(TAG_GENERATE) || col.TagOptions.Contains(DBTAG_READONLY):
+			prop.ReadOnly = true
+		case col.ExtOptions.Contains(TAG_SECRET):
+			prop.WriteOnly = true
+		}
+		if col.ExtOptions.Contains(TAG_REQUIRED) {
+			s.Required = append(s.Required, jn)
+		}
+		s.Properties[jn] = prop
+		pp := *prop
+		if col.ExtOptions.Contains(TAG_DENY) { // 不可编辑
+			pp.ReadOnly = true
+		}
+		ps.Properties[jn] = &pp
+	}
+	g.doc.Define(name+"Patch", ps)
+	g.doc.Define(name, s)
+	return name
+}
+
+/* }}} */
+
+/* {{{ func (g *apiGen) listParams(r *REST) []*openapi.Parameter
+ * 条件字段(C/pk/uk/k)支持前缀: !不等于, ~模糊匹配, >大于, <小于, 逗号分隔多个值
+ */
+func (g *apiGen) listParams(r *REST) (ps []*openapi.Parameter) {
+	var orders, fields []string
+	var timeRange bool
+	for _, col := range r.Columns() {
+		if col.Tag == "-" || col.ExtOptions.Contains(TAG_HIDDEN) {
+			continue
+		}
+		if !col.ExtOptions.Contains(TAG_SECRET) {
+			fields = append(fields, col.Tag)
+		}
+		if col.ExtOptions.Contains(TAG_ORDERBY) || col.ExtOptions.Contains(TAG_AORDERBY) {
+			orders = append(orders, col.Tag)
+		}
+		if col.ExtOptions.Contains(TAG_TIMERANGE) {
+			timeRange = true
+		}
+		if !(col.ExtOptions.Contains(TAG_CONDITION) || col.TagOptions.Contains(DBTAG_PK) ||
+			col.TagOptions.Contains(DBTAG_UK) || col.TagOptions.Contains(DBTAG_KEY)) {
+			continue
+		}
+		ts := openapi.TypeSchema(col.Type)
+		if ts == nil {
+			ts = &openapi.Schema{Type: "string"}
+		}
+		cond := func(prefix, desc string) {
+			s := *ts
+			ps = append(ps, &openapi.Parameter{Name: prefix + col.Tag, In: "query", Description: desc, Schema: &s})
+		}
+		cond("", fmt.Sprintf("%s equals, comma separated values match any of them", col.Tag))
+		cond(string(_PPREFIX_NOT), fmt.Sprintf("%s not equals", col.Tag))
+		switch {
+		case ts.Type == "string" && ts.Format == "":
+			cond(string(_PPREFIX_LIKE), fmt.Sprintf("%s contains", col.Tag))
+		case ts.Type == "integer" || ts.Type == "number" || ts.Format == "date-time":
+			cond(string(_PPREFIX_GT), fmt.Sprintf("%s greater than", col.Tag))
+			cond(string(_PPREFIX_LT), fmt.Sprintf("%s less than", col.Tag))
+		}
+	}
+	if timeRange {
+		ps = append(ps,
+			openapi.Query(PARAM_START, "string", "start time of the range, e.g. 20060102 or 2006-01-02"),
+			openapi.Query(PARAM_END, "string", "end time of the range, same format as start"),
+			openapi.Query(PARAM_DATE, "string", "time range as {start},{end}"),
+		)
+	}
+	ps = append(ps,
+		&openapi.Parameter{Name: PARAM_PAGE, In: "query", Description: "page number, 1-based",
+			Schema: &openapi.Schema{Type: "integer", Default: _DEF_PAGE, Minimum: number(1)}},
+		&openapi.Parameter{Name: PARAM_PERPAGE, In: "query", Description: "items per page",
+			Schema: &openapi.Schema{Type: "integer", Default: _DEF_PER_PAGE, Minimum: number(1), Maximum: number(_MAX_PER_PAGE)}},
+		openapi.Query(PARAM_FIELDS, "string", "comma separated fields to return: "+strings.Join(fields, ", ")),
+	)
+	if len(orders) > 0 {
+		ps = append(ps, openapi.Query(PARAM_ORDERBY, "string", "{field}[,ASC|DESC], orderable: "+strings.Join(orders, ", ")))
+	}
+	return
+}
+
+/* }}} */
+
+func number(f float64) *float64 {
+	return &f
+}
@@ -180,10 +180,12 @@ func (r *REST) setTimeRangeFromDate(p []string) {
 
 /* {{{ func (r *REST) SetUserID(opts ...interface{})
  * 设置UserID, 可以覆盖默认cookie中的user id
+ * 传入的uid视为已经认证(如读取session之后设置), 可以用于RBAC的$user
  */
 func (r *REST) SetUserID(opts ...interface{}) {
 	if uid := utils.PrimaryString(opts); uid != "" {
 		r.SetEnv(USERID_KEY, uid)
+		r.SetEnv(AUTHUSER_KEY, uid)
 	} else if uid = r.Context().UserID(); uid != "" {
 		r.SetEnv(USERID_KEY, uid)
 	}
@@ -0,0 +1,451 @@
+//
+// rbac.go
+// 基于角色的权限控制: 按endpoint配置policy, 决定role可以执行的action(GM_*), 行级过滤(自动添加条件)以及字段的读写范围
+// 没有policy的endpoint不做限制, 有policy时没有匹配的policy返回403
+//
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"wgo"
+	"wgo/utils"
+	"wgo/whttp"
+)
+
+const (
+	RoleAny   = "*"     // 任何认证过的请求
+	RoleGuest = "guest" // 没有认证的请求
+	UserVar   = "$user" // filter中代表当前用户id
+
+	rolesKey         = "_roles_"
+	defaultRoleClaim = "roles"
+	defaultUserClaim = "user_id"
+)
+
+type (
+	// Policy 一个role对一个endpoint的权限
+	Policy struct {
+		Role    string            `mapstructure:"role"`
+		Actions int               `mapstructure:"-"`       // GM_*, 代码中使用
+		Acts    []string          `mapstructure:"actions"` // 配置中使用: get, list, post, delete, patch, put, head, rpt, all
+		Filter  map[string]string `mapstructure:"filter"`  // 行级过滤, 字段 => 值(UserVar为当前用户), 读取时作为条件, 修改/删除时检查原记录并作为WHERE条件, 创建时自动赋值
+		Read    []string          `mapstructure:"read"`    // 可读字段, 为空不限制
+		Write   []string          `mapstructure:"write"`   // 可写字段, 为空不限制
+	}
+
+	// RBACConfig 配置中的rest.rbac部分
+	RBACConfig struct {
+		RoleClaim string               `mapstructure:"role_claim"` // JWT claims及session(json)中role的key, 默认roles
+		UserClaim string               `mapstructure:"user_claim"` // session(json)中用户id的key, 默认user_id
+		Models    map[string][]*Policy `mapstructure:"models"`     // endpoint => policies, 覆盖代码中的设置
+	}
+)
+
+var (
+	rbacMu       sync.RWMutex
+	rbacCfg      = &RBACConfig{RoleClaim: defaultRoleClaim, UserClaim: defaultUserClaim}
+	codePolicies = make(map[string][]*Policy)
+	roleFunc     func(*REST) []string
+
+	errPermissionDenied = fmt.Errorf("permission denied")
+)
+
+// 读取rbac配置, 默认的wgo初始化时调用(见rest.go init)
+func loadRBACConfig(w *wgo.WGO) {
+	cfg := &RBACConfig{}
+	if w.Cfg().IsSet(AECK_RBAC) {
+		if err := w.Cfg().UnmarshalKey(AECK_RBAC, cfg); err != nil {
+			Error("[loadRBACConfig]invalid config: %s", err)
+		}
+	}
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = defaultRoleClaim
+	}
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = defaultUserClaim
+	}
+	models := make(map[string][]*Policy, len(cfg.Models))
+	for ep, ps := range cfg.Models {
+		for _, p := range ps {
+			p.Actions = parseActions(p.Acts)
+		}
+		models[strings.ToLower(ep)] = ps
+	}
+	cfg.Models = models
+	rbacMu.Lock()
+	rbacCfg = cfg
+	rbacMu.Unlock()
+}
+
+/* {{{ func (r *REST) SetPolicies(ps ...*Policy) *REST
+ * 代码中设置model的policy(替换之前的), 按顺序匹配, 配置文件中有该endpoint时以配置为准
+ * 例: rest.SetPolicies("docs", &rest.Policy{Role: "user", Actions: rest.GM_GET | rest.GM_LIST, Filter: map[string]string{"creator": rest.UserVar}})
+ */
+func (r *REST) SetPolicies(ps ...*Policy) *REST {
+	SetPolicies(r.Endpoint(), ps...)
+	return r
+}
+func SetPolicies(endpoint string, ps ...*Policy) {
+	rbacMu.Lock()
+	defer rbacMu.Unlock()
+	codePolicies[strings.ToLower(endpoint)] = ps
+}
+
+/* }}} */
+
+// 自定义获取role的方法(如从数据库中获取), 替换默认的JWT/session
+func SetRoleFunc(fn func(*REST) []string) {
+	rbacMu.Lock()
+	defer rbacMu.Unlock()
+	roleFunc = fn
+}
+
+// endpoint的policy
+func policies(endpoint string) []*Policy {
+	rbacMu.RLock()
+	defer rbacMu.RUnlock()
+	ep := strings.ToLower(endpoint)
+	if ps, ok := rbacCfg.Models[ep]; ok {
+		return ps
+	}
+	return codePolicies[ep]
+}
+
+// 配置中的action名称转为GM_*
+func parseActions(acts []string) (flag int) {
+	for _, act := range acts {
+		switch strings.ToLower(strings.TrimSpace(act)) {
+		case "get":
+			flag |= GM_GET
+		case "list", "search":
+			flag |= GM_LIST
+		case "post", "create":
+			flag |= GM_POST
+		case "delete":
+			flag |= GM_DELETE
+		case "patch", "update":
+			flag |= GM_PATCH
+		case "put":
+			flag |= GM_PUT
+		case "head":
+			flag |= GM_HEAD
+		case "rpt", "report":
+			flag |= GM_RPT
+		case "all", "*":
+			flag |= GM_ALL | GM_RPT
+		default:
+			Warn("[parseActions]unknown action: %s", act)
+		}
+	}
+	return
+}
+
+func actionName(action int) string {
+	switch action {
+	case GM_GET:
+		return "get"
+	case GM_LIST:
+		return "list"
+	case GM_POST:
+		return "post"
+	case GM_DELETE:
+		return "delete"
+	case GM_PATCH:
+		return "patch"
+	case GM_PUT:
+		return "put"
+	case GM_HEAD:
+		return "head"
+	case GM_RPT:
+		return "rpt"
+	}
+	return "unknown"
+}
+
+/* {{{ func (rest *REST) Roles() []string
+ * 当前请求的role, 优先SetRoleFunc, 然后是JWT claims, 最后是session(json)
+ */
+func (rest *REST) Roles() []string {
+	if roles, ok := rest.GetEnv(rolesKey).([]string); ok {
+		return roles
+	}
+	rbacMu.RLock()
+	fn, claim := roleFunc, rbacCfg.RoleClaim
+	rbacMu.RUnlock()
+	var roles []string
+	c := rest.Context()
+	if fn != nil {
+		roles = fn(rest)
+	} else if claims := c.Claims(); claims != nil {
+		roles = toRoles(claims[claim])
+	} else if sm := rest.sessionMap(); sm != nil {
+		roles = toRoles(sm[claim])
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	rest.SetEnv(rolesKey, roles)
+	return roles
+}
+
+/* }}} */
+
+/* {{{ func (rest *REST) authUserID() string
+ * RBAC中$user的值, 只来自认证过的身份: rest.SetUserID(uid), JWT(或c.SetUserID), session(json)中的user_claim
+ * 不使用客户端传入的X-WGO-UserId头(可以伪造), 取不到时为空
+ */
+func (rest *REST) authUserID() string {
+	if uid, _ := rest.GetEnv(AUTHUSER_KEY).(string); uid != "" {
+		return uid
+	}
+	if uid := rest.Context().AuthUserID(); uid != "" {
+		return uid
+	}
+	rbacMu.RLock()
+	claim := rbacCfg.UserClaim
+	rbacMu.RUnlock()
+	if sm := rest.sessionMap(); sm != nil {
+		switch uid := sm[claim].(type) {
+		case string:
+			return uid
+		case float64:
+			return strconv.FormatFloat(uid, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+/* }}} */
+
+// session的值按json解析, 不是json时为nil
+func (rest *REST) sessionMap() map[string]interface{} {
+	_, v := rest.Session()
+	var sm map[string]interface{}
+	switch sv := v.(type) {
+	case []byte:
+		json.Unmarshal(sv, &sm)
+	case string:
+		json.Unmarshal([]byte(sv), &sm)
+	case map[string]interface{}:
+		sm = sv
+	}
+	return sm
+}
+
+// 字符串(逗号分隔)或数组
+func toRoles(v interface{}) []string {
+	switch rv := v.(type) {
+	case string:
+		roles := make([]string, 0)
+		for _, r := range strings.Split(rv, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				roles = append(roles, r)
+			}
+		}
+		return roles
+	case []string:
+		return rv
+	case []interface{}:
+		roles := make([]string, 0, len(rv))
+		for _, r := range rv {
+			if s, ok := r.(string); ok && s != "" {
+				roles = append(roles, s)
+			}
+		}
+		return roles
+	}
+	return nil
+}
+
+/* {{{ func (rest *REST) Permit(action int) error
+ * 检查当前请求是否可以执行action, builtin的handler自动调用, 自定义handler可以主动调用
+ * 按顺序找第一个role匹配并且允许该action的policy, 放入env(PERMISSION_KEY), 没有时返回403
+ */
+func (rest *REST) Permit(action int) error {
+	ps := policies(rest.Endpoint())
+	c := rest.Context()
+	if len(ps) == 0 || SignedApp(c) != "" { // 没有policy或服务间调用, 不限制
+		return nil
+	}
+	roles := rest.Roles()
+	var perm *Policy
+	for _, p := range ps {
+		if p.Actions&action > 0 && matchRole(p.Role, roles, c.Authorized()) {
+			perm = p
+			break
+		}
+	}
+	if perm == nil {
+		return rest.deny(fmt.Sprintf("roles %v cannot %s %s", roles, actionName(action), rest.Endpoint()))
+	}
+	filter, ok := rest.resolveFilter(perm)
+	if !ok {
+		return rest.deny(fmt.Sprintf("cannot resolve filter of %s for %s", perm.Role, rest.Endpoint()))
+	}
+	rest.SetEnv(PERMISSION_KEY, perm)
+
+	// 行级过滤
+	switch action {
+	case GM_GET, GM_LIST, GM_HEAD, GM_RPT:
+		for _, col := range rest.Columns() {
+			if v, ok := filter[col.Tag]; ok {
+				rest.conditions = append(rest.conditions, ParseCondition(col.Type.String(), NewCondition(CTYPE_IS, col.Tag, v)))
+			}
+		}
+	case GM_PATCH, GM_PUT, GM_DELETE:
+		// 先检查原记录(返回403), 执行时filter同时作为UPDATE的条件(见rowFilter)
+		if len(filter) > 0 {
+			older := rest.GetOlder()
+			if older == nil { // 获取失败时不放行
+				c.Info("[RBAC]%s %s, older record not found", c.Method(), c.Path())
+				return rest.NotFound(ErrNoRecord)
+			}
+			if !rest.matchFilter(older, filter) {
+				return rest.deny(fmt.Sprintf("roles %v cannot %s %s/%s", roles, actionName(action), rest.Endpoint(), c.Param(RowkeyKey)))
+			}
+		}
+	}
+	return nil
+}
+
+/* }}} */
+
+// 拒绝, 记录到access log
+func (rest *REST) deny(msg string) error {
+	c := rest.Context()
+	c.Info("[RBAC]%s %s, %s", c.Method(), c.Path(), msg)
+	if ac := c.Access(); ac != nil {
+		ac.Service.Denied = msg
+	}
+	return rest.returnError(errPermissionDenied, whttp.StatusForbidden*1000+2)
+}
+
+func matchRole(role string, roles []string, authorized bool) bool {
+	switch role {
+	case RoleAny:
+		return authorized
+	case RoleGuest:
+		if !authorized {
+			return true
+		}
+	}
+	return utils.InSlice(role, roles)
+}
+
+// filter中的变量替换为实际的值, 字段必须存在, 没有认证过的当前用户时失败
+func (rest *REST) resolveFilter(p *Policy) (map[string]string, bool) {
+	if len(p.Filter) == 0 {
+		return nil, true
+	}
+	filter := make(map[string]string, len(p.Filter))
+	for field, v := range p.Filter {
+		found := false
+		for _, col := range rest.Columns() {
+			if col.Tag == field {
+				found = true
+				break
+			}
+		}
+		if !found {
+			rest.Error("[RBAC]filter field %s not found in %s", field, rest.Endpoint())
+			return nil, false
+		}
+		if v == UserVar {
+			if v = rest.authUserID(); v == "" {
+				return nil, false
+			}
+		}
+		filter[field] = v
+	}
+	return filter, true
+}
+
+// 记录是否满足filter
+func (rest *REST) matchFilter(m Model, filter map[string]string) bool {
+	v := reflect.ValueOf(m)
+	for _, col := range rest.Columns() {
+		if want, ok := filter[col.Tag]; ok {
+			fv := utils.FieldByIndex(v, col.Index)
+			if !fv.IsValid() || utils.MustString(fv) != want {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 当前请求的权限, 没有时为nil
+func (rest *REST) permission() *Policy {
+	if rest == nil || rest.Context() == nil {
+		return nil
+	}
+	if p, ok := rest.GetEnv(PERMISSION_KEY).(*Policy); ok {
+		return p
+	}
+	return nil
+}
+
+// 字段是否可读, 非数据库字段(-)不限制
+func (rest *REST) readable(col utils.StructColumn) bool {
+	if p := rest.permission(); p != nil && len(p.Read) > 0 && col.Tag != "-" {
+		return col.TagOptions.Contains(DBTAG_PK) || utils.InSlice(col.Tag, p.Read)
+	}
+	return true
+}
+
+// 字段是否可写, filter中的字段创建时由服务端赋值, 之后不可修改
+func (rest *REST) writable(col utils.StructColumn) bool {
+	p := rest.permission()
+	if p == nil || col.Tag == "-" {
+		return true
+	}
+	if _, ok := p.Filter[col.Tag]; ok {
+		return rest.Creating()
+	}
+	return len(p.Write) == 0 || col.TagOptions.Contains(DBTAG_PK) || utils.InSlice(col.Tag, p.Write)
+}
+
+// 修改/删除时附加到WHERE的行级条件, 没有时为nil
+func (rest *REST) rowFilter() (map[string]interface{}, error) {
+	p := rest.permission()
+	if p == nil || len(p.Filter) == 0 {
+		return nil, nil
+	}
+	filter, ok := rest.resolveFilter(p)
+	if !ok {
+		return nil, errPermissionDenied
+	}
+	where := make(map[string]interface{}, len(filter))
+	for f, v := range filter {
+		where[f] = v
+	}
+	return where, nil
+}
+
+// 创建时filter字段赋值(如creator为当前用户)
+func (rest *REST) applyFilter(m Model) error {
+	p := rest.permission()
+	if p == nil || len(p.Filter) == 0 {
+		return nil
+	}
+	filter, ok := rest.resolveFilter(p)
+	if !ok {
+		return errPermissionDenied
+	}
+	v := reflect.ValueOf(m)
+	for _, col := range rest.Columns() {
+		if fv, ok := filter[col.Tag]; ok {
+			if err := utils.SetWithProperType(fv, utils.FieldByIndex(v, col.Index)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package rest_test
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"wgo"
+	"wgo/jwt"
+	"wgo/rest"
+	"wgo/wgotest"
+)
+
+type Doc struct {
+	Id      *string `json:"id,omitempty" db:",pk" filter:",C,G"`
+	Title   *string `json:"title,omitempty" filter:",C"`
+	Creator *string `json:"creator,omitempty" filter:",C"`
+	Secret  *string `json:"secret,omitempty" filter:",C"`
+	State   *int    `json:"state,omitempty" db:",logic" filter:",G,D"`
+	*rest.REST
+}
+
+const rbacCfg = `
+proc_name: rbactest
+jwt:
+  keys:
+    - kid: k1
+      secret: s3cret
+`
+
+var docCols = []string{"id", "title", "creator", "secret"}
+
+func newRBACApp(t *testing.T) (*wgotest.App, *wgotest.FakeDB) {
+	db := wgotest.NewFakeDB()
+	app := wgotest.New(rbacCfg, wgotest.WithDB(db))
+	rest.AddModel((*Doc)(nil))
+	rest.SetPolicies("docs",
+		&rest.Policy{Role: "admin", Actions: rest.GM_ALL},
+		&rest.Policy{Role: "user", Actions: rest.GM_GET | rest.GM_LIST | rest.GM_POST | rest.GM_PATCH | rest.GM_DELETE,
+			Filter: map[string]string{"creator": rest.UserVar},
+			Read:   []string{"title", "creator"},
+			Write:  []string{"title"}},
+	)
+	return app, db
+}
+
+// uid为空时token中没有sub
+func bearer(t *testing.T, uid, roles string) map[string]string {
+	claims := jwt.Claims{"roles": roles}
+	if uid != "" {
+		claims["sub"] = uid
+	}
+	token, err := wgo.IssueToken(claims, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+func query(db *wgotest.FakeDB, prefix string) string {
+	for _, q := range db.Queries() {
+		if strings.HasPrefix(q.SQL, prefix) {
+			return fmt.Sprintf("%s %v", q.SQL, q.Args)
+		}
+	}
+	return ""
+}
+
+func TestRBACDeny(t *testing.T) {
+	app, _ := newRBACApp(t)
+	defer app.Close()
+	cases := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"guest", nil},
+		{"no matching role", bearer(t, "u1", "viewer")},
+		// $user只来自认证过的身份, 伪造的X-WGO-UserId头不算
+		{"spoofed user id", mergeHeaders(bearer(t, "", "user"), map[string]string{"X-WGO-UserId": "u1"})},
+		{"spoofed user id without token", map[string]string{"X-WGO-UserId": "u1"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			app.Model("docs", c.headers).List(nil).Expect(t, 403).ExpectContains(t, `"code":403002`)
+		})
+	}
+}
+
+func mergeHeaders(hs ...map[string]string) map[string]string {
+	m := map[string]string{}
+	for _, h := range hs {
+		for k, v := range h {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+func TestRBACListFilter(t *testing.T) {
+	app, db := newRBACApp(t)
+	defer app.Close()
+
+	db.Expect(`^SELECT\s+count`).Rows([]string{"count"}, []interface{}{1})
+	db.Expect(`^SELECT .* FROM .doc.`).Rows(docCols, []interface{}{"d1", "t1", "u1", "s1"})
+	app.Model("docs", bearer(t, "u1", "user")).List(url.Values{}).Expect(t, 200).ExpectContains(t, `"title":"t1"`)
+	s := query(db, "SELECT  T.")
+	if !strings.Contains(s, "T.`creator` = ?") || !strings.Contains(s, "u1") {
+		t.Errorf("list sql: %s", s)
+	}
+	// 列表只select可读字段
+	if strings.Contains(s, "`secret`") {
+		t.Errorf("secret selected: %s", s)
+	}
+
+	// admin不限制
+	db.Reset()
+	db.Expect(`^SELECT\s+count`).Rows([]string{"count"}, []interface{}{1})
+	db.Expect(`^SELECT .* FROM .doc.`).Rows(docCols, []interface{}{"d2", "t2", "u2", "s2"})
+	app.Model("docs", bearer(t, "a1", "admin")).List(url.Values{}).Expect(t, 200).ExpectContains(t, `"secret":"s2"`)
+	if s := query(db, "SELECT  T."); strings.Contains(s, "`creator` = ?") {
+		t.Errorf("admin list filtered: %s", s)
+	}
+}
+
+func TestRBACRowFilter(t *testing.T) {
+	app, db := newRBACApp(t)
+	defer app.Close()
+	docs := app.Model("docs", bearer(t, "u1", "user"))
+
+	// 别人的记录
+	db.Expect(`^SELECT .* FROM .doc.`).Rows(docCols, []interface{}{"d2", "t2", "u2", "s2"})
+	docs.Update("d2", map[string]string{"title": "x"}).Expect(t, 403)
+	docs.Delete("d2").Expect(t, 403)
+	if s := query(db, "UPDATE") + query(db, "DELETE"); s != "" {
+		t.Errorf("modified other's row: %s", s)
+	}
+
+	// 自己的记录, filter同时作为WHERE条件, 不可写的字段忽略
+	db.Reset()
+	db.Expect(`^SELECT .* FROM .doc.`).Rows(docCols, []interface{}{"d1", "t1", "u1", "s1"})
+	docs.Update("d1", map[string]string{"title": "x", "secret": "leak", "creator": "u2"}).Expect(t, 200)
+	s := query(db, "UPDATE")
+	if !strings.Contains(s, "`title`=?") || !strings.Contains(s, "`creator`") || !strings.Contains(s, "u1") {
+		t.Errorf("update sql: %s", s)
+	}
+	if strings.Contains(s, "leak") || strings.Contains(s, "u2") {
+		t.Errorf("unwritable field updated: %s", s)
+	}
+
+	db.Reset()
+	db.Expect(`^SELECT .* FROM .doc.`).Rows(docCols, []interface{}{"d1", "t1", "u1", "s1"})
+	docs.Delete("d1").Expect(t, 204)
+	if s := query(db, "UPDATE"); !strings.Contains(s, "`state`=?") || !strings.Contains(s, "`creator`=?") || !strings.Contains(s, "u1") {
+		t.Errorf("delete sql: %s", s)
+	}
+}
+
+func TestRBACFieldMask(t *testing.T) {
+	app, db := newRBACApp(t)
+	defer app.Close()
+	docs := app.Model("docs", bearer(t, "u1", "user"))
+
+	// 单条记录清空不可读的字段
+	db.Expect(`^SELECT .* FROM .doc.`).Rows(docCols, []interface{}{"d1", "t1", "u1", "s1"})
+	r := docs.Get("d1").Expect(t, 200).ExpectContains(t, `"title":"t1"`)
+	if strings.Contains(r.String(), "s1") {
+		t.Errorf("secret returned: %s", r)
+	}
+
+	// 创建时creator由服务端赋值, 不可写的字段忽略
+	db.Reset()
+	docs.Create(map[string]string{"title": "t3", "creator": "u2", "secret": "leak"}).Expect(t, 201)
+	s := query(db, "INSERT")
+	if !strings.Contains(s, "t3") || !strings.Contains(s, "u1") {
+		t.Errorf("insert sql: %s", s)
+	}
+	if strings.Contains(s, "leak") || strings.Contains(s, "u2") {
+		t.Errorf("unwritable field inserted: %s", s)
+	}
+}
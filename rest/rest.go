@@ -45,13 +45,23 @@ type REST struct {
 }
 
 func init() {
-	SetLogger(wgo.Self())
-	// wgo.Use(Init())
-	// wgo.Use(Auth())
-
-	// try register self
-	// behind SetLogger
-	RegisterConfig(wgo.Env().ProcName)
+	// 默认的wgo初始化后再读取配置, import时不触发wgo.Init
+	wgo.OnInit(func(w *wgo.WGO) {
+		SetLogger(w)
+		// wgo.Use(Init())
+		// wgo.Use(Auth())
+
+		// try register self
+		// behind SetLogger
+		RegisterConfig(w.Env().ProcName)
+		loadSessionConfig(w)
+		loadSignConfig(w)
+		loadRBACConfig(w)
+		if len(es) > 0 {
+			w.AddHealthCheck("es", esHealth)
+		}
+		serveOpenAPI(w)
+	})
 }
 
 // 新建一个REST的工厂, 闭包
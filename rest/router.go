@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"wgo"
+	"wgo/openapi"
 	"wgo/server"
 	"wgo/utils"
 	"wgo/whttp"
@@ -172,19 +173,19 @@ func (r *REST) handlerByMethod(opts ...interface{}) wgo.HandlerFunc {
 	if flag&method > 0 {
 		switch method {
 		case GM_GET:
-			return r.RESTGet()
+			return permit(GM_GET, r.RESTGet())
 		case GM_POST:
-			return r.RESTPost()
+			return permit(GM_POST, r.RESTPost())
 		case GM_DELETE:
-			return r.RESTDelete()
+			return permit(GM_DELETE, r.RESTDelete())
 		case GM_PATCH:
-			return r.RESTPatch()
+			return permit(GM_PATCH, r.RESTPatch())
 		case GM_PUT:
-			return r.RESTPut()
+			return permit(GM_PUT, r.RESTPut())
 		case GM_HEAD:
-			return r.RESTHead()
+			return permit(GM_HEAD, r.RESTHead())
 		case GM_LIST, GM_RPT:
-			return r.RESTSearch()
+			return permit(method, r.RESTSearch())
 		default:
 			return RESTDeny
 		}
@@ -192,6 +193,18 @@ func (r *REST) handlerByMethod(opts ...interface{}) wgo.HandlerFunc {
 	return RESTDeny
 }
 
+// 执行前检查权限(rbac)
+func permit(action int, h wgo.HandlerFunc) wgo.HandlerFunc {
+	return func(c *wgo.Context) error {
+		if rest := GetREST(c); rest != nil {
+			if err := rest.Permit(action); err != nil {
+				return err
+			}
+		}
+		return h(c)
+	}
+}
+
 // Func
 func (r *REST) RESTGet() wgo.HandlerFunc {
 	return func(c *wgo.Context) error {
@@ -522,6 +535,12 @@ func (rs Routes) Desc(desc string) Routes {
 	return rs.SetOptions(DescKey, desc)
 }
 
+// openapi文档注释
+func (rs Routes) Doc(doc *openapi.Doc) Routes {
+	openapi.Annotate(rs.Routes, doc)
+	return rs
+}
+
 func (rest *REST) Options(k string) interface{} {
 	if c := rest.Context(); c != nil {
 		if opt := c.Options(optionKey(k)); opt != nil {
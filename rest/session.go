@@ -2,13 +2,15 @@
 package rest
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
 	"os"
 	"strings"
 	"time"
 
 	"wgo"
 	"wgo/utils"
+	"wgo/whttp"
 )
 
 // session
@@ -18,52 +20,90 @@ type SessionConfig struct {
 	Key      string              `json:"key"`    // session key, cookie key
 	Life     int                 `json:"life"`   // session life , cookie 过期时间
 	Path     string              `json:"path"`
-	Domain   string              `json:"domain"`
-	Domains  []string            `json:"domains"`
+	Domain   string              `json:"domain"`  // 为空时cookie不带domain(只对当前host有效)
+	Domains  []string            `json:"domains"` // host匹配其中之一时使用该domain, 为空时使用domain
 	Security bool                `json:"security"`
 	HTTPOnly bool                `json:"http_only"`
 	Redis    []map[string]string `json:"redis"`
+	Store    string              `json:"store"`     // redis(默认), memory, cookie
+	Secrets  []string            `json:"secrets"`   // cookie store的密钥, 第一个用于加密, 其他只用于解密(轮换)
+	Idle     int                 `json:"idle"`      // 空闲超时(秒), 默认life
+	Absolute int                 `json:"absolute"`  // 绝对超时(秒), 从创建开始计算, 默认86400, <0不限
+	SameSite string              `json:"same_site"` // lax(默认), strict, none(自动secure), off不设置
+	CSRFKey  string              `json:"csrf_key"`  // CSRF token的cookie名, 默认csrf_token
 }
 
-var scfg *SessionConfig = new(SessionConfig)
+var (
+	scfg         *SessionConfig = new(SessionConfig)
+	sessionStore SessionStore
 
-// cache key
-func cacheKey(key string) (ck string) {
-	return fmt.Sprintf("%s:%s", scfg.Prefix, key)
+	errNoSession = errors.New("session not found")
+)
+
+/* {{{ func SetSessionStore(s SessionStore)
+ * 代码中替换session的存储
+ */
+func SetSessionStore(s SessionStore) {
+	sessionStore = s
+}
+
+/* }}} */
+
+func getSessionStore() SessionStore {
+	if sessionStore == nil {
+		sessionStore = NewRedisSessionStore(nil, scfg.Prefix)
+	}
+	return sessionStore
 }
 
 // get session
 func (rest *REST) Session(opts ...interface{}) (key string, value interface{}) {
 	c := rest.Context()
+	var token string
 	if s, err := c.Cookie(scfg.Key); err == nil { // 优先从cookie中获取sessionid, 防止客户端的攻击
-		key = s.Value()
-	} else if key = utils.PrimaryString(opts); key == "" { // 传入session key, 主动获取
+		token = s.Value()
+	} else if token = utils.PrimaryString(opts); token == "" { // 传入session key, 主动获取
 		// 没有获取到key, return
 		c.Debug("[Session]got nothing from cookie(by key %s)", scfg.Key)
 		return
 	}
 
-	var err error
 	if value = rest.GetEnv(SESSION_KEY); value != nil {
 		// 内存里找到, 返回
+		if st, ok := rest.GetEnv(SESSION_STATE_KEY).(*SessionState); ok {
+			return st.ID, value
+		}
+		return token, value
+	}
+	store := getSessionStore()
+	if st, err := loadSession(store, token); err != nil {
+		c.Warn("[Session]load failed: %s", err)
+	} else if st != nil {
+		rest.touchSession(store, st)
+		rest.SetEnv(SESSION_STATE_KEY, st)
+		rest.SaveSession(st.Value)
+		return st.ID, st.Value
+	}
+	if _, stateless := store.(*CookieSessionStore); stateless {
 		return
-	} else if value, err = RedisGet(cacheKey(key)); value != nil {
-		//c.Info("find auth from cookie(%s): %s", scfg.Key, key)
-		rest.SaveSession(value)
-	} else if client, ce := NewInnerClient("ac"); ce == nil {
-		path := "/auth/" + key
+	}
+	if client, ce := NewInnerClient("ac"); ce == nil {
+		path := "/auth/" + token
 		rest.Debug("[Session]%s query path: %s", "ac", path)
 		if resp, re := client.Get(path); re == nil && resp.Code() == 0 && len(resp.Body()) > 0 {
 			rest.Debug("[Session]ac response: %d", resp.StatusCode())
-			value = resp.Body()
-			err = re
-			RedisSet(cacheKey(key), value, 86400)
+			now := time.Now().Unix()
+			st := &SessionState{ID: token, Value: resp.Body(), Created: now, Access: now}
+			if _, err := store.Save(st, sessionTTL(st)); err != nil {
+				c.Warn("[Session]save failed: %s", err)
+			}
+			key, value = token, st.Value
 			// rest.SaveSession(value)
 		} else {
 			rest.Debug("[Session]ac response code: %d", resp.StatusCode())
 		}
 	} else {
-		c.Warn("[Session]not found auth by cookie(%s): %s", scfg.Key, err.Error())
+		c.Warn("[Session]not found auth by cookie(%s): %s", scfg.Key, ce)
 	}
 	return
 }
@@ -76,36 +116,88 @@ func (rest *REST) SaveSession(session interface{}) {
 func checkDomain(host string, domains []string, def string) string {
 	for _, domain := range domains {
 		if strings.Contains(host, domain) {
-			wgo.Info("[checkDomain]host: %s, domain: %s", host, domain)
+			wgo.Debug("[checkDomain]host: %s, domain: %s", host, domain)
 			return domain
-		} else {
-			wgo.Info("[checkDomain]failed, host: %s, domain: %s", host, domain)
 		}
 	}
 	return def
 }
 
-// set session
+/* {{{ func (rest *REST) SetSession(key string, value interface{}, opts ...interface{})
+ * 保存session并设置cookie, key为空时生成新的session id, opts[0]为true时cookie带过期时间(remember me)
+ * 请求中已经有其他session(如登录之前的)时删除, 防止session fixation, 同时更换CSRF token
+ */
 func (rest *REST) SetSession(key string, value interface{}, opts ...interface{}) {
 	ctx := rest.Context()
-	// check if host in domains
-	if domain := checkDomain(ctx.Host(), scfg.Domains, scfg.Domain); domain != "" {
-		// save to cache
-		// rest.Debug("set session, key: %s, opts: %+v", key, opts)
-		RedisSet(cacheKey(key), value, scfg.Life)
-		// expire
-		expire := time.Time{}
-		if len(opts) > 0 {
-			if remember := utils.NewParams(opts).BoolByIndex(0, false); remember {
-				expire = time.Now().Add(time.Duration(scfg.Life) * time.Second)
-			}
+	store := getSessionStore()
+	now := time.Now().Unix()
+	st := &SessionState{ID: key, Created: now, Access: now}
+	if key == "" {
+		st.ID = newSessionID()
+	}
+	if old, err := ctx.Cookie(scfg.Key); err == nil && old.Value() != "" {
+		if prev, _ := loadSession(store, old.Value()); prev != nil && prev.ID == st.ID {
+			st.Created = prev.Created // 同一个session更新值, 不改变创建时间
+		} else {
+			store.Delete(old.Value())
 		}
-		// set cookie
-		rest.Debug("set session, key: %s, path: %s, domain: %s, life: %d", key, scfg.Path, domain, scfg.Life)
-		ctx.SetCookie(wgo.NewCookie(scfg.Key, key, scfg.Path, domain, expire, scfg.Security, scfg.HTTPOnly))
 	}
+	switch v := value.(type) {
+	case []byte:
+		st.Value = v
+	case string:
+		st.Value = []byte(v)
+	default:
+		st.Value, _ = json.Marshal(value)
+	}
+	if len(opts) > 0 {
+		st.Remember = utils.NewParams(opts).BoolByIndex(0, false)
+	}
+	token, err := store.Save(st, sessionTTL(st))
+	if err != nil {
+		rest.Warn("[SetSession]save failed: %s", err)
+		return
+	}
+	rest.Debug("set session, key: %s, path: %s, life: %d", st.ID, scfg.Path, scfg.Idle)
+	rest.setSessionCookie(token, st)
+	rest.SetEnv(SESSION_STATE_KEY, st)
+	rest.SaveSession(st.Value)
+	SetCSRFToken(ctx)
+}
+
+/* }}} */
+
+/* {{{ func (rest *REST) RotateSession() (string, error)
+ * 更换session id(如权限变化), 值及创建时间不变, 旧的session删除, 同时更换CSRF token, 返回新的id
+ */
+func (rest *REST) RotateSession() (string, error) {
+	c := rest.Context()
+	ck, err := c.Cookie(scfg.Key)
+	if err != nil || ck.Value() == "" {
+		return "", errNoSession
+	}
+	store := getSessionStore()
+	st, err := loadSession(store, ck.Value())
+	if err != nil {
+		return "", err
+	} else if st == nil {
+		return "", errNoSession
+	}
+	st.ID, st.Access = newSessionID(), time.Now().Unix()
+	token, err := store.Save(st, sessionTTL(st))
+	if err != nil {
+		return "", err
+	}
+	store.Delete(ck.Value())
+	rest.setSessionCookie(token, st)
+	rest.SetEnv(SESSION_STATE_KEY, st)
+	rest.SaveSession(st.Value)
+	SetCSRFToken(c)
+	return st.ID, nil
 }
 
+/* }}} */
+
 // del session
 func (rest *REST) DelSession(opts ...interface{}) (key string) {
 	c := rest.Context()
@@ -115,15 +207,81 @@ func (rest *REST) DelSession(opts ...interface{}) (key string) {
 		return
 	}
 
-	RedisDel(cacheKey(key))
-	c.SetCookie(wgo.NewCookie(scfg.Key, "", scfg.Path, scfg.Domain, time.Now().Add(time.Duration(scfg.Life)*time.Second), scfg.Security, scfg.HTTPOnly))
+	getSessionStore().Delete(key)
+	setCookie(c, scfg.Key, "", time.Unix(0, 0), scfg.HTTPOnly)
+	setCookie(c, scfg.csrfKey(), "", time.Unix(0, 0), false)
+	rest.SetEnv(SESSION_STATE_KEY, nil)
+	rest.SetEnv(SESSION_KEY, nil)
 
 	return
 }
 
-// 鉴权+session, 包括cs,ss
-func Auth() wgo.MiddlewareFunc {
-	if err := wgo.Cfg().AppConfig(scfg, "session"); err != nil {
+// 读取session并检查超时, 超时的删除
+func loadSession(store SessionStore, token string) (*SessionState, error) {
+	st, err := store.Load(token)
+	if err != nil || st == nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if now-st.Access >= int64(scfg.Idle) || (scfg.Absolute > 0 && now-st.Created >= int64(scfg.Absolute)) {
+		store.Delete(token)
+		return nil, nil
+	}
+	return st, nil
+}
+
+// 存储的有效期, idle与absolute剩余时间中较小的
+func sessionTTL(st *SessionState) time.Duration {
+	ttl := int64(scfg.Idle)
+	if scfg.Absolute > 0 {
+		if left := st.Created + int64(scfg.Absolute) - time.Now().Unix(); left < ttl {
+			ttl = left
+		}
+	}
+	if ttl < 1 {
+		ttl = 1
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// 更新最后访问时间, 每idle/10最多一次, 避免每个请求都写
+func (rest *REST) touchSession(store SessionStore, st *SessionState) {
+	now := time.Now().Unix()
+	if now-st.Access < int64(scfg.Idle/10) {
+		return
+	}
+	st.Access = now
+	token, err := store.Save(st, sessionTTL(st))
+	if err != nil {
+		rest.Warn("[touchSession]save failed: %s", err)
+		return
+	}
+	rest.setSessionCookie(token, st)
+}
+
+// session cookie, remember时带过期时间
+func (rest *REST) setSessionCookie(token string, st *SessionState) {
+	expire := time.Time{}
+	if st.Remember {
+		expire = time.Now().Add(sessionTTL(st))
+	}
+	setCookie(rest.Context(), scfg.Key, token, expire, scfg.HTTPOnly)
+}
+
+// 按配置(path, domain, secure, samesite)设置cookie
+func setCookie(c *wgo.Context, name, value string, expire time.Time, httpOnly bool) {
+	domain := checkDomain(c.Host(), scfg.Domains, scfg.Domain)
+	ck := wgo.NewCookie(name, value, scfg.Path, domain, expire, scfg.Security, httpOnly)
+	if scfg.SameSite != "off" {
+		ck.SetSameSite(scfg.SameSite)
+	}
+	c.SetCookie(ck)
+}
+
+// 读取session配置, 默认的wgo初始化时调用(见rest.go init)
+func loadSessionConfig(w *wgo.WGO) {
+	*scfg = SessionConfig{}
+	if err := w.Cfg().AppConfig(scfg, "session"); err != nil {
 		Info("not found session scfg")
 	}
 	if scfg.Prefix == "" {
@@ -137,6 +295,15 @@ func Auth() wgo.MiddlewareFunc {
 	if scfg.Life == 0 {
 		scfg.Life = 1800
 	}
+	if scfg.Idle <= 0 {
+		scfg.Idle = scfg.Life
+	}
+	if scfg.Absolute == 0 {
+		scfg.Absolute = 86400
+	}
+	if scfg.SameSite == "" {
+		scfg.SameSite = "lax"
+	}
 	if scfg.Path == "" {
 		scfg.Path = "/"
 	}
@@ -144,14 +311,10 @@ func Auth() wgo.MiddlewareFunc {
 		// 可通过环境参数传入, for local/develop env
 		scfg.Domain = sd
 		scfg.Domains = []string{sd}
-	} else if scfg.Domain == "" {
-		scfg.Domain = ".gladsheim.cn"
 	}
 	if sds := os.Getenv(AECK_SESSION_DOMAINS); sds != "" {
 		// 可通过环境参数传入, for local/develop env
-		scfg.Domains = strings.Split(",", sds)
-	} else if len(scfg.Domains) <= 0 {
-		scfg.Domains = []string{".adchina.io"}
+		scfg.Domains = strings.Split(sds, ",")
 	}
 	// get storage
 	if tc := os.Getenv(AECK_REDIS_ADDR); tc != "" {
@@ -162,15 +325,46 @@ func Auth() wgo.MiddlewareFunc {
 		// 如果设置了redis信息, 则使用(session使用的storage可与底层wgo不同)
 		OpenRedis(scfg)
 	}
+	// session store
+	switch scfg.Store {
+	case "memory":
+		SetSessionStore(NewMemorySessionStore())
+	case "cookie":
+		if cs, err := NewCookieSessionStore(scfg.Secrets...); err != nil {
+			Error("[loadSessionConfig]cookie store: %s", err)
+		} else {
+			SetSessionStore(cs)
+		}
+	default:
+		SetSessionStore(NewRedisSessionStore(nil, scfg.Prefix))
+	}
+}
+
+// 鉴权+session, 包括cs,ss
+func Auth() wgo.MiddlewareFunc {
 	return func(next wgo.HandlerFunc) wgo.HandlerFunc {
 		return func(c *wgo.Context) (err error) {
 
 			c.Debug("[REST.Auth]-->%s<--", c.Query())
-			// cs用户端访问鉴权
-			if k, v := GetREST(c).Session(); k != "" && v != nil {
-				c.Authorize() // 授权
-			} else {
-				// TODO, server端访问鉴权
+			rest := GetREST(c)
+			// server端访问鉴权, 带签名的请求校验签名, Free()的路由不校验
+			if !rest.IsFree() {
+				app, err := verifySign(c)
+				switch {
+				case err == nil:
+					c.Debug("[REST.Auth]signed by app: %s", app)
+					return next(c)
+				case err != errNotSigned:
+					return err
+				case rest.IsInner(): // 只能内部访问
+					return c.NewError(whttp.StatusForbidden*1000, "inner api")
+				}
+			}
+			// cs用户端访问鉴权, 已经通过其他方式认证(如JWT)时跳过
+			if !c.Authorized() {
+				if k, v := rest.Session(); k != "" && v != nil {
+					c.Authorize() // 授权
+				}
 			}
 
 			return next(c)
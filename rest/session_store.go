@@ -0,0 +1,254 @@
+//
+// session_store.go
+// session的存储: redis(storage), 内存, 加密的cookie(不需要服务端状态)
+// 超时(idle/absolute)由rest统一检查, store只负责存取
+//
+
+package rest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"wgo/storage"
+	"wgo/storage/core"
+)
+
+const maxCookieSize = 4000 // 浏览器限制4096, 留出name及属性
+
+var (
+	b64 = base64.RawURLEncoding
+
+	errCookieTooLarge = errors.New("session: cookie too large")
+)
+
+type (
+	// SessionState 一个session, Value为SetSession时的值(string/[]byte原样, 其他json)
+	SessionState struct {
+		ID       string `json:"id"`
+		Value    []byte `json:"v"`
+		Created  int64  `json:"c"` // 创建时间(unix), 用于absolute timeout
+		Access   int64  `json:"a"` // 最后访问时间(unix), 用于idle timeout
+		Remember bool   `json:"r"` // cookie是否带过期时间
+	}
+
+	// SessionStore session的存储, token为cookie中的值(redis/memory为session id, cookie为加密之后的state)
+	SessionStore interface {
+		Load(token string) (*SessionState, error) // 不存在返回nil, nil
+		Save(s *SessionState, ttl time.Duration) (token string, err error)
+		Delete(token string) error
+	}
+
+	// RedisSessionStore 基于storage, 默认使用rest的storage
+	RedisSessionStore struct {
+		Storage *storage.Storage
+		Prefix  string
+	}
+
+	// MemorySessionStore 本地内存, 只适用于单实例
+	MemorySessionStore struct {
+		mu    sync.Mutex
+		m     map[string]*memSession
+		swept time.Time
+	}
+	memSession struct {
+		b   []byte
+		exp time.Time
+	}
+
+	// CookieSessionStore state加密(AES-GCM)后放在cookie中, 第一个secret用于加密, 其他的用于解密(轮换)
+	// 没有服务端状态, Delete只能清除cookie, 无法吊销已经泄露的cookie
+	CookieSessionStore struct {
+		aeads []cipher.AEAD
+	}
+)
+
+// 新的session id, 256位随机数
+func newSessionID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b64.EncodeToString(b)
+}
+
+/* {{{ RedisSessionStore
+ *
+ */
+func NewRedisSessionStore(st *storage.Storage, prefix string) *RedisSessionStore {
+	return &RedisSessionStore{Storage: st, Prefix: prefix}
+}
+
+func (rs *RedisSessionStore) storage() *storage.Storage {
+	if rs.Storage != nil {
+		return rs.Storage
+	}
+	return restStorage()
+}
+
+func (rs *RedisSessionStore) key(id string) string {
+	return rs.Prefix + ":" + id
+}
+
+func (rs *RedisSessionStore) Load(token string) (*SessionState, error) {
+	st := rs.storage()
+	if st == nil {
+		return nil, errors.New("session: not found storage")
+	}
+	v := st.Get(rs.key(token))
+	if v == nil {
+		return nil, nil
+	}
+	return decodeState(token, []byte(core.GetString(v))), nil
+}
+
+func (rs *RedisSessionStore) Save(s *SessionState, ttl time.Duration) (string, error) {
+	st := rs.storage()
+	if st == nil {
+		return "", errors.New("session: not found storage")
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return s.ID, st.Put(rs.key(s.ID), b, ttl)
+}
+
+// 同步删除(storage.Delete是异步的), 登出/轮换之后旧的id立即失效
+func (rs *RedisSessionStore) Delete(token string) error {
+	if st := rs.storage(); st != nil {
+		k := rs.key(token)
+		_, err := st.Do(k, "DEL", k)
+		return err
+	}
+	return nil
+}
+
+/* }}} */
+
+// 之前直接保存的值(没有state)作为刚创建的session
+func decodeState(id string, b []byte) *SessionState {
+	s := &SessionState{}
+	if err := json.Unmarshal(b, s); err != nil || s.Created == 0 || s.ID != id {
+		now := time.Now().Unix()
+		s = &SessionState{ID: id, Value: b, Created: now, Access: now}
+	}
+	return s
+}
+
+/* {{{ MemorySessionStore
+ *
+ */
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{m: make(map[string]*memSession)}
+}
+
+func (ms *MemorySessionStore) Load(token string) (*SessionState, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if e, ok := ms.m[token]; ok && time.Now().Before(e.exp) {
+		s := &SessionState{}
+		return s, json.Unmarshal(e.b, s)
+	}
+	return nil, nil
+}
+
+func (ms *MemorySessionStore) Save(s *SessionState, ttl time.Duration) (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if now.Sub(ms.swept) > time.Minute {
+		ms.swept = now
+		for k, e := range ms.m {
+			if now.After(e.exp) {
+				delete(ms.m, k)
+			}
+		}
+	}
+	ms.m[s.ID] = &memSession{b: b, exp: now.Add(ttl)}
+	return s.ID, nil
+}
+
+func (ms *MemorySessionStore) Delete(token string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.m, token)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ CookieSessionStore
+ *
+ */
+func NewCookieSessionStore(secrets ...string) (*CookieSessionStore, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("session: cookie store needs a secret")
+	}
+	cs := &CookieSessionStore{}
+	for _, secret := range secrets {
+		// secret任意长度, sha256之后作为AES-256的key
+		k := sha256.Sum256([]byte(secret))
+		block, err := aes.NewCipher(k[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		cs.aeads = append(cs.aeads, aead)
+	}
+	return cs, nil
+}
+
+// 解密失败(篡改, secret已经移除)当作不存在
+func (cs *CookieSessionStore) Load(token string) (*SessionState, error) {
+	b, err := b64.DecodeString(token)
+	if err != nil {
+		return nil, nil
+	}
+	for _, aead := range cs.aeads {
+		ns := aead.NonceSize()
+		if len(b) < ns {
+			return nil, nil
+		}
+		if pt, err := aead.Open(nil, b[:ns], b[ns:], nil); err == nil {
+			s := &SessionState{}
+			return s, json.Unmarshal(pt, s)
+		}
+	}
+	return nil, nil
+}
+
+func (cs *CookieSessionStore) Save(s *SessionState, _ time.Duration) (string, error) {
+	pt, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	aead := cs.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	token := b64.EncodeToString(aead.Seal(nonce, nonce, pt, nil))
+	if len(token) > maxCookieSize {
+		return "", errCookieTooLarge
+	}
+	return token, nil
+}
+
+func (cs *CookieSessionStore) Delete(string) error { return nil }
+
+/* }}} */
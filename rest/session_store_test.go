@@ -0,0 +1,259 @@
+package rest_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"wgo"
+	"wgo/rest"
+	"wgo/wgotest"
+)
+
+type Sess struct {
+	Id *string `json:"id,omitempty" db:",pk" filter:",C,G"`
+	*rest.REST
+}
+
+const sessionCfg = `
+proc_name: sessiontest
+session:
+  key: sid
+  http_only: true
+  store: memory
+  idle: 600
+  absolute: 3600
+`
+
+func cookies(r *wgotest.Response) map[string]*http.Cookie {
+	m := map[string]*http.Cookie{}
+	for _, c := range (&http.Response{Header: r.Header}).Cookies() {
+		m[c.Name] = c
+	}
+	return m
+}
+
+func sid(v string) map[string]string {
+	return map[string]string{"Cookie": "sid=" + v}
+}
+
+// 登录/查看/轮换/登出的路由, 返回[session id, 值]
+func newSessionApp(t *testing.T, cfg string) *wgotest.App {
+	app := wgotest.New(cfg)
+	h := func(fn func(*rest.REST) []string) wgo.HandlerFunc {
+		return func(c *wgo.Context) error {
+			return c.JSON(200, fn(rest.GetREST(c)))
+		}
+	}
+	s := rest.Register("sess", (*Sess)(nil), 0)
+	s.Add("POST", "/login", h(func(r *rest.REST) []string {
+		r.SetSession("", "u1")
+		k, v := r.Session()
+		return []string{k, fmt.Sprintf("%s", v)}
+	})).Free()
+	s.Add("GET", "/me", h(func(r *rest.REST) []string {
+		k, v := r.Session()
+		if v == nil {
+			return []string{k, ""}
+		}
+		return []string{k, fmt.Sprintf("%s", v)}
+	})).Free()
+	s.Add("POST", "/rotate", h(func(r *rest.REST) []string {
+		id, err := r.RotateSession()
+		return []string{id, fmt.Sprint(err)}
+	})).Free()
+	s.Add("GET", "/authed", h(func(r *rest.REST) []string {
+		return []string{fmt.Sprint(r.Context().Authorized())}
+	})).Free()
+	return app
+}
+
+func TestCookieSessionStore(t *testing.T) {
+	if _, err := rest.NewCookieSessionStore(); err == nil {
+		t.Errorf("cookie store without secret")
+	}
+	old, _ := rest.NewCookieSessionStore("k1")
+	rotated, _ := rest.NewCookieSessionStore("k2", "k1")
+	other, _ := rest.NewCookieSessionStore("k3")
+	st := &rest.SessionState{ID: "s1", Value: []byte(`{"email":"alice@example.com"}`), Created: 100, Access: 200, Remember: true}
+
+	token, err := old.Save(st, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(token, "alice") || strings.Contains(token, "email") {
+		t.Errorf("token not sealed: %s", token)
+	}
+	if again, _ := old.Save(st, time.Minute); again == token {
+		t.Errorf("nonce reused")
+	}
+	newToken, _ := rotated.Save(st, time.Minute)
+	// 改动中间的一个字符
+	b := []byte(token)
+	if i := len(b) / 2; b[i] == 'A' {
+		b[i] = 'B'
+	} else {
+		b[i] = 'A'
+	}
+	tampered := string(b)
+
+	cases := []struct {
+		name  string
+		store *rest.CookieSessionStore
+		token string
+		ok    bool
+	}{
+		{"same secret", old, token, true},
+		{"rotated, old secret still decrypts", rotated, token, true},
+		{"rotated, new secret", rotated, newToken, true},
+		{"new token with old store", old, newToken, false},
+		{"removed secret", other, token, false},
+		{"tampered", old, tampered, false},
+		{"truncated", old, token[:8], false},
+		{"not base64", old, "***", false},
+		{"empty", old, "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.store.Load(c.token)
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if !c.ok {
+				if got != nil {
+					t.Errorf("should not load: %+v", got)
+				}
+				return
+			}
+			if got == nil || got.ID != st.ID || string(got.Value) != string(st.Value) ||
+				got.Created != st.Created || got.Access != st.Access || !got.Remember {
+				t.Errorf("loaded: %+v", got)
+			}
+		})
+	}
+
+	big := &rest.SessionState{ID: "s2", Value: []byte(strings.Repeat("x", 4000))}
+	if _, err := old.Save(big, time.Minute); err == nil {
+		t.Errorf("cookie too large should fail")
+	}
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	ms := rest.NewMemorySessionStore()
+	st := &rest.SessionState{ID: "m1", Value: []byte("v"), Created: 1, Access: 2}
+	if token, err := ms.Save(st, time.Minute); err != nil || token != "m1" {
+		t.Fatalf("save: %s %v", token, err)
+	}
+	if got, _ := ms.Load("m1"); got == nil || string(got.Value) != "v" {
+		t.Errorf("load: %+v", got)
+	}
+	ms.Save(&rest.SessionState{ID: "m2"}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if got, _ := ms.Load("m2"); got != nil {
+		t.Errorf("expired: %+v", got)
+	}
+	ms.Delete("m1")
+	if got, _ := ms.Load("m1"); got != nil {
+		t.Errorf("deleted: %+v", got)
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	app := newSessionApp(t, sessionCfg)
+	defer app.Close()
+	ms := rest.NewMemorySessionStore()
+	rest.SetSessionStore(ms)
+
+	now := time.Now()
+	ago := func(d time.Duration) int64 { return now.Add(-d).Unix() }
+	cases := []struct {
+		name    string
+		created int64
+		access  int64
+		valid   bool
+	}{
+		{"fresh", ago(0), ago(0), true},
+		{"idle within", ago(time.Hour - time.Minute), ago(9 * time.Minute), true},
+		{"idle exceeded", ago(20 * time.Minute), ago(11 * time.Minute), false},
+		{"absolute exceeded", ago(time.Hour + time.Second), ago(0), false},
+	}
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			id := fmt.Sprintf("t%d", i)
+			ms.Save(&rest.SessionState{ID: id, Value: []byte("u1"), Created: c.created, Access: c.access}, time.Hour)
+			r := app.GET("/sess/me", sid(id)).Expect(t, 200)
+			if got := strings.Contains(r.String(), `"u1"`); got != c.valid {
+				t.Errorf("valid: %v, want %v, %s", got, c.valid, r)
+			}
+			if st, _ := ms.Load(id); (st != nil) != c.valid {
+				t.Errorf("expired session should be deleted: %+v", st)
+			}
+		})
+	}
+
+	// 超过idle/10之后访问会更新access
+	ms.Save(&rest.SessionState{ID: "touch", Value: []byte("u1"), Created: ago(time.Minute), Access: ago(2 * time.Minute)}, time.Hour)
+	app.GET("/sess/me", sid("touch")).Expect(t, 200)
+	if st, _ := ms.Load("touch"); st == nil || st.Access < now.Unix() {
+		t.Errorf("access not refreshed: %+v", st)
+	}
+}
+
+func TestSessionRotation(t *testing.T) {
+	app := newSessionApp(t, sessionCfg)
+	defer app.Close()
+	ms := rest.NewMemorySessionStore()
+	rest.SetSessionStore(ms)
+
+	// 登录之前的session(可能被攻击者植入)在登录时删除, 登录后是新的id
+	ms.Save(&rest.SessionState{ID: "planted", Value: []byte("anon"), Created: time.Now().Unix(), Access: time.Now().Unix()}, time.Hour)
+	r := app.POST("/sess/login", "", sid("planted")).Expect(t, 200)
+	ck := cookies(r)
+	login := ck["sid"]
+	if login == nil || login.Value == "planted" || !login.HttpOnly {
+		t.Fatalf("login cookie: %v", r.Header["Set-Cookie"])
+	}
+	if ck["csrf_token"] == nil || ck["csrf_token"].Value == "" {
+		t.Errorf("csrf token not renewed on login")
+	}
+	if st, _ := ms.Load("planted"); st != nil {
+		t.Errorf("pre-login session not deleted")
+	}
+	app.GET("/sess/me", sid(login.Value)).Expect(t, 200).ExpectContains(t, `"u1"`)
+
+	// 轮换: 新id, 值及创建时间不变, 旧id失效
+	before, _ := ms.Load(login.Value)
+	r = app.POST("/sess/rotate", "", sid(login.Value)).Expect(t, 200)
+	rotated := cookies(r)["sid"]
+	if rotated == nil || rotated.Value == login.Value {
+		t.Fatalf("rotate cookie: %v", r.Header["Set-Cookie"])
+	}
+	after, _ := ms.Load(rotated.Value)
+	if after == nil || string(after.Value) != "u1" || after.Created != before.Created {
+		t.Errorf("rotated state: %+v, before %+v", after, before)
+	}
+	if st, _ := ms.Load(login.Value); st != nil {
+		t.Errorf("old id still valid")
+	}
+	app.GET("/sess/me", sid(login.Value)).Expect(t, 200).ExpectContains(t, `["",""]`)
+	app.POST("/sess/rotate", "").Expect(t, 200).ExpectContains(t, "session not found")
+}
+
+// Free()的路由只跳过签名校验, session仍然加载并授权
+func TestAuthFreeRoute(t *testing.T) {
+	app := newSessionApp(t, sessionCfg)
+	defer app.Close()
+	ms := rest.NewMemorySessionStore()
+	rest.SetSessionStore(ms)
+	now := time.Now().Unix()
+	ms.Save(&rest.SessionState{ID: "f1", Value: []byte("u1"), Created: now, Access: now}, time.Hour)
+
+	app.GET("/sess/authed").Expect(t, 200).ExpectContains(t, `["false"]`)
+	app.GET("/sess/authed", sid("f1")).Expect(t, 200).ExpectContains(t, `["true"]`)
+	// 错误的签名不影响
+	h := signed("GET", "/sess/authed", "", "wrong", time.Now(), "n1")
+	h["Cookie"] = "sid=f1"
+	app.GET("/sess/authed", h).Expect(t, 200).ExpectContains(t, `["true"]`)
+}
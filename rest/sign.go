@@ -0,0 +1,288 @@
+//
+// sign.go
+// 服务之间调用的签名(HMAC-SHA256), 签名内容: method, uri(path+query), body的sha256, 时间戳, nonce, app id
+// 调用方(NewInnerClient, 或NewClient传入有secret的app)自动签名, 服务端在Auth或VerifySign中校验
+//
+
+package rest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wgo"
+	"wgo/resty"
+	"wgo/utils"
+	"wgo/whttp"
+	"wgo/whttp/standard"
+)
+
+const (
+	signVerifiedKey = "_sign_verified_" // context中保存校验通过的app id
+	nonceKeyPrefix  = "sign:nonce:"
+	defaultSkew     = 5 * time.Minute
+)
+
+type (
+	// SignApp 一个app及其secret
+	SignApp struct {
+		ID     string `mapstructure:"id"`
+		Secret string `mapstructure:"secret"`
+	}
+
+	// SignConfig 配置中的rest.sign部分
+	SignConfig struct {
+		App    string        `mapstructure:"app"`    // 本服务调用其他服务时的app id, 默认proc_name
+		Secret string        `mapstructure:"secret"` // 本服务的secret, 为空时从apps中找
+		Skew   time.Duration `mapstructure:"skew"`   // 允许的时钟误差, 默认5m, nonce保留2倍时间
+		Apps   []*SignApp    `mapstructure:"apps"`   // 允许调用本服务的app
+	}
+)
+
+var (
+	scMu       sync.RWMutex
+	signCfg    = &SignConfig{Skew: defaultSkew}
+	nonceCache = &nonces{m: make(map[string]time.Time)}
+
+	errNotSigned = fmt.Errorf("request not signed")
+)
+
+// 读取签名配置, 默认的wgo初始化时调用(见rest.go init)
+func loadSignConfig(w *wgo.WGO) {
+	cfg := &SignConfig{}
+	if w.Cfg().IsSet(AECK_SIGN) {
+		if err := w.Cfg().UnmarshalKey(AECK_SIGN, cfg); err != nil {
+			Error("[loadSignConfig]invalid config: %s", err)
+		}
+	}
+	if cfg.App == "" {
+		cfg.App = w.Env().ProcName
+	}
+	if cfg.Skew <= 0 {
+		cfg.Skew = defaultSkew
+	}
+	scMu.Lock()
+	signCfg = cfg
+	scMu.Unlock()
+}
+
+/* {{{ func SetSignApp(id, secret string)
+ * 代码中添加(或修改)允许调用的app
+ */
+func SetSignApp(id, secret string) {
+	scMu.Lock()
+	defer scMu.Unlock()
+	for _, a := range signCfg.Apps {
+		if a.ID == id {
+			a.Secret = secret
+			return
+		}
+	}
+	signCfg.Apps = append(signCfg.Apps, &SignApp{ID: id, Secret: secret})
+}
+
+/* }}} */
+
+// app的secret
+func signSecret(app string) string {
+	scMu.RLock()
+	defer scMu.RUnlock()
+	if app == signCfg.App && signCfg.Secret != "" {
+		return signCfg.Secret
+	}
+	for _, a := range signCfg.Apps {
+		if a.ID == app {
+			return a.Secret
+		}
+	}
+	return ""
+}
+
+// 本服务的app id
+func selfApp() string {
+	scMu.RLock()
+	defer scMu.RUnlock()
+	return signCfg.App
+}
+
+func signSkew() time.Duration {
+	scMu.RLock()
+	defer scMu.RUnlock()
+	return signCfg.Skew
+}
+
+/* {{{ func Sign(secret, method, uri string, body []byte, ts, nonce, app string) string
+ * 签名, 返回hex
+ */
+func Sign(secret, method, uri string, body []byte, ts, nonce, app string) string {
+	bh := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{strings.ToUpper(method), uri, hex.EncodeToString(bh[:]), ts, nonce, app}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/* }}} */
+
+// resty的pre-request hook, 请求已经生成(包括query及body)
+func signRequest(app, secret string) func(*resty.Client, *resty.Request) error {
+	return func(_ *resty.Client, r *resty.Request) error {
+		req := r.RawRequest
+		var body []byte
+		if req.GetBody != nil {
+			rc, err := req.GetBody()
+			if err != nil {
+				return err
+			}
+			if body, err = ioutil.ReadAll(rc); err != nil {
+				return err
+			}
+		} else if req.Body != nil {
+			return fmt.Errorf("sign: body not replayable")
+		}
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := utils.FastRequestId(16)
+		req.Header.Set(whttp.HeaderXAppId, app)
+		req.Header.Set(whttp.HeaderXTimestamp, ts)
+		req.Header.Set(whttp.HeaderXNonce, nonce)
+		req.Header.Set(whttp.HeaderXSignature, Sign(secret, req.Method, req.URL.RequestURI(), body, ts, nonce, app))
+		return nil
+	}
+}
+
+/* {{{ func VerifySign() wgo.MiddlewareFunc
+ * 校验签名的middleware(非REST的路由使用), 没有签名的请求放行, 签名错误返回401
+ */
+func VerifySign() wgo.MiddlewareFunc {
+	return func(next wgo.HandlerFunc) wgo.HandlerFunc {
+		return func(c *wgo.Context) error {
+			if _, err := verifySign(c); err != nil && err != errNotSigned {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+/* }}} */
+
+// 请求的签名是否已经校验通过, 返回调用方的app id
+func SignedApp(c *wgo.Context) string {
+	if app, ok := c.Get(signVerifiedKey).(string); ok {
+		return app
+	}
+	return ""
+}
+
+/* {{{ func verifySign(c *wgo.Context) (string, error)
+ * 校验签名, 时间误差及nonce重放, 通过后Authorize, 没有签名返回errNotSigned
+ */
+func verifySign(c *wgo.Context) (string, error) {
+	if app := SignedApp(c); app != "" {
+		return app, nil
+	}
+	h := c.RequestHeader()
+	sig := h.Get(whttp.HeaderXSignature)
+	if sig == "" {
+		return "", errNotSigned
+	}
+	app, ts, nonce := h.Get(whttp.HeaderXAppId), h.Get(whttp.HeaderXTimestamp), h.Get(whttp.HeaderXNonce)
+	if app == "" || ts == "" || nonce == "" {
+		return "", c.NewError(whttp.StatusUnauthorized*1000+1, "incomplete signature")
+	}
+	secret := signSecret(app)
+	if secret == "" {
+		c.Info("[verifySign]unknown app: %s", app)
+		return "", c.NewError(whttp.StatusUnauthorized*1000+2, "unknown app")
+	}
+	skew := signSkew()
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if d := time.Since(time.Unix(t, 0)); err != nil || d > skew || d < -skew {
+		return "", c.NewError(whttp.StatusUnauthorized*1000+3, "timestamp out of range")
+	}
+	body, err := readBody(c)
+	if err != nil {
+		return "", err
+	}
+	req := c.Request().(whttp.Request)
+	expected := Sign(secret, req.Method(), req.URI(), body, ts, nonce, app)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(sig))) {
+		c.Info("[verifySign]app: %s, bad signature", app)
+		return "", c.NewError(whttp.StatusUnauthorized*1000+4, "invalid signature")
+	}
+	if replayed, err := useNonce(app, nonce, 2*skew); err != nil {
+		c.Warn("[verifySign]check nonce failed: %s", err)
+		return "", err
+	} else if replayed {
+		c.Info("[verifySign]app: %s, replayed nonce: %s", app, nonce)
+		return "", c.NewError(whttp.StatusUnauthorized*1000+5, "replayed request")
+	}
+	c.Set(signVerifiedKey, app)
+	c.Authorize()
+	return app, nil
+}
+
+/* }}} */
+
+// 读取body并放回(standard的body只能读一次)
+func readBody(c *wgo.Context) ([]byte, error) {
+	req := c.Request().(whttp.Request)
+	if req.Body() == nil {
+		return nil, nil
+	}
+	b, err := ioutil.ReadAll(req.Body())
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if _, ok := req.(*standard.Request); ok {
+		req.SetBody(bytes.NewReader(b))
+	}
+	return b, nil
+}
+
+// 记录nonce, 已经用过返回true, 有storage时多实例共享(SET NX EX, 没有写入即为重放)
+func useNonce(app, nonce string, ttl time.Duration) (bool, error) {
+	key := nonceKeyPrefix + app + ":" + nonce
+	if st := restStorage(); st != nil {
+		r, err := st.Do(key, "SET", key, 1, "NX", "EX", int(ttl/time.Second)+1)
+		if err != nil {
+			return false, err
+		}
+		return r == nil, nil
+	}
+	return nonceCache.use(key, ttl), nil
+}
+
+// 没有storage时使用本地的nonce缓存
+type nonces struct {
+	mu    sync.Mutex
+	m     map[string]time.Time
+	swept time.Time
+}
+
+func (ns *nonces) use(key string, ttl time.Duration) bool {
+	now := time.Now()
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if now.Sub(ns.swept) > ttl {
+		ns.swept = now
+		for k, exp := range ns.m {
+			if now.After(exp) {
+				delete(ns.m, k)
+			}
+		}
+	}
+	if exp, ok := ns.m[key]; ok && now.Before(exp) {
+		return true
+	}
+	ns.m[key] = now.Add(ttl)
+	return false
+}
@@ -0,0 +1,103 @@
+package rest_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"wgo"
+	"wgo/rest"
+	"wgo/storage"
+	"wgo/wgotest"
+)
+
+const signCfg = `
+proc_name: signtest
+rest:
+  sign:
+    skew: 1m
+    apps:
+      - id: caller
+        secret: s3cret
+`
+
+func signed(method, uri, body, secret string, ts time.Time, nonce string) map[string]string {
+	s := strconv.FormatInt(ts.Unix(), 10)
+	return map[string]string{
+		"X-WGO-AppId":     "caller",
+		"X-WGO-Timestamp": s,
+		"X-WGO-Nonce":     nonce,
+		"X-WGO-Signature": rest.Sign(secret, method, uri, []byte(body), s, nonce, "caller"),
+	}
+}
+
+func newSignApp(t *testing.T, st *storage.Storage) *wgotest.App {
+	var opts []wgotest.Option
+	if st != nil {
+		opts = append(opts, wgotest.WithStorage(st))
+	}
+	app := wgotest.New(signCfg, opts...)
+	wgo.POST("/v", func(c *wgo.Context) error {
+		return c.JSON(200, map[string]interface{}{"app": rest.SignedApp(c), "auth": c.Authorized()})
+	}, rest.VerifySign())
+	return app
+}
+
+func TestVerifySign(t *testing.T) {
+	app := newSignApp(t, wgotest.NewStorage())
+	defer app.Close()
+	now := time.Now()
+	body := `{"x":1}`
+	cases := []struct {
+		name    string
+		path    string
+		body    string
+		headers map[string]string
+		code    int
+		errCode string
+	}{
+		{"valid", "/v?a=1", body, signed("POST", "/v?a=1", body, "s3cret", now, "n1"), 200, ""},
+		{"skew within", "/v", body, signed("POST", "/v", body, "s3cret", now.Add(-50*time.Second), "n2"), 200, ""},
+		{"skew future within", "/v", body, signed("POST", "/v", body, "s3cret", now.Add(50*time.Second), "n3"), 200, ""},
+		{"skew past", "/v", body, signed("POST", "/v", body, "s3cret", now.Add(-2*time.Minute), "n4"), 401, "401003"},
+		{"skew future", "/v", body, signed("POST", "/v", body, "s3cret", now.Add(2*time.Minute), "n5"), 401, "401003"},
+		{"tampered body", "/v", `{"x":2}`, signed("POST", "/v", body, "s3cret", now, "n6"), 401, "401004"},
+		{"tampered query", "/v?a=2", body, signed("POST", "/v?a=1", body, "s3cret", now, "n7"), 401, "401004"},
+		{"wrong secret", "/v", body, signed("POST", "/v", body, "other", now, "n8"), 401, "401004"},
+		{"unknown app", "/v", body, map[string]string{"X-WGO-AppId": "nobody", "X-WGO-Timestamp": "1", "X-WGO-Nonce": "n9", "X-WGO-Signature": "x"}, 401, "401002"},
+		{"incomplete", "/v", body, map[string]string{"X-WGO-Signature": "x"}, 401, "401001"},
+		{"replayed", "/v?a=1", body, signed("POST", "/v?a=1", body, "s3cret", now, "n1"), 401, "401005"},
+		{"unsigned", "/v", body, nil, 200, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := app.POST(c.path, c.body, c.headers).Expect(t, c.code)
+			if c.errCode != "" {
+				r.ExpectContains(t, `"code":`+c.errCode)
+			}
+		})
+	}
+}
+
+// 没有storage时使用本地的nonce缓存
+func TestVerifySignLocalNonce(t *testing.T) {
+	app := newSignApp(t, nil)
+	defer app.Close()
+	h := signed("POST", "/v", "", "s3cret", time.Now(), "local1")
+	app.POST("/v", "", h).Expect(t, 200).ExpectContains(t, `"auth":true`)
+	app.POST("/v", "", h).Expect(t, 401).ExpectContains(t, `"code":401005`)
+}
+
+func TestVerifySignRotation(t *testing.T) {
+	app := newSignApp(t, wgotest.NewStorage())
+	defer app.Close()
+	now := time.Now()
+	app.POST("/v", "", signed("POST", "/v", "", "s3cret", now, "r1")).Expect(t, 200)
+
+	rest.SetSignApp("caller", "n3w")
+	app.POST("/v", "", signed("POST", "/v", "", "s3cret", now, "r2")).Expect(t, 401).ExpectContains(t, `"code":401004`)
+	app.POST("/v", "", signed("POST", "/v", "", "n3w", now, "r3")).Expect(t, 200).ExpectContains(t, `"app":"caller"`)
+
+	rest.SetSignApp("other", "x")
+	app.POST("/v", "", signed("POST", "/v", "", "n3w", now, "r4")).Expect(t, 200)
+}
@@ -784,7 +784,9 @@ func (c *Client) execute(req *Request) (*Response, error) {
 	}
 
 	req.Time = time.Now()
+	finish := req.traceRequest()
 	resp, err := c.httpClient.Do(req.RawRequest)
+	finish(resp, err)
 
 	response := &Response{
 		Request:     req,
@@ -57,3 +57,7 @@ func (r *Request) isContextCancelledIfAvailable() bool {
 	// just always return false golang<1.7
 	return false
 }
+
+func (r *Request) traceRequest() func(*http.Response, error) {
+	return func(*http.Response, error) {}
+}
@@ -14,6 +14,9 @@ import (
 	"net/http"
 	"net/url"
 	"time"
+
+	"wgo/trace"
+	"wgo/whttp"
 )
 
 // Request type is used to compose and send individual request from client
@@ -63,6 +66,10 @@ func (r *Request) SetContext(ctx context.Context) *Request {
 func (r *Request) addContextIfAvailable() {
 	if r.ctx != nil {
 		r.RawRequest = r.RawRequest.WithContext(r.ctx)
+		// 剩余时间传给下游
+		if to := whttp.TimeoutHeader(r.ctx); to != "" {
+			r.RawRequest.Header.Set(whttp.HeaderXTimeout, to)
+		}
 	}
 }
 
@@ -74,3 +81,24 @@ func (r *Request) isContextCancelledIfAvailable() bool {
 	}
 	return false
 }
+
+// ctx中有span时记录client span, 并在请求头中注入traceparent
+func (r *Request) traceRequest() func(*http.Response, error) {
+	ctx, span := trace.StartChild(r.ctx, "HTTP "+r.Method, trace.SpanKindClient,
+		trace.Attr("http.method", r.Method), trace.Attr("http.url", r.RawRequest.URL.String()))
+	if span == nil {
+		return func(*http.Response, error) {}
+	}
+	trace.Inject(ctx, r.RawRequest.Header)
+	return func(resp *http.Response, err error) {
+		if err != nil {
+			span.SetError(err)
+		} else if resp != nil {
+			span.SetAttr("http.status_code", resp.StatusCode)
+			if resp.StatusCode >= 500 {
+				span.SetStatus(trace.StatusError, resp.Status)
+			}
+		}
+		span.End()
+	}
+}
@@ -2,6 +2,7 @@
 package wgo
 
 import (
+	"wgo/server"
 	"wgo/wrpc"
 
 	"google.golang.org/grpc"
@@ -62,14 +63,14 @@ func wrpcMiddlewareToMiddleware(m wrpc.MiddlewareFunc) MiddlewareFunc {
 
 // 注册RPC服务
 func RegisterRPCService(rf func(s *grpc.Server)) {
-	if ss := wgo.RPCServers(); len(ss) > 0 {
+	if ss := Self().RPCServers(); len(ss) > 0 {
 		ss.RegisterRPCService(rf)
 	}
 }
 func (ss Servers) RegisterRPCService(rf func(s *grpc.Server)) {
 	for _, s := range ss {
 		if s.Mux() != nil {
-			Warn("%s RegisterRPCService", s.Name())
+			server.Warn("%s RegisterRPCService", s.Name())
 			s.Engine().(*wrpc.Engine).RegisterService(rf)
 		}
 	}
@@ -77,14 +78,14 @@ func (ss Servers) RegisterRPCService(rf func(s *grpc.Server)) {
 
 // add rpc method
 func AddRPC(methodName string, h HandlerFunc) {
-	if ss := wgo.RPCServers(); len(ss) > 0 {
+	if ss := Self().RPCServers(); len(ss) > 0 {
 		ss.AddRPC(methodName, h)
 	}
 }
 func (ss Servers) AddRPC(methodName string, h HandlerFunc) {
 	for _, s := range ss {
 		if s.Mux() != nil {
-			Warn("add rpc, mehtod: %s", methodName)
+			server.Warn("add rpc, mehtod: %s", methodName)
 			s.Mux().(*wrpc.Mux).Add(methodName, handlerFuncToWrpcHandlerFunc(h))
 		}
 	}
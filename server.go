@@ -17,22 +17,44 @@ func (w *WGO) push(s *server.Server) *WGO {
 	return w
 }
 
-// get all http/https servers
-func AllServers(labels ...string) (ss Servers) { return wgo.AllServers(labels...) }
+func (w *WGO) removeServer(s *server.Server) *WGO {
+	ss := make(Servers, 0, len(w.servers))
+	for _, o := range w.servers {
+		if o != s {
+			ss = append(ss, o)
+		}
+	}
+	w.servers = ss
+	return w
+}
+
+// get all servers, 不包括admin
+func AllServers(labels ...string) (ss Servers) { return Self().AllServers(labels...) }
 func (w *WGO) AllServers(labels ...string) (ss Servers) {
-	return w.servers
+	for _, s := range w.servers {
+		if s.Mode() != server.MODE_ADMIN {
+			ss = append(ss, s)
+		}
+	}
+	return
+}
+
+// get all admin servers
+func AdminServers(labels ...string) (ss Servers) { return Self().AdminServers(labels...) }
+func (w *WGO) AdminServers(labels ...string) (ss Servers) {
+	return w.GetServersByMode(server.MODE_ADMIN, labels...)
 }
 
 // get all http/https servers
-func HTTPServers(labels ...string) (ss Servers) { return wgo.HTTPServers(labels...) }
+func HTTPServers(labels ...string) (ss Servers) { return Self().HTTPServers(labels...) }
 func (w *WGO) HTTPServers(labels ...string) (ss Servers) {
-	return wgo.GetServersByMode("http", labels...)
+	return w.GetServersByMode("http", labels...)
 }
 
 // get all rpc servers
-func RPCServers(labels ...string) (ss Servers) { return wgo.RPCServers(labels...) }
+func RPCServers(labels ...string) (ss Servers) { return Self().RPCServers(labels...) }
 func (w *WGO) RPCServers(labels ...string) (ss Servers) {
-	return wgo.GetServersByMode("rpc", labels...)
+	return w.GetServersByMode("rpc", labels...)
 }
 
 // get servers by mode
@@ -43,6 +65,8 @@ func (w *WGO) GetServersByMode(mode string, labels ...string) (ss Servers) {
 		ms = []string{"http", "https", "whttp"}
 	case "rpc", "grpc", "wrpc":
 		ms = []string{"rpc", "grpc", "wrpc"}
+	case "admin":
+		ms = []string{"admin"}
 	}
 	for _, s := range w.servers {
 		if utils.InSliceIgnorecase(s.Mode(), ms) { // 只要http的
@@ -63,7 +87,7 @@ func (w *WGO) GetServersByMode(mode string, labels ...string) (ss Servers) {
  * 默认all
  */
 func Use(ms ...interface{}) (ss Servers) {
-	if ss = wgo.AllServers(); len(ss) > 0 {
+	if ss = Self().AllServers(); len(ss) > 0 {
 		ss.Use(ms...)
 	}
 	return
@@ -83,7 +107,7 @@ func (ss Servers) Use(ms ...interface{}) Servers {
  * 默认all
  */
 func NotFound(h HandlerFunc) (ss Servers) {
-	if ss = wgo.AllServers(); len(ss) > 0 {
+	if ss = Self().AllServers(); len(ss) > 0 {
 		ss.NotFound(h)
 	}
 	return
@@ -95,7 +119,7 @@ func (ss Servers) NotFound(h HandlerFunc) Servers {
 			case "http", "https", "whttp":
 				s.Mux().(*whttp.Mux).NotFound(handlerFuncToWhttpHandlerFunc(h))
 			case "rpc", "wrpc", "grpc":
-				Info("wrpc not found")
+				server.Info("wrpc not found")
 			default: // do nothing
 			}
 		}
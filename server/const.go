@@ -13,4 +13,5 @@ const (
 	MODE_RPC   = "rpc"
 	MODE_GRPC  = "grpc"
 	MODE_WRPC  = "wrpc"
+	MODE_ADMIN = "admin" // 管理接口, 独立端口
 )
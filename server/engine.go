@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"net"
 	"time"
 )
@@ -22,6 +23,11 @@ type (
 		Mux() Mux
 	}
 
+	// engine可选实现, 优雅关闭: 停止接收新请求, 等待进行中的请求结束, ctx到期后强制关闭
+	Shutdowner interface {
+		Shutdown(context.Context) error
+	}
+
 	// multiplexer
 	Mux interface {
 		Name() string
@@ -59,6 +65,11 @@ type (
 		HTTPOnly() bool
 	}
 
+	// CookieSameSite 可选, 实现时设置cookie的SameSite(Lax, Strict, None), 为空时不设置
+	CookieSameSite interface {
+		SameSite() string
+	}
+
 	// Header defines the interface for HTTP header.
 	Header interface {
 		// Add adds the key, value pair to the header. It appends to any existing values
@@ -8,8 +8,9 @@ import (
 
 type (
 	ServerError struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
+		Code    int         `json:"code"`
+		Message string      `json:"message"`
+		Data    interface{} `json:"data,omitempty"` // 附加信息, 如配额用量
 	}
 )
 
@@ -41,6 +42,12 @@ func NewErrorf(code int, format string, a ...interface{}) *ServerError {
 	}
 }
 
+// 带上附加信息
+func (e *ServerError) WithData(data interface{}) *ServerError {
+	e.Data = data
+	return e
+}
+
 // wrap
 func WrapError(err error) *ServerError {
 	if se, ok := err.(*ServerError); ok {
@@ -8,6 +8,7 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -35,6 +36,7 @@ type (
 		engine     Engine
 		engine_gen EngineFactory
 		mux_gen    MuxFactory
+		logger     Logger // mux使用的logger, 没有则用包级别的
 	}
 	Config struct {
 		Name       string   `mapstructure:"name"`
@@ -46,6 +48,11 @@ type (
 		NoCallback bool     `mapstructure:"no_callback"`
 		CertFile   string   `mapstructure:"cert_file"`
 		KeyFile    string   `mapstructure:"key_file"`
+		Token      string   `mapstructure:"token"` // admin模式的访问token
+		// 连接的读写超时, 0时使用engine的默认值(fasthttp: 180s/90s)
+		ReadTimeout  time.Duration `mapstructure:"read_timeout"`
+		WriteTimeout time.Duration `mapstructure:"write_timeout"`
+		IdleTimeout  time.Duration `mapstructure:"idle_timeout"`
 	}
 )
 
@@ -100,11 +107,25 @@ func (s *Server) SetEngine(eng Engine) *Server {
 	return s
 }
 
+// set logger
+func (s *Server) SetLogger(l Logger) *Server {
+	s.logger = l
+	return s
+}
+
+// logger
+func (s *Server) Logger() Logger {
+	if s.logger != nil {
+		return s.logger
+	}
+	return logger
+}
+
 // new mux
 func (s *Server) buildMux() *Server {
 	if s.mux_gen != nil {
 		m := s.mux_gen()
-		m.SetLogger(logger)
+		m.SetLogger(s.Logger())
 		// m.SetEngine(s.engine)
 		s.Engine().SetMux(m)
 		Debug("[buildMux]mux_gen")
@@ -204,6 +225,24 @@ func (s *Server) Mode() string {
 
 /* }}} */
 
+/* {{{ func (s *Server) Token() string
+ * admin模式的访问token
+ */
+func (s *Server) Token() string {
+	return s.cfg.Token
+}
+
+/* }}} */
+
+/* {{{ func (s *Server) Timeouts() (read, write, idle time.Duration)
+ * 连接的读写及空闲超时, 为0时engine使用默认值
+ */
+func (s *Server) Timeouts() (read, write, idle time.Duration) {
+	return s.cfg.ReadTimeout, s.cfg.WriteTimeout, s.cfg.IdleTimeout
+}
+
+/* }}} */
+
 /* {{{ func (s *Server) Engine() Engine
 * Scheme returns http or https if SSL is enabled
  */
@@ -237,6 +276,18 @@ func (s *Server) Close() error {
 
 /* }}} */
 
+/* {{{ func (s *Server) Shutdown(ctx context.Context) error
+ * 优雅关闭, engine实现了Shutdowner则等待进行中的请求, 否则直接关闭listener
+ */
+func (s *Server) Shutdown(ctx context.Context) error {
+	if sd, ok := s.Engine().(Shutdowner); ok {
+		return sd.Shutdown(ctx)
+	}
+	return s.Close()
+}
+
+/* }}} */
+
 /* {{{ func (s *Server) IsIdle() bool
  * listener没有请求, 代表服务器空闲
  */
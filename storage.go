@@ -8,6 +8,7 @@
 package wgo
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -17,13 +18,13 @@ import (
 )
 
 // init storage
-func initStorage() {
+func (w *WGO) initStorage() {
 	var sn string
 	var nodes []string
 	if rns := os.Getenv("storage.redis.nodes"); rns != "" {
 		sn = "redis"
 		nodes = strings.Split(rns, ",")
-	} else if scfg := SubConfig(environ.CFG_KEY_STORAGE); scfg != nil {
+	} else if scfg := w.Cfg().Sub(environ.CFG_KEY_STORAGE); scfg != nil {
 		// nodes可以通过env传递
 		sn = scfg.String("name")
 		nodes = scfg.StringSlice("nodes")
@@ -32,7 +33,7 @@ func initStorage() {
 		// 配置了storage再进行初始化
 		css := make([]string, 0)
 		for _, node := range nodes {
-			Info("open %s storage, %s", sn, node)
+			w.Info("open %s storage, %s", sn, node)
 			split := strings.Split(node, ":")
 			if len(split) > 0 && split[0] != "" {
 				host := split[0]
@@ -49,22 +50,26 @@ func initStorage() {
 		}
 		s, err := storage.New(sn, css...)
 		if err != nil {
-			Fatal("open storage failed: %s", err)
+			w.Fatal("open storage failed: %s", err)
 		}
-		SetStorage(s)
+		w.SetStorage(s)
+		w.OnStop(func(context.Context) error {
+			w.Info("close %s storage", sn)
+			return s.Close()
+		})
 	}
 }
 
 // storage
 // get
-func Storage() *storage.Storage { return wgo.Storage() }
+func Storage() *storage.Storage { return Self().Storage() }
 func (w *WGO) Storage() *storage.Storage {
 	return w.storage
 }
 
 // set storage
 func SetStorage(s *storage.Storage) {
-	wgo.SetStorage(s)
+	Self().SetStorage(s)
 }
 func (w *WGO) SetStorage(s *storage.Storage) {
 	if w != nil {
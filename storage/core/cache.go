@@ -18,8 +18,15 @@ type Cache interface {
 	Push(key string, val interface{}) error
 	Pop(key string) (interface{}, error)
 	IsExist(key string) bool
+	Do(cmd string, args ...interface{}) (interface{}, error)
 	ClearAll() error
 	Start(config string) error
+	Close() error
+}
+
+// TimeoutDoer 可以给单个命令设置超时的节点(如redis), 可选
+type TimeoutDoer interface {
+	DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error)
 }
 
 // Instance is a function create a new Cache Instance
@@ -9,12 +9,18 @@ import (
 
 	"github.com/garyburd/redigo/redis"
 
+	"wgo/metrics"
 	"wgo/storage/core"
 )
 
 var (
 	// DefaultKey the collection name of redis for cache adapter.
 	DefaultKey = "storage_redis"
+
+	cmdDuration = metrics.GetHistogram("wgo_redis_command_duration_seconds",
+		"Redis command latency in seconds.", nil, "node", "command")
+	cmdErrors = metrics.GetCounter("wgo_redis_errors_total",
+		"Redis commands that returned an error.", "node", "command")
 )
 
 // Cache is Redis cache adapter.
@@ -34,10 +40,35 @@ func NewRedisCache() core.Cache {
 
 // actually do the redis cmds
 func (rc *Cache) do(commandName string, args ...interface{}) (reply interface{}, err error) {
+	return rc.doWithTimeout(0, commandName, args...)
+}
+
+// timeout为0时使用连接池的默认超时
+func (rc *Cache) doWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (reply interface{}, err error) {
 	c := rc.p.Get()
 	defer c.Close()
 
-	return c.Do(commandName, args...)
+	start := time.Now()
+	if timeout > 0 {
+		reply, err = redis.DoWithTimeout(c, timeout, commandName, args...)
+	} else {
+		reply, err = c.Do(commandName, args...)
+	}
+	cmdDuration.Observe(time.Since(start).Seconds(), rc.conninfo, commandName)
+	if err != nil {
+		cmdErrors.Inc(rc.conninfo, commandName)
+	}
+	return
+}
+
+// Do 直接执行redis命令, 不会给参数中的key加prefix
+func (rc *Cache) Do(commandName string, args ...interface{}) (interface{}, error) {
+	return rc.do(commandName, args...)
+}
+
+// DoWithTimeout 同Do, 命令的读超时为timeout
+func (rc *Cache) DoWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	return rc.doWithTimeout(timeout, commandName, args...)
 }
 
 // Get cache from redis.
@@ -210,6 +241,14 @@ func (rc *Cache) ClearAll() error {
 	return err
 }
 
+// Close closes the redis connection pool.
+func (rc *Cache) Close() error {
+	if rc.p != nil {
+		return rc.p.Close()
+	}
+	return nil
+}
+
 func (rc *Cache) GetPrefix() string {
 	return rc.prefix
 }
@@ -8,16 +8,19 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"hash/crc32"
 	"time"
 
 	"wgo/storage/core"
+	"wgo/trace"
 )
 
 type Storage struct {
 	name  string
 	nodes []core.Cache
+	ctx   context.Context // 见WithContext
 }
 
 func New(name string, css ...string) (*Storage, error) {
@@ -43,11 +46,64 @@ func New(name string, css ...string) (*Storage, error) {
 	return nil, fmt.Errorf("storage not created")
 }
 
+// NewWithCaches 使用现成的节点, 如测试中的内存实现
+func NewWithCaches(name string, nodes ...core.Cache) *Storage {
+	return &Storage{
+		name:  name,
+		nodes: nodes,
+	}
+}
+
+// WithContext 使用ctx的storage, ctx中有span时命令记录为其子span
+// ctx已经结束时命令直接返回ctx.Err(), 有deadline时Do的超时为剩余时间
+func (s *Storage) WithContext(ctx context.Context) *Storage {
+	return &Storage{
+		name:  s.name,
+		nodes: s.nodes,
+		ctx:   ctx,
+	}
+}
+
+// ctx的剩余时间, 没有deadline时为0, 已经结束时返回错误
+func (s *Storage) remaining() (time.Duration, error) {
+	if s.ctx == nil {
+		return 0, nil
+	}
+	if err := s.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if dl, ok := s.ctx.Deadline(); ok {
+		if d := time.Until(dl); d > 0 {
+			return d, nil
+		}
+		return 0, context.DeadlineExceeded
+	}
+	return 0, nil
+}
+
+// 命令的span, 没有context时不记录
+func (s *Storage) span(cmd, key string, idx int) func(error) {
+	if s.ctx == nil {
+		return func(error) {}
+	}
+	_, span := trace.StartChild(s.ctx, s.name+" "+cmd, trace.SpanKindClient,
+		trace.Attr("db.system", s.name), trace.Attr("db.operation", cmd), trace.Attr("db.key", key), trace.Attr("db.node", idx))
+	return func(err error) {
+		span.SetError(err)
+		span.End()
+	}
+}
+
 // Get 根据hash规则查询节点
 func (s *Storage) Get(key string) interface{} {
 	if key != "" {
+		if _, err := s.remaining(); err != nil {
+			return nil
+		}
 		idx := s.Hash(key)
+		end := s.span("GET", key, idx)
 		v := s.nodes[idx].Get(key)
+		end(nil)
 		if v == nil {
 			Debug("[Get]idx: %d, key: %s", idx, key)
 		}
@@ -59,36 +115,103 @@ func (s *Storage) Get(key string) interface{} {
 // get and set
 func (s *Storage) GetSet(key string, value interface{}) (interface{}, error) {
 	if key != "" {
+		if _, err := s.remaining(); err != nil {
+			return nil, err
+		}
 		idx := s.Hash(key)
-		return s.nodes[idx].GetSet(key, value)
+		end := s.span("GETSET", key, idx)
+		v, err := s.nodes[idx].GetSet(key, value)
+		end(err)
+		return v, err
 	}
 	return nil, fmt.Errorf("no key")
 }
 
 // Put 根据hash规则保存数据
 func (s *Storage) Put(key string, val interface{}, timeout time.Duration, opts ...interface{}) error {
+	if _, err := s.remaining(); err != nil {
+		return err
+	}
 	idx := s.Hash(key)
 	//go s.nodes[idx].Put(key, val, timeout)
+	end := s.span("SET", key, idx)
 	tried := 0
+	var err error
 	for tried < 5 {
 		tried++
-		err := s.nodes[idx].Put(key, val, timeout, opts...)
+		err = s.nodes[idx].Put(key, val, timeout, opts...)
 		if err == nil {
 			break
 		}
 		Warn("[Put:%d] error: %s", idx, err)
 	}
 	Debug("[Put]idx: %d, key: %s, tried: %d", idx, key, tried)
+	end(err)
 	return nil
 }
 
 // Delete 根据hash规则删除数据
 func (s *Storage) Delete(key string) error {
 	idx := s.Hash(key)
-	go s.nodes[idx].Delete(key)
+	end := s.span("DEL", key, idx)
+	go func() {
+		end(s.nodes[idx].Delete(key))
+	}()
 	return nil
 }
 
+// Do 在key所在节点上执行命令, key只用于选择节点
+// 多个key的命令(如lua脚本)需要调用方保证这些key用同一个key选择节点
+func (s *Storage) Do(key string, cmd string, args ...interface{}) (interface{}, error) {
+	if key == "" {
+		return nil, fmt.Errorf("no key")
+	}
+	timeout, err := s.remaining()
+	if err != nil {
+		return nil, err
+	}
+	idx := s.Hash(key)
+	end := s.span(cmd, key, idx)
+	var reply interface{}
+	if td, ok := s.nodes[idx].(core.TimeoutDoer); ok && timeout > 0 {
+		reply, err = td.DoWithTimeout(timeout, cmd, args...)
+	} else {
+		reply, err = s.nodes[idx].Do(cmd, args...)
+	}
+	end(err)
+	return reply, err
+}
+
+// Close 关闭所有节点
+func (s *Storage) Close() (err error) {
+	for idx, node := range s.nodes {
+		if e := node.Close(); e != nil {
+			Warn("[Close:%d] error: %s", idx, e)
+			err = e
+		}
+	}
+	return
+}
+
+// Name 类型, 如redis
+func (s *Storage) Name() string {
+	return s.name
+}
+
+// Len 节点数
+func (s *Storage) Len() int {
+	return len(s.nodes)
+}
+
+// Ping 检查第idx个节点是否可用
+func (s *Storage) Ping(idx int) error {
+	if idx < 0 || idx >= len(s.nodes) {
+		return fmt.Errorf("invalid node: %d", idx)
+	}
+	_, err := s.nodes[idx].Do("PING")
+	return err
+}
+
 func (s *Storage) Hash(key string) int {
 	if key == "" {
 		panic("keys error")
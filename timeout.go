@@ -0,0 +1,433 @@
+//
+// timeout.go
+// 请求超时middleware, deadline放在Context(c.SetContext)中, 下游调用(gorp/storage/rest.Client/resty/grpc)以c为ctx时会带上剩余时间
+// 规则来自路由option(TimeoutKey)或配置(timeout), 上游可以用X-WGO-Timeout头传入剩余时间(毫秒)
+//
+
+package wgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"wgo/environ"
+	"wgo/metrics"
+	"wgo/server"
+	"wgo/utils"
+	"wgo/whttp"
+)
+
+// 路由option的key, 值为time.Duration, 0或false代表该路由不设置超时(上游传入的剩余时间仍然有效)
+const TimeoutKey = "timeout"
+
+var errLateWrite = errors.New("response already finished")
+
+var timedOut = metrics.GetCounter("wgo_http_timeouts_total",
+	"Total number of requests that exceeded their deadline.", "method", "route")
+
+type (
+	// TimeoutRule 配置中单个路由的超时
+	TimeoutRule struct {
+		Route   string        `mapstructure:"route"` // "GET /users/:id"或"/users/:id"(所有method)
+		Timeout time.Duration `mapstructure:"timeout"`
+	}
+
+	// TimeoutConfig 配置中的timeout部分
+	TimeoutConfig struct {
+		Disable     bool           `mapstructure:"disable"`
+		Default     time.Duration  `mapstructure:"default"`      // 没有单独规则的路由, 0为不限
+		Routes      []*TimeoutRule `mapstructure:"routes"`       //
+		IgnoreInput bool           `mapstructure:"ignore_input"` // 忽略上游传入的X-WGO-Timeout
+	}
+
+	// 超时前的输出(包括header和cookie)都先放在buffer, 超时后丢弃, 保证不会输出超时之后的内容
+	timeoutResponse struct {
+		whttp.Response
+		ctx         context.Context
+		mu          sync.Mutex
+		header      *timeoutHeader
+		cookies     []server.Cookie
+		buf         bytes.Buffer
+		status      int
+		passthrough bool // Flush之后直接写入原response(如stream)
+		closed      bool // 已经超时或者handler已经结束, 之后的写入都丢弃
+	}
+
+	// 在原header的副本之上记录修改, 没有超时才写入原header
+	// handler的goroutine只读写副本, 原header只在超时(持有锁)或者handler结束之后修改, 避免并发读写
+	timeoutHeader struct {
+		base server.Header
+		keys []string    // 原header的key, 保持顺序
+		orig http.Header // 原header的副本(每个key的第一个值)
+		set  http.Header
+		del  map[string]bool
+	}
+)
+
+/* {{{ func Timeout() MiddlewareFunc
+ * 超时返回504, 上游传入的剩余时间已经用完时直接返回503, 默认已加载
+ * handler在单独的goroutine运行, 到deadline时立即写入504
+ * standard引擎立即发送; fasthttp的response只能在handler返回后发送, 因此504要等handler结束才到达客户端
+ * (fasthttp的TimeoutError要求之后不再使用ctx, 与Context复用冲突, 不使用)
+ * Context及request/response都会被复用, 因此仍然要等handler结束才返回, handler需要检查c.Done()或者把c作为ctx传给下游以便及时结束
+ */
+func Timeout() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			cfg := c.App().timeoutConfig()
+			if cfg == nil {
+				return next(c)
+			}
+			d := cfg.timeout(c)
+			rpc := false
+			switch c.ServerMode() {
+			case "rpc", "wrpc", "grpc": // grpc的deadline已经在ctx中(grpc-timeout)
+				rpc = true
+			default:
+				if !cfg.IgnoreInput {
+					if in, ok := whttp.ParseTimeout(c.RequestHeader().Get(whttp.HeaderXTimeout)); ok {
+						if in <= 0 {
+							timedOut.Inc(c.Method(), c.Path())
+							return c.NewError(whttp.StatusServiceUnavailable*1000, "deadline exceeded")
+						}
+						if d <= 0 || in < d {
+							d = in
+						}
+					}
+				}
+			}
+			if d <= 0 {
+				return next(c)
+			}
+			parent := c.Context()
+			ctx, cancel := context.WithTimeout(parent, d)
+			defer cancel()
+			c.SetContext(ctx)
+			defer c.SetContext(parent)
+			if rpc {
+				return next(c)
+			}
+
+			res := c.response.(whttp.Response)
+			tr := &timeoutResponse{Response: res, ctx: ctx, header: newTimeoutHeader(res.Header())}
+			c.response = tr
+			defer func() { c.response = res }()
+
+			var (
+				err      error
+				panicked interface{}
+				done     = make(chan struct{})
+			)
+			go func() {
+				defer func() {
+					panicked = recover()
+					close(done)
+				}()
+				err = next(c)
+			}()
+
+			select {
+			case <-done:
+			case <-ctx.Done():
+				if ctx.Err() == context.DeadlineExceeded && tr.timeout(c.NewError(whttp.StatusGatewayTimeout*1000, "request timeout")) {
+					timedOut.Inc(c.Method(), c.Path())
+					c.Warn("[Timeout]%s %s, exceeded %s", c.Method(), c.Path(), d)
+				}
+				<-done
+			}
+			if panicked != nil {
+				panic(panicked)
+			}
+			if tr.flush() {
+				return err
+			}
+			return nil // 504已经写入
+		}
+	}
+}
+
+/* }}} */
+
+// 读取配置, 没有配置时也需要(路由option及上游传入的剩余时间)
+func (w *WGO) timeoutConfig() *TimeoutConfig {
+	w.timeoutOnce.Do(func() {
+		cfg := &TimeoutConfig{}
+		if w.Cfg().IsSet(environ.CFG_KEY_TIMEOUT) {
+			if err := w.Cfg().UnmarshalKey(environ.CFG_KEY_TIMEOUT, cfg); err != nil {
+				w.Logger().Error("[timeout]invalid config: %s", err)
+			}
+		}
+		if !cfg.Disable {
+			w.timeout = cfg
+		}
+	})
+	return w.timeout
+}
+
+// 路由option > 配置中的路由 > 默认
+func (cfg *TimeoutConfig) timeout(c *Context) time.Duration {
+	switch opt := c.Options(TimeoutKey).(type) {
+	case time.Duration:
+		return opt
+	case bool:
+		if !opt {
+			return 0
+		}
+	}
+	if c.Path() != "" {
+		route := c.Method() + " " + c.Path()
+		for _, r := range cfg.Routes {
+			if r.Route == route || r.Route == c.Path() {
+				return r.Timeout
+			}
+		}
+	}
+	return cfg.Default
+}
+
+/* {{{ timeoutResponse
+ *
+ */
+func (r *timeoutResponse) Header() server.Header {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.passthrough {
+		return r.Response.Header()
+	}
+	return r.header
+}
+
+func (r *timeoutResponse) SetCookie(cookie server.Cookie) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if r.passthrough {
+		r.Response.SetCookie(cookie)
+		return
+	}
+	r.cookies = append(r.cookies, cookie)
+}
+
+func (r *timeoutResponse) WriteHeader(code int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if r.passthrough {
+		r.Response.WriteHeader(code)
+		return
+	}
+	r.status = code
+}
+
+func (r *timeoutResponse) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.check(); err != nil {
+		return 0, err
+	}
+	if r.passthrough {
+		return r.Response.Write(b)
+	}
+	return r.buf.Write(b)
+}
+
+func (r *timeoutResponse) WriteGzip(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.check(); err != nil {
+		return 0, err
+	}
+	if r.passthrough {
+		return r.Response.WriteGzip(b)
+	}
+	return utils.WriteGzip(&r.buf, b)
+}
+
+func (r *timeoutResponse) Status() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status == 0 || r.passthrough || r.closed {
+		return r.Response.Status()
+	}
+	return r.status
+}
+
+func (r *timeoutResponse) Size() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Response.Size() + int64(r.buf.Len())
+}
+
+func (r *timeoutResponse) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.check() != nil {
+		return
+	}
+	r.replay()
+	r.passthrough = true
+	r.Response.Flush()
+}
+
+// handler结束, 没有超时时把buffer写入原response, 返回false表示已经超时
+func (r *timeoutResponse) flush() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	r.replay()
+	r.closed = true
+	return true
+}
+
+// 到达deadline, 丢弃buffer, 直接在原response写入错误, 之后的写入都会返回错误
+func (r *timeoutResponse) timeout(err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	r.buf.Reset()
+	r.status = 0
+	r.cookies = nil
+	r.closed = true
+	if r.passthrough { // 已经开始输出, 只能中断
+		return true
+	}
+	se := server.WrapError(err)
+	b, _ := json.Marshal(se)
+	hdr := r.Response.Header()
+	hdr.Del(whttp.HeaderContentEncoding)
+	hdr.Set(whttp.HeaderContentType, whttp.MIMEApplicationJSONCharsetUTF8)
+	hdr.Set(whttp.HeaderContentLength, strconv.Itoa(len(b))) // 不使用chunked, 客户端不需要等待handler结束
+	r.Response.WriteHeader(se.HTTPStatusCode())
+	r.Response.Write(b)
+	r.Response.Flush()
+	return true
+}
+
+func (r *timeoutResponse) check() error {
+	if r.closed {
+		return errLateWrite
+	}
+	if err := r.ctx.Err(); err == context.DeadlineExceeded {
+		return err
+	}
+	return nil
+}
+
+func (r *timeoutResponse) replay() {
+	r.header.apply()
+	for _, cookie := range r.cookies {
+		r.Response.SetCookie(cookie)
+	}
+	r.cookies = nil
+	if r.status != 0 {
+		r.Response.WriteHeader(r.status)
+		r.status = 0
+	}
+	if r.buf.Len() > 0 {
+		r.Response.Write(r.buf.Bytes())
+		r.buf.Reset()
+	}
+}
+
+/* }}} */
+
+/* {{{ timeoutHeader
+ *
+ */
+func newTimeoutHeader(base server.Header) *timeoutHeader {
+	h := &timeoutHeader{base: base, keys: base.Keys(), orig: http.Header{}}
+	for _, k := range h.keys {
+		h.orig.Set(k, base.Get(k))
+	}
+	return h
+}
+
+func (h *timeoutHeader) Add(key, val string) {
+	key = http.CanonicalHeaderKey(key)
+	if _, ok := h.set[key]; !ok && !h.del[key] && h.orig[key] != nil {
+		h.Set(key, h.orig.Get(key))
+	}
+	if h.set == nil {
+		h.set = http.Header{}
+	}
+	h.set.Add(key, val)
+}
+
+func (h *timeoutHeader) Del(key string) {
+	key = http.CanonicalHeaderKey(key)
+	if h.del == nil {
+		h.del = map[string]bool{}
+	}
+	h.del[key] = true
+	h.set.Del(key)
+}
+
+func (h *timeoutHeader) Set(key, val string) {
+	if h.set == nil {
+		h.set = http.Header{}
+	}
+	h.set.Set(key, val)
+}
+
+func (h *timeoutHeader) Get(key string) string {
+	key = http.CanonicalHeaderKey(key)
+	if vs, ok := h.set[key]; ok && len(vs) > 0 {
+		return vs[0]
+	}
+	if h.del[key] {
+		return ""
+	}
+	return h.orig.Get(key)
+}
+
+func (h *timeoutHeader) Keys() (keys []string) {
+	for _, k := range h.keys {
+		if ck := http.CanonicalHeaderKey(k); !h.del[ck] || h.set[ck] != nil {
+			keys = append(keys, k)
+		}
+	}
+	for k := range h.set {
+		if h.orig[k] == nil {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+func (h *timeoutHeader) Contains(key string) bool {
+	key = http.CanonicalHeaderKey(key)
+	if _, ok := h.set[key]; ok {
+		return true
+	}
+	return !h.del[key] && h.orig[key] != nil
+}
+
+// 写入原header
+func (h *timeoutHeader) apply() {
+	for k := range h.del {
+		h.base.Del(k)
+	}
+	for k, vs := range h.set {
+		h.base.Del(k)
+		for _, v := range vs {
+			h.base.Add(k, v)
+		}
+	}
+	h.set, h.del = nil, nil
+}
+
+/* }}} */
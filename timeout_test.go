@@ -0,0 +1,60 @@
+package wgo_test
+
+import (
+	"testing"
+	"time"
+
+	"wgo"
+	"wgo/wgotest"
+)
+
+// 超时之后handler继续修改header, 需要在-race下运行
+func TestTimeoutLateHeader(t *testing.T) {
+	for _, engine := range []string{"standard", "fasthttp"} {
+		t.Run(engine, func(t *testing.T) {
+			app := wgotest.New("proc_name: timeouttest\ntimeout:\n  default: 20ms", wgotest.Engine(engine))
+			defer app.Close()
+			wgo.GET("/slow", func(c *wgo.Context) error {
+				h := c.ResponseHeader()
+				h.Set("X-Before", "1")
+				<-c.Done()
+				for i := 0; i < 100; i++ {
+					h.Set("X-After", "1")
+					h.Add("X-After", "2")
+					h.Get("Content-Type")
+					h.Keys()
+					h.Contains("Content-Length")
+					h.Del("X-Before")
+				}
+				return c.String(200, "late")
+			})
+			wgo.GET("/fast", func(c *wgo.Context) error {
+				c.ResponseHeader().Set("X-Fast", "1")
+				return c.String(200, "ok")
+			})
+
+			r := app.GET("/slow").Expect(t, 504).ExpectContains(t, `"code":504000`)
+			if r.Header.Get("X-Before") != "" || r.Header.Get("X-After") != "" {
+				t.Errorf("header written before/after timeout leaked: %v", r.Header)
+			}
+			r = app.GET("/fast").Expect(t, 200).ExpectContains(t, "ok")
+			if r.Header.Get("X-Fast") != "1" {
+				t.Errorf("header lost: %v", r.Header)
+			}
+		})
+	}
+}
+
+// 路由单独设置的超时
+func TestTimeoutRouteOption(t *testing.T) {
+	app := wgotest.New("proc_name: timeouttest")
+	defer app.Close()
+	wgo.GET("/search", func(c *wgo.Context) error {
+		select {
+		case <-c.Done():
+		case <-time.After(time.Second):
+		}
+		return c.String(200, "done")
+	}).SetOptions(wgo.TimeoutKey, 10*time.Millisecond)
+	app.GET("/search").Expect(t, 504)
+}
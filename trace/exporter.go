@@ -0,0 +1,243 @@
+//
+// exporter.go
+// stdout/文件(json, 每行一个span), OTLP over HTTP(json)
+//
+
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	otlpTracesPath     = "/v1/traces"
+	defaultOTLPTimeout = 10 * time.Second
+)
+
+type (
+	// WriterExporter 每行一个json格式的span
+	WriterExporter struct {
+		lock sync.Mutex
+		w    io.Writer
+		c    io.Closer
+	}
+
+	// OTLPExporter 以OTLP/HTTP json格式发送到collector
+	OTLPExporter struct {
+		endpoint string
+		headers  map[string]string
+		client   *http.Client
+	}
+)
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+func NewStdoutExporter() *WriterExporter {
+	return NewWriterExporter(os.Stdout)
+}
+
+// 追加写入文件, Shutdown时关闭
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterExporter{w: f, c: f}, nil
+}
+
+func (e *WriterExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, sd := range spans {
+		if err := enc.Encode(sd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.c != nil {
+		err := e.c.Close()
+		e.c = nil
+		return err
+	}
+	return nil
+}
+
+/* {{{ func NewOTLPExporter(endpoint string, opts ...interface{}) *OTLPExporter
+ * endpoint如http://127.0.0.1:4318, 没有path时使用/v1/traces
+ * opts: map[string]string(额外的header, 如认证), time.Duration(超时, 默认10s)
+ */
+func NewOTLPExporter(endpoint string, opts ...interface{}) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint: endpoint,
+		headers:  make(map[string]string),
+		client:   &http.Client{Timeout: defaultOTLPTimeout},
+	}
+	if i := strings.Index(endpoint, "://"); i < 0 || !strings.Contains(endpoint[i+3:], "/") {
+		e.endpoint = strings.TrimRight(endpoint, "/") + otlpTracesPath
+	}
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case map[string]string:
+			for k, v := range o {
+				e.headers[k] = v
+			}
+		case time.Duration:
+			e.client.Timeout = o
+		}
+	}
+	return e
+}
+
+/* }}} */
+
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("otlp export: %s %s", resp.Status, msg)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+/* {{{ otlp json
+ * 见opentelemetry-proto, trace id/span id为hex, 时间及int为字符串
+ */
+type (
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpStatus struct {
+		Code    StatusCode `json:"code,omitempty"`
+		Message string     `json:"message,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		TraceState        string     `json:"traceState,omitempty"`
+		Name              string     `json:"name"`
+		Kind              SpanKind   `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []otlpAttr `json:"attributes,omitempty"`
+		Status            otlpStatus `json:"status"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpResourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttr `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+)
+
+func otlpAttrs(as []Attribute) []otlpAttr {
+	oas := make([]otlpAttr, 0, len(as))
+	for _, a := range as {
+		var v otlpValue
+		switch val := a.Value.(type) {
+		case bool:
+			v.BoolValue = &val
+		case int64:
+			s := strconv.FormatInt(val, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &val
+		default:
+			s := fmt.Sprint(val)
+			v.StringValue = &s
+		}
+		oas = append(oas, otlpAttr{Key: a.Key, Value: v})
+	}
+	return oas
+}
+
+// 按service分组
+func otlpRequest(spans []*SpanData) *otlpTraces {
+	req := &otlpTraces{ResourceSpans: make([]otlpResourceSpans, 0)}
+	idx := make(map[string]int)
+	for _, sd := range spans {
+		i, ok := idx[sd.Service]
+		if !ok {
+			rs := otlpResourceSpans{ScopeSpans: make([]otlpScopeSpans, 1)}
+			rs.Resource.Attributes = otlpAttrs([]Attribute{Attr("service.name", sd.Service)})
+			rs.ScopeSpans[0].Scope.Name = "wgo"
+			rs.ScopeSpans[0].Spans = make([]otlpSpan, 0)
+			req.ResourceSpans = append(req.ResourceSpans, rs)
+			i = len(req.ResourceSpans) - 1
+			idx[sd.Service] = i
+		}
+		ss := &req.ResourceSpans[i].ScopeSpans[0]
+		ss.Spans = append(ss.Spans, otlpSpan{
+			TraceID:           sd.TraceID,
+			SpanID:            sd.SpanID,
+			ParentSpanID:      sd.ParentID,
+			TraceState:        sd.TraceState,
+			Name:              sd.Name,
+			Kind:              sd.Kind,
+			StartTimeUnixNano: strconv.FormatInt(sd.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(sd.End.UnixNano(), 10),
+			Attributes:        otlpAttrs(sd.Attributes),
+			Status:            otlpStatus{Code: sd.Status, Message: sd.Message},
+		})
+	}
+	return req
+}
+
+/* }}} */
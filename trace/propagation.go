@@ -0,0 +1,115 @@
+//
+// propagation.go
+// W3C trace context: traceparent/tracestate的解析与生成
+//
+
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+
+	maxTracestate = 512
+)
+
+// Carrier 可以读写header的载体, 如http.Header, server.Header
+type Carrier interface {
+	Get(key string) string
+	Set(key, value string)
+}
+
+/* {{{ func ParseTraceparent(s string) (SpanContext, error)
+ * 格式: version-traceid-parentid-flags, 如00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+ * version为ff或者id全为0时无效, 高于00的版本允许后面有其他字段
+ */
+func ParseTraceparent(s string) (sc SpanContext, err error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 55 {
+		return sc, fmt.Errorf("invalid traceparent: %q", s)
+	}
+	version := s[0:2]
+	if !isLowerHex(version) || version == "ff" {
+		return sc, fmt.Errorf("invalid traceparent version: %q", version)
+	}
+	if version == "00" && len(s) != 55 {
+		return sc, fmt.Errorf("invalid traceparent: %q", s)
+	}
+	if len(s) > 55 && s[55] != '-' {
+		return sc, fmt.Errorf("invalid traceparent: %q", s)
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, fmt.Errorf("invalid traceparent: %q", s)
+	}
+	tid, sid, flags := s[3:35], s[36:52], s[53:55]
+	if !isLowerHex(tid) || !isLowerHex(sid) || !isLowerHex(flags) {
+		return sc, fmt.Errorf("invalid traceparent: %q", s)
+	}
+	hex.Decode(sc.TraceID[:], []byte(tid))
+	hex.Decode(sc.SpanID[:], []byte(sid))
+	var fb [1]byte
+	hex.Decode(fb[:], []byte(flags))
+	sc.Flags = fb[0] & FlagSampled // 只认识sampled
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent: %q", s)
+	}
+	return sc, nil
+}
+
+/* }}} */
+
+// 生成traceparent, 无效时为空
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ctx中当前span的traceparent, 用于保存后再恢复(如持久化的job)
+func Traceparent(ctx context.Context) string {
+	return SpanContextFromContext(ctx).Traceparent()
+}
+
+// 把ctx中当前的SpanContext写入carrier
+func Inject(ctx context.Context, carrier Carrier) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() || carrier == nil {
+		return
+	}
+	carrier.Set(HeaderTraceparent, sc.Traceparent())
+	if sc.State != "" {
+		carrier.Set(HeaderTracestate, sc.State)
+	}
+}
+
+// 从carrier读取上游的SpanContext, traceparent无效时忽略tracestate
+func Extract(carrier Carrier) (SpanContext, bool) {
+	if carrier == nil {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(carrier.Get(HeaderTraceparent))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	if ts := strings.TrimSpace(carrier.Get(HeaderTracestate)); ts != "" && len(ts) <= maxTracestate {
+		sc.State = ts
+	}
+	sc.Remote = true
+	return sc, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,309 @@
+//
+// span.go
+// trace id, span id, span及其在context.Context中的传递
+//
+
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// span的类型, 数值与OTLP一致
+	SpanKindInternal SpanKind = 1 + iota
+	SpanKindServer
+	SpanKindClient
+	SpanKindProducer
+	SpanKindConsumer
+
+	// span的状态, 数值与OTLP一致
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+
+	FlagSampled byte = 0x01
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+
+	SpanKind   int
+	StatusCode int
+
+	// SpanContext 跨进程传播的部分(traceparent/tracestate)
+	SpanContext struct {
+		TraceID TraceID
+		SpanID  SpanID
+		Flags   byte
+		State   string // tracestate, 原样传递
+		Remote  bool   // 来自上游
+	}
+
+	// Attribute span的属性, Value为string/bool/int/int64/float64, 其他类型转为string
+	Attribute struct {
+		Key   string      `json:"key"`
+		Value interface{} `json:"value"`
+	}
+
+	// Span 一次操作, 方法对nil安全(没有父span时StartChild返回nil)
+	Span struct {
+		lock   sync.Mutex
+		tracer *Tracer
+		name   string
+		kind   SpanKind
+		sc     SpanContext
+		parent SpanID
+		start  time.Time
+		end    time.Time
+		attrs  []Attribute
+		status StatusCode
+		msg    string
+		ended  bool
+	}
+
+	// SpanData 结束的span, 交给Exporter
+	SpanData struct {
+		TraceID    string      `json:"trace_id"`
+		SpanID     string      `json:"span_id"`
+		ParentID   string      `json:"parent_id,omitempty"`
+		TraceState string      `json:"trace_state,omitempty"`
+		Name       string      `json:"name"`
+		Kind       SpanKind    `json:"kind"`
+		Start      time.Time   `json:"start"`
+		End        time.Time   `json:"end"`
+		Attributes []Attribute `json:"attributes,omitempty"`
+		Status     StatusCode  `json:"status"`
+		Message    string      `json:"message,omitempty"`
+		Service    string      `json:"service,omitempty"`
+	}
+
+	spanKey   struct{}
+	remoteKey struct{}
+)
+
+var (
+	idLock sync.Mutex
+	idRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func (t TraceID) IsValid() bool  { return t != TraceID{} }
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) IsValid() bool   { return s != SpanID{} }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+func (sc SpanContext) Sampled() bool { return sc.Flags&FlagSampled != 0 }
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	case SpanKindProducer:
+		return "producer"
+	case SpanKindConsumer:
+		return "consumer"
+	}
+	return "internal"
+}
+
+func Attr(key string, value interface{}) Attribute {
+	switch v := value.(type) {
+	case string, bool, int64, float64:
+	case int:
+		value = int64(v)
+	case int32:
+		value = int64(v)
+	case uint64:
+		value = int64(v)
+	case uint32:
+		value = int64(v)
+	case float32:
+		value = float64(v)
+	case fmt.Stringer:
+		value = v.String()
+	case error:
+		value = v.Error()
+	default:
+		value = fmt.Sprint(v)
+	}
+	return Attribute{Key: key, Value: value}
+}
+
+func newTraceID() (t TraceID) {
+	idLock.Lock()
+	defer idLock.Unlock()
+	for !t.IsValid() {
+		idRand.Read(t[:])
+	}
+	return
+}
+
+func newSpanID() (s SpanID) {
+	idLock.Lock()
+	defer idLock.Unlock()
+	for !s.IsValid() {
+		idRand.Read(s[:])
+	}
+	return
+}
+
+/* {{{ func Start(ctx context.Context, name string, opts ...interface{}) (context.Context, *Span)
+ * 开始一个span, ctx中有span(或上游的SpanContext)时为其子span, 否则开始新的trace
+ * opts: SpanKind(默认SpanKindInternal), Attribute, []Attribute, time.Time(开始时间)
+ */
+func Start(ctx context.Context, name string, opts ...interface{}) (context.Context, *Span) {
+	return Default.Start(ctx, name, opts...)
+}
+
+// 同Start, 但ctx中没有span时不记录(返回nil), 用于db, redis等不应该单独成为trace的操作
+func StartChild(ctx context.Context, name string, opts ...interface{}) (context.Context, *Span) {
+	if ctx == nil || !SpanContextFromContext(ctx).IsValid() {
+		return ctx, nil
+	}
+	return Default.Start(ctx, name, opts...)
+}
+
+/* }}} */
+
+// ctx中的span
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// 上游传来的SpanContext, 之后Start的span以它为父
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sc.Remote = true
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// ctx中当前的SpanContext, 优先本地的span
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.SpanContext()
+	}
+	if ctx != nil {
+		if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+			return sc
+		}
+	}
+	return SpanContext{}
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.sc.TraceID.String()
+}
+
+func (s *Span) Name() string {
+	if s == nil {
+		return ""
+	}
+	return s.name
+}
+
+// 修改名称, 如http span在路由后才知道route
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.name = name
+	s.lock.Unlock()
+}
+
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.attrs = append(s.attrs, Attr(key, value))
+	s.lock.Unlock()
+}
+
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.status, s.msg = code, msg
+	s.lock.Unlock()
+}
+
+// err不为nil时状态为error
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.SetStatus(StatusError, err.Error())
+}
+
+// 结束, 多次调用只有第一次有效, sampled的span交给tracer导出
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.lock.Unlock()
+	if s.sc.Sampled() && s.tracer != nil {
+		s.tracer.export(s.data())
+	}
+}
+
+func (s *Span) data() *SpanData {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sd := &SpanData{
+		TraceID:    s.sc.TraceID.String(),
+		SpanID:     s.sc.SpanID.String(),
+		TraceState: s.sc.State,
+		Name:       s.name,
+		Kind:       s.kind,
+		Start:      s.start,
+		End:        s.end,
+		Attributes: append([]Attribute(nil), s.attrs...),
+		Status:     s.status,
+		Message:    s.msg,
+	}
+	if s.parent.IsValid() {
+		sd.ParentID = s.parent.String()
+	}
+	return sd
+}
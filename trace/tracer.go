@@ -0,0 +1,265 @@
+//
+// tracer.go
+// 采样及批量导出
+//
+
+package trace
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueueSize = 2048
+	defaultBatchSize = 512
+)
+
+// 批量导出的间隔
+var BatchInterval = time.Second
+
+type (
+	// Exporter 导出结束的span
+	Exporter interface {
+		ExportSpans(ctx context.Context, spans []*SpanData) error
+		Shutdown(ctx context.Context) error
+	}
+
+	Tracer struct {
+		lock     sync.RWMutex
+		service  string
+		ratio    float64
+		exporter Exporter
+		queue    chan *SpanData
+		flush    chan chan struct{}
+		quit     chan struct{}
+		done     chan struct{}
+		dropped  uint64
+	}
+)
+
+// 进程级别的tracer
+var Default = NewTracer()
+
+// 默认全部采样, 没有exporter时span只用于传播, 不导出
+func NewTracer() *Tracer {
+	return &Tracer{ratio: 1}
+}
+
+func SetService(name string) { Default.SetService(name) }
+func (t *Tracer) SetService(name string) {
+	t.lock.Lock()
+	t.service = name
+	t.lock.Unlock()
+}
+
+/* {{{ func (t *Tracer) SetSampleRatio(ratio float64)
+ * 新trace的采样比例(0~1), 有父span时跟随父span的sampled
+ */
+func SetSampleRatio(ratio float64) { Default.SetSampleRatio(ratio) }
+func (t *Tracer) SetSampleRatio(ratio float64) {
+	t.lock.Lock()
+	t.ratio = ratio
+	t.lock.Unlock()
+}
+
+/* }}} */
+
+// 按trace id采样, 同一trace在各服务的结果一致
+func (t *Tracer) sample(id TraceID) bool {
+	t.lock.RLock()
+	ratio := t.ratio
+	t.lock.RUnlock()
+	switch {
+	case ratio >= 1:
+		return true
+	case ratio <= 0:
+		return false
+	}
+	return binary.BigEndian.Uint64(id[8:])>>1 < uint64(ratio*(1<<63))
+}
+
+/* {{{ func (t *Tracer) SetExporter(e Exporter)
+ * 设置exporter并开始批量导出, 替换时旧的exporter由调用方关闭
+ */
+func SetExporter(e Exporter) { Default.SetExporter(e) }
+func (t *Tracer) SetExporter(e Exporter) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.exporter = e
+	if t.queue == nil {
+		t.queue = make(chan *SpanData, defaultQueueSize)
+		t.flush = make(chan chan struct{})
+		t.quit = make(chan struct{})
+		t.done = make(chan struct{})
+		go t.loop(t.queue, t.flush, t.quit, t.done)
+	}
+}
+
+/* }}} */
+
+func (t *Tracer) Start(ctx context.Context, name string, opts ...interface{}) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s := &Span{tracer: t, name: name, kind: SpanKindInternal}
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case SpanKind:
+			s.kind = o
+		case Attribute:
+			s.attrs = append(s.attrs, o)
+		case []Attribute:
+			s.attrs = append(s.attrs, o...)
+		case time.Time:
+			s.start = o
+		}
+	}
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+	s.sc.SpanID = newSpanID()
+	if parent := SpanContextFromContext(ctx); parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Flags = parent.Flags
+		s.sc.State = parent.State
+		s.parent = parent.SpanID
+	} else {
+		s.sc.TraceID = newTraceID()
+		if t.sample(s.sc.TraceID) {
+			s.sc.Flags = FlagSampled
+		}
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+// 放入队列, 满了丢弃
+func (t *Tracer) export(sd *SpanData) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if t.exporter == nil {
+		return
+	}
+	sd.Service = t.service
+	select {
+	case t.queue <- sd:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// 因队列满丢弃的span数
+func (t *Tracer) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+func (t *Tracer) loop(queue chan *SpanData, flush chan chan struct{}, quit, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(BatchInterval)
+	defer ticker.Stop()
+	batch := make([]*SpanData, 0, defaultBatchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		t.lock.RLock()
+		e := t.exporter
+		t.lock.RUnlock()
+		if e != nil {
+			if err := e.ExportSpans(context.Background(), batch); err != nil {
+				Warn("[trace]export %d spans failed: %s", len(batch), err)
+			}
+		}
+		batch = make([]*SpanData, 0, defaultBatchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case sd := <-queue:
+				if batch = append(batch, sd); len(batch) >= defaultBatchSize {
+					send()
+				}
+			default:
+				send()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case sd := <-queue:
+			if batch = append(batch, sd); len(batch) >= defaultBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ch := <-flush:
+			drain()
+			close(ch)
+		case <-quit:
+			drain()
+			return
+		}
+	}
+}
+
+/* {{{ func (t *Tracer) Flush(ctx context.Context) error
+ * 立即导出队列中的span
+ */
+func Flush(ctx context.Context) error { return Default.Flush(ctx) }
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.lock.RLock()
+	flush := t.flush
+	t.lock.RUnlock()
+	if flush == nil {
+		return nil
+	}
+	ch := make(chan struct{})
+	select {
+	case flush <- ch:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/* }}} */
+
+/* {{{ func (t *Tracer) Shutdown(ctx context.Context) error
+ * 导出剩余的span并关闭exporter, 之后可以再SetExporter
+ */
+func Shutdown(ctx context.Context) error { return Default.Shutdown(ctx) }
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.lock.Lock()
+	quit, done, e := t.quit, t.done, t.exporter
+	t.queue, t.flush, t.quit, t.done = nil, nil, nil, nil
+	t.lock.Unlock()
+	if quit == nil {
+		return nil
+	}
+	close(quit)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	t.lock.Lock()
+	if t.exporter == e && t.queue == nil {
+		t.exporter = nil
+	}
+	t.lock.Unlock()
+	if e != nil {
+		return e.Shutdown(ctx)
+	}
+	return nil
+}
+
+/* }}} */
@@ -0,0 +1,167 @@
+//
+// tracing.go
+// 分布式追踪: Prepare中解析/生成W3C traceparent, handler, wrpc, job的span, exporter配置
+//
+
+package wgo
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"wgo/environ"
+	"wgo/storage"
+	"wgo/trace"
+	"wgo/whttp"
+	"wgo/wrpc"
+)
+
+type (
+	// TraceConfig 配置中的trace部分
+	TraceConfig struct {
+		Exporter    string            `mapstructure:"exporter"` // stdout/file/otlp, 为空时只传播不导出
+		Path        string            `mapstructure:"path"`     // exporter为file时的文件
+		Endpoint    string            `mapstructure:"endpoint"` // exporter为otlp时的地址, 如http://127.0.0.1:4318
+		Headers     map[string]string `mapstructure:"headers"`  // otlp请求的额外header
+		Timeout     time.Duration     `mapstructure:"timeout"`  // otlp请求超时
+		SampleRatio float64           `mapstructure:"sample_ratio"`
+	}
+)
+
+/* {{{ func (w *WGO) initTrace()
+ * 根据配置设置exporter及采样比例, 关闭时导出剩余的span
+ */
+func (w *WGO) initTrace() {
+	if !w.Cfg().IsSet(environ.CFG_KEY_TRACE) {
+		return
+	}
+	tc := &TraceConfig{SampleRatio: 1}
+	if err := w.Cfg().UnmarshalKey(environ.CFG_KEY_TRACE, tc); err != nil {
+		w.Logger().Error("[trace]invalid config: %s", err)
+		return
+	}
+	service := w.Env().ServiceName
+	if service == "" {
+		service = w.Env().ProcName
+	}
+	trace.SetService(service)
+	trace.SetSampleRatio(tc.SampleRatio)
+
+	var exporter trace.Exporter
+	switch tc.Exporter {
+	case "":
+		return
+	case "stdout":
+		exporter = trace.NewStdoutExporter()
+	case "file":
+		fe, err := trace.NewFileExporter(tc.Path)
+		if err != nil {
+			w.Logger().Error("[trace]open %s failed: %s", tc.Path, err)
+			return
+		}
+		exporter = fe
+	case "otlp":
+		opts := []interface{}{tc.Headers}
+		if tc.Timeout > 0 {
+			opts = append(opts, tc.Timeout)
+		}
+		exporter = trace.NewOTLPExporter(tc.Endpoint, opts...)
+	default:
+		w.Logger().Error("[trace]unknown exporter: %s", tc.Exporter)
+		return
+	}
+	trace.SetExporter(exporter)
+	w.OnStop(func(ctx context.Context) error {
+		return trace.Shutdown(ctx)
+	})
+}
+
+/* }}} */
+
+// 当前的span, 没有时为nil
+func (c *Context) Span() *trace.Span {
+	return trace.SpanFromContext(c.Context())
+}
+
+// 当前的trace id, 没有时为空
+func (c *Context) TraceID() string {
+	if sc := trace.SpanContextFromContext(c.Context()); sc.IsValid() {
+		return sc.TraceID.String()
+	}
+	return ""
+}
+
+// 带当前context的storage, 命令会记录为当前span的子span
+func (c *Context) Storage() *storage.Storage {
+	if s := c.App().Storage(); s != nil {
+		return s.WithContext(c.Context())
+	}
+	return nil
+}
+
+// 请求的server span, 有上游的traceparent时继承
+func (c *Context) startServerSpan() *trace.Span {
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if sc, ok := trace.Extract(c.RequestHeader()); ok {
+		ctx = trace.ContextWithRemote(ctx, sc)
+	}
+	var name string
+	attrs := []trace.Attribute{trace.Attr("request_id", c.RequestID())}
+	switch c.ServerMode() {
+	case "rpc", "wrpc", "grpc":
+		name = c.Request().(*wrpc.Request).Method()
+		attrs = append(attrs, trace.Attr("rpc.system", "grpc"), trace.Attr("rpc.method", name))
+	default:
+		name = c.Method()
+		if route := c.Path(); route != "" {
+			name += " " + route
+			attrs = append(attrs, trace.Attr("http.route", route))
+		}
+		attrs = append(attrs,
+			trace.Attr("http.method", c.Method()),
+			trace.Attr("http.target", c.Request().(whttp.Request).URL().Path()),
+		)
+	}
+	ctx, span := trace.Start(ctx, name, trace.SpanKindServer, attrs)
+	c.SetContext(ctx)
+	return span
+}
+
+func (c *Context) endServerSpan(span *trace.Span, err error) {
+	switch c.ServerMode() {
+	case "rpc", "wrpc", "grpc":
+		if err != nil {
+			span.SetAttr("rpc.code", ErrorCode(err))
+			span.SetError(err)
+		}
+	default:
+		status := responseStatus(c, err)
+		span.SetAttr("http.status_code", status)
+		if err != nil {
+			span.SetError(err)
+		} else if status >= whttp.StatusInternalServerError {
+			span.SetStatus(trace.StatusError, http.StatusText(status))
+		}
+	}
+	span.End()
+}
+
+// job的span, 父span为提交job的请求(持久化的job为入队时的traceparent)
+func (j *Job) startSpan() *trace.Span {
+	ctx, span := trace.Start(j.context.Context(), "job "+j.work+"."+j.method, trace.SpanKindConsumer,
+		trace.Attr("job.work", j.work), trace.Attr("job.method", j.method), trace.Attr("job.id", j.id))
+	j.context.SetContext(ctx)
+	return span
+}
+
+func (j *Job) endSpan(span *trace.Span) {
+	if j.err != nil {
+		span.SetAttr("job.error_code", j.err.Code)
+		span.SetError(j.err)
+	}
+	span.End()
+}
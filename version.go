@@ -30,7 +30,6 @@ var (
 
 func init() {
 	VERSION = Version()
-	Info("Package: %s, Level: %s, Version: %s, Built at: %s", Package, AppLevel, VERSION, BuildTime)
 }
 
 // 获取版本号
@@ -1,15 +1,18 @@
 package wgo
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	wcache "wgo/cache"
 	"wgo/cron"
 	"wgo/daemon"
 	"wgo/environ"
+	"wgo/metrics"
 	"wgo/server"
 	"wgo/storage"
 	"wgo/whttp"
@@ -20,30 +23,58 @@ import (
 type (
 	// WGO options
 	WGO struct {
-		lock     sync.Mutex
-		cfg      *environ.Config  // 配置参数
-		env      *environ.Environ // 环境参数
-		logger   logger           // 日志
-		accessor wlog.Logger
-		storage  *storage.Storage
-		cron     *cron.Cron
-		works    []*WorkerPool
-		servers  Servers
+		lock        sync.Mutex
+		cfg         *environ.Config  // 配置参数
+		env         *environ.Environ // 环境参数
+		logger      logger           // 日志
+		accessor    wlog.Logger
+		accessOnce  sync.Once
+		rateOnce    sync.Once
+		rates       *rateLimits
+		quotaOnce   sync.Once
+		quota       *quotas
+		timeoutOnce sync.Once
+		timeout     *TimeoutConfig
+		corsOnce    sync.Once
+		cors        *CORSConfig
+		jwtOnce     sync.Once
+		jwt         *jwtAuth
+		storage     *storage.Storage
+		cron        *cron.Cron
+		works       []*WorkerPool
+		wp          *WorkerPool // 默认的worker pool(第一个)
+		servers     Servers
+		scs         []server.Config // NewApp传入的servers
+		ready       bool            // setup完成
+
+		healthChecks []*healthCheck
+		healthMu     sync.Mutex
+		healthCache  map[string]*healthCached // 探针的结果缓存, 见cachedHealth
+		registry     *metrics.Registry        // app自己的指标
+
+		// async job
+		jobStore      JobStatusStore
+		jobStatusPath string
+
+		// lifecycle
+		startHooks []StartHook
+		stopHooks  []StopHook
+		stopping   bool
+		stopOnce   sync.Once
+		stopErr    error
+		done       chan error // shutdown结束
+		errc       chan error // server异常退出
 
 		Daemon *daemon.Daemon // 守护进程
 	}
 )
 
 var (
-	debug bool
-	wgo   *WGO
-	wp    *WorkerPool
+	self      atomic.Value // 默认的wgo(*WGO), Register时替换
+	initOnce  sync.Once
+	initHooks []func(*WGO)
 )
 
-func init() {
-	Init()
-}
-
 /* {{{ func New(cfg *Config) *WGO
  * return a new wgo
  */
@@ -52,20 +83,22 @@ func New(env *environ.Environ) *WGO {
 	w := new(WGO)
 
 	w.env = env
+	w.done = make(chan error, 1)
+	w.errc = make(chan error, 1)
 
-	// daemon
-	w.Daemon = (&daemon.Daemon{
+	// daemon, Register时才注册到daemon包
+	w.Daemon = &daemon.Daemon{
 		Daemonize: w.env.Daemonize, // 是否守护进程
 		Dockerize: w.env.Dockerize, // 是否dockerize
 		WorkDir:   w.env.WorkDir,   // 工作目录
 		ExecPath:  w.env.ExecPath,  // 执行文件
 		ProcName:  w.env.ProcName,  // 进程表中的名称
 		PidFile:   w.env.PidFile,   // pidfile, reload时候需要unlock
-	}).Register(w) // 注册到daemon包, 效果是调用daemnon.xxx 等效于 w.Daemon.xxx
-	daemon.RegisterShutdown(w.shutdown)
-
-	// debug mode
-	debug = w.env.DebugMode
+		// 优雅关闭留出余量, 超时后强制退出
+		HammerTime: w.env.ShutdownTimeout + 5*time.Second,
+	}
+	w.Daemon.SetLogger(w)
+	w.Daemon.RegisterShutdown(w.shutdown)
 
 	return w
 }
@@ -73,17 +106,21 @@ func New(env *environ.Environ) *WGO {
 /* }}} */
 
 /* {{{ func Register() *WGO
- *
+ * 作为默认的wgo, 包级别的函数(wgo.GET, wgo.Use等)都作用于它
  */
 func (w *WGO) Register() *WGO {
-	wgo = w
-	return wgo
+	w.Daemon.Register(w) // 注册到daemon包, 效果是调用daemnon.xxx 等效于 w.Daemon.xxx
+	self.Store(w)
+	if w.ready {
+		w.runInitHooks()
+	}
+	return w
 }
 
 /* }}} */
 
 /* {{{ func Init()
- * wgo initialization
+ * 初始化默认的wgo(读取命令行及配置文件), 第一次使用包级别的函数时自动调用
  */
 func Init() {
 	defer func() {
@@ -103,60 +140,157 @@ func Init() {
 	// init env(include read configuration, init logger)
 	env := environ.New(AppLevel).WithConfig()
 
-	// build wgo with env, and register
-	New(env).Register()
+	New(env).Register().setup(true)
+}
+
+/* }}} */
+
+/* {{{ func InitWith(cfg *environ.Config) *WGO
+ * 用给定的配置(不读取配置文件和命令行)重新初始化, 替换当前的wgo, 之前注册的路由/works都不再生效
+ * 主要用于测试, 见wgotest
+ */
+func InitWith(cfg *environ.Config) *WGO {
+	initOnce.Do(func() {}) // 不再自动Init
+	return New(environ.New(AppLevel).SetCfg(cfg).Register().Configure()).Register().setup(false)
+}
+
+/* }}} */
+
+/* {{{ func NewApp(opts ...interface{}) *WGO
+ * 按参数创建一个独立的app, 不读取命令行, 不影响默认的wgo, 同一进程可以有多个app
+ * opts: *environ.Config, ConfigFile(path), ConfigContent(content, type), RunLevel(level),
+ *       *storage.Storage, server.Config(可以多个, 有则忽略配置中的servers)
+ * 路由等通过app.HTTPServers().GET(...), app.AllServers().Use(...)添加, app.Run()启动
+ * 需要包级别的函数(如rest)作用于它时, 调用app.Register()
+ */
+func NewApp(opts ...interface{}) *WGO {
+	ao := newAppOptions(opts...)
+	env := environ.New(ao.level).SetCfg(ao.cfg).Configure()
+	w := New(env)
+	if ao.storage != nil {
+		w.SetStorage(ao.storage)
+	}
+	w.scs = ao.servers
+	return w.setup(false)
+}
 
-	// packages logging
-	whttp.SetLogger(Logger())
-	server.SetLogger(Logger())
-	wrpc.SetLogger(Logger())
-	storage.SetLogger(Logger())
-	cron.SetLogger(Logger())
+/* }}} */
+
+// 根据env初始化wgo, cmd表示是否处理命令行中的命令(status/stop/reload), 只有默认的wgo处理
+func (w *WGO) setup(cmd bool) *WGO {
+	if w == current() {
+		// packages logging
+		whttp.SetLogger(w.Logger())
+		server.SetLogger(w.Logger())
+		wrpc.SetLogger(w.Logger())
+		storage.SetLogger(w.Logger())
+		cron.SetLogger(w.Logger())
+		wcache.SetLogger(w)
+	}
+	w.Info("Package: %s, Level: %s, Version: %s, Built at: %s", Package, w.Env().Level, VERSION, BuildTime)
 
 	// 处理命令
-	if tag := environ.CommandTag(); tag != "" {
-		Info("iterrupt command tag: %s", tag)
-		interceptCmd(tag)
+	if cmd {
+		if tag := environ.CommandTag(); tag != "" {
+			if _, ok := commands[tag]; !ok { // 自定义命令在Run时执行, 那时路由等都已经注册
+				w.Info("iterrupt command tag: %s", tag)
+				w.interceptCmd(tag)
+			}
+		}
 	}
 
 	// init storage
-	initStorage()
+	if w.storage == nil {
+		w.initStorage()
+	}
 
 	// init cron
-	initCron()
+	w.initCron()
+
+	// init trace
+	w.initTrace()
 
 	// add servers
-	if scs := environ.ServersConfig(Cfg()); len(scs) > 0 {
-		for _, sc := range scs {
-			// sc.Name = fmt.Sprintf("%s %s", Env().ProcName, Version())
-			AddServer(sc)
-		}
+	scs := w.scs
+	if len(scs) == 0 {
+		scs = environ.ServersConfig(w.Cfg())
+	}
+	for _, sc := range scs {
+		// sc.Name = fmt.Sprintf("%s %s", Env().ProcName, Version())
+		w.AddServer(sc)
 	}
 
 	// default middleware
-	Use(Recover())
-	Use(Prepare())
-	Use(Access())
-	if env.EnableCache {
-		Use(Cache())
+	ss := w.AllServers()
+	ss.Use(Recover())
+	ss.Use(Prepare())
+	ss.Use(CORS())
+	ss.Use(Metrics())
+	ss.Use(Access())
+	ss.Use(JWT())
+	ss.Use(RateLimit())
+	ss.Use(Quota())
+	if w.Env().EnableCache {
+		ss.Use(Cache())
+	}
+	ss.Use(Timeout())
+
+	// health, metrics, admin
+	w.serveHealth()
+	w.serveMetrics()
+	w.serveAdmin()
+	w.serveQuota()
+
+	w.ready = true
+	if w == current() {
+		w.runInitHooks()
+	}
+	return w
+}
+
+/* {{{ func OnInit(fn func(*WGO))
+ * 默认的wgo初始化(或Register)后调用fn, 已经初始化则立即调用
+ * 依赖默认wgo配置的包(如rest)在init()中用它, 避免import时就Init
+ */
+func OnInit(fn func(*WGO)) {
+	initHooks = append(initHooks, fn)
+	if w := current(); w != nil && w.ready {
+		fn(w)
 	}
 }
 
 /* }}} */
 
+func (w *WGO) runInitHooks() {
+	for _, fn := range initHooks {
+		fn(w)
+	}
+}
+
 /* {{{ func Self() *WGO
- *
+ * 默认的wgo, 还没有初始化则先Init
  */
 func Self() *WGO {
-	return wgo
+	initOnce.Do(func() {
+		if current() == nil {
+			Init()
+		}
+	})
+	return current()
 }
 
 /* }}} */
 
+// 当前默认的wgo, 没有则返回nil
+func current() *WGO {
+	w, _ := self.Load().(*WGO)
+	return w
+}
+
 /* {{{ Cfg() *environ.Config
  * get config info
  */
-func Cfg() *environ.Config { return wgo.Cfg() }
+func Cfg() *environ.Config { return Self().Cfg() }
 func (w *WGO) Cfg() *environ.Config {
 	return w.Env().Cfg()
 }
@@ -166,7 +300,7 @@ func (w *WGO) Cfg() *environ.Config {
 /* {{{ Env() *environ.Environ
  * get env info
  */
-func Env() *environ.Environ { return wgo.Env() }
+func Env() *environ.Environ { return Self().Env() }
 func (w *WGO) Env() *environ.Environ {
 	if w.env == nil { // init env
 		panic("[PANIC] not found env")
@@ -176,36 +310,55 @@ func (w *WGO) Env() *environ.Environ {
 
 /* }}} */
 
-/* {{{ func Run(ces ...server.Engine)
+/* {{{ func Run(ces ...server.Engine) error
  * 可传入自定义的engine `ces = custom engines`
+ * 阻塞直到优雅关闭结束, 返回关闭过程或server启动的错误
  */
-func Run(ces ...server.Engine) { wgo.Run(ces...) }
-func (w *WGO) Run(ces ...server.Engine) {
+func Run(ces ...server.Engine) error { return Self().Run(ces...) }
+func (w *WGO) Run(ces ...server.Engine) error {
 	defer func() {
 		if err := recover(); err != nil {
-			Error("[WGO.Run]crashed with error: %s", err)
+			w.Error("[WGO.Run]crashed with error: %s", err)
 			for i := 1; ; i++ {
 				_, file, line, ok := runtime.Caller(i)
 				if !ok {
 					break
 				}
-				Error("[WGO.Run]%s:%d", file, line)
+				w.Error("[WGO.Run]%s:%d", file, line)
 			}
 			time.Sleep(10 * time.Millisecond)
 			panic(err)
 		}
 	}()
 
-	// daemonize
-	w.daemonize()
+	// daemonize, 进程级别的(spawn, pidfile, 信号), 只有默认的wgo处理
+	if w == current() {
+		w.runCmd(environ.CommandTag())
+		w.daemonize()
+	} else {
+		w.Logger().AddConsole()
+	}
+
+	// start hooks
+	if err := w.start(); err != nil {
+		return err
+	}
 
 	// serve
 	w.serve(ces...)
 
-	// never end
-	//Info("run to end")
-	ch := make(chan os.Signal)
-	<-ch
+	select {
+	case err := <-w.done:
+		return err
+	case err := <-w.errc:
+		// server异常退出, 关闭其余部分
+		ctx, cancel := context.WithTimeout(context.Background(), w.Env().ShutdownTimeout)
+		defer cancel()
+		if serr := w.Shutdown(ctx); serr != nil {
+			w.Error("shutdown error: %s", serr)
+		}
+		return err
+	}
 }
 
 /* }}} */
@@ -229,7 +382,7 @@ func AddWork(label string, opts ...interface{}) *WorkerPool {
 			dh = idh
 		}
 	}
-	return wgo.AddWork(label, max, dh)
+	return Self().AddWork(label, max, dh)
 }
 func (w *WGO) AddWork(label string, max int, jf HandlerFunc) *WorkerPool {
 	if len(w.works) <= 0 {
@@ -237,19 +390,41 @@ func (w *WGO) AddWork(label string, max int, jf HandlerFunc) *WorkerPool {
 	}
 
 	wp := NewWorkerPool(label, max, jf)
+	wp.app = w
+	if cfg := w.Cfg().Sub(environ.CFG_KEY_WORKS); cfg != nil {
+		wp.Configure(cfg.Sub(label))
+	}
 	w.works = append(w.works, wp)
 	return wp
 }
 
-// factory
-func Factory(s *server.Server) *server.Server {
+// all works
+func Works() []*WorkerPool { return Self().Works() }
+func (w *WGO) Works() []*WorkerPool {
+	return w.works
+}
+
+// 按名称查找work, 没有返回nil
+func Work(name string) *WorkerPool { return Self().Work(name) }
+func (w *WGO) Work(name string) *WorkerPool {
+	for _, work := range w.works {
+		if work.Name() == name {
+			return work
+		}
+	}
+	return nil
+}
+
+// factory, server中的context属于w
+func Factory(s *server.Server) *server.Server { return Self().Factory(s) }
+func (w *WGO) Factory(s *server.Server) *server.Server {
 	switch s.Mode() {
 	case server.MODE_RPC, server.MODE_GRPC, server.MODE_WRPC: // all is grpc
-		return wrpc.Factory(s, NewContext, mixWrpcMiddlewares).BuildEngine()
-	case server.MODE_HTTP, server.MODE_HTTPS: // http/https
-		return whttp.Factory(s, NewContext, mixWhttpMiddlewares).BuildEngine()
+		return wrpc.Factory(s, w.NewContext, mixWrpcMiddlewares).BuildEngine()
+	case server.MODE_HTTP, server.MODE_HTTPS, server.MODE_ADMIN: // http/https, admin也是http
+		return whttp.Factory(s, w.NewContext, mixWhttpMiddlewares).BuildEngine()
 	default: // 直接return s, 将使用自定义的server.Engine(在Run的时候使用...)
-		Debug("[wgo.Factory]custom mode: %s", s.Mode())
+		w.Debug("[wgo.Factory]custom mode: %s", s.Mode())
 		return s
 	}
 }
@@ -257,14 +432,14 @@ func Factory(s *server.Server) *server.Server {
 /* {{{ func AddServer(sc environ.Server)
  *
  */
-func AddServer(sc server.Config) { wgo.AddServer(sc) }
+func AddServer(sc server.Config) { Self().AddServer(sc) }
 func (w *WGO) AddServer(sc server.Config) {
 	// 新建server
-	s := server.NewServer(sc)
+	s := server.NewServer(sc).SetLogger(w.Logger())
 	// 装入
 	// Debug("[AddServer]mode: %s, engine: %s", s.Mode(), s.EngineName())
-	w.push(Factory(s))
-	Debug("Added server: %s(%s<%s>)", s.Name(), s.Mode(), s.EngineName())
+	w.push(w.Factory(s))
+	w.Debug("Added server: %s(%s<%s>)", s.Name(), s.Mode(), s.EngineName())
 }
 
 /* }}} */
@@ -273,15 +448,39 @@ func (w *WGO) AddServer(sc server.Config) {
  *
  */
 func (w *WGO) serve(ces ...server.Engine) {
+	w.Build(ces...)
+
+	// start servers
+	for _, s := range w.servers {
+		go func(s *server.Server) {
+			if err := s.ListenAndServe(w.Daemon); err != nil && !w.ShuttingDown() {
+				w.Error("server(%s) exited: %s", s.Name(), err)
+				select {
+				case w.errc <- err:
+				default:
+				}
+			}
+		}(s)
+	}
+}
+
+/* }}} */
+
+/* {{{ func Build(ces ...server.Engine)
+ * 启动works, 构建各server的路由, 不监听端口(Run中调用, 测试时可以直接把请求交给engine)
+ * 只能调用一次, 之后添加的路由不会生效
+ */
+func Build(ces ...server.Engine) { Self().Build(ces...) }
+func (w *WGO) Build(ces ...server.Engine) {
 	// works
 	if len(w.works) > 0 {
 		for i, worker := range w.works {
 			if i == 0 {
 				// 注册第一个为默认
-				Debug("start work(default): %s", worker.Name())
+				w.Debug("start work(default): %s", worker.Name())
 				worker.Start().Register()
 			} else {
-				Debug("start work(%d): %s", i, worker.Name())
+				w.Debug("start work(%d): %s", i, worker.Name())
 				worker.Start()
 			}
 		}
@@ -292,30 +491,17 @@ func (w *WGO) serve(ces ...server.Engine) {
 		if s.Engine() == nil {
 			for _, ce := range ces {
 				if s.Mode() == ce.Name() { // engine名称与mode需要匹配
-					Debug("[wgo.Run]found custom server engine: %s", ce.Name())
+					w.Debug("[wgo.Run]found custom server engine: %s", ce.Name())
 					s.SetEngine(ce)
 				}
 			}
 		}
 	}
 
-	// start servers
-	wg := new(sync.WaitGroup)
 	for _, s := range w.servers {
-		wg.Add(1)
-		go func(s *server.Server) {
-			defer wg.Done()
-
-			// prepare, build routes, etc...
-			s.Prepare()
-
-			if err := s.ListenAndServe(w.Daemon); err != nil {
-				panic(err)
-			}
-		}(s)
+		// prepare, build routes, etc...
+		s.Prepare()
 	}
-
-	wg.Wait()
 }
 
 /* }}} */
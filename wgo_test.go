@@ -0,0 +1,35 @@
+package wgo_test
+
+import (
+	"sync"
+	"testing"
+
+	"wgo"
+	"wgo/wgotest"
+)
+
+// Register与Self并发, 需要在-race下运行
+func TestSelfRegister(t *testing.T) {
+	app := wgotest.New("proc_name: selftest")
+	defer app.Close()
+	other := wgo.NewApp(wgo.ConfigContent("proc_name: other", "yaml"))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if w := wgo.Self(); w != app.WGO && w != other {
+					t.Errorf("self: %p", w)
+					return
+				}
+			}
+		}()
+	}
+	other.Register()
+	app.Register()
+	wg.Wait()
+	if wgo.Self() != app.WGO {
+		t.Errorf("self is not the registered app")
+	}
+}
@@ -0,0 +1,251 @@
+//
+// db.go
+// database/sql的假驱动, 按正则匹配sql返回预设的结果, 记录执行过的sql
+//
+// db := wgotest.NewFakeDB()
+// db.Expect(`^SELECT .* FROM .user.`).Rows([]string{"id", "name"}, []interface{}{1, "odin"})
+// db.Expect(`^INSERT INTO .user.`).Result(10, 1)
+//
+
+package wgotest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"wgo/gorp"
+	"wgo/rest"
+)
+
+const driverName = "wgotest"
+
+var (
+	dbOnce sync.Once
+	dbSeq  int64
+	dbs    sync.Map // dsn => *FakeDB
+)
+
+type (
+	FakeDB struct {
+		dsn string
+
+		mu      sync.Mutex
+		expects []*FakeQuery
+		queries []Query
+		lastID  int64
+	}
+
+	// FakeQuery 一条预设, 默认可以匹配任意次
+	FakeQuery struct {
+		re       *regexp.Regexp
+		cols     []string
+		rows     [][]driver.Value
+		lastID   int64
+		affected int64
+		result   bool
+		err      error
+		times    int // 0为不限
+		used     int
+	}
+
+	// Query 执行过的sql及参数
+	Query struct {
+		SQL  string
+		Args []interface{}
+	}
+
+	fakeDriver struct{}
+	fakeConn   struct{ db *FakeDB }
+	fakeStmt   struct {
+		db    *FakeDB
+		query string
+	}
+	fakeRows struct {
+		cols []string
+		rows [][]driver.Value
+		pos  int
+	}
+	fakeResult struct {
+		lastID, affected int64
+	}
+)
+
+func NewFakeDB() *FakeDB {
+	dbOnce.Do(func() {
+		sql.Register(driverName, fakeDriver{})
+	})
+	db := &FakeDB{
+		dsn:     fmt.Sprintf("fake-%d", atomic.AddInt64(&dbSeq, 1)),
+		expects: make([]*FakeQuery, 0),
+		queries: make([]Query, 0),
+	}
+	dbs.Store(db.dsn, db)
+	return db
+}
+
+// 增加一条预设, pattern为匹配sql的正则(不区分大小写), 先添加的优先
+func (db *FakeDB) Expect(pattern string) *FakeQuery {
+	fq := &FakeQuery{re: regexp.MustCompile("(?i)" + pattern)}
+	db.mu.Lock()
+	db.expects = append(db.expects, fq)
+	db.mu.Unlock()
+	return fq
+}
+
+// 执行过的所有sql
+func (db *FakeDB) Queries() []Query {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]Query(nil), db.queries...)
+}
+
+// 清空预设及记录
+func (db *FakeDB) Reset() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.expects = make([]*FakeQuery, 0)
+	db.queries = make([]Query, 0)
+}
+
+/* {{{ func (db *FakeDB) Open(tag string) error
+ * 以tag打开gorp连接(已存在的会先关闭), 与rest.OpenDB一致
+ */
+func (db *FakeDB) Open(tag string) error {
+	gorp.Close(tag)
+	gorp.SetTypeConvert(rest.BaseConverter{})
+	return gorp.Open(tag, driverName, db.dsn)
+}
+
+/* }}} */
+
+// 记录sql并查找匹配的预设
+func (db *FakeDB) match(query string, args []driver.Value) *FakeQuery {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q := Query{SQL: query, Args: make([]interface{}, len(args))}
+	for i, a := range args {
+		q.Args[i] = a
+	}
+	db.queries = append(db.queries, q)
+	for _, fq := range db.expects {
+		if fq.times > 0 && fq.used >= fq.times {
+			continue
+		}
+		if fq.re.MatchString(query) {
+			fq.used++
+			return fq
+		}
+	}
+	return nil
+}
+
+// 查询返回的列和行
+func (fq *FakeQuery) Rows(cols []string, rows ...[]interface{}) *FakeQuery {
+	fq.cols = cols
+	fq.rows = make([][]driver.Value, 0, len(rows))
+	for _, row := range rows {
+		vs := make([]driver.Value, len(row))
+		for i, v := range row {
+			cv, err := driver.DefaultParameterConverter.ConvertValue(v)
+			if err != nil {
+				panic(fmt.Sprintf("[wgotest]invalid row value(%T): %s", v, err))
+			}
+			vs[i] = cv
+		}
+		fq.rows = append(fq.rows, vs)
+	}
+	return fq
+}
+
+// exec的结果
+func (fq *FakeQuery) Result(lastID, affected int64) *FakeQuery {
+	fq.lastID, fq.affected, fq.result = lastID, affected, true
+	return fq
+}
+
+func (fq *FakeQuery) Error(err error) *FakeQuery {
+	fq.err = err
+	return fq
+}
+
+// 只匹配n次
+func (fq *FakeQuery) Times(n int) *FakeQuery {
+	fq.times = n
+	return fq
+}
+
+// 已匹配的次数
+func (fq *FakeQuery) Used() int {
+	return fq.used
+}
+
+// driver
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	if v, ok := dbs.Load(dsn); ok {
+		return &fakeConn{db: v.(*FakeDB)}, nil
+	}
+	return nil, fmt.Errorf("wgotest: unknown fake db %q", dsn)
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+// 事务不做任何事
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+
+func (c *fakeConn) Commit() error { return nil }
+
+func (c *fakeConn) Rollback() error { return nil }
+
+func (s *fakeStmt) Close() error { return nil }
+
+func (s *fakeStmt) NumInput() int { return -1 }
+
+// 没有匹配的预设时, affected为1, lastID自增
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	fq := s.db.match(s.query, args)
+	if fq != nil && fq.err != nil {
+		return nil, fq.err
+	}
+	if fq != nil && fq.result {
+		return fakeResult{lastID: fq.lastID, affected: fq.affected}, nil
+	}
+	return fakeResult{lastID: atomic.AddInt64(&s.db.lastID, 1), affected: 1}, nil
+}
+
+// 没有匹配的预设时返回空结果
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	fq := s.db.match(s.query, args)
+	if fq == nil {
+		return &fakeRows{}, nil
+	}
+	if fq.err != nil {
+		return nil, fq.err
+	}
+	return &fakeRows{cols: fq.cols, rows: fq.rows}, nil
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.pos])
+	r.pos++
+	return nil
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.lastID, nil }
+
+func (r fakeResult) RowsAffected() (int64, error) { return r.affected, nil }
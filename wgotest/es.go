@@ -0,0 +1,260 @@
+//
+// es.go
+// 假的elasticsearch(httptest.Server), 支持index的创建/判断, _bulk, _doc, _search(返回index中所有文档)
+//
+
+package wgotest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/olivere/elastic/v7"
+
+	"wgo/rest"
+)
+
+type (
+	FakeES struct {
+		*httptest.Server
+
+		mu       sync.Mutex
+		indexes  map[string]map[string]json.RawMessage // index => id => source
+		requests []ESRequest
+		stubs    []esStub
+	}
+
+	// ESRequest 收到的请求
+	ESRequest struct {
+		Method string
+		Path   string
+		Body   string
+	}
+
+	esStub struct {
+		method, path string
+		status       int
+		body         string
+	}
+)
+
+func NewFakeES() *FakeES {
+	es := &FakeES{
+		indexes:  make(map[string]map[string]json.RawMessage),
+		requests: make([]ESRequest, 0),
+	}
+	es.Server = httptest.NewServer(http.HandlerFunc(es.serve))
+	return es
+}
+
+// 指向该server的client
+func (es *FakeES) Client() *elastic.Client {
+	client, err := elastic.NewClient(
+		elastic.SetURL(es.URL),
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+	)
+	if err != nil {
+		panic("[wgotest]new elastic client failed: " + err.Error())
+	}
+	return client
+}
+
+// 作为rest使用的elasticsearch
+func (es *FakeES) Use() {
+	rest.ElasticClient = es.Client()
+}
+
+// 对method+path(如"POST /orders/_search")返回固定的结果, 优先于默认处理
+func (es *FakeES) Respond(method, path string, status int, body string) *FakeES {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.stubs = append(es.stubs, esStub{method: method, path: path, status: status, body: body})
+	return es
+}
+
+// index中的所有文档
+func (es *FakeES) Docs(index string) map[string]json.RawMessage {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	docs := make(map[string]json.RawMessage)
+	for id, src := range es.indexes[index] {
+		docs[id] = src
+	}
+	return docs
+}
+
+// 收到的所有请求
+func (es *FakeES) Requests() []ESRequest {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return append([]ESRequest(nil), es.requests...)
+}
+
+// 调用方持有锁
+func (es *FakeES) index(name string, create bool) map[string]json.RawMessage {
+	idx, ok := es.indexes[name]
+	if !ok && create {
+		idx = make(map[string]json.RawMessage)
+		es.indexes[name] = idx
+	}
+	return idx
+}
+
+/* {{{ func (es *FakeES) serve(w http.ResponseWriter, r *http.Request)
+ *
+ */
+func (es *FakeES) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.requests = append(es.requests, ESRequest{Method: r.Method, Path: r.URL.Path, Body: string(body)})
+
+	reply := func(status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if s, ok := v.(string); ok {
+			w.Write([]byte(s))
+		} else {
+			json.NewEncoder(w).Encode(v)
+		}
+	}
+	for _, st := range es.stubs {
+		if st.method == r.Method && st.path == r.URL.Path {
+			reply(st.status, st.body)
+			return
+		}
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] == "" {
+		reply(http.StatusOK, map[string]interface{}{
+			"name":    "wgotest",
+			"version": map[string]string{"number": "7.6.0"},
+			"tagline": "You Know, for Search",
+		})
+		return
+	}
+	switch {
+	case parts[len(parts)-1] == "_bulk":
+		dft := ""
+		if len(parts) > 1 {
+			dft = parts[0]
+		}
+		reply(http.StatusOK, es.bulk(dft, body))
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodHead:
+			if es.index(parts[0], false) == nil {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			es.index(parts[0], true)
+			reply(http.StatusOK, map[string]interface{}{"acknowledged": true, "index": parts[0]})
+		case http.MethodDelete:
+			delete(es.indexes, parts[0])
+			reply(http.StatusOK, map[string]interface{}{"acknowledged": true})
+		default:
+			reply(http.StatusOK, map[string]interface{}{})
+		}
+	case parts[1] == "_search":
+		reply(http.StatusOK, es.search(parts[0]))
+	case parts[1] == "_doc" && len(parts) == 3:
+		id := parts[2]
+		switch r.Method {
+		case http.MethodGet:
+			if src, ok := es.index(parts[0], false)[id]; ok {
+				reply(http.StatusOK, map[string]interface{}{"_index": parts[0], "_id": id, "found": true, "_source": src})
+			} else {
+				reply(http.StatusNotFound, map[string]interface{}{"_index": parts[0], "_id": id, "found": false})
+			}
+		case http.MethodDelete:
+			delete(es.index(parts[0], true), id)
+			reply(http.StatusOK, map[string]interface{}{"_index": parts[0], "_id": id, "result": "deleted"})
+		default:
+			es.index(parts[0], true)[id] = json.RawMessage(body)
+			reply(http.StatusOK, map[string]interface{}{"_index": parts[0], "_id": id, "result": "created"})
+		}
+	default:
+		reply(http.StatusOK, map[string]interface{}{})
+	}
+}
+
+/* }}} */
+
+// ndjson: action行 + 文档行(delete没有文档行)
+func (es *FakeES) bulk(dft string, body []byte) map[string]interface{} {
+	items := make([]interface{}, 0)
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var action map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal(line, &action); err != nil {
+			continue
+		}
+		for op, meta := range action {
+			idx := meta.Index
+			if idx == "" {
+				idx = dft
+			}
+			result := "created"
+			if op == "delete" {
+				delete(es.index(idx, true), meta.ID)
+				result = "deleted"
+			} else if sc.Scan() {
+				doc := append([]byte(nil), sc.Bytes()...)
+				if op == "update" { // {"doc": {...}}
+					var u struct {
+						Doc json.RawMessage `json:"doc"`
+					}
+					if json.Unmarshal(doc, &u) == nil && u.Doc != nil {
+						doc = u.Doc
+					}
+					result = "updated"
+				}
+				es.index(idx, true)[meta.ID] = json.RawMessage(doc)
+			}
+			items = append(items, map[string]interface{}{
+				op: map[string]interface{}{"_index": idx, "_id": meta.ID, "status": http.StatusOK, "result": result},
+			})
+		}
+	}
+	return map[string]interface{}{"took": 1, "errors": false, "items": items}
+}
+
+// 不解析查询条件, 返回index中的所有文档(按id排序)
+func (es *FakeES) search(index string) map[string]interface{} {
+	docs := es.index(index, false)
+	ids := make([]string, 0, len(docs))
+	for id := range docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	hits := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		hits = append(hits, map[string]interface{}{"_index": index, "_id": id, "_score": 1, "_source": docs[id]})
+	}
+	return map[string]interface{}{
+		"took":      1,
+		"timed_out": false,
+		"hits": map[string]interface{}{
+			"total":     map[string]interface{}{"value": len(hits), "relation": "eq"},
+			"max_score": 1,
+			"hits":      hits,
+		},
+	}
+}
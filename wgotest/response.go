@@ -0,0 +1,61 @@
+//
+// response.go
+// 请求结果及断言, 断言失败调用t.Fatalf并输出body
+//
+
+package wgotest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type Response struct {
+	Code   int
+	Header http.Header
+	Body   []byte
+}
+
+func (r *Response) String() string {
+	return string(r.Body)
+}
+
+// 把body解析到v
+func (r *Response) JSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+func (r *Response) Expect(t testing.TB, code int) *Response {
+	t.Helper()
+	if r.Code != code {
+		t.Fatalf("expect status %d, got %d, body: %s", code, r.Code, r.Body)
+	}
+	return r
+}
+
+func (r *Response) ExpectHeader(t testing.TB, key, value string) *Response {
+	t.Helper()
+	if v := r.Header.Get(key); v != value {
+		t.Fatalf("expect header %s: %q, got %q, body: %s", key, value, v, r.Body)
+	}
+	return r
+}
+
+func (r *Response) ExpectContains(t testing.TB, s string) *Response {
+	t.Helper()
+	if !strings.Contains(string(r.Body), s) {
+		t.Fatalf("expect body contains %q, got: %s", s, r.Body)
+	}
+	return r
+}
+
+// 把body解析到v, 失败时Fatalf
+func (r *Response) ExpectJSON(t testing.TB, v interface{}) *Response {
+	t.Helper()
+	if err := r.JSON(v); err != nil {
+		t.Fatalf("decode body failed: %s, body: %s", err, r.Body)
+	}
+	return r
+}
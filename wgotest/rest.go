@@ -0,0 +1,56 @@
+//
+// rest.go
+// rest model内置路由的请求封装: app.Model("users").Create(u), Get(id), List(query)...
+//
+
+package wgotest
+
+import (
+	"net/url"
+	"strings"
+)
+
+type Model struct {
+	app      *App
+	endpoint string
+	headers  []map[string]string
+}
+
+// endpoint为model路由的前缀(复数形式, 如"users"), headers附加到每个请求
+func (a *App) Model(endpoint string, headers ...map[string]string) *Model {
+	return &Model{app: a, endpoint: "/" + strings.Trim(endpoint, "/"), headers: headers}
+}
+
+// POST /{endpoint}
+func (m *Model) Create(body interface{}) *Response {
+	return m.app.POST(m.endpoint, body, m.headers...)
+}
+
+// GET /{endpoint}/{id}
+func (m *Model) Get(id string) *Response {
+	return m.app.GET(m.endpoint+"/"+id, m.headers...)
+}
+
+// GET /{endpoint}?query
+func (m *Model) List(query url.Values) *Response {
+	path := m.endpoint
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return m.app.GET(path, m.headers...)
+}
+
+// PATCH /{endpoint}/{id}
+func (m *Model) Update(id string, body interface{}) *Response {
+	return m.app.PATCH(m.endpoint+"/"+id, body, m.headers...)
+}
+
+// PUT /{endpoint}/{id}
+func (m *Model) Replace(id string, body interface{}) *Response {
+	return m.app.PUT(m.endpoint+"/"+id, body, m.headers...)
+}
+
+// DELETE /{endpoint}/{id}
+func (m *Model) Delete(id string) *Response {
+	return m.app.DELETE(m.endpoint+"/"+id, m.headers...)
+}
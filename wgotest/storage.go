@@ -0,0 +1,554 @@
+//
+// storage.go
+// 内存实现的storage节点, 值的形式与redis一致(Get返回[]byte), Do只支持常用命令, EVAL需要SetEvaluator
+//
+
+package wgotest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wgo/storage"
+	"wgo/storage/core"
+)
+
+type (
+	// MemoryCache 实现core.Cache
+	MemoryCache struct {
+		mu    sync.Mutex
+		items map[string]*memItem
+		eval  Evaluator
+	}
+
+	// Evaluator 执行EVAL(args为script numkeys key... arg...), 脚本中的redis.call通过do执行
+	// 调用时MemoryCache已经持有锁, 与redis一样是原子的
+	Evaluator func(do func(cmd string, args ...interface{}) (interface{}, error), args ...interface{}) (interface{}, error)
+
+	memItem struct {
+		str    []byte
+		hash   map[string][]byte
+		list   [][]byte
+		zset   map[string]float64
+		expire time.Time
+	}
+)
+
+// 使用一个内存节点的storage
+func NewStorage() *storage.Storage {
+	return storage.NewWithCaches("memory", NewMemoryCache())
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{items: make(map[string]*memItem)}
+}
+
+// 设置EVAL的实现, wgotest本身不带lua引擎, 没有设置时EVAL返回错误
+func (mc *MemoryCache) SetEvaluator(e Evaluator) *MemoryCache {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.eval = e
+	return mc
+}
+
+// 与redigo的参数编码一致
+func encode(v interface{}) []byte {
+	switch t := v.(type) {
+	case nil:
+		return []byte{}
+	case []byte:
+		return append([]byte(nil), t...)
+	case string:
+		return []byte(t)
+	case bool:
+		if t {
+			return []byte("1")
+		}
+		return []byte("0")
+	case float64:
+		return []byte(strconv.FormatFloat(t, 'g', -1, 64))
+	default:
+		return []byte(fmt.Sprint(t))
+	}
+}
+
+// 获取未过期的item, 调用方持有锁
+func (mc *MemoryCache) item(key string) *memItem {
+	it, ok := mc.items[key]
+	if !ok {
+		return nil
+	}
+	if !it.expire.IsZero() && time.Now().After(it.expire) {
+		delete(mc.items, key)
+		return nil
+	}
+	return it
+}
+
+func (mc *MemoryCache) Get(key string) interface{} {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if it := mc.item(key); it != nil && it.str != nil {
+		return it.str
+	}
+	return nil
+}
+
+func (mc *MemoryCache) GetSet(key string, val interface{}) (interface{}, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	var old interface{}
+	if it := mc.item(key); it != nil && it.str != nil {
+		old = it.str
+	}
+	mc.items[key] = &memItem{str: encode(val)}
+	return old, nil
+}
+
+func (mc *MemoryCache) HGet(key string, field string) interface{} {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	it := mc.item(key)
+	if field != "" {
+		v, _ := it.field(field)
+		return v
+	}
+	return it.hgetall()
+}
+
+// 与HGETALL一致, field/value交替
+func (it *memItem) hgetall() []interface{} {
+	all := make([]interface{}, 0)
+	if it != nil {
+		for f, v := range it.hash {
+			all = append(all, []byte(f), v)
+		}
+	}
+	return all
+}
+
+func (mc *MemoryCache) GetMulti(keys []string) []interface{} {
+	rv := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		rv = append(rv, mc.Get(key))
+	}
+	return rv
+}
+
+// opts[0]为true时相当于NX, timeout为0不过期
+func (mc *MemoryCache) Put(key string, val interface{}, timeout time.Duration, opts ...interface{}) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if len(opts) > 0 {
+		if nx, ok := opts[0].(bool); ok && nx && mc.item(key) != nil {
+			return nil
+		}
+	}
+	it := &memItem{str: encode(val)}
+	if timeout > 0 {
+		it.expire = time.Now().Add(timeout)
+	}
+	mc.items[key] = it
+	return nil
+}
+
+func (mc *MemoryCache) Delete(key string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.items, key)
+	return nil
+}
+
+func (mc *MemoryCache) Incr(key string) (int, error) {
+	return mc.IncrBy(key, 1)
+}
+
+func (mc *MemoryCache) Decr(key string) (int, error) {
+	return mc.IncrBy(key, -1)
+}
+
+func (mc *MemoryCache) DecrBy(key string, num int) (int, error) {
+	return mc.IncrBy(key, -num)
+}
+
+func (mc *MemoryCache) IncrBy(key string, num int) (int, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.incrBy(key, num)
+}
+
+// 调用方持有锁
+func (mc *MemoryCache) incrBy(key string, num int) (int, error) {
+	it := mc.item(key)
+	if it == nil {
+		it = &memItem{str: []byte("0")}
+		mc.items[key] = it
+	}
+	n, err := strconv.Atoi(string(it.str))
+	if err != nil {
+		return 0, fmt.Errorf("value is not an integer")
+	}
+	n += num
+	it.str = []byte(strconv.Itoa(n))
+	return n, nil
+}
+
+func (mc *MemoryCache) Push(key string, val interface{}) error {
+	_, err := mc.Do("RPUSH", key, val)
+	return err
+}
+
+func (mc *MemoryCache) Pop(key string) (interface{}, error) {
+	return mc.Do("LPOP", key)
+}
+
+func (mc *MemoryCache) IsExist(key string) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.item(key) != nil
+}
+
+/* {{{ func (mc *MemoryCache) Do(cmd string, args ...interface{}) (interface{}, error)
+ * 支持: PING GET SET(NX/XX/EX/PX) DEL EXISTS EXPIRE PEXPIRE INCR INCRBY DECR DECRBY
+ * HGET HSET HDEL HEXISTS HMGET HMSET HVALS HGETALL LPUSH RPUSH LPOP RPOP LLEN
+ * ZADD ZREM ZCARD ZSCORE ZRANGEBYSCORE(LIMIT) EVAL(见SetEvaluator)
+ */
+func (mc *MemoryCache) Do(cmd string, args ...interface{}) (interface{}, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.do(strings.ToUpper(cmd), args...)
+}
+
+// 调用方持有锁, lua脚本中的redis.call也在这里执行
+func (mc *MemoryCache) do(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "PING":
+		return "PONG", nil
+	case "EVAL":
+		if mc.eval == nil {
+			return nil, fmt.Errorf("ERR EVAL not supported, see MemoryCache.SetEvaluator")
+		}
+		return mc.eval(mc.do, args...)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("wrong number of arguments for '%s'", cmd)
+	}
+	key := string(encode(args[0]))
+	it := mc.item(key)
+	switch cmd {
+	case "GET":
+		if it != nil && it.str != nil {
+			return it.str, nil
+		}
+		return nil, nil
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		num := 1
+		if strings.HasSuffix(cmd, "BY") {
+			if len(args) < 2 {
+				return nil, fmt.Errorf("wrong number of arguments for '%s'", cmd)
+			}
+			var err error
+			if num, err = strconv.Atoi(string(encode(args[1]))); err != nil {
+				return nil, fmt.Errorf("value is not an integer")
+			}
+		}
+		if strings.HasPrefix(cmd, "DECR") {
+			num = -num
+		}
+		n, err := mc.incrBy(key, num)
+		return int64(n), err
+	case "HGETALL":
+		return it.hgetall(), nil
+	case "SET": // 支持NX/XX/EX/PX, 没有写入时返回nil
+		if len(args) < 2 {
+			return nil, fmt.Errorf("wrong number of arguments for '%s'", cmd)
+		}
+		var ttl time.Duration
+		for i := 2; i < len(args); i++ {
+			switch opt := strings.ToUpper(string(encode(args[i]))); opt {
+			case "NX":
+				if it != nil {
+					return nil, nil
+				}
+			case "XX":
+				if it == nil {
+					return nil, nil
+				}
+			case "EX", "PX":
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("syntax error")
+				}
+				i++
+				n, err := strconv.Atoi(string(encode(args[i])))
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("invalid expire time in 'set' command")
+				}
+				if ttl = time.Duration(n) * time.Second; opt == "PX" {
+					ttl = time.Duration(n) * time.Millisecond
+				}
+			default:
+				return nil, fmt.Errorf("syntax error")
+			}
+		}
+		it = &memItem{str: encode(args[1])}
+		if ttl > 0 {
+			it.expire = time.Now().Add(ttl)
+		}
+		mc.items[key] = it
+		return "OK", nil
+	case "DEL":
+		var n int64
+		for _, k := range args {
+			if mc.item(string(encode(k))) != nil {
+				delete(mc.items, string(encode(k)))
+				n++
+			}
+		}
+		return n, nil
+	case "EXISTS":
+		if it != nil {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "EXPIRE", "PEXPIRE":
+		if it == nil || len(args) < 2 {
+			return int64(0), nil
+		}
+		n, _ := strconv.Atoi(string(encode(args[1])))
+		d := time.Duration(n) * time.Second
+		if cmd == "PEXPIRE" {
+			d = time.Duration(n) * time.Millisecond
+		}
+		it.expire = time.Now().Add(d)
+		return int64(1), nil
+	case "HGET", "HEXISTS":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("wrong number of arguments for '%s'", cmd)
+		}
+		v, ok := it.field(string(encode(args[1])))
+		if cmd == "HEXISTS" {
+			if ok {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+		return v, nil
+	case "HMGET":
+		vs := make([]interface{}, 0, len(args)-1)
+		for _, f := range args[1:] {
+			v, _ := it.field(string(encode(f)))
+			vs = append(vs, v)
+		}
+		return vs, nil
+	case "HSET", "HMSET":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for '%s'", cmd)
+		}
+		if it == nil {
+			it = &memItem{}
+			mc.items[key] = it
+		}
+		if it.hash == nil {
+			it.hash = make(map[string][]byte)
+		}
+		var n int64
+		for i := 1; i+1 < len(args); i += 2 {
+			f := string(encode(args[i]))
+			if _, ok := it.hash[f]; !ok {
+				n++
+			}
+			it.hash[f] = encode(args[i+1])
+		}
+		if cmd == "HMSET" {
+			return "OK", nil
+		}
+		return n, nil
+	case "HDEL":
+		var n int64
+		if it != nil && it.hash != nil {
+			for _, f := range args[1:] {
+				if _, ok := it.hash[string(encode(f))]; ok {
+					delete(it.hash, string(encode(f)))
+					n++
+				}
+			}
+		}
+		return n, nil
+	case "HVALS":
+		vals := make([]interface{}, 0)
+		if it != nil {
+			for _, v := range it.hash {
+				vals = append(vals, v)
+			}
+		}
+		return vals, nil
+	case "LPUSH", "RPUSH":
+		if it == nil {
+			it = &memItem{}
+			mc.items[key] = it
+		}
+		for _, v := range args[1:] {
+			if cmd == "LPUSH" {
+				it.list = append([][]byte{encode(v)}, it.list...)
+			} else {
+				it.list = append(it.list, encode(v))
+			}
+		}
+		return int64(len(it.list)), nil
+	case "LPOP", "RPOP":
+		if it == nil || len(it.list) == 0 {
+			return nil, nil
+		}
+		if cmd == "LPOP" {
+			v := it.list[0]
+			it.list = it.list[1:]
+			return v, nil
+		}
+		v := it.list[len(it.list)-1]
+		it.list = it.list[:len(it.list)-1]
+		return v, nil
+	case "LLEN":
+		if it == nil {
+			return int64(0), nil
+		}
+		return int64(len(it.list)), nil
+	case "ZADD":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for '%s'", cmd)
+		}
+		if it == nil {
+			it = &memItem{}
+			mc.items[key] = it
+		}
+		if it.zset == nil {
+			it.zset = make(map[string]float64)
+		}
+		var n int64
+		for i := 1; i+1 < len(args); i += 2 {
+			score, err := strconv.ParseFloat(string(encode(args[i])), 64)
+			if err != nil {
+				return nil, fmt.Errorf("value is not a valid float")
+			}
+			m := string(encode(args[i+1]))
+			if _, ok := it.zset[m]; !ok {
+				n++
+			}
+			it.zset[m] = score
+		}
+		return n, nil
+	case "ZREM":
+		var n int64
+		if it != nil && it.zset != nil {
+			for _, m := range args[1:] {
+				if _, ok := it.zset[string(encode(m))]; ok {
+					delete(it.zset, string(encode(m)))
+					n++
+				}
+			}
+		}
+		return n, nil
+	case "ZCARD":
+		if it == nil {
+			return int64(0), nil
+		}
+		return int64(len(it.zset)), nil
+	case "ZSCORE":
+		if it == nil || len(args) < 2 {
+			return nil, nil
+		}
+		if score, ok := it.zset[string(encode(args[1]))]; ok {
+			return encode(score), nil
+		}
+		return nil, nil
+	case "ZRANGEBYSCORE":
+		return it.zrangeByScore(args[1:]...)
+	}
+	return nil, fmt.Errorf("wgotest: unsupported command '%s'", cmd)
+}
+
+/* }}} */
+
+// hash中的field
+func (it *memItem) field(f string) (interface{}, bool) {
+	if it != nil && it.hash != nil {
+		if v, ok := it.hash[f]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// ZRANGEBYSCORE min max [LIMIT offset count], 不支持开区间及WITHSCORES
+func (it *memItem) zrangeByScore(args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("wrong number of arguments for 'zrangebyscore'")
+	}
+	bound := func(v interface{}) (float64, error) {
+		switch s := strings.ToLower(string(encode(v))); s {
+		case "-inf":
+			return math.Inf(-1), nil
+		case "+inf", "inf":
+			return math.Inf(1), nil
+		default:
+			return strconv.ParseFloat(s, 64)
+		}
+	}
+	min, err := bound(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("min or max is not a float")
+	}
+	max, err := bound(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("min or max is not a float")
+	}
+	offset, count := 0, -1
+	if len(args) == 5 && strings.ToUpper(string(encode(args[2]))) == "LIMIT" {
+		offset, _ = strconv.Atoi(string(encode(args[3])))
+		count, _ = strconv.Atoi(string(encode(args[4])))
+	} else if len(args) != 2 {
+		return nil, fmt.Errorf("syntax error")
+	}
+	ms := make([]string, 0)
+	if it != nil {
+		for m, score := range it.zset {
+			if score >= min && score <= max {
+				ms = append(ms, m)
+			}
+		}
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		if si, sj := it.zset[ms[i]], it.zset[ms[j]]; si != sj {
+			return si < sj
+		}
+		return ms[i] < ms[j]
+	})
+	vs := make([]interface{}, 0, len(ms))
+	for i, m := range ms {
+		if i < offset {
+			continue
+		}
+		if count >= 0 && len(vs) >= count {
+			break
+		}
+		vs = append(vs, []byte(m))
+	}
+	return vs, nil
+}
+
+func (mc *MemoryCache) ClearAll() error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.items = make(map[string]*memItem)
+	return nil
+}
+
+func (mc *MemoryCache) Start(config string) error { return nil }
+
+func (mc *MemoryCache) Close() error { return nil }
+
+var _ core.Cache = (*MemoryCache)(nil)
@@ -0,0 +1,278 @@
+//
+// wgotest.go
+// 进程内测试: 用内存中的配置创建app, 请求直接交给engine处理, 不监听端口
+//
+// app := wgotest.New(`{"proc_name": "demo"}`, wgotest.Engine("fasthttp"), wgotest.WithStorage(wgotest.NewStorage()))
+// defer app.Close()
+// wgo.GET("/hello", hello)
+// app.GET("/hello").Expect(t, 200).ExpectContains(t, "world")
+//
+// New会替换当前的wgo, 路由/works需要在New之后注册(init中AddModel的model需要再AddModel一次), 第一次请求时构建路由
+// wgo/rest model/gorp连接/metrics都是进程级别的, 同一时间只能有一个App, 使用App的测试不能t.Parallel, 结束时需要Close
+//
+
+package wgotest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"wgo"
+	"wgo/environ"
+	"wgo/server"
+	"wgo/storage"
+	"wgo/whttp"
+)
+
+const (
+	defaultRemoteAddr = "127.0.0.1:10000"
+	closeTimeout      = 5 * time.Second
+)
+
+type (
+	// App 测试用的wgo实例, 创建后替换当前的wgo(不隔离, 见文件头), 路由等仍然通过wgo.GET等注册
+	App struct {
+		*wgo.WGO
+
+		cfg     *environ.Config
+		engine  string
+		storage *storage.Storage
+		dbs     map[string]*FakeDB
+		es      *FakeES
+
+		once   sync.Once
+		server *server.Server
+	}
+
+	Option func(*App)
+)
+
+// http engine: standard或fasthttp, 只对默认server有效(配置中没有servers时)
+func Engine(name string) Option {
+	return func(a *App) {
+		a.engine = name
+	}
+}
+
+// 使用指定的storage, 如NewStorage()
+func WithStorage(s *storage.Storage) Option {
+	return func(a *App) {
+		a.storage = s
+	}
+}
+
+// 使用FakeDB作为数据库, tags默认为"db"(rest使用的连接)
+func WithDB(db *FakeDB, tags ...string) Option {
+	return func(a *App) {
+		if len(tags) == 0 {
+			tags = []string{"db"}
+		}
+		for _, tag := range tags {
+			a.dbs[tag] = db
+		}
+	}
+}
+
+// 使用FakeES作为rest的elasticsearch
+func WithES(es *FakeES) Option {
+	return func(a *App) {
+		a.es = es
+	}
+}
+
+/* {{{ func New(cfg string, opts ...Option) *App
+ * cfg为配置内容(json或yaml), 可以为空
+ * 没有配置servers/listen/addr/port时添加一个默认的http server(不会监听)
+ */
+func New(cfg string, opts ...Option) *App {
+	a := &App{
+		cfg: environ.NewConfig(),
+		dbs: make(map[string]*FakeDB),
+	}
+	if cfg = strings.TrimSpace(cfg); cfg != "" {
+		ct := "yaml"
+		if strings.HasPrefix(cfg, "{") {
+			ct = "json"
+		}
+		a.cfg.ReadConfig(cfg, ct)
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.cfg.Get(environ.CFG_KEY_SERVERS) == nil && a.cfg.String(environ.CFG_KEY_LISTEN) == "" &&
+		a.cfg.String(environ.CFG_KEY_ADDR) == "" && a.cfg.String(environ.CFG_KEY_PORT) == "" {
+		a.cfg.Set(environ.CFG_KEY_PORT, "0")
+	}
+	if a.engine != "" {
+		a.cfg.Set(environ.CFG_KEY_ENGINE, a.engine)
+	}
+
+	a.WGO = wgo.InitWith(a.cfg)
+
+	if a.storage != nil {
+		a.SetStorage(a.storage)
+	}
+	for tag, db := range a.dbs {
+		if err := db.Open(tag); err != nil {
+			panic(fmt.Sprintf("[wgotest]open fake db(%s) failed: %s", tag, err))
+		}
+	}
+	if a.es != nil {
+		a.es.Use()
+	}
+	return a
+}
+
+/* }}} */
+
+// 第一次请求时调用, 启动works并构建路由
+func (a *App) build() {
+	a.once.Do(func() {
+		a.Build()
+		for _, s := range a.AllServers() {
+			if m := s.Mode(); m == server.MODE_HTTP || m == server.MODE_HTTPS {
+				a.server = s
+				break
+			}
+		}
+	})
+}
+
+// 关闭app: works, cron, stop hooks, 以及fake es
+func (a *App) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	err := a.Shutdown(ctx)
+	if a.es != nil {
+		a.es.Close()
+	}
+	return err
+}
+
+/* {{{ func (a *App) Request(method, path string, body interface{}, headers ...map[string]string) *Response
+ * body可以是string, []byte, io.Reader, 其他类型按json序列化(并设置Content-Type)
+ */
+func (a *App) Request(method, path string, body interface{}, headers ...map[string]string) *Response {
+	a.build()
+	if a.server == nil {
+		panic("[wgotest]not found http server")
+	}
+	var b []byte
+	ct := ""
+	switch v := body.(type) {
+	case nil:
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	case io.Reader:
+		b, _ = ioutil.ReadAll(v)
+	default:
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			panic(fmt.Sprintf("[wgotest]marshal body failed: %s", err))
+		}
+		ct = whttp.MIMEApplicationJSONCharsetUTF8
+	}
+	hs := make(map[string]string)
+	if ct != "" {
+		hs[whttp.HeaderContentType] = ct
+	}
+	for _, h := range headers {
+		for k, v := range h {
+			hs[k] = v
+		}
+	}
+
+	switch eng := a.server.Engine().(type) {
+	case http.Handler:
+		return serveStandard(eng, method, path, b, hs)
+	case interface {
+		ServeHTTP(*fasthttp.RequestCtx)
+	}:
+		return serveFasthttp(eng, method, path, b, hs)
+	default:
+		panic(fmt.Sprintf("[wgotest]unsupported engine: %s", a.server.EngineName()))
+	}
+}
+
+/* }}} */
+
+func (a *App) GET(path string, headers ...map[string]string) *Response {
+	return a.Request(whttp.METHOD_GET, path, nil, headers...)
+}
+
+func (a *App) HEAD(path string, headers ...map[string]string) *Response {
+	return a.Request(whttp.METHOD_HEAD, path, nil, headers...)
+}
+
+func (a *App) DELETE(path string, headers ...map[string]string) *Response {
+	return a.Request(whttp.METHOD_DELETE, path, nil, headers...)
+}
+
+func (a *App) POST(path string, body interface{}, headers ...map[string]string) *Response {
+	return a.Request(whttp.METHOD_POST, path, body, headers...)
+}
+
+func (a *App) PUT(path string, body interface{}, headers ...map[string]string) *Response {
+	return a.Request(whttp.METHOD_PUT, path, body, headers...)
+}
+
+func (a *App) PATCH(path string, body interface{}, headers ...map[string]string) *Response {
+	return a.Request(whttp.METHOD_PATCH, path, body, headers...)
+}
+
+func serveStandard(h http.Handler, method, path string, body []byte, headers map[string]string) *Response {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.RemoteAddr = defaultRemoteAddr
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return &Response{
+		Code:   rec.Code,
+		Header: rec.Header(),
+		Body:   rec.Body.Bytes(),
+	}
+}
+
+func serveFasthttp(h interface {
+	ServeHTTP(*fasthttp.RequestCtx)
+}, method, path string, body []byte, headers map[string]string) *Response {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod(method)
+	req.SetRequestURI(path)
+	req.Header.SetHost("localhost")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.SetBody(body)
+	addr, _ := net.ResolveTCPAddr("tcp", defaultRemoteAddr)
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Init(req, addr, nil)
+	h.ServeHTTP(ctx)
+
+	res := &Response{
+		Code:   ctx.Response.StatusCode(),
+		Header: make(http.Header),
+		Body:   append([]byte(nil), ctx.Response.Body()...),
+	}
+	ctx.Response.Header.VisitAll(func(k, v []byte) {
+		res.Header.Add(string(k), string(v))
+	})
+	return res
+}
@@ -0,0 +1,102 @@
+package wgotest_test
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"wgo"
+	"wgo/rest"
+	"wgo/wgotest"
+)
+
+type User struct {
+	Id      *string    `json:"id,omitempty" db:",pk" filter:",C,G"`
+	Name    *string    `json:"name,omitempty" db:",k" filter:",R,C,D"`
+	Avatar  string     `json:"avatar,omitempty" filter:",C"`
+	State   *int       `json:"state,omitempty" db:",logic" filter:",G,D"`
+	Created *time.Time `json:"created,omitempty" db:",add_now" filter:",G,D,TR"`
+	*rest.REST
+}
+
+// App会替换全局的wgo, 不能t.Parallel
+func TestCRUD(t *testing.T) {
+	for _, engine := range []string{"standard", "fasthttp"} {
+		t.Run(engine, func(t *testing.T) { testCRUD(t, engine) })
+	}
+}
+
+func testCRUD(t *testing.T, engine string) {
+	db := wgotest.NewFakeDB()
+	app := wgotest.New(`{"proc_name":"wgotest"}`, wgotest.Engine(engine), wgotest.WithDB(db), wgotest.WithStorage(wgotest.NewStorage()))
+	defer app.Close()
+	rest.AddModel((*User)(nil))
+	wgo.GET("/hello", func(c *wgo.Context) error { return c.JSON(200, map[string]string{"hello": c.QueryParam("name")}) })
+
+	if got := app.AllServers()[0].EngineName(); got != engine {
+		t.Fatalf("engine: %s, want %s", got, engine)
+	}
+	app.GET("/hello?name=world").Expect(t, 200).ExpectContains(t, `"world"`)
+
+	sql := func(prefix string) string {
+		for _, q := range db.Queries() {
+			if strings.HasPrefix(q.SQL, prefix) {
+				return fmt.Sprintf("%s %v", q.SQL, q.Args)
+			}
+		}
+		return ""
+	}
+	users := app.Model("users")
+
+	users.Create(map[string]string{"name": "odin", "avatar": "a.png"}).Expect(t, 201)
+	if s := sql("INSERT"); !strings.Contains(s, "INTO `user`") || !strings.Contains(s, "odin") {
+		t.Errorf("create sql: %s", s)
+	}
+
+	db.Reset()
+	db.Expect(`^SELECT .* FROM .user. .*LIMIT 1$`).Rows([]string{"id", "name", "avatar"}, []interface{}{"u1", "odin", "a.png"})
+	db.Expect(`^SELECT\s+count`).Rows([]string{"count"}, []interface{}{2})
+	db.Expect(`^SELECT .* FROM .user.`).Rows([]string{"id", "name", "avatar"}, []interface{}{"u1", "odin", "a.png"}, []interface{}{"u2", "thor", ""})
+	users.Get("u1").Expect(t, 200).ExpectContains(t, `"name":"odin"`)
+	users.Get("u1").Expect(t, 200).ExpectContains(t, `"avatar":"a.png"`)
+	users.List(url.Values{"avatar": {"a.png"}}).Expect(t, 200).ExpectContains(t, `"name":"thor"`).ExpectContains(t, `"total":2`)
+	if s := sql("SELECT  T."); !strings.Contains(s, "`id` = ?") || !strings.Contains(s, "u1") {
+		t.Errorf("get sql: %s", s)
+	}
+
+	db.Reset()
+	db.Expect(`^SELECT .* FROM .user.`).Rows([]string{"id", "name", "avatar"}, []interface{}{"u1", "odin", "a.png"})
+	users.Update("u1", map[string]string{"avatar": "b.png"}).Expect(t, 200)
+	if s := sql("UPDATE"); !strings.Contains(s, "SET `avatar`=?") || !strings.Contains(s, "b.png") {
+		t.Errorf("update sql: %s", s)
+	}
+
+	db.Reset()
+	db.Expect(`^SELECT .* FROM .user.`).Rows([]string{"id", "name", "avatar"}, []interface{}{"u1", "odin", "a.png"})
+	users.Delete("u1").Expect(t, 204)
+	if s := sql("UPDATE"); !strings.Contains(s, "`state`=?") || !strings.Contains(s, "-1") {
+		t.Errorf("delete sql: %s", s)
+	}
+
+	db.Reset()
+	users.Get("u404").Expect(t, 404)
+}
+
+// EVAL需要SetEvaluator, 脚本中的redis.call通过do执行
+func TestMemoryCacheEval(t *testing.T) {
+	mc := wgotest.NewMemoryCache()
+	if _, err := mc.Do("EVAL", "return 1", 0); err == nil {
+		t.Errorf("eval without evaluator")
+	}
+	mc.SetEvaluator(func(do func(string, ...interface{}) (interface{}, error), args ...interface{}) (interface{}, error) {
+		return do("INCR", args[2])
+	})
+	if n, err := mc.Do("eval", "return redis.call('INCR', KEYS[1])", 1, "k"); err != nil || n != int64(1) {
+		t.Errorf("eval: %v %v", n, err)
+	}
+	if v := mc.Get("k"); string(v.([]byte)) != "1" {
+		t.Errorf("get: %v", v)
+	}
+}
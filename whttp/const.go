@@ -205,9 +205,18 @@ const (
 	HeaderAccessControlMaxAge           = "Access-Control-Max-Age"
 	HeaderXRequestId                    = "X-Request-Id"
 	HeaderXAppId                        = "X-WGO-AppId"
+	HeaderXClientAppId                  = "X-App-Id"
+	HeaderRetryAfter                    = "Retry-After"
+	HeaderRateLimitLimit                = "RateLimit-Limit"
+	HeaderRateLimitRemaining            = "RateLimit-Remaining"
+	HeaderRateLimitReset                = "RateLimit-Reset"
 	HeaderXUserId                       = "X-WGO-UserId"
 	HeaderXIp                           = "X-WGO-Ip"
 	HeaderXDepth                        = "X-WGO-Depth"
+	HeaderXTimeout                      = "X-WGO-Timeout"
+	HeaderXTimestamp                    = "X-WGO-Timestamp"
+	HeaderXNonce                        = "X-WGO-Nonce"
+	HeaderXSignature                    = "X-WGO-Signature"
 
 	// Security
 	HeaderStrictTransportSecurity = "Strict-Transport-Security"
@@ -163,14 +163,32 @@ func NewMiddleware(tag string, m MiddlewareFunc) *Middleware {
 	}
 }
 
+// tag
+func (m *Middleware) Tag() string {
+	return m.tag
+}
+
 // newEngine
-func newEngine(name string) server.Engine {
-	// Debug("[whttp.newEngine]name: %s", name)
-	switch name {
+func newEngine(s *server.Server) server.Engine {
+	// Debug("[whttp.newEngine]name: %s", s.EngineName())
+	rt, wt, it := s.Timeouts()
+	switch s.EngineName() {
 	case "standard":
-		return standard.New()
+		e := standard.New()
+		e.Server.ReadTimeout, e.Server.WriteTimeout, e.Server.IdleTimeout = rt, wt, it
+		return e
 	default:
-		return fasthttp.New()
+		e := fasthttp.New()
+		if rt > 0 {
+			e.Server.ReadTimeout = rt
+		}
+		if wt > 0 {
+			e.Server.WriteTimeout = wt
+		}
+		if it > 0 {
+			e.Server.IdleTimeout = it
+		}
+		return e
 	}
 }
 
@@ -181,7 +199,7 @@ func Factory(s *server.Server, cgen func() interface{}, mconv func(...interface{
 	// engine factory func
 	var ef server.EngineFactory
 	ef = func() server.Engine {
-		return newEngine(s.EngineName())
+		return newEngine(s)
 	}
 	// mux factory func
 	var mf server.MuxFactory
@@ -1,6 +1,7 @@
 package fasthttp
 
 import (
+	"context"
 	"net"
 	"sync"
 	"time"
@@ -93,6 +94,21 @@ func (e *Engine) Start(l net.Listener) error {
 	return e.Server.Serve(l)
 }
 
+// Shutdown
+// fasthttp的Shutdown会关闭listener并等待所有连接结束, 这里加上ctx的期限
+func (e *Engine) Shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Server.Shutdown()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // handler
 //func (e *Engine) SetHandler(h func(interface{}, interface{})) {
 //	e.handler = h
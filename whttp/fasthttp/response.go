@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strings"
 
 	"wgo/server"
 	"wgo/utils"
@@ -88,6 +89,16 @@ func (r *Response) SetCookie(c server.Cookie) {
 	cookie.SetExpire(c.Expires())
 	cookie.SetSecure(c.Secure())
 	cookie.SetHTTPOnly(c.HTTPOnly())
+	if ss, ok := c.(server.CookieSameSite); ok {
+		switch strings.ToLower(ss.SameSite()) {
+		case "lax":
+			cookie.SetSameSite(fasthttp.CookieSameSiteLaxMode)
+		case "strict":
+			cookie.SetSameSite(fasthttp.CookieSameSiteStrictMode)
+		case "none":
+			cookie.SetSameSite(fasthttp.CookieSameSiteNoneMode)
+		}
+	}
 	r.Response.Header.SetCookie(cookie)
 }
 
@@ -73,23 +73,12 @@ var Transport = &http.Transport{
 	ExpectContinueTimeout: 1 * time.Second,
 }
 
-func Proxy() MiddlewareFunc {
-	pool := sync.Pool{
-		New: func() interface{} {
-			return &ReverseProxy{
-				ReverseProxy: &httputil.ReverseProxy{
-					Transport:      http.RoundTripper(Transport), // 连接参数
-					ModifyResponse: nil,                          // 对返回信息进行修改
-				},
-			}
-		},
-	}
-	cache := wcache.NewCache()
+// proxy配置, 支持定义多个host, 逗号分隔
+func proxyConfig(ec *environ.Config) map[string](map[string]interface{}) {
 	var cfg map[string](map[string]interface{})
-	if err := environ.Cfg().UnmarshalKey(CFG_KEY_PROXY, &cfg); err != nil {
+	if err := ec.UnmarshalKey(CFG_KEY_PROXY, &cfg); err != nil {
 		Info("[Proxy]not found proxy config")
 	}
-	// 支持定义多个host, 逗号分隔
 	var config map[string](map[string]interface{})
 	if cfg != nil && len(cfg) > 0 {
 		config = make(map[string](map[string]interface{}))
@@ -100,8 +89,29 @@ func Proxy() MiddlewareFunc {
 			}
 		}
 	}
+	return config
+}
+
+func Proxy() MiddlewareFunc {
+	pool := sync.Pool{
+		New: func() interface{} {
+			return &ReverseProxy{
+				ReverseProxy: &httputil.ReverseProxy{
+					Transport:      http.RoundTripper(Transport), // 连接参数
+					ModifyResponse: nil,                          // 对返回信息进行修改
+				},
+			}
+		},
+	}
+	cache := wcache.NewCache("proxy")
+	// 第一次请求时从context所属app的配置中读取
+	var once sync.Once
+	var config map[string](map[string]interface{})
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c Context) (err error) {
+			once.Do(func() {
+				config = proxyConfig(c.Cfg())
+			})
 
 			if config == nil { // 没有proxy配置, 跳过
 				return next(c)
@@ -425,22 +435,6 @@ func (rp *ReverseProxy) doProxy(c Context, opts ...interface{}) error {
 	// 	r.RequestCtx.Response.SetBodyStream(bytes.NewReader(r.RequestCtx.Response.Body()), -1)
 	// }
 
-	// cors header(Access-Control-Allow-*)
-	if origin := outreq.Header.Get(HeaderOrigin); origin != "" {
-		res.Header.Set(HeaderAccessControlAllowOrigin, origin)
-		res.Header.Set(HeaderAccessControlAllowCredentials, "true")
-	}
-	if c.Request().(Request).Method() == "OPTIONS" { // 统一处理options请求
-		res.Header.Set(HeaderAccessControlMaxAge, "86400")
-		res.Header.Set(HeaderAccessControlAllowMethods, "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		if ch := outreq.Header.Get(HeaderAccessControlRequestHeaders); ch != "" {
-			res.Header.Set(HeaderAccessControlAllowHeaders, ch) // 来者不拒
-		}
-		c.Response().(Response).WriteHeader(StatusOK)
-		copyFastHeader(c.Response().(Response).Header(), res.Header)
-		return nil
-	}
-
 	// Deal with 101 Switching Protocols responses: (WebSocket, h2c, etc)
 	if res.StatusCode == http.StatusSwitchingProtocols {
 		if rp.ModifyResponse != nil {
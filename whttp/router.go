@@ -191,6 +191,25 @@ func (r *Route) abandon(ms ...interface{}) {
 	r.Middleware = mws
 }
 
+// route options
+func (r *Route) Options() Options {
+	return r.opts
+}
+
+// 实际生效的middleware(同tag只生效一次), 按执行顺序
+func (r *Route) Chain() []string {
+	tags := make([]string, 0, len(r.Middleware))
+	for i := len(r.Middleware) - 1; i >= 0; i-- {
+		if !utils.InSliceIgnorecase(r.Middleware[i].tag, tags) {
+			tags = append(tags, r.Middleware[i].tag)
+		}
+	}
+	for i, j := 0, len(tags)-1; i < j; i, j = i+1, j-1 {
+		tags[i], tags[j] = tags[j], tags[i]
+	}
+	return tags
+}
+
 // set route options
 func (r *Route) SetOptions(key string, value interface{}) *Route {
 	if r.opts == nil {
@@ -544,6 +563,51 @@ func (n *RouteNode) findHandler(method string) Func {
 // Find lookup a handler registed for method and path. It also parses URL for path
 // parameters and load them into context.
 func (r *Router) Find(method, path string, pvalues []string) *RouteNode {
+	cn := r.find(path, pvalues)
+	if cn == nil {
+		return nil
+	}
+
+	f := cn.findHandler(method)
+	o := cn.findOptions(method)
+
+	if f == nil {
+		if cn = cn.findChildByKind(akind); cn == nil {
+			return cn
+		}
+
+		if h := cn.findHandler(method); h != nil {
+			cn.Func = h
+			cn.Opts = cn.findOptions(method)
+		} else {
+			cn.Func = nil
+			cn.Opts = nil
+		}
+		pvalues[len(cn.pnames)-1] = ""
+	} else {
+		cn.Func = f
+		cn.Opts = o
+	}
+
+	return cn
+}
+
+// Lookup 查找method+path的路由, 返回路由的path及options, 不修改节点(用于preflight等非本次method的查询)
+func (r *Router) Lookup(method, path string) (string, Options, bool) {
+	cn := r.find(path, make([]string, r.Depth()))
+	if cn == nil {
+		return "", nil, false
+	}
+	if cn.findHandler(method) == nil {
+		if cn = cn.findChildByKind(akind); cn == nil || cn.findHandler(method) == nil {
+			return "", nil, false
+		}
+	}
+	return cn.Path(), cn.findOptions(method), true
+}
+
+// 按path查找节点, 同时解析路由参数
+func (r *Router) find(path string, pvalues []string) *RouteNode {
 	cn := r.tree // Current node as root
 
 	var (
@@ -653,26 +717,5 @@ func (r *Router) Find(method, path string, pvalues []string) *RouteNode {
 	}
 
 End:
-	f := cn.findHandler(method)
-	o := cn.findOptions(method)
-
-	if f == nil {
-		if cn = cn.findChildByKind(akind); cn == nil {
-			return cn
-		}
-
-		if h := cn.findHandler(method); h != nil {
-			cn.Func = h
-			cn.Opts = cn.findOptions(method)
-		} else {
-			cn.Func = nil
-			cn.Opts = nil
-		}
-		pvalues[len(cn.pnames)-1] = ""
-	} else {
-		cn.Func = f
-		cn.Opts = o
-	}
-
 	return cn
 }
@@ -2,6 +2,7 @@ package standard
 
 import (
 	"bytes"
+	"context"
 	"net"
 	"net/http"
 	"sync"
@@ -84,6 +85,11 @@ func (e *Engine) Start(l net.Listener) error {
 	return e.Server.Serve(l)
 }
 
+// Shutdown
+func (e *Engine) Shutdown(ctx context.Context) error {
+	return e.Server.Shutdown(ctx)
+}
+
 // ServeHTTP implements `http.Handler` interface.
 func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Request
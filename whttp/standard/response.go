@@ -6,6 +6,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strings"
 
 	"wgo/server"
 	"wgo/utils"
@@ -99,7 +100,7 @@ func (r *Response) WriteGzip(b []byte) (n int, err error) {
 
 // SetCookie implements `whttp.Response#SetCookie` function.
 func (r *Response) SetCookie(c server.Cookie) {
-	http.SetCookie(r.ResponseWriter, &http.Cookie{
+	hc := &http.Cookie{
 		Name:     c.Name(),
 		Value:    c.Value(),
 		Path:     c.Path(),
@@ -107,7 +108,18 @@ func (r *Response) SetCookie(c server.Cookie) {
 		Expires:  c.Expires(),
 		Secure:   c.Secure(),
 		HttpOnly: c.HTTPOnly(),
-	})
+	}
+	if ss, ok := c.(server.CookieSameSite); ok {
+		switch strings.ToLower(ss.SameSite()) {
+		case "lax":
+			hc.SameSite = http.SameSiteLaxMode
+		case "strict":
+			hc.SameSite = http.SameSiteStrictMode
+		case "none":
+			hc.SameSite, hc.Secure = http.SameSiteNoneMode, true
+		}
+	}
+	http.SetCookie(r.ResponseWriter, hc)
 }
 
 // Status implements `whttp.Response#Status` function.
@@ -0,0 +1,42 @@
+//
+// timeout.go
+// 剩余时间(毫秒)通过X-WGO-Timeout头传给下游
+//
+
+package whttp
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeoutHeader ctx的剩余时间(毫秒), 没有deadline时返回空, 已经过期返回"0"
+func TimeoutHeader(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	dl, ok := ctx.Deadline()
+	if !ok {
+		return ""
+	}
+	ms := int64(time.Until(dl) / time.Millisecond)
+	if ms < 0 {
+		ms = 0
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+// ParseTimeout 解析X-WGO-Timeout, 非法或为空时ok为false
+func ParseTimeout(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
@@ -271,3 +271,62 @@ func (log Logger) Limit(l int) {
 		}
 	}
 }
+
+// level string, 如"INFO|WARNING|ERROR"
+func (l Level) String() string {
+	names := make([]string, 0, len(levelMapping))
+	for i := uint(DEBUG); i <= ACCESS; i++ {
+		if l.lvl&(1<<i) != 0 {
+			for name, lvl := range levelMapping {
+				if lvl == i {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// 各filter当前的level
+func (log Logger) Levels() map[string]string {
+	ls := make(map[string]string, len(log))
+	for tag, filter := range log {
+		ls[tag] = filter.Level.String()
+	}
+	return ls
+}
+
+// 运行时修改level, 不传tags则修改所有filter
+// lvl可以是"DEBUG|INFO|ERROR"这种组合, 也可以是单个level, 表示该level及以上
+func (log Logger) SetLevel(lvl string, tags ...string) error {
+	var level Level
+	if strings.Contains(lvl, "|") {
+		level = BuildLevel(lvl)
+	} else if min, ok := levelMapping[strings.ToUpper(strings.TrimSpace(lvl))]; ok {
+		for _, l := range levelMapping {
+			if l >= min {
+				level.lvl = level.lvl | (1 << l)
+			}
+		}
+		level.desc = lvl
+	}
+	if level.lvl == 0 {
+		return fmt.Errorf("unknown log level: %s", lvl)
+	}
+	for tag, filter := range log {
+		if len(tags) == 0 || inSlice(tag, tags) {
+			filter.Level.lvl = level.lvl
+			filter.Level.desc = level.desc
+		}
+	}
+	return nil
+}
+
+func inSlice(s string, ss []string) bool {
+	for _, v := range ss {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,19 +3,93 @@
 package wgo
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"wgo/environ"
 	"wgo/server"
+	"wgo/trace"
 	"wgo/utils"
+	"wgo/whttp"
 )
 
+const (
+	// Req等待结果超时/被取消, 对应http 504
+	ErrCodeJobTimeout  = whttp.StatusGatewayTimeout * 1000
+	ErrCodeJobCanceled = whttp.StatusGatewayTimeout*1000 + 1
+	// 队列已满(reject/drop_oldest), 对应http 503
+	ErrCodeQueueFull = whttp.StatusServiceUnavailable * 1000
+
+	defaultReqTimeout = 60 * time.Second // 调用方没有deadline也没有指定超时时使用
+)
+
+// job优先级, 同一个pool中优先级高的job先执行
+type JobPriority int
+
+const (
+	PriorityLow JobPriority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// job选项, 与其他opts一起传入, 不会传给job
+// c.Req("method", payload, wgo.JobTimeout(3*time.Second))
+// c.PushAfter(30*time.Minute, "expire", order, wgo.JobKey("expire:"+order.ID))
+// c.Push("notify", msg, wgo.PriorityHigh)
+type (
+	jobTimeout time.Duration // Req的超时
+	jobKey     string        // 延迟job的去重key
+	jobOptions struct {
+		timeout  time.Duration
+		key      string
+		priority JobPriority
+		stage    string
+	}
+)
+
+func JobTimeout(d time.Duration) interface{} {
+	return jobTimeout(d)
+}
+func JobKey(key string) interface{} {
+	return jobKey(key)
+}
+
+// 分离出job选项
+func splitJobOpts(opts []interface{}) (jo jobOptions, rest []interface{}) {
+	rest = make([]interface{}, 0, len(opts))
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case jobTimeout:
+			jo.timeout = time.Duration(o)
+		case jobKey:
+			jo.key = string(o)
+		case JobPriority:
+			jo.priority = o
+		case jobStage:
+			jo.stage = string(o)
+		default:
+			rest = append(rest, opt)
+		}
+	}
+	return
+}
+
+// 是否为Req超时/取消的错误
+func IsJobTimeout(err error) bool {
+	code := ErrorCode(err)
+	return code == ErrCodeJobTimeout || code == ErrCodeJobCanceled
+}
+
 type Job struct {
 	id       string
 	start    time.Time
-	context  *Context // 异步job直接使用Context会有问题, todo: 应该clone一个context
+	context  *Context // Detach出的context, 与调用方互不影响
 	work     string
 	method   string
 	payload  interface{}
@@ -26,6 +100,11 @@ type Job struct {
 	resp     []interface{} // 对外请求的返回
 	err      *server.ServerError
 	response chan interface{}
+	msg      *JobMessage // 来自持久化队列
+	priority JobPriority
+	tracked  bool      // 记录状态, 见JobStore
+	queued   time.Time // 进入队列的时间
+	stage    string    // 所属的流水线阶段, 见Pipeline
 }
 
 func (j *Job) ID() string {
@@ -46,6 +125,31 @@ func (j *Job) Payload() interface{} {
 func (j *Job) Opts() []interface{} {
 	return j.opts
 }
+
+// 把payload解析到v, 来自持久化队列的payload是json解析出的通用类型, 用Bind取回具体类型
+func (j *Job) Bind(v interface{}) error {
+	b, err := json.Marshal(j.payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// 调用方已经放弃(Req超时或取消), handler可以通过c.Done()提前结束
+func (j *Job) Canceled() bool {
+	if ctx := j.context.Context(); ctx != nil {
+		return ctx.Err() != nil
+	}
+	return false
+}
+
+// 第几次执行, 从1开始
+func (j *Job) Attempts() int {
+	if j.msg != nil {
+		return j.msg.Attempts + 1
+	}
+	return 1
+}
 func (j *Job) Error(err error) {
 	j.err = server.WrapError(err)
 }
@@ -95,6 +199,13 @@ func (j *Job) SaveAccessLog() {
 	ac.Service.User.IP = c.ClientIP()
 	ac.Service.Endpoint = j.Work()
 	ac.Service.Desc = j.Method()
+	ac.Service.Stage = j.stage
+	ac.Call.Depth = c.Depth()
+	ac.TraceID = c.TraceID()
+	if j.err != nil {
+		ac.Err = j.err.Status()
+		ac.Msg = j.err.Message
+	}
 	// ac.Service.Action = "C"
 	// new & old, new对应对外请求, old对应对外请求的返回
 	if j.req != nil {
@@ -113,20 +224,22 @@ func (j *Job) SaveAccessLog() {
 	if sa, err := json.Marshal(ac); err != nil {
 		c.Error("serialize access data failed: %s", err)
 	} else {
-		Accessor().Access(string(sa))
+		c.App().Accessor().Access(string(sa))
 	}
 }
 
+// job运行时请求可能已经结束, 使用Detach的context
 func (c *Context) NewJob(name, method string, pl interface{}, opts ...interface{}) *Job {
 	id := c.RequestID()
 	// generate random job id
 	if id == "" {
 		id = utils.FastRequestId(16)
 	}
-	c.job = &Job{
+	jc := c.Detach()
+	jc.job = &Job{
 		id:       id,
 		start:    time.Now(),
-		context:  c,
+		context:  jc,
 		work:     name,
 		method:   method,
 		payload:  pl,
@@ -135,7 +248,7 @@ func (c *Context) NewJob(name, method string, pl interface{}, opts ...interface{
 		req:      make([]interface{}, 0),
 		resp:     make([]interface{}, 0),
 	}
-	return c.job
+	return jc.job
 }
 
 // type JobHandler func(*Job) interface{}
@@ -160,28 +273,85 @@ func (c *Context) NewJob(name, method string, pl interface{}, opts ...interface{
 // }
 
 type JobWorker struct {
-	pool    chan chan *Job
-	channel chan *Job
-	quit    chan bool
-	handler HandlerFunc
-	routes  map[string]*JobRoute
+	quit chan bool
+	work *WorkerPool
+}
+
+// 队列满时的处理策略
+type QueuePolicy string
+
+const (
+	QueueBlock      QueuePolicy = "block"       // 等待, 默认
+	QueueReject     QueuePolicy = "reject"      // 拒绝, 返回ErrCodeQueueFull
+	QueueDropOldest QueuePolicy = "drop_oldest" // 丢弃队列中最早的job
+	QueueCallerRuns QueuePolicy = "caller_runs" // 在调用方的goroutine中执行
+
+	defaultQueueSize   = 1000
+	defaultIdleTimeout = time.Minute
+	defaultScaleWait   = 100 * time.Millisecond
+)
+
+// pool状态
+type WorkStats struct {
+	Name       string      `json:"name"`
+	Workers    int         `json:"workers"` // 当前worker数
+	MinWorkers int         `json:"min_workers"`
+	MaxWorkers int         `json:"max_workers"`
+	Busy       int         `json:"busy"`     // 正在执行job的worker数
+	AvgWait    float64     `json:"avg_wait"` // 平均排队时间(ms)
+	Capacity   int         `json:"capacity"`
+	Depth      int         `json:"depth"` // 排队中的job(包括等待并发限制的)
+	Policy     QueuePolicy `json:"policy"`
+	Rejected   uint64      `json:"rejected"`
+	Dropped    uint64      `json:"dropped"`
+	CallerRuns uint64      `json:"caller_runs"`
 }
 
 type WorkerPool struct {
 	// A pool of workers channels that are registered with the dispatcher
+	app     *WGO // 所属的app, AddWork时设置
 	name    string
-	queue   chan *Job
-	pool    chan chan *Job
+	queues  [3]chan *Job // 按优先级从高到低
+	jobs    chan *Job    // 空闲worker从这里接收job
+	min     int
 	max     int
-	handler HandlerFunc // default handler
+	idle    time.Duration // worker空闲超时, min<max时生效
+	grow    time.Duration // 排队时间超过此值才扩容, min<max时生效
+	wlock   sync.Mutex    // workers
+	handler HandlerFunc   // default handler
 	routes  map[string]*JobRoute
 	workers []*JobWorker
+	quit    chan struct{}
+	lock    sync.RWMutex
+	closed  bool           // 关闭后不再接收job
+	pending sync.WaitGroup // 已接收未完成的job
+	jq      JobQueue       // 持久化队列, 为nil时job只在内存中流转
+	sched   JobQueue       // 没有持久化队列时, 延迟job使用的内存队列
+	retry   RetryPolicy    // 默认重试策略
+	lease   time.Duration
+	slots   chan struct{} // 从队列取出未处理完的job, 不超过max
+	policy  QueuePolicy
+	limits  map[string]int // 配置的并发限制, Start时生效
+	parked  int64          // 因并发限制等待的job
+
+	// stats
+	busy       int32
+	slock      sync.Mutex
+	avgWait    float64 // ms
+	rejected   uint64
+	dropped    uint64
+	callerRuns uint64
 }
 
 type JobRoute struct {
 	method      string
 	middlewares []MiddlewareFunc
 	handlers    []HandlerFunc
+	retry       *RetryPolicy
+	limit       int // 并发上限, 0为不限制
+	lock        sync.Mutex
+	running     int
+	waiting     []*Job
 }
 
 // 使用中间件
@@ -193,6 +363,20 @@ func (jr *JobRoute) Use(m MiddlewareFunc) *JobRoute {
 	return jr
 }
 
+// 该method同时执行的job数上限, 超出的job排队等待, 不占用worker
+func (jr *JobRoute) Limit(n int) *JobRoute {
+	jr.limit = n
+	return jr
+}
+
+// 该method的重试策略, 覆盖pool的默认策略, 只对经过队列的job(持久化/延迟)生效
+// maxBackoff可选, 默认10分钟
+func (jr *JobRoute) Retry(max int, backoff time.Duration, maxBackoff ...time.Duration) *JobRoute {
+	rp := newRetryPolicy(max, backoff, maxBackoff...)
+	jr.retry = &rp
+	return jr
+}
+
 func (hf HandlerFunc) Do(j *Job) {
 	c := j.Context()
 	defer func() {
@@ -247,33 +431,37 @@ func jobHandler(method string, route *JobRoute) HandlerFunc {
 }
 
 // worker run
+// 空闲超过idle且worker数大于min时退出
 func (jw *JobWorker) Run(sn int) {
+	wp := jw.work
 	go func() {
 		for {
-			// register the current worker into the worker queue.
-			jw.pool <- jw.channel
-
+			var idle <-chan time.Time
+			var timer *time.Timer
+			if wp.idle > 0 && wp.min < wp.max {
+				timer = time.NewTimer(wp.idle)
+				idle = timer.C
+			}
 			select {
-			case job := <-jw.channel:
+			case job := <-wp.jobs:
+				if timer != nil {
+					timer.Stop()
+				}
 				// we have received a job, route it
-				handler := jw.handler // default handler
-				if job.method != "" {
-					if route, ok := jw.routes[job.method]; ok && len(route.handlers) > 0 {
-						// handler = route.handlers[0]
-						// for _, h := range route.handlers[1:] {
-						// 	handler = handler.Chain(h)
-						// }
-						// if len(route.middlewares) > 0 {
-						// 	for i := len(route.middlewares) - 1; i >= 0; i-- {
-						// 		handler = route.middlewares[i](handler)
-						// 	}
-						// }
-						handler = jobHandler(job.method, route)
-					}
+				atomic.AddInt32(&wp.busy, 1)
+				for job != nil {
+					job = wp.handle(job)
+				}
+				atomic.AddInt32(&wp.busy, -1)
+			case <-idle:
+				if wp.retire(jw) {
+					return
 				}
-				handler.Do(job)
 			case <-jw.quit:
 				// we have received a signal to stop
+				if timer != nil {
+					timer.Stop()
+				}
 				return
 			}
 		}
@@ -289,22 +477,38 @@ func (jw *JobWorker) Stop() {
 
 // create new worker pool
 func NewWorkerPool(name string, maxWorkers int, handler HandlerFunc) *WorkerPool {
-	pool := make(chan chan *Job, maxWorkers)
-	queue := make(chan *Job)
-	return &WorkerPool{
+	wp := &WorkerPool{
 		name:    name,
-		queue:   queue,
-		pool:    pool,
+		jobs:    make(chan *Job),
+		min:     maxWorkers,
 		max:     maxWorkers,
 		handler: handler,
 		routes:  make(map[string]*JobRoute),
 		workers: make([]*JobWorker, 0),
+		quit:    make(chan struct{}),
+		sched:   NewMemoryJobQueue(),
+		retry:   newRetryPolicy(defaultMaxAttempts, defaultBackoff),
+		lease:   defaultJobLease,
+		grow:    defaultScaleWait,
+		policy:  QueueBlock,
+	}
+	for i := range wp.queues {
+		wp.queues[i] = make(chan *Job, defaultQueueSize)
 	}
+	return wp
 }
 
 // register to default var
 func (workerPool *WorkerPool) Register() {
-	wp = workerPool
+	workerPool.App().wp = workerPool
+}
+
+// 所属的app, 不是通过AddWork创建的属于默认的wgo
+func (wp *WorkerPool) App() *WGO {
+	if wp.app != nil {
+		return wp.app
+	}
+	return Self()
 }
 
 // routes for methods
@@ -321,49 +525,706 @@ func (wp *WorkerPool) Name() string {
 	return wp.name
 }
 
+// 使用持久化队列, Push/PushTo的job先写入队列再由pool取出执行, 失败按重试策略重新投递
+// 超过最多次数进入死信队列, 可以通过DeadJobs查看, Replay重新投递
+// Req是同步调用, 不经过队列
+func (wp *WorkerPool) UseQueue(q JobQueue) *WorkerPool {
+	wp.jq = q
+	return wp
+}
+
+// 使用storage作为持久化队列, 没有配置storage时使用内存队列
+func (wp *WorkerPool) Durable() *WorkerPool {
+	if s := wp.App().Storage(); s != nil {
+		return wp.UseQueue(NewStorageJobQueue(s, wp.name))
+	}
+	wp.App().Warn("work(%s) no storage found, use memory queue", wp.name)
+	return wp.UseQueue(NewMemoryJobQueue())
+}
+
+// 默认重试策略, maxBackoff可选, 默认10分钟
+func (wp *WorkerPool) Retry(max int, backoff time.Duration, maxBackoff ...time.Duration) *WorkerPool {
+	wp.retry = newRetryPolicy(max, backoff, maxBackoff...)
+	return wp
+}
+
+// 从队列取出的job, 超过lease未处理完会被重新投递
+func (wp *WorkerPool) Lease(d time.Duration) *WorkerPool {
+	if d > 0 {
+		wp.lease = d
+	}
+	return wp
+}
+
+// 队列容量(每个优先级)及队列满时的策略, 需要在Start之前设置
+func (wp *WorkerPool) Queue(size int, policy QueuePolicy) *WorkerPool {
+	if size > 0 {
+		for i := range wp.queues {
+			wp.queues[i] = make(chan *Job, size)
+		}
+	}
+	switch policy {
+	case QueueBlock, QueueReject, QueueDropOldest, QueueCallerRuns:
+		wp.policy = policy
+	default:
+		wp.App().Warn("work(%s) unknown queue policy: %s", wp.name, policy)
+	}
+	return wp
+}
+
+// 从配置读取pool参数, 如works.mail.queue_size, works.mail.queue_policy
+// works.mail.limits为method的并发上限, 如{"export": 2}, 覆盖代码中的Limit
+// works.mail.min_workers/max_workers/idle_timeout/scale_wait为自动伸缩参数, 见Scale, ScaleWait
+func (wp *WorkerPool) Configure(cfg *environ.Config) *WorkerPool {
+	if cfg == nil {
+		return wp
+	}
+	if max := cfg.Int("max_workers"); max > 0 {
+		if wp.min == wp.max || wp.min > max {
+			wp.min = max
+		}
+		wp.max = max
+	}
+	if cfg.IsSet("min_workers") || cfg.IsSet("idle_timeout") {
+		wp.Scale(cfg.Int("min_workers"), cfg.Duration("idle_timeout"))
+	}
+	if cfg.IsSet("scale_wait") {
+		wp.ScaleWait(cfg.Duration("scale_wait"))
+	}
+	for method, v := range cfg.StringMap("limits") {
+		if n, err := strconv.Atoi(fmt.Sprint(v)); err == nil {
+			if wp.limits == nil {
+				wp.limits = make(map[string]int)
+			}
+			wp.limits[method] = n
+		}
+	}
+	size := cfg.Int("queue_size")
+	policy := QueuePolicy(cfg.String("queue_policy"))
+	if policy == "" {
+		policy = wp.policy
+	}
+	return wp.Queue(size, policy)
+}
+
+// 自动伸缩, worker数在min和max之间: 排队时间超过阈值(见ScaleWait)时扩容, 空闲超过idle的worker退出
+// 需要在Start之前设置, 默认min=max(不伸缩)
+func (wp *WorkerPool) Scale(min int, idle time.Duration) *WorkerPool {
+	if min < 0 {
+		min = 0
+	}
+	if min > wp.max {
+		min = wp.max
+	}
+	wp.min = min
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+	wp.idle = idle
+	return wp
+}
+
+// 扩容的阈值: 平均排队时间或者当前job的排队时间达到d时增加worker, <=0 没有空闲worker就扩容
+// 短暂的繁忙由现有worker消化, 避免突发的请求把pool撑到max
+func (wp *WorkerPool) ScaleWait(d time.Duration) *WorkerPool {
+	if d < 0 {
+		d = 0
+	}
+	wp.grow = d
+	return wp
+}
+
+// 记录job的排队时间, 指数加权平均
+func (wp *WorkerPool) observeWait(job *Job) {
+	if job.queued.IsZero() {
+		return
+	}
+	wait := float64(time.Since(job.queued)) / float64(time.Millisecond)
+	jobWait.Observe(wait/1000, wp.name)
+	wp.slock.Lock()
+	if wp.avgWait == 0 {
+		wp.avgWait = wait
+	} else {
+		wp.avgWait = wp.avgWait*0.9 + wait*0.1
+	}
+	wp.slock.Unlock()
+}
+
+// 状态
+func (wp *WorkerPool) Stats() WorkStats {
+	depth := int(atomic.LoadInt64(&wp.parked))
+	for _, q := range wp.queues {
+		depth += len(q)
+	}
+	wp.wlock.Lock()
+	size := len(wp.workers)
+	wp.wlock.Unlock()
+	wp.slock.Lock()
+	avgWait := utils.Round(wp.avgWait, 3)
+	wp.slock.Unlock()
+	return WorkStats{
+		Name:       wp.name,
+		Workers:    size,
+		MinWorkers: wp.min,
+		MaxWorkers: wp.max,
+		Busy:       int(atomic.LoadInt32(&wp.busy)),
+		AvgWait:    avgWait,
+		Capacity:   cap(wp.queues[0]) * len(wp.queues),
+		Depth:      depth,
+		Policy:     wp.policy,
+		Rejected:   atomic.LoadUint64(&wp.rejected),
+		Dropped:    atomic.LoadUint64(&wp.dropped),
+		CallerRuns: atomic.LoadUint64(&wp.callerRuns),
+	}
+}
+
+// 持久化队列, 没有则使用内存队列(只用于延迟job)
+func (wp *WorkerPool) backend() JobQueue {
+	if wp.jq != nil {
+		return wp.jq
+	}
+	return wp.sched
+}
+
+// 死信
+func (wp *WorkerPool) DeadJobs() ([]*JobMessage, error) {
+	return wp.backend().Dead()
+}
+
+// 死信重新投递
+func (wp *WorkerPool) Replay(id string) error {
+	return wp.backend().Replay(id)
+}
+
+// 等待执行的延迟job
+func (wp *WorkerPool) Scheduled() ([]*JobMessage, error) {
+	return wp.backend().Scheduled()
+}
+
+// 取消还未执行的延迟job
+func (wp *WorkerPool) Cancel(id string) error {
+	return wp.backend().Cancel(id)
+}
+
+// d之后执行, 返回job id
+func (wp *WorkerPool) PushAfter(d time.Duration, method string, i interface{}, opts ...interface{}) (string, error) {
+	return wp.PushAt(time.Now().Add(d), method, i, opts...)
+}
+
+// 在t时执行, 返回job id
+func (wp *WorkerPool) PushAt(t time.Time, method string, i interface{}, opts ...interface{}) (string, error) {
+	c := wp.App().NewContext().(*Context)
+	c.SetRequestID(utils.FastRequestId(16))
+	return wp.schedule(c, t, method, i, opts...)
+}
+
 // pool start
 func (wp *WorkerPool) Start() *WorkerPool {
-	// starting n number of workers
-	for i := 0; i < wp.max; i++ {
-		worker := &JobWorker{
-			pool:    wp.pool,
-			channel: make(chan *Job),
-			quit:    make(chan bool),
-			handler: wp.handler,
-			routes:  wp.routes,
+	for method, n := range wp.limits {
+		if route, ok := wp.routes[method]; ok {
+			route.Limit(n)
 		}
-		worker.Run(i)
-		wp.workers = append(wp.workers, worker)
+	}
+	// starting min number of workers, 其余按需启动
+	for i := 0; i < wp.min; i++ {
+		wp.spawn()
 	}
 
 	go wp.dispatch()
 
+	wp.slots = make(chan struct{}, wp.max)
+	go wp.fetch()
+
 	return wp
 }
 
 // pool end
 func (wp *WorkerPool) End() {
+	wp.wlock.Lock()
+	defer wp.wlock.Unlock()
 	for _, worker := range wp.workers {
 		worker.Stop()
 	}
+	wp.workers = nil
+}
+
+// 启动一个worker, 已达到max返回false
+func (wp *WorkerPool) spawn() bool {
+	wp.wlock.Lock()
+	defer wp.wlock.Unlock()
+	if len(wp.workers) >= wp.max {
+		return false
+	}
+	worker := &JobWorker{
+		quit: make(chan bool),
+		work: wp,
+	}
+	worker.Run(len(wp.workers))
+	wp.workers = append(wp.workers, worker)
+	return true
+}
+
+// 空闲的worker退出, 不少于min
+func (wp *WorkerPool) retire(jw *JobWorker) bool {
+	wp.wlock.Lock()
+	defer wp.wlock.Unlock()
+	if len(wp.workers) <= wp.min {
+		return false
+	}
+	for i, worker := range wp.workers {
+		if worker == jw {
+			wp.workers = append(wp.workers[:i], wp.workers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// pool shutdown
+// 不再接收新job, 等待已接收的job处理完毕(或ctx到期)后停止workers
+func (wp *WorkerPool) Shutdown(ctx context.Context) error {
+	wp.lock.Lock()
+	if wp.closed {
+		wp.lock.Unlock()
+		return nil
+	}
+	wp.closed = true
+	wp.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wp.pending.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	close(wp.quit)
+	wp.End()
+	return err
+}
+
+// accept, 登记一个待处理job, pool已关闭返回false
+func (wp *WorkerPool) accept() bool {
+	wp.lock.RLock()
+	defer wp.lock.RUnlock()
+	if wp.closed {
+		return false
+	}
+	wp.pending.Add(1)
+	return true
 }
 
 // pool dispatch
 func (wp *WorkerPool) dispatch() {
+	for {
+		job := wp.next()
+		if job == nil {
+			return
+		}
+		if !wp.acquire(job) {
+			// 超过并发限制的job挂在route上, 等同method的job结束后执行
+			continue
+		}
+		// a job request has been received
+		// 有空闲worker直接交给它, 没有则按排队时间决定是否扩容(不超过max)
+		// this will block until a worker is idle, 期间job在队列中排队
+		select {
+		case wp.jobs <- job:
+		default:
+			wp.deliver(job)
+		}
+	}
+}
+
+// 没有空闲worker时交付job: 排队时间达到阈值才扩容, 否则等待worker空闲
+func (wp *WorkerPool) deliver(job *Job) {
+	if wp.min >= wp.max { // 不伸缩
+		wp.send(job)
+		return
+	}
+	wait := wp.grow
+	if !job.queued.IsZero() {
+		wait -= time.Since(job.queued)
+	}
+	wp.slock.Lock()
+	slow := wp.avgWait >= float64(wp.grow)/float64(time.Millisecond)
+	wp.slock.Unlock()
+	if slow || wait <= 0 {
+		wp.spawn()
+		wp.send(job)
+		return
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case wp.jobs <- job:
+	case <-timer.C:
+		wp.spawn()
+		wp.send(job)
+	case <-wp.quit:
+	}
+}
+
+// 交给worker, Shutdown之后workers已经停止, 不再等待(持久化job的lease到期后会重新投递)
+func (wp *WorkerPool) send(job *Job) {
+	select {
+	case wp.jobs <- job:
+	case <-wp.quit:
+	}
+}
+
+// 把job放入队列, 队列满时按policy处理, 返回nil表示job会被执行(并由handle结束pending)
+func (wp *WorkerPool) submit(job *Job) error {
+	job.queued = time.Now()
+	queue := wp.queues[priorityIndex(job.priority)]
+	select {
+	case queue <- job:
+		return nil
+	default:
+	}
+	switch wp.policy {
+	case QueueReject:
+		atomic.AddUint64(&wp.rejected, 1)
+		return Errorf(ErrCodeQueueFull, "work(%s) queue is full", wp.name)
+	case QueueDropOldest:
+		for {
+			select {
+			case queue <- job:
+				return nil
+			default:
+			}
+			select {
+			case old := <-queue:
+				atomic.AddUint64(&wp.dropped, 1)
+				wp.drop(old)
+			default:
+			}
+		}
+	case QueueCallerRuns:
+		atomic.AddUint64(&wp.callerRuns, 1)
+		if wp.acquire(job) {
+			for job != nil {
+				job = wp.handle(job)
+			}
+		}
+		return nil
+	default:
+		// 等待队列空出, 调用方超时/取消或者pool关闭时放弃
+		select {
+		case queue <- job:
+			return nil
+		case <-job.context.Done():
+			if job.context.Err() == context.DeadlineExceeded {
+				return Errorf(ErrCodeJobTimeout, "work(%s) job(%s) timeout while queuing", wp.name, job.method)
+			}
+			return Errorf(ErrCodeJobCanceled, "work(%s) job(%s) canceled while queuing", wp.name, job.method)
+		case <-wp.quit:
+			return fmt.Errorf("work(%s) is closed", wp.name)
+		}
+	}
+}
+
+func priorityIndex(p JobPriority) int {
+	switch {
+	case p >= PriorityHigh:
+		return 0
+	case p <= PriorityLow:
+		return 2
+	}
+	return 1
+}
+
+// 按优先级取job, 阻塞直到有job或者pool关闭(返回nil)
+func (wp *WorkerPool) next() *Job {
+	high, normal, low := wp.queues[0], wp.queues[1], wp.queues[2]
+	select {
+	case job := <-high:
+		return job
+	default:
+	}
+	select {
+	case job := <-high:
+		return job
+	case job := <-normal:
+		return job
+	default:
+	}
+	select {
+	case job := <-high:
+		return job
+	case job := <-normal:
+		return job
+	case job := <-low:
+		return job
+	case <-wp.quit:
+		return nil
+	}
+}
+
+// 占用route的并发名额, 超过上限时job挂起, 返回false
+func (wp *WorkerPool) acquire(job *Job) bool {
+	route := wp.route(job.method)
+	if route == nil || route.limit <= 0 {
+		return true
+	}
+	route.lock.Lock()
+	defer route.lock.Unlock()
+	if route.running >= route.limit {
+		route.waiting = append(route.waiting, job)
+		atomic.AddInt64(&wp.parked, 1)
+		return false
+	}
+	route.running++
+	return true
+}
+
+// 释放route的并发名额, 有挂起的job则直接返回(名额转给它)
+func (wp *WorkerPool) release(job *Job) *Job {
+	route := wp.route(job.method)
+	if route == nil || route.limit <= 0 {
+		return nil
+	}
+	route.lock.Lock()
+	defer route.lock.Unlock()
+	if len(route.waiting) > 0 {
+		next := route.waiting[0]
+		route.waiting = route.waiting[1:]
+		atomic.AddInt64(&wp.parked, -1)
+		return next
+	}
+	route.running--
+	return nil
+}
+
+// method对应的route, 没有返回nil
+func (wp *WorkerPool) route(method string) *JobRoute {
+	if method != "" {
+		if route, ok := wp.routes[method]; ok && len(route.handlers) > 0 {
+			return route
+		}
+	}
+	return nil
+}
+
+// 被挤出队列的job, 持久化job放回队列, 其他job以错误结束
+func (wp *WorkerPool) drop(job *Job) {
+	defer wp.pending.Done()
+	if job.msg != nil {
+		<-wp.slots
+		job.msg.At = time.Now()
+		if err := wp.backend().Retry(job.msg); err != nil {
+			wp.App().Error("work(%s) requeue job(%s) failed: %s", wp.name, job.msg.ID, err)
+		}
+		return
+	}
+	job.context.Warn("work(%s) queue is full, drop job: %s", wp.name, job.method)
+	job.Error(Errorf(ErrCodeQueueFull, "work(%s) queue is full, job dropped", wp.name))
+	job.Response()
+}
+
+// 执行job
+// 返回同method挂起的下一个job, 由当前goroutine继续执行
+func (wp *WorkerPool) handle(job *Job) *Job {
+	defer wp.pending.Done()
+	wp.observeWait(job)
+	handler := wp.handler // default handler
+	if route := wp.route(job.method); route != nil {
+		handler = jobHandler(job.method, route)
+	}
+	if job.tracked {
+		wp.track(job, JobRunning)
+	}
+	if job.msg == nil && job.Canceled() {
+		// 还没开始执行调用方就放弃了, 不再执行
+		job.context.Warn("skip job(%s:%s): %s", job.work, job.method, job.context.Err())
+		job.Error(Errorf(ErrCodeJobCanceled, "job canceled: %s", job.context.Err()))
+	} else {
+		start := time.Now()
+		span := job.startSpan()
+		handler.Do(job)
+		job.endSpan(span)
+		observeJob(job, start)
+	}
+	retrying := false
+	if job.msg != nil {
+		retrying = wp.settle(job)
+	}
+	if job.tracked {
+		switch {
+		case job.err == nil:
+			wp.track(job, JobSucceeded)
+		case retrying:
+			wp.track(job, JobPending)
+		default:
+			wp.track(job, JobFailed)
+		}
+	}
+	return wp.release(job)
+}
+
+// 从队列中取job, 同时最多取出max个
+func (wp *WorkerPool) fetch() {
 	for {
 		select {
-		case job := <-wp.queue:
-			// a job request has been received
-			go func(job *Job) {
-				// try to obtain a worker job channel that is available.
-				// this will block until a worker is idle
-				channel := <-wp.pool
+		case <-wp.quit:
+			return
+		case wp.slots <- struct{}{}:
+		}
+		msg, err := wp.backend().Reserve(wp.lease)
+		if err != nil || msg == nil {
+			<-wp.slots
+			if err != nil {
+				wp.App().Error("work(%s) reserve job failed: %s", wp.name, err)
+			}
+			select {
+			case <-wp.quit:
+				return
+			case <-time.After(defaultJobPoll):
+			}
+			continue
+		}
+		if !wp.accept() {
+			// 已关闭, 放回队列
+			<-wp.slots
+			msg.At = time.Now()
+			if err := wp.backend().Retry(msg); err != nil {
+				wp.App().Error("work(%s) requeue job(%s) failed: %s", wp.name, msg.ID, err)
+			}
+			return
+		}
+		job := wp.messageJob(msg)
+		job.queued = time.Now()
+		wp.queues[priorityIndex(job.priority)] <- job
+	}
+}
+
+// 队列中的job, context不来自请求, 只保留request id
+func (wp *WorkerPool) messageJob(msg *JobMessage) *Job {
+	c := wp.App().NewContext().(*Context)
+	c.SetRequestID(msg.RequestID)
+	c.SetContext(context.Background())
+	if sc, err := trace.ParseTraceparent(msg.Trace); err == nil {
+		c.SetContext(trace.ContextWithRemote(c.Context(), sc))
+	}
+	var pl interface{}
+	var opts []interface{}
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &pl); err != nil {
+			c.Warn("work(%s) decode job(%s) payload failed: %s", wp.name, msg.ID, err)
+		}
+	}
+	if len(msg.Opts) > 0 {
+		if err := json.Unmarshal(msg.Opts, &opts); err != nil {
+			c.Warn("work(%s) decode job(%s) opts failed: %s", wp.name, msg.ID, err)
+		}
+	}
+	job := c.NewJob(wp.name, msg.Method, pl, opts...)
+	job.id = msg.ID
+	job.msg = msg
+	job.tracked = msg.Track
+	job.priority = msg.Priority
+	return job
+}
 
-				// dispatch the job to the worker job channel
-				channel <- job
-			}(job)
+// 内存中的job, 分离出job选项
+func (wp *WorkerPool) newJob(c *Context, method string, i interface{}, opts ...interface{}) *Job {
+	jo, opts := splitJobOpts(opts)
+	job := c.NewJob(wp.name, method, i, opts...)
+	job.priority = jo.priority
+	job.stage = jo.stage
+	return job
+}
+
+// 队列中的job
+// 有去重key时job id由key决定
+func (wp *WorkerPool) newMessage(c *Context, method string, i interface{}, opts ...interface{}) (*JobMessage, error) {
+	jo, opts := splitJobOpts(opts)
+	msg := &JobMessage{
+		ID:        utils.NewShortUUID(),
+		RequestID: c.RequestID(),
+		Trace:     trace.Traceparent(c.Context()),
+		Work:      wp.name,
+		Method:    method,
+		Priority:  jo.priority,
+		Created:   time.Now(),
+	}
+	if jo.key != "" {
+		msg.Key = jo.key
+		msg.ID = utils.NewShortUUID5(wp.name, jo.key)
+	}
+	var err error
+	if msg.Payload, err = json.Marshal(i); err != nil {
+		return nil, err
+	}
+	if len(opts) > 0 {
+		if msg.Opts, err = json.Marshal(opts); err != nil {
+			return nil, err
 		}
 	}
+	return msg, nil
+}
+
+// job入队
+func (wp *WorkerPool) enqueue(c *Context, method string, i interface{}, opts ...interface{}) error {
+	msg, err := wp.newMessage(c, method, i, opts...)
+	if err != nil {
+		return err
+	}
+	return wp.jq.Enqueue(msg)
+}
+
+// 延迟job入队, 有去重key时重复入队返回已有的id
+func (wp *WorkerPool) schedule(c *Context, t time.Time, method string, i interface{}, opts ...interface{}) (string, error) {
+	msg, err := wp.newMessage(c, method, i, opts...)
+	if err != nil {
+		return "", err
+	}
+	msg.At = t
+	if err := wp.backend().Enqueue(msg); err == ErrJobExists {
+		c.Debug("work(%s) job(%s) exists, key: %s", wp.name, msg.ID, msg.Key)
+	} else if err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+// 队列中的job处理完毕, 成功则ack, 失败则重试或者进入死信, 返回是否会重试
+func (wp *WorkerPool) settle(job *Job) bool {
+	defer func() { <-wp.slots }()
+	msg := job.msg
+	q := wp.backend()
+	if job.err == nil {
+		if err := q.Ack(msg); err != nil {
+			wp.App().Error("work(%s) ack job(%s) failed: %s", wp.name, msg.ID, err)
+		}
+		return false
+	}
+	msg.Attempts++
+	msg.LastError = job.err.Error()
+	rp := wp.retry
+	if route, ok := wp.routes[msg.Method]; ok && route.retry != nil {
+		rp = *route.retry
+	}
+	if msg.Attempts < rp.MaxAttempts {
+		d := rp.delay(msg.Attempts)
+		msg.At = time.Now().Add(d)
+		if err := q.Retry(msg); err != nil {
+			wp.App().Error("work(%s) retry job(%s) failed: %s", wp.name, msg.ID, err)
+		} else {
+			wp.App().Warn("work(%s) job(%s) failed %d times, retry in %s", wp.name, msg.ID, msg.Attempts, d)
+		}
+		return true
+	}
+	if err := q.Bury(msg); err != nil {
+		wp.App().Error("work(%s) bury job(%s) failed: %s", wp.name, msg.ID, err)
+	} else {
+		wp.App().Error("work(%s) job(%s) dead after %d attempts: %s", wp.name, msg.ID, msg.Attempts, msg.LastError)
+	}
+	return false
 }
 
 func Push(method string, i interface{}, opts ...interface{}) {
@@ -374,11 +1235,35 @@ func Push(method string, i interface{}, opts ...interface{}) {
 
 // push job, 异步
 func (c *Context) Push(method string, i interface{}, opts ...interface{}) {
-	if wp != nil {
+	if wp := c.App().wp; wp != nil {
 		wp.push(c, method, i, opts...)
 	}
 }
 
+// 延迟job, 使用默认pool
+func PushAfter(d time.Duration, method string, i interface{}, opts ...interface{}) (string, error) {
+	c := NewContext().(*Context)
+	c.SetRequestID(utils.FastRequestId(16))
+	return c.PushAfter(d, method, i, opts...)
+}
+
+func (c *Context) PushAfter(d time.Duration, method string, i interface{}, opts ...interface{}) (string, error) {
+	return c.PushAt(time.Now().Add(d), method, i, opts...)
+}
+
+func PushAt(t time.Time, method string, i interface{}, opts ...interface{}) (string, error) {
+	c := NewContext().(*Context)
+	c.SetRequestID(utils.FastRequestId(16))
+	return c.PushAt(t, method, i, opts...)
+}
+
+func (c *Context) PushAt(t time.Time, method string, i interface{}, opts ...interface{}) (string, error) {
+	if wp := c.App().wp; wp != nil {
+		return wp.schedule(c, t, method, i, opts...)
+	}
+	return "", fmt.Errorf("not found worker pool")
+}
+
 func Req(method string, i interface{}, opts ...interface{}) (interface{}, error) {
 	c := NewContext().(*Context)
 	c.SetRequestID(utils.FastRequestId(16))
@@ -387,7 +1272,7 @@ func Req(method string, i interface{}, opts ...interface{}) (interface{}, error)
 
 // req job, 同步
 func (c *Context) Req(method string, i interface{}, opts ...interface{}) (interface{}, error) {
-	if wp != nil {
+	if wp := c.App().wp; wp != nil {
 		return wp.req(c, method, i, opts...)
 	}
 	return nil, fmt.Errorf("not found worker pool")
@@ -400,7 +1285,7 @@ func PushTo(name string, method string, i interface{}, opts ...interface{}) {
 }
 
 func (c *Context) PushTo(name string, method string, i interface{}, opts ...interface{}) {
-	for _, work := range wgo.works {
+	for _, work := range c.App().works {
 		if work.Name() == name {
 			work.push(c, method, i, opts...)
 		}
@@ -414,7 +1299,7 @@ func ReqTo(name string, method string, i interface{}, opts ...interface{}) (inte
 }
 
 func (c *Context) ReqTo(name string, method string, i interface{}, opts ...interface{}) (interface{}, error) {
-	for _, work := range wgo.works {
+	for _, work := range c.App().works {
 		if work.Name() == name {
 			return work.req(c, method, i, opts...)
 		}
@@ -423,19 +1308,69 @@ func (c *Context) ReqTo(name string, method string, i interface{}, opts ...inter
 }
 
 func (work *WorkerPool) push(c *Context, method string, i interface{}, opts ...interface{}) {
+	if !work.accept() {
+		c.Warn("work(%s) is closed, drop job: %s", work.Name(), method)
+		return
+	}
+	if work.jq != nil {
+		err := work.enqueue(c, method, i, opts...)
+		if err == nil {
+			work.pending.Done()
+			return
+		}
+		// 入队失败, 退化为内存job, 不再重试
+		c.Error("work(%s) enqueue job(%s) failed: %s", work.Name(), method, err)
+	}
 	// 封装为job, method为空, 这样默认handler会处理这个job
-	work.queue <- c.NewJob(work.Name(), method, i, opts...)
+	if err := work.submit(work.newJob(c, method, i, opts...)); err != nil {
+		work.pending.Done()
+		c.Warn("drop job(%s): %s", method, err)
+	}
 }
 func (work *WorkerPool) req(c *Context, method string, i interface{}, opts ...interface{}) (interface{}, error) {
+	if !work.accept() {
+		return nil, fmt.Errorf("work(%s) is closed", work.Name())
+	}
+	// 超时: 选项优先, 其次是调用方的deadline, 都没有用默认值
+	jo, jopts := splitJobOpts(opts)
+	timeout := jo.timeout
+	parent := c.Context()
+	if parent == nil {
+		parent = context.Background()
+	}
+	if _, ok := parent.Deadline(); !ok && timeout <= 0 {
+		timeout = defaultReqTimeout
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	// 返回时取消job的context, 调用方放弃后job可以提前结束
+	defer cancel()
+
 	// 封装为job, method为空, 这样默认handler会处理这个job
-	job := c.NewJob(work.Name(), method, i, opts...)
-	work.queue <- job
-	// waiting result, timeout in 60 seconds
-	to := time.Tick(60 * time.Second)
+	job := c.NewJob(work.Name(), method, i, jopts...)
+	job.priority = jo.priority
+	job.stage = jo.stage
+	// 调用方在等待结果, job跟随调用方的超时和取消
+	job.context.SetContext(ctx)
+	if err := work.submit(job); err != nil {
+		work.pending.Done()
+		return nil, err
+	}
 	select {
-	case <-to: //超时
-		Warn("timeout in 60s")
-		return nil, fmt.Errorf("timeout in 60s")
+	case <-ctx.Done():
+		var err error
+		if ctx.Err() == context.DeadlineExceeded {
+			err = Errorf(ErrCodeJobTimeout, "work(%s) req(%s) timeout", work.Name(), method)
+		} else {
+			err = Errorf(ErrCodeJobCanceled, "work(%s) req(%s) canceled", work.Name(), method)
+		}
+		c.Warn("%s", err)
+		return nil, err
 	case response := <-job.response:
 		// Info("received job response: %+v", response)
 		if err, ok := response.(error); ok {
@@ -0,0 +1,169 @@
+//
+// work_pipeline.go
+// 多阶段job: 按顺序经过多个method(可以跨pool), 以及把一组payload分发给并行的job再汇总结果
+//
+
+package wgo
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"wgo/utils"
+)
+
+type (
+	// Pipeline 按顺序执行的阶段, 上一阶段的result作为下一阶段的payload
+	// p := wgo.NewPipeline("etl").Then("fetch", "get").FanOut("cpu", "transform").Then("db", "persist")
+	// res, err := p.Req(c, urls)
+	Pipeline struct {
+		name   string
+		stages []*Stage
+	}
+
+	// Stage 在work(pool)中执行method, work为空使用默认pool
+	Stage struct {
+		Work   string
+		Method string
+		FanOut bool          // payload为slice, 每个元素一个job并行执行, 结果按顺序组成slice
+		Opts   []interface{} // job选项, 如JobTimeout, PriorityHigh
+	}
+
+	// job选项: 所属的流水线阶段, 记录在access log中
+	jobStage string
+)
+
+func NewPipeline(name string) *Pipeline {
+	return &Pipeline{name: name, stages: make([]*Stage, 0)}
+}
+
+func (p *Pipeline) Name() string {
+	return p.name
+}
+
+func (p *Pipeline) Stages() []*Stage {
+	return p.stages
+}
+
+// 增加一个阶段
+func (p *Pipeline) Then(work, method string, opts ...interface{}) *Pipeline {
+	p.stages = append(p.stages, &Stage{Work: work, Method: method, Opts: opts})
+	return p
+}
+
+// 增加一个并行阶段
+func (p *Pipeline) FanOut(work, method string, opts ...interface{}) *Pipeline {
+	p.stages = append(p.stages, &Stage{Work: work, Method: method, FanOut: true, Opts: opts})
+	return p
+}
+
+/* {{{ func (p *Pipeline) Req(c *Context, payload interface{}, opts ...interface{}) (interface{}, error)
+ * 同步执行所有阶段, 返回最后一个阶段的result, 任一阶段失败即返回该阶段的错误
+ * opts为每个阶段共用的job选项, 阶段自己的选项优先
+ */
+func (p *Pipeline) Req(c *Context, payload interface{}, opts ...interface{}) (interface{}, error) {
+	start := time.Now()
+	res := payload
+	for i, st := range p.stages {
+		work := c.App().wp
+		if st.Work != "" {
+			work = c.App().Work(st.Work)
+		}
+		if work == nil {
+			return nil, fmt.Errorf("pipeline(%s) stage %d: not found worker pool %q", p.name, i+1, st.Work)
+		}
+		stage := fmt.Sprintf("%s#%d", p.name, i+1)
+		sopts := append(append([]interface{}{jobStage(stage)}, opts...), st.Opts...)
+		var err error
+		if st.FanOut {
+			res, err = work.fanOut(c, st.Method, res, sopts...)
+		} else {
+			res, err = work.req(c, st.Method, res, sopts...)
+		}
+		if err != nil {
+			c.Warn("pipeline(%s) failed at stage %d(%s:%s) after %s: %s", p.name, i+1, work.Name(), st.Method, time.Since(start), err)
+			return nil, err
+		}
+	}
+	c.Debug("pipeline(%s) finished %d stages in %s", p.name, len(p.stages), time.Since(start))
+	return res, nil
+}
+
+/* }}} */
+
+// 异步执行, 不关心结果
+func (p *Pipeline) Push(c *Context, payload interface{}, opts ...interface{}) {
+	c.Go(func(dc *Context) {
+		p.Req(dc, payload, opts...)
+	})
+}
+
+// 并行执行, 使用默认pool
+func FanOut(method string, items interface{}, opts ...interface{}) ([]interface{}, error) {
+	c := NewContext().(*Context)
+	c.SetRequestID(utils.FastRequestId(16))
+	return c.FanOut(method, items, opts...)
+}
+
+/* {{{ func (c *Context) FanOut(method string, items interface{}, opts ...interface{}) ([]interface{}, error)
+ * items必须是slice/array, 每个元素作为payload提交一个job, 等待全部完成后按顺序返回result
+ * 任一job失败返回第一个错误
+ */
+func (c *Context) FanOut(method string, items interface{}, opts ...interface{}) ([]interface{}, error) {
+	wp := c.App().wp
+	if wp == nil {
+		return nil, fmt.Errorf("not found worker pool")
+	}
+	return wp.fanOut(c, method, items, opts...)
+}
+
+/* }}} */
+
+func FanOutTo(name string, method string, items interface{}, opts ...interface{}) ([]interface{}, error) {
+	c := NewContext().(*Context)
+	c.SetRequestID(utils.FastRequestId(16))
+	return c.FanOutTo(name, method, items, opts...)
+}
+
+func (c *Context) FanOutTo(name string, method string, items interface{}, opts ...interface{}) ([]interface{}, error) {
+	work := c.App().Work(name)
+	if work == nil {
+		return nil, fmt.Errorf("not found worker pool")
+	}
+	return work.fanOut(c, method, items, opts...)
+}
+
+func (work *WorkerPool) fanOut(c *Context, method string, items interface{}, opts ...interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("work(%s) fan out %s: payload must be slice, got %T", work.Name(), method, items)
+	}
+	jo, _ := splitJobOpts(opts)
+	label := jo.stage
+	if label == "" {
+		label = method
+	}
+	results := make([]interface{}, v.Len())
+	errs := make([]error, v.Len())
+	wg := new(sync.WaitGroup)
+	for i := 0; i < v.Len(); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// 每个元素标记自己的序号
+			iopts := make([]interface{}, 0, len(opts)+1)
+			iopts = append(append(iopts, opts...), jobStage(fmt.Sprintf("%s[%d]", label, i)))
+			results[i], errs[i] = work.req(c, method, v.Index(i).Interface(), iopts...)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			c.Warn("work(%s) fan out %s: item %d failed: %s", work.Name(), method, i, err)
+			return nil, err
+		}
+	}
+	return results, nil
+}
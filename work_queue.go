@@ -0,0 +1,520 @@
+//
+// work_queue.go
+// 持久化的job队列, 投递语义为at-least-once
+//
+
+package wgo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"wgo/storage"
+)
+
+const (
+	defaultJobLease    = 5 * time.Minute // 取出后多久未ack会被重新投递
+	defaultJobPoll     = time.Second     // 队列为空时的轮询间隔
+	defaultMaxAttempts = 3
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = 10 * time.Minute
+)
+
+var (
+	ErrJobExists   = errors.New("job exists")
+	ErrJobNotFound = errors.New("job not found")
+)
+
+type (
+	// JobMessage 队列中的job, payload/opts序列化为json
+	JobMessage struct {
+		ID        string          `json:"id"`
+		RequestID string          `json:"rid,omitempty"`
+		Trace     string          `json:"trace,omitempty"` // 入队时的traceparent
+		Work      string          `json:"work"`
+		Method    string          `json:"method,omitempty"`
+		Payload   json.RawMessage `json:"payload,omitempty"`
+		Opts      json.RawMessage `json:"opts,omitempty"`
+		Attempts  int             `json:"attempts"`             // 已失败的次数
+		LastError string          `json:"last_error,omitempty"` // 最后一次失败的原因
+		Key       string          `json:"key,omitempty"`        // 去重key, 同一个key只会有一个待执行的延迟job
+		Priority  JobPriority     `json:"priority,omitempty"`
+		Track     bool            `json:"track,omitempty"` // 记录状态(异步job)
+		Created   time.Time       `json:"created"`
+		At        time.Time       `json:"at"` // 在此时间之后才会被投递, 为零值时立即投递
+
+		raw string // 出队时的原始内容, ack/retry时用来定位
+	}
+
+	// JobQueue job队列后端
+	JobQueue interface {
+		// Enqueue 入队, msg.At不为零值则延迟投递
+		// msg.Key不为空且已有同id的延迟job时返回ErrJobExists
+		Enqueue(msg *JobMessage) error
+		// Reserve 取出一个到期的job, lease内没有Ack/Retry/Bury会被重新投递, 队列为空返回nil, nil
+		Reserve(lease time.Duration) (*JobMessage, error)
+		// Ack 处理成功, 从队列中移除
+		Ack(msg *JobMessage) error
+		// Retry 重新入队, msg.At之后再次投递
+		Retry(msg *JobMessage) error
+		// Bury 放入死信队列
+		Bury(msg *JobMessage) error
+		// Dead 死信列表
+		Dead() ([]*JobMessage, error)
+		// Replay 把死信重新放回队列, 重试次数清零
+		Replay(id string) error
+		// Scheduled 等待投递的延迟job(包括等待重试的), 按投递时间排序
+		Scheduled() ([]*JobMessage, error)
+		// Cancel 取消还未投递的延迟job, 不存在返回ErrJobNotFound
+		Cancel(id string) error
+	}
+
+	// RetryPolicy 失败重试策略, 重试间隔按Backoff指数增长
+	RetryPolicy struct {
+		MaxAttempts int           // 最多执行次数(含第一次), <=1 不重试
+		Backoff     time.Duration // 第一次重试的间隔
+		MaxBackoff  time.Duration // 重试间隔上限
+	}
+)
+
+func newRetryPolicy(max int, backoff time.Duration, opts ...time.Duration) RetryPolicy {
+	rp := RetryPolicy{
+		MaxAttempts: max,
+		Backoff:     backoff,
+		MaxBackoff:  defaultMaxBackoff,
+	}
+	if len(opts) > 0 && opts[0] > 0 {
+		rp.MaxBackoff = opts[0]
+	}
+	return rp
+}
+
+// 第n次失败之后的重试间隔
+func (rp RetryPolicy) delay(attempts int) time.Duration {
+	d := rp.Backoff
+	for i := 1; i < attempts && (rp.MaxBackoff <= 0 || d < rp.MaxBackoff); i++ {
+		d *= 2
+	}
+	if rp.MaxBackoff > 0 && d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+	return d
+}
+
+func (m *JobMessage) encode() (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodeJobMessage(raw []byte) (*JobMessage, error) {
+	m := new(JobMessage)
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, err
+	}
+	m.raw = string(raw)
+	return m, nil
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+/* {{{ memory queue
+ * 进程内的队列, 不持久化, 主要用于测试
+ */
+type memoryJobQueue struct {
+	lock       sync.Mutex
+	ready      []*JobMessage
+	delayed    map[string]*JobMessage    // id -> msg
+	processing map[*JobMessage]time.Time // msg -> lease到期时间
+	dead       map[string]*JobMessage
+	now        func() time.Time // 测试中替换
+}
+
+func NewMemoryJobQueue() JobQueue {
+	return &memoryJobQueue{
+		delayed:    make(map[string]*JobMessage),
+		processing: make(map[*JobMessage]time.Time),
+		dead:       make(map[string]*JobMessage),
+		now:        time.Now,
+	}
+}
+
+func (q *memoryJobQueue) Enqueue(msg *JobMessage) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.schedule(msg, msg.Key != "")
+}
+
+// 放入ready或delayed, nx为true时delayed中已有同id的job则返回ErrJobExists
+func (q *memoryJobQueue) schedule(msg *JobMessage, nx bool) error {
+	m := *msg
+	if m.At.IsZero() {
+		q.ready = append(q.ready, &m)
+		return nil
+	}
+	if _, ok := q.delayed[m.ID]; ok && nx {
+		return ErrJobExists
+	}
+	q.delayed[m.ID] = &m
+	return nil
+}
+
+func (q *memoryJobQueue) Reserve(lease time.Duration) (*JobMessage, error) {
+	now := q.now()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	// 到期的延迟job
+	due := make([]*JobMessage, 0)
+	for id, m := range q.delayed {
+		if !m.At.After(now) {
+			delete(q.delayed, id)
+			due = append(due, m)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].At.Before(due[j].At) })
+	q.ready = append(q.ready, due...)
+	// lease过期的job
+	for m, until := range q.processing {
+		if until.Before(now) {
+			delete(q.processing, m)
+			q.ready = append(q.ready, m)
+		}
+	}
+	if len(q.ready) == 0 {
+		return nil, nil
+	}
+	m := q.ready[0]
+	q.ready = q.ready[1:]
+	q.processing[m] = now.Add(lease)
+	rm := *m
+	rm.raw = m.ID
+	return &rm, nil
+}
+
+// 从processing中移除
+func (q *memoryJobQueue) release(msg *JobMessage) {
+	for m := range q.processing {
+		if m.ID == msg.raw {
+			delete(q.processing, m)
+			return
+		}
+	}
+}
+
+func (q *memoryJobQueue) Ack(msg *JobMessage) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.release(msg)
+	return nil
+}
+
+func (q *memoryJobQueue) Retry(msg *JobMessage) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.release(msg)
+	return q.schedule(msg, false)
+}
+
+func (q *memoryJobQueue) Bury(msg *JobMessage) error {
+	m := *msg
+	m.raw = ""
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.release(msg)
+	q.dead[m.ID] = &m
+	return nil
+}
+
+func (q *memoryJobQueue) Dead() ([]*JobMessage, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	ms := make([]*JobMessage, 0, len(q.dead))
+	for _, m := range q.dead {
+		dm := *m
+		ms = append(ms, &dm)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Created.Before(ms[j].Created) })
+	return ms, nil
+}
+
+func (q *memoryJobQueue) Replay(id string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	m, ok := q.dead[id]
+	if !ok {
+		return fmt.Errorf("dead job(%s) not found", id)
+	}
+	delete(q.dead, id)
+	m.Attempts = 0
+	m.At = time.Time{}
+	return q.schedule(m, false)
+}
+
+func (q *memoryJobQueue) Scheduled() ([]*JobMessage, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	ms := make([]*JobMessage, 0, len(q.delayed))
+	for _, m := range q.delayed {
+		dm := *m
+		ms = append(ms, &dm)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].At.Before(ms[j].At) })
+	return ms, nil
+}
+
+func (q *memoryJobQueue) Cancel(id string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, ok := q.delayed[id]; !ok {
+		return ErrJobNotFound
+	}
+	delete(q.delayed, id)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ storage queue
+ * 基于storage(redis)的队列, 同一个队列的key都落在同一个节点上
+ * ready: list, delayed: zset(score为投递时间), processing: zset(score为lease到期时间)
+ * scheduled: hash(id -> msg), delayed的索引, 用于去重/查询/取消; dead: hash(id -> msg)
+ */
+const (
+	// 放入delayed, 同id的旧job会被替换
+	scheduleLua = `
+local function schedule(delayed, index, id, score, raw)
+	local old = redis.call('HGET', index, id)
+	if old then
+		redis.call('ZREM', delayed, old)
+	end
+	redis.call('HSET', index, id, raw)
+	redis.call('ZADD', delayed, score, raw)
+end
+`
+	// KEYS: delayed, scheduled; ARGV: id, score, raw, nx
+	scheduleScript = scheduleLua + `
+if ARGV[4] == '1' and redis.call('HEXISTS', KEYS[2], ARGV[1]) == 1 then
+	return 0
+end
+schedule(KEYS[1], KEYS[2], ARGV[1], ARGV[2], ARGV[3])
+return 1`
+	// 把到期的delayed/processing挪回ready, 然后取出一个放入processing
+	// KEYS: ready, delayed, processing, scheduled; ARGV: now, lease
+	reserveScript = `
+local function requeue(src, index)
+	local due = redis.call('ZRANGEBYSCORE', src, '-inf', ARGV[1], 'LIMIT', 0, 100)
+	for _, v in ipairs(due) do
+		redis.call('ZREM', src, v)
+		if index then
+			local ok, m = pcall(cjson.decode, v)
+			if ok and m['id'] then
+				redis.call('HDEL', index, m['id'])
+			end
+		end
+		redis.call('LPUSH', KEYS[1], v)
+	end
+end
+requeue(KEYS[2], KEYS[4])
+requeue(KEYS[3])
+local v = redis.call('RPOP', KEYS[1])
+if v then
+	redis.call('ZADD', KEYS[3], ARGV[2], v)
+end
+return v`
+	// KEYS: processing, delayed, scheduled; ARGV: old, id, score, raw
+	retryScript = scheduleLua + `
+redis.call('ZREM', KEYS[1], ARGV[1])
+schedule(KEYS[2], KEYS[3], ARGV[2], ARGV[3], ARGV[4])
+return 1`
+	// KEYS: processing, dead; ARGV: old, id, raw
+	buryScript = `
+redis.call('ZREM', KEYS[1], ARGV[1])
+return redis.call('HSET', KEYS[2], ARGV[2], ARGV[3])`
+	// 在json末尾追加attempts/at(重复的key解析时以后出现的为准), 不需要在lua中重新编码
+	// KEYS: dead, ready; ARGV: id, reset
+	replayScript = `
+local raw = redis.call('HGET', KEYS[1], ARGV[1])
+if not raw then
+	return 0
+end
+if string.sub(raw, -1) ~= '}' then
+	return {err = 'invalid dead job: ' .. ARGV[1]}
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('LPUSH', KEYS[2], string.sub(raw, 1, -2) .. ',' .. ARGV[2] .. '}')
+return 1`
+	// KEYS: delayed, scheduled; ARGV: id
+	cancelScript = `
+local old = redis.call('HGET', KEYS[2], ARGV[1])
+if not old then
+	return 0
+end
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[1], old)
+return 1`
+)
+
+// 重放时覆盖的字段, 与JobMessage的json一致
+const replayReset = `"attempts":0,"at":"0001-01-01T00:00:00Z"`
+
+type storageJobQueue struct {
+	s          *storage.Storage
+	key        string // 选择节点
+	ready      string
+	delayed    string
+	processing string
+	scheduled  string
+	dead       string
+	now        func() time.Time // 测试中替换
+}
+
+func NewStorageJobQueue(s *storage.Storage, name string) JobQueue {
+	key := "wgo:work:" + name
+	return &storageJobQueue{
+		s:          s,
+		key:        key,
+		ready:      key + ":ready",
+		delayed:    key + ":delayed",
+		processing: key + ":processing",
+		scheduled:  key + ":scheduled",
+		dead:       key + ":dead",
+		now:        time.Now,
+	}
+}
+
+func (q *storageJobQueue) Enqueue(msg *JobMessage) error {
+	raw, err := msg.encode()
+	if err != nil {
+		return err
+	}
+	if msg.At.IsZero() {
+		_, err = q.s.Do(q.key, "LPUSH", q.ready, raw)
+		return err
+	}
+	nx := 0
+	if msg.Key != "" {
+		nx = 1
+	}
+	reply, err := q.s.Do(q.key, "EVAL", scheduleScript, 2, q.delayed, q.scheduled,
+		msg.ID, unixMilli(msg.At), raw, nx)
+	if err != nil {
+		return err
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return ErrJobExists
+	}
+	return nil
+}
+
+func (q *storageJobQueue) Reserve(lease time.Duration) (*JobMessage, error) {
+	now := q.now()
+	reply, err := q.s.Do(q.key, "EVAL", reserveScript, 4, q.ready, q.delayed, q.processing, q.scheduled,
+		unixMilli(now), unixMilli(now.Add(lease)))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	raw, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply: %v", reply)
+	}
+	m, err := decodeJobMessage(raw)
+	if err != nil {
+		// 无法解析, 直接丢掉, 否则会一直被重新投递
+		q.s.Do(q.key, "ZREM", q.processing, raw)
+		return nil, fmt.Errorf("invalid job(%s): %s", raw, err)
+	}
+	return m, nil
+}
+
+func (q *storageJobQueue) Ack(msg *JobMessage) error {
+	_, err := q.s.Do(q.key, "ZREM", q.processing, msg.raw)
+	return err
+}
+
+func (q *storageJobQueue) Retry(msg *JobMessage) error {
+	raw, err := msg.encode()
+	if err != nil {
+		return err
+	}
+	_, err = q.s.Do(q.key, "EVAL", retryScript, 3, q.processing, q.delayed, q.scheduled,
+		msg.raw, msg.ID, unixMilli(msg.At), raw)
+	return err
+}
+
+func (q *storageJobQueue) Bury(msg *JobMessage) error {
+	raw, err := msg.encode()
+	if err != nil {
+		return err
+	}
+	_, err = q.s.Do(q.key, "EVAL", buryScript, 2, q.processing, q.dead, msg.raw, msg.ID, raw)
+	return err
+}
+
+// hash中的所有job
+func (q *storageJobQueue) values(key string) ([]*JobMessage, error) {
+	reply, err := q.s.Do(q.key, "HVALS", key)
+	if err != nil {
+		return nil, err
+	}
+	vs, _ := reply.([]interface{})
+	ms := make([]*JobMessage, 0, len(vs))
+	for _, v := range vs {
+		if raw, ok := v.([]byte); ok {
+			if m, err := decodeJobMessage(raw); err == nil {
+				ms = append(ms, m)
+			}
+		}
+	}
+	return ms, nil
+}
+
+func (q *storageJobQueue) Dead() ([]*JobMessage, error) {
+	ms, err := q.values(q.dead)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Created.Before(ms[j].Created) })
+	return ms, nil
+}
+
+// 取出和放回在同一个脚本中, 避免与并发的Replay/Bury交错
+func (q *storageJobQueue) Replay(id string) error {
+	reply, err := q.s.Do(q.key, "EVAL", replayScript, 2, q.dead, q.ready, id, replayReset)
+	if err != nil {
+		return err
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return fmt.Errorf("dead job(%s) not found", id)
+	}
+	return nil
+}
+
+func (q *storageJobQueue) Scheduled() ([]*JobMessage, error) {
+	ms, err := q.values(q.scheduled)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].At.Before(ms[j].At) })
+	return ms, nil
+}
+
+func (q *storageJobQueue) Cancel(id string) error {
+	reply, err := q.s.Do(q.key, "EVAL", cancelScript, 2, q.delayed, q.scheduled, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+/* }}} */
@@ -0,0 +1,274 @@
+package wgo_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"wgo"
+	"wgo/internal/luatest"
+	"wgo/wgotest"
+)
+
+// 两种队列实现跑同样的用例, storage队列的lua脚本由luatest执行
+// 时钟由测试推进, 不依赖sleep
+func queues() map[string]func() wgo.JobQueue {
+	return map[string]func() wgo.JobQueue{
+		"memory":  wgo.NewMemoryJobQueue,
+		"storage": func() wgo.JobQueue { return wgo.NewStorageJobQueue(luatest.NewStorage(), "test") },
+	}
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time                  { return c.now }
+func (c *fakeClock) Advance(d time.Duration)         { c.now = c.now.Add(d) }
+func (c *fakeClock) After(d time.Duration) time.Time { return c.now.Add(d) }
+
+func withClock(q wgo.JobQueue) (wgo.JobQueue, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	wgo.SetQueueNow(q, clock.Now)
+	return q, clock
+}
+
+func reserve(t *testing.T, q wgo.JobQueue, lease time.Duration) *wgo.JobMessage {
+	t.Helper()
+	m, err := q.Reserve(lease)
+	if err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	return m
+}
+
+func expectEmpty(t *testing.T, q wgo.JobQueue) {
+	t.Helper()
+	if m := reserve(t, q, time.Minute); m != nil {
+		t.Fatalf("unexpected job: %+v", m)
+	}
+}
+
+func TestJobQueueLease(t *testing.T) {
+	for name, newQueue := range queues() {
+		t.Run(name, func(t *testing.T) {
+			q, clock := withClock(newQueue())
+			expectEmpty(t, q)
+			q.Enqueue(&wgo.JobMessage{ID: "j1", Work: "test", Created: clock.Now()})
+			q.Enqueue(&wgo.JobMessage{ID: "j2", Work: "test", Created: clock.Now()})
+
+			// 先进先出
+			m1 := reserve(t, q, 30*time.Millisecond)
+			if m1 == nil || m1.ID != "j1" {
+				t.Fatalf("reserve: %+v", m1)
+			}
+			m2 := reserve(t, q, time.Minute)
+			if m2 == nil || m2.ID != "j2" {
+				t.Fatalf("reserve: %+v", m2)
+			}
+			expectEmpty(t, q)
+
+			// lease到期未ack的重新投递, ack之后不再投递
+			clock.Advance(20 * time.Millisecond)
+			expectEmpty(t, q)
+			clock.Advance(20 * time.Millisecond)
+			again := reserve(t, q, 30*time.Millisecond)
+			if again == nil || again.ID != "j1" {
+				t.Fatalf("expired lease not redelivered: %+v", again)
+			}
+			if err := q.Ack(again); err != nil {
+				t.Fatal(err)
+			}
+			if err := q.Ack(m2); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(time.Hour)
+			expectEmpty(t, q)
+		})
+	}
+}
+
+func TestJobQueueSchedule(t *testing.T) {
+	for name, newQueue := range queues() {
+		t.Run(name, func(t *testing.T) {
+			q, clock := withClock(newQueue())
+			at := clock.After(40 * time.Millisecond)
+			if err := q.Enqueue(&wgo.JobMessage{ID: "k1", Key: "k", At: at}); err != nil {
+				t.Fatal(err)
+			}
+			if err := q.Enqueue(&wgo.JobMessage{ID: "k1", Key: "k", At: at}); err != wgo.ErrJobExists {
+				t.Errorf("dedupe: %v", err)
+			}
+			q.Enqueue(&wgo.JobMessage{ID: "c1", At: at.Add(time.Hour)})
+			if ms, _ := q.Scheduled(); len(ms) != 2 || ms[0].ID != "k1" || ms[1].ID != "c1" {
+				t.Errorf("scheduled: %+v", ms)
+			}
+			if err := q.Cancel("c1"); err != nil {
+				t.Errorf("cancel: %v", err)
+			}
+			if err := q.Cancel("c1"); err != wgo.ErrJobNotFound {
+				t.Errorf("cancel again: %v", err)
+			}
+
+			clock.Advance(39 * time.Millisecond)
+			expectEmpty(t, q)
+			clock.Advance(time.Millisecond)
+			if m := reserve(t, q, time.Minute); m == nil || m.ID != "k1" {
+				t.Fatalf("due job: %+v", m)
+			}
+			if ms, _ := q.Scheduled(); len(ms) != 0 {
+				t.Errorf("scheduled after due: %+v", ms)
+			}
+			// 已经投递, 同一个key可以再次入队
+			if err := q.Enqueue(&wgo.JobMessage{ID: "k1", Key: "k", At: at}); err != nil {
+				t.Errorf("enqueue after due: %v", err)
+			}
+		})
+	}
+}
+
+func TestJobQueueRetry(t *testing.T) {
+	for name, newQueue := range queues() {
+		t.Run(name, func(t *testing.T) {
+			q, clock := withClock(newQueue())
+			q.Enqueue(&wgo.JobMessage{ID: "r1", Work: "test"})
+			m := reserve(t, q, 30*time.Millisecond)
+			m.Attempts = 1
+			m.LastError = "boom"
+			m.At = clock.After(60 * time.Millisecond)
+			if err := q.Retry(m); err != nil {
+				t.Fatal(err)
+			}
+
+			// 退避期间不投递, 原来的lease(30ms)到期也不会导致重新投递
+			clock.Advance(40 * time.Millisecond)
+			expectEmpty(t, q)
+			if ms, _ := q.Scheduled(); len(ms) != 1 || ms[0].Attempts != 1 {
+				t.Errorf("scheduled: %+v", ms)
+			}
+			clock.Advance(20 * time.Millisecond)
+			r := reserve(t, q, time.Minute)
+			if r == nil || r.ID != "r1" || r.Attempts != 1 || r.LastError != "boom" {
+				t.Fatalf("retried job: %+v", r)
+			}
+			expectEmpty(t, q)
+		})
+	}
+}
+
+func TestJobQueueDead(t *testing.T) {
+	for name, newQueue := range queues() {
+		t.Run(name, func(t *testing.T) {
+			q, clock := withClock(newQueue())
+			// 大整数在replay之后不能变成浮点数
+			payload := json.RawMessage(`{"n":9007199254740993,"attempts":7}`)
+			q.Enqueue(&wgo.JobMessage{ID: "d1", Work: "test", Payload: payload, Created: clock.Now()})
+			m := reserve(t, q, 30*time.Millisecond)
+			m.Attempts = 3
+			m.LastError = "boom"
+			m.At = clock.Now()
+			if err := q.Bury(m); err != nil {
+				t.Fatal(err)
+			}
+			// 死信不会因为lease到期重新投递
+			clock.Advance(time.Hour)
+			expectEmpty(t, q)
+			dead, _ := q.Dead()
+			if len(dead) != 1 || dead[0].ID != "d1" || dead[0].Attempts != 3 || dead[0].LastError != "boom" {
+				t.Fatalf("dead: %+v", dead)
+			}
+
+			if err := q.Replay("nobody"); err == nil {
+				t.Errorf("replay unknown job")
+			}
+			if err := q.Replay("d1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := q.Replay("d1"); err == nil {
+				t.Errorf("replay twice")
+			}
+			if dead, _ := q.Dead(); len(dead) != 0 {
+				t.Errorf("dead after replay: %+v", dead)
+			}
+			r := reserve(t, q, time.Minute)
+			if r == nil || r.ID != "d1" || r.Attempts != 0 || !r.At.IsZero() {
+				t.Fatalf("replayed: %+v", r)
+			}
+			var p map[string]json.Number
+			d := json.NewDecoder(bytes.NewReader(r.Payload))
+			d.UseNumber()
+			if err := d.Decode(&p); err != nil || p["n"] != "9007199254740993" || p["attempts"] != "7" {
+				t.Errorf("payload: %s", r.Payload)
+			}
+			// replay之后可以正常ack
+			q.Ack(r)
+			clock.Advance(time.Hour)
+			expectEmpty(t, q)
+		})
+	}
+}
+
+// 失败按退避重试, 超过次数进入死信
+func TestWorkRetryBackoff(t *testing.T) {
+	for name, newQueue := range queues() {
+		t.Run(name, func(t *testing.T) {
+			app := wgotest.New("proc_name: worktest")
+			defer app.Close()
+			var (
+				mu       sync.Mutex
+				attempts []time.Time
+				delays   []time.Duration
+			)
+			wp := wgo.AddWork("dq", 1).UseQueue(newQueue()).Retry(3, 100*time.Millisecond, 150*time.Millisecond)
+			wp.Add("fail", func(c *wgo.Context) error {
+				mu.Lock()
+				defer mu.Unlock()
+				attempts = append(attempts, time.Now())
+				return fmt.Errorf("fail %d", len(attempts))
+			})
+			app.GET("/")
+			wgo.PushTo("dq", "fail", map[string]int{"x": 1})
+
+			deadline := time.Now().Add(10 * time.Second)
+			for time.Now().Before(deadline) {
+				if ms, _ := wp.Scheduled(); len(ms) == 1 {
+					mu.Lock()
+					if n := len(attempts); n > len(delays) {
+						delays = append(delays, ms[0].At.Sub(attempts[n-1]))
+					}
+					mu.Unlock()
+				}
+				if dead, _ := wp.DeadJobs(); len(dead) == 1 {
+					if dead[0].Attempts != 3 || !strings.HasPrefix(dead[0].LastError, "fail 3") {
+						t.Errorf("dead: %+v", dead[0])
+					}
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(attempts) != 3 {
+				t.Fatalf("attempts: %d", len(attempts))
+			}
+			// 100ms, 200ms受上限限制为150ms
+			want := []time.Duration{100 * time.Millisecond, 150 * time.Millisecond}
+			if len(delays) != len(want) {
+				t.Fatalf("delays: %v", delays)
+			}
+			for i, d := range delays {
+				// 上限没有生效时第二次为200ms
+				if d < want[i] || d >= want[i]+50*time.Millisecond {
+					t.Errorf("delay %d: %s, want %s", i, d, want[i])
+				}
+				if gap := attempts[i+1].Sub(attempts[i]); gap < want[i] {
+					t.Errorf("attempt %d too early: %s", i+1, gap)
+				}
+			}
+		})
+	}
+}
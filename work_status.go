@@ -0,0 +1,319 @@
+//
+// work_status.go
+// 异步job: 提交后返回202, 通过状态接口查询进度和结果
+//
+
+package wgo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"wgo/environ"
+	"wgo/server"
+	"wgo/storage"
+	"wgo/utils"
+	"wgo/whttp"
+)
+
+const (
+	// job state
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+
+	defaultJobStatusPath = "/jobs"
+	defaultJobResultTTL  = time.Hour
+	pendingJobTTL        = 24 * time.Hour // 未结束的job状态最多保留
+)
+
+type (
+	// JobStatus 异步job的状态及结果
+	JobStatus struct {
+		ID       string              `json:"id"`
+		Work     string              `json:"work"`
+		Method   string              `json:"method,omitempty"`
+		State    string              `json:"state"`
+		Result   interface{}         `json:"result,omitempty"`
+		Error    *server.ServerError `json:"error,omitempty"`
+		Attempts int                 `json:"attempts,omitempty"`
+		Created  time.Time           `json:"created"`
+		Updated  time.Time           `json:"updated"`
+	}
+
+	// JobStatusStore 保存job状态, ttl之后过期
+	JobStatusStore interface {
+		Save(st *JobStatus, ttl time.Duration) error
+		// Load 不存在或已过期返回nil, nil
+		Load(id string) (*JobStatus, error)
+	}
+)
+
+/* {{{ memory store
+ *
+ */
+type memoryJobStore struct {
+	lock    sync.Mutex
+	items   map[string]memoryJobItem
+	sweeped time.Time
+}
+
+type memoryJobItem struct {
+	st     JobStatus
+	expire time.Time
+}
+
+func NewMemoryJobStore() JobStatusStore {
+	return &memoryJobStore{
+		items:   make(map[string]memoryJobItem),
+		sweeped: time.Now(),
+	}
+}
+
+func (ms *memoryJobStore) Save(st *JobStatus, ttl time.Duration) error {
+	now := time.Now()
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ms.items[st.ID] = memoryJobItem{st: *st, expire: now.Add(ttl)}
+	// 每分钟清理一次过期的状态
+	if now.Sub(ms.sweeped) > time.Minute {
+		for id, item := range ms.items {
+			if item.expire.Before(now) {
+				delete(ms.items, id)
+			}
+		}
+		ms.sweeped = now
+	}
+	return nil
+}
+
+func (ms *memoryJobStore) Load(id string) (*JobStatus, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	item, ok := ms.items[id]
+	if !ok || item.expire.Before(time.Now()) {
+		return nil, nil
+	}
+	st := item.st
+	return &st, nil
+}
+
+/* }}} */
+
+/* {{{ storage store
+ *
+ */
+type storageJobStore struct {
+	s *storage.Storage
+}
+
+func NewStorageJobStore(s *storage.Storage) JobStatusStore {
+	return &storageJobStore{s: s}
+}
+
+func (ss *storageJobStore) key(id string) string {
+	return "wgo:job:" + id
+}
+
+func (ss *storageJobStore) Save(st *JobStatus, ttl time.Duration) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return ss.s.Put(ss.key(st.ID), b, ttl)
+}
+
+func (ss *storageJobStore) Load(id string) (*JobStatus, error) {
+	v := ss.s.Get(ss.key(id))
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, nil
+	}
+	st := new(JobStatus)
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+/* }}} */
+
+// job status store, 没有设置时有storage用storage, 否则用内存
+func JobStore() JobStatusStore { return Self().JobStore() }
+func (w *WGO) JobStore() JobStatusStore {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.jobStore == nil {
+		if s := w.Storage(); s != nil {
+			w.jobStore = NewStorageJobStore(s)
+		} else {
+			w.jobStore = NewMemoryJobStore()
+		}
+	}
+	return w.jobStore
+}
+
+func SetJobStore(s JobStatusStore) { Self().SetJobStore(s) }
+func (w *WGO) SetJobStore(s JobStatusStore) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.jobStore = s
+}
+
+// 结束的job状态保留时间, 配置job_result_ttl, 默认1小时
+func (w *WGO) jobResultTTL() time.Duration {
+	if d := w.Cfg().Duration(environ.CFG_KEY_JOBTTL); d > 0 {
+		return d
+	}
+	return defaultJobResultTTL
+}
+
+/* {{{ func ServeJobStatus(opts ...string) whttp.Routes
+ * 在所有http server上注册job状态接口: GET {path}/:id, path默认为/jobs
+ */
+func ServeJobStatus(opts ...string) whttp.Routes { return Self().ServeJobStatus(opts...) }
+func (w *WGO) ServeJobStatus(opts ...string) whttp.Routes {
+	path := defaultJobStatusPath
+	if len(opts) > 0 && opts[0] != "" {
+		path = opts[0]
+	}
+	w.lock.Lock()
+	w.jobStatusPath = path
+	w.lock.Unlock()
+	return w.HTTPServers().GET(path+"/:id", jobStatusHandler)
+}
+
+/* }}} */
+
+// job状态接口的path, 没有调用ServeJobStatus时为空
+func (w *WGO) jobStatusPrefix() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.jobStatusPath
+}
+
+// GET {path}/:id
+func jobStatusHandler(c *Context) error {
+	st, err := c.App().JobStore().Load(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return c.NewError(whttp.StatusNotFound*1000, "job not found")
+	}
+	return c.JSON(whttp.StatusOK, st)
+}
+
+// 异步执行, 使用默认pool, 返回202及状态地址
+func (c *Context) Async(method string, i interface{}, opts ...interface{}) error {
+	wp := c.App().wp
+	if wp == nil {
+		return fmt.Errorf("not found worker pool")
+	}
+	return c.async(wp, method, i, opts...)
+}
+
+// 异步执行, 使用指定的pool
+func (c *Context) AsyncTo(name string, method string, i interface{}, opts ...interface{}) error {
+	work := c.App().Work(name)
+	if work == nil {
+		return fmt.Errorf("not found worker pool")
+	}
+	return c.async(work, method, i, opts...)
+}
+
+func (c *Context) async(work *WorkerPool, method string, i interface{}, opts ...interface{}) error {
+	// 没有状态接口时返回的地址无法访问, 不提交
+	prefix := c.App().jobStatusPrefix()
+	if prefix == "" {
+		c.Error("work(%s) async job(%s) without job status endpoint, call ServeJobStatus() first", work.Name(), method)
+		return Errorf(whttp.StatusInternalServerError*1000, "job status not served")
+	}
+	id, err := work.async(c, method, i, opts...)
+	if err != nil {
+		return err
+	}
+	url := prefix + "/" + id
+	c.SetHeader(whttp.HeaderLocation, url)
+	return c.JSON(whttp.StatusAccepted, map[string]string{
+		"id":    id,
+		"state": JobPending,
+		"url":   url,
+	})
+}
+
+// 提交需要记录状态的job, 返回job id
+func (work *WorkerPool) async(c *Context, method string, i interface{}, opts ...interface{}) (string, error) {
+	if !work.accept() {
+		return "", Errorf(whttp.StatusServiceUnavailable*1000, "work(%s) is closed", work.Name())
+	}
+	id := utils.NewShortUUID()
+	now := time.Now()
+	st := &JobStatus{
+		ID:      id,
+		Work:    work.Name(),
+		Method:  method,
+		State:   JobPending,
+		Created: now,
+		Updated: now,
+	}
+	if err := work.App().JobStore().Save(st, pendingJobTTL); err != nil {
+		work.pending.Done()
+		return "", err
+	}
+	if work.jq != nil {
+		msg, err := work.newMessage(c, method, i, opts...)
+		if err == nil {
+			msg.ID = id
+			msg.Track = true
+			if err = work.jq.Enqueue(msg); err == nil {
+				work.pending.Done()
+				return id, nil
+			}
+		}
+		// 入队失败, 退化为内存job
+		c.Error("work(%s) enqueue job(%s) failed: %s", work.Name(), method, err)
+	}
+	job := work.newJob(c, method, i, opts...)
+	job.id = id
+	job.tracked = true
+	if err := work.submit(job); err != nil {
+		work.pending.Done()
+		job.Error(err)
+		work.track(job, JobFailed)
+		return "", err
+	}
+	return id, nil
+}
+
+// 更新job状态
+func (work *WorkerPool) track(job *Job, state string) {
+	store := work.App().JobStore()
+	st, err := store.Load(job.id)
+	if err != nil || st == nil {
+		st = &JobStatus{
+			ID:      job.id,
+			Work:    job.work,
+			Method:  job.method,
+			Created: job.start,
+		}
+	}
+	st.State = state
+	st.Attempts = job.Attempts()
+	st.Updated = time.Now()
+	st.Error = job.err
+	ttl := pendingJobTTL
+	switch state {
+	case JobSucceeded:
+		st.Result = job.result
+		ttl = work.App().jobResultTTL()
+	case JobFailed:
+		ttl = work.App().jobResultTTL()
+	}
+	if err := store.Save(st, ttl); err != nil {
+		work.App().Error("work(%s) save job(%s) status failed: %s", work.Name(), job.id, err)
+	}
+}
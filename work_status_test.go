@@ -0,0 +1,210 @@
+package wgo_test
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"wgo"
+	"wgo/wgotest"
+)
+
+// 记录每个状态保存时的ttl
+type ttlStore struct {
+	wgo.JobStatusStore
+	mu   sync.Mutex
+	ttls map[string]time.Duration
+}
+
+func (s *ttlStore) Save(st *wgo.JobStatus, ttl time.Duration) error {
+	s.mu.Lock()
+	s.ttls[st.State] = ttl
+	s.mu.Unlock()
+	return s.JobStatusStore.Save(st, ttl)
+}
+
+// 入队总是失败的队列
+type brokenQueue struct {
+	wgo.JobQueue
+}
+
+func (brokenQueue) Enqueue(*wgo.JobMessage) error {
+	return errors.New("queue down")
+}
+
+func submit(t *testing.T, app *wgotest.App, path string) (id, url string) {
+	t.Helper()
+	r := app.POST(path, "").Expect(t, 202)
+	var v map[string]string
+	if err := json.Unmarshal([]byte(r.String()), &v); err != nil {
+		t.Fatalf("accepted: %s", r)
+	}
+	if v["state"] != wgo.JobPending || v["url"] != "/jobs/"+v["id"] || r.Header.Get("Location") != v["url"] {
+		t.Fatalf("accepted: %s, location %s", r, r.Header.Get("Location"))
+	}
+	return v["id"], v["url"]
+}
+
+func jobStatus(t *testing.T, app *wgotest.App, url string) *wgo.JobStatus {
+	t.Helper()
+	st := new(wgo.JobStatus)
+	if err := json.Unmarshal([]byte(app.GET(url).Expect(t, 200).String()), st); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// 轮询直到状态为state
+func waitJob(t *testing.T, app *wgotest.App, url, state string) *wgo.JobStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		st := jobStatus(t, app, url)
+		if st.State == state {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job state: %+v, want %s", st, state)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newAsyncApp(t *testing.T, gate chan struct{}) (*wgotest.App, *ttlStore) {
+	app := wgotest.New("proc_name: asynctest\njob_result_ttl: 2m")
+	store := &ttlStore{JobStatusStore: wgo.NewMemoryJobStore(), ttls: map[string]time.Duration{}}
+	wgo.SetJobStore(store)
+	wgo.ServeJobStatus()
+	double := func(c *wgo.Context) error {
+		<-gate
+		var p struct{ N int }
+		if err := c.Job().Bind(&p); err != nil {
+			return err
+		}
+		return c.Job().SetResult(map[string]int{"n": p.N * 2})
+	}
+	fail := func(c *wgo.Context) error { return errors.New("boom") }
+	report := wgo.AddWork("report", 1).Retry(1, time.Millisecond, time.Millisecond)
+	report.Add("double", double)
+	report.Add("fail", fail)
+	wgo.AddWork("queued", 1).UseQueue(brokenQueue{wgo.NewMemoryJobQueue()}).Add("double", double)
+
+	wgo.POST("/double", func(c *wgo.Context) error { return c.AsyncTo("report", "double", map[string]int{"n": 21}) })
+	wgo.POST("/fail", func(c *wgo.Context) error { return c.AsyncTo("report", "fail", nil) })
+	wgo.POST("/queued", func(c *wgo.Context) error { return c.AsyncTo("queued", "double", map[string]int{"n": 1}) })
+	wgo.POST("/default", func(c *wgo.Context) error { return c.Async("double", map[string]int{"n": 2}) })
+	wgo.POST("/nowhere", func(c *wgo.Context) error { return c.AsyncTo("nowhere", "double", nil) })
+	return app, store
+}
+
+func TestAsyncStates(t *testing.T) {
+	gate := make(chan struct{})
+	app, store := newAsyncApp(t, gate)
+	defer app.Close()
+
+	id, url := submit(t, app, "/double")
+	if st := waitJob(t, app, url, wgo.JobRunning); st.ID != id || st.Work != "report" || st.Method != "double" || st.Attempts != 1 {
+		t.Errorf("running: %+v", st)
+	}
+	close(gate)
+	st := waitJob(t, app, url, wgo.JobSucceeded)
+	if b, _ := json.Marshal(st.Result); string(b) != `{"n":42}` || st.Error != nil {
+		t.Errorf("succeeded: %+v", st)
+	}
+
+	_, url = submit(t, app, "/fail")
+	if st := waitJob(t, app, url, wgo.JobFailed); st.Error == nil || st.Result != nil {
+		t.Errorf("failed: %+v", st)
+	}
+
+	// 未结束的状态保留24h, 结束之后按job_result_ttl
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	want := map[string]time.Duration{
+		wgo.JobPending:   24 * time.Hour,
+		wgo.JobRunning:   24 * time.Hour,
+		wgo.JobSucceeded: 2 * time.Minute,
+		wgo.JobFailed:    2 * time.Minute,
+	}
+	for state, ttl := range want {
+		if got := store.ttls[state]; got != ttl {
+			t.Errorf("%s ttl: %s, want %s", state, got, ttl)
+		}
+	}
+}
+
+func TestAsyncPools(t *testing.T) {
+	gate := make(chan struct{})
+	close(gate)
+	app, _ := newAsyncApp(t, gate)
+	defer app.Close()
+
+	// 入队失败时退化为内存job
+	_, url := submit(t, app, "/queued")
+	if st := waitJob(t, app, url, wgo.JobSucceeded); st.Work != "queued" {
+		t.Errorf("fallback: %+v", st)
+	}
+	// 默认pool为第一个work
+	_, url = submit(t, app, "/default")
+	if st := waitJob(t, app, url, wgo.JobSucceeded); st.Work != "report" {
+		t.Errorf("default pool: %+v", st)
+	}
+	app.POST("/nowhere", "").ExpectContains(t, "not found worker pool")
+	app.GET("/jobs/nobody").Expect(t, 404).ExpectContains(t, "job not found")
+}
+
+// 没有ServeJobStatus时不提交
+func TestAsyncWithoutStatus(t *testing.T) {
+	app := wgotest.New("proc_name: asynctest")
+	defer app.Close()
+	var ran int32
+	wgo.AddWork("report", 1).Add("double", func(c *wgo.Context) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	})
+	wgo.POST("/double", func(c *wgo.Context) error { return c.AsyncTo("report", "double", nil) })
+	app.POST("/double", "").Expect(t, 500).ExpectContains(t, "job status not served")
+	if n := atomic.LoadInt32(&ran); n != 0 {
+		t.Errorf("job submitted without status endpoint")
+	}
+}
+
+func TestJobStoreTTL(t *testing.T) {
+	app := wgotest.New("proc_name: asynctest", wgotest.WithStorage(wgotest.NewStorage()))
+	defer app.Close()
+	for name, store := range map[string]wgo.JobStatusStore{
+		"memory":  wgo.NewMemoryJobStore(),
+		"storage": wgo.JobStore(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			store.Save(&wgo.JobStatus{ID: "short", State: wgo.JobSucceeded, Result: "r"}, 20*time.Millisecond)
+			store.Save(&wgo.JobStatus{ID: "long", State: wgo.JobSucceeded}, time.Hour)
+			if st, err := store.Load("short"); err != nil || st == nil || st.Result != "r" {
+				t.Fatalf("load: %+v %v", st, err)
+			}
+			deadline := time.Now().Add(10 * time.Second)
+			for {
+				st, err := store.Load("short")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if st == nil {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("not expired: %+v", st)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if st, _ := store.Load("long"); st == nil {
+				t.Errorf("long ttl expired")
+			}
+			if st, _ := store.Load("nobody"); st != nil {
+				t.Errorf("unknown job: %+v", st)
+			}
+		})
+	}
+}
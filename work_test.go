@@ -0,0 +1,72 @@
+package wgo_test
+
+import (
+	"testing"
+	"time"
+
+	"wgo"
+	"wgo/wgotest"
+)
+
+// 排队时间没有达到scale_wait时不扩容, 达到之后扩容到max
+// job由测试放行, 不依赖执行时间
+func TestWorkScaleWait(t *testing.T) {
+	cases := []struct {
+		name    string
+		wait    time.Duration
+		jobs    int
+		running int // 放行之前同时在执行的job
+		workers int
+	}{
+		{"below scale_wait", time.Hour, 3, 1, 1},
+		{"reach scale_wait", time.Millisecond, 4, 4, 4},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			app := wgotest.New("proc_name: worktest")
+			defer app.Close()
+			started, finished := make(chan struct{}, c.jobs), make(chan struct{}, c.jobs)
+			gate := make(chan struct{})
+			wp := wgo.AddWork("scale", 4).Scale(1, time.Minute).ScaleWait(c.wait)
+			wp.Add("block", func(*wgo.Context) error {
+				started <- struct{}{}
+				<-gate
+				finished <- struct{}{}
+				return nil
+			})
+			app.GET("/")
+			for i := 0; i < c.jobs; i++ {
+				wgo.PushTo("scale", "block", nil)
+			}
+
+			// 等到应该在执行的job都开始, 之后不应再有job开始
+			timeout := time.After(10 * time.Second)
+			for i := 0; i < c.running; i++ {
+				select {
+				case <-started:
+				case <-timeout:
+					t.Fatalf("%d jobs running, want %d", i, c.running)
+				}
+			}
+			if c.running < c.jobs {
+				select {
+				case <-started:
+					t.Fatalf("more than %d jobs running", c.running)
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+			if n := wp.Stats().Workers; n != c.workers {
+				t.Errorf("workers: %d, want %d", n, c.workers)
+			}
+
+			close(gate)
+			for i := 0; i < c.jobs; i++ {
+				select {
+				case <-finished:
+				case <-timeout:
+					t.Fatalf("%d jobs finished, want %d", i, c.jobs)
+				}
+			}
+		})
+	}
+}
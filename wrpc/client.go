@@ -0,0 +1,55 @@
+// Package wrpc provides ...
+package wrpc
+
+import (
+	"context"
+
+	"wgo/trace"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type mdCarrier metadata.MD
+
+func (mc mdCarrier) Get(key string) string {
+	if vs := metadata.MD(mc).Get(key); len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+func (mc mdCarrier) Set(key, value string) {
+	metadata.MD(mc).Set(key, value)
+}
+
+/* {{{ func UnaryClientInterceptor() grpc.UnaryClientInterceptor
+ * 调用方的client拦截器, ctx中有span时记录client span并在metadata中注入traceparent
+ * grpc.Dial(addr, grpc.WithUnaryInterceptor(wrpc.UnaryClientInterceptor()))
+ */
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := trace.StartChild(ctx, method, trace.SpanKindClient,
+			trace.Attr("rpc.system", "grpc"), trace.Attr("rpc.method", method))
+		if span == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		md, ok := metadata.FromOutgoingContext(ctx)
+		if ok {
+			md = md.Copy()
+		} else {
+			md = metadata.MD{}
+		}
+		trace.Inject(ctx, mdCarrier(md))
+		err := invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+		if err != nil {
+			span.SetAttr("rpc.code", status.Code(err).String())
+			span.SetError(err)
+		}
+		span.End()
+		return err
+	}
+}
+
+/* }}} */
@@ -18,15 +18,16 @@ type (
 
 	Engine struct {
 		*grpc.Server
-		mux  server.Mux
-		name string
+		mux     server.Mux
+		name    string
+		natives map[string]bool // 不经过mux的服务, 直接调用服务自己的实现
 	}
 )
 
 // wrpc newEngine
 func newEngine() server.Engine {
 	// interceptor
-	e := &Engine{name: "grpc"}
+	e := &Engine{name: "grpc", natives: make(map[string]bool)}
 	e.Server = grpc.NewServer(grpc.UnaryInterceptor(e.InterceptorWrapper()))
 	return e
 }
@@ -88,6 +89,26 @@ func (e *Engine) Start(l net.Listener) error {
 
 /* }}} */
 
+/* {{{ func (e *Engine) Shutdown(ctx context.Context) error
+ * 等待进行中的rpc结束, ctx到期则强制停止
+ */
+func (e *Engine) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.Server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		e.Server.Stop()
+		return ctx.Err()
+	}
+}
+
+/* }}} */
+
 /* {{{ func RegisterService(rf RegisterFunc)
  * 注册服务
  */
@@ -99,6 +120,17 @@ func (e *Engine) RegisterService(rf RegisterFunc) {
 
 /* }}} */
 
+/* {{{ func (e *Engine) RegisterNative(service string, rf RegisterFunc)
+ * 注册不经过mux路由的服务(如grpc health), service为完整的服务名(如grpc.health.v1.Health)
+ * 需要在Start之前注册
+ */
+func (e *Engine) RegisterNative(service string, rf RegisterFunc) {
+	e.natives[service] = true
+	rf(e.Server)
+}
+
+/* }}} */
+
 func (e *Engine) InterceptorWrapper() func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 	reqPool := sync.Pool{
 		New: func() interface{} {
@@ -112,6 +144,10 @@ func (e *Engine) InterceptorWrapper() func(ctx context.Context, req interface{},
 	}
 
 	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (respose interface{}, err error) {
+		// native服务, FullMethod: /service/method
+		if fs := strings.Split(info.FullMethod, "/"); len(fs) > 2 && e.natives[fs[1]] {
+			return handler(ctx, request)
+		}
 		// request
 		req := reqPool.Get().(*Request)
 		defer reqPool.Put(req)